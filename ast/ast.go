@@ -2,6 +2,8 @@ package ast
 
 import (
 	"bytes"
+	"fmt"
+	"math/big"
 	"strings"
 
 	"github.com/nhoffmann/monkey/token"
@@ -138,6 +140,16 @@ func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
 
+// BigIntegerLiteral is an integer literal too large for an int64.
+type BigIntegerLiteral struct {
+	Token token.Token
+	Value *big.Int
+}
+
+func (bil *BigIntegerLiteral) expressionNode()      {}
+func (bil *BigIntegerLiteral) TokenLiteral() string { return bil.Token.Literal }
+func (bil *BigIntegerLiteral) String() string       { return bil.Token.Literal }
+
 type BooleanLiteral struct {
 	Token token.Token
 	Value bool
@@ -147,6 +159,14 @@ func (bl *BooleanLiteral) expressionNode()      {}
 func (bl *BooleanLiteral) TokenLiteral() string { return bl.Token.Literal }
 func (bl *BooleanLiteral) String() string       { return bl.Token.Literal }
 
+type NullLiteral struct {
+	Token token.Token
+}
+
+func (nl *NullLiteral) expressionNode()      {}
+func (nl *NullLiteral) TokenLiteral() string { return nl.Token.Literal }
+func (nl *NullLiteral) String() string       { return nl.Token.Literal }
+
 type StringLiteral struct {
 	Token token.Token
 	Value string
@@ -220,10 +240,100 @@ func (ie *IfExpression) String() string {
 	return out.String()
 }
 
+// ThrowStatement raises its value as an error.
+type ThrowStatement struct {
+	Token token.Token
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode()       {}
+func (ts *ThrowStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *ThrowStatement) String() string {
+	return ts.TokenLiteral() + " " + ts.Value.String() + ";"
+}
+
+// ExportStatement defines a global like Statement and exports it from the
+// module being imported. Exports of the main program are plain globals.
+type ExportStatement struct {
+	Token     token.Token
+	Statement *LetStatement
+}
+
+func (es *ExportStatement) statementNode()       {}
+func (es *ExportStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExportStatement) String() string {
+	return es.TokenLiteral() + " " + es.Statement.String()
+}
+
+// YieldStatement suspends the generator running it and passes Value to the
+// loop resuming it.
+type YieldStatement struct {
+	Token token.Token
+	Value Expression
+}
+
+func (ys *YieldStatement) statementNode()       {}
+func (ys *YieldStatement) TokenLiteral() string { return ys.Token.Literal }
+func (ys *YieldStatement) String() string {
+	return ys.TokenLiteral() + " " + ys.Value.String() + ";"
+}
+
+// TryExpression evaluates Block and, if it raises an error, binds the error to
+// CatchParameter and evaluates Catch. Finally is evaluated in any case. Either
+// Catch or Finally may be nil, but not both.
+type TryExpression struct {
+	Token          token.Token
+	Block          *BlockStatement
+	CatchParameter *Identifier
+	Catch          *BlockStatement
+	Finally        *BlockStatement
+}
+
+func (te *TryExpression) expressionNode()      {}
+func (te *TryExpression) TokenLiteral() string { return te.Token.Literal }
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("try ")
+	out.WriteString(te.Block.String())
+
+	if te.Catch != nil {
+		out.WriteString(" catch (")
+		out.WriteString(te.CatchParameter.String())
+		out.WriteString(") ")
+		out.WriteString(te.Catch.String())
+	}
+
+	if te.Finally != nil {
+		out.WriteString(" finally ")
+		out.WriteString(te.Finally.String())
+	}
+
+	return out.String()
+}
+
+// PropagateExpression written as v? evaluates to v, unless v is an error. In
+// that case the error is returned from the enclosing function.
+type PropagateExpression struct {
+	Token token.Token
+	Value Expression
+}
+
+func (pe *PropagateExpression) expressionNode()      {}
+func (pe *PropagateExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PropagateExpression) String() string {
+	return "(" + pe.Value.String() + "?)"
+}
+
 type FunctionLiteral struct {
 	Token      token.Token
 	Parameters []*Identifier
 	Body       *BlockStatement
+	// Name is set if the function literal is bound by a let statement
+	Name string
+	// Generator is set if the body yields, calls of the function return a
+	// generator running the body
+	Generator bool
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
@@ -238,6 +348,9 @@ func (fl *FunctionLiteral) String() string {
 	}
 
 	out.WriteString(fl.TokenLiteral())
+	if fl.Name != "" {
+		out.WriteString(fmt.Sprintf("<%s>", fl.Name))
+	}
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteString(") ")
@@ -250,6 +363,11 @@ type CallExpression struct {
 	Token     token.Token
 	Function  Expression
 	Arguments []Expression
+	// Optional calls written as f?.(x) skip the rest of their optional chain
+	// if f is null
+	Optional bool
+	// Tail is set by MarkTailCalls for calls in tail position
+	Tail bool
 }
 
 func (ce *CallExpression) expressionNode()      {}
@@ -263,6 +381,9 @@ func (ce *CallExpression) String() string {
 	}
 
 	out.WriteString(ce.Function.String())
+	if ce.Optional {
+		out.WriteString("?.")
+	}
 	out.WriteString("(")
 	out.WriteString(strings.Join(args, ", "))
 	out.WriteString(")")
@@ -296,6 +417,9 @@ type IndexExpression struct {
 	Token token.Token
 	Left  Expression
 	Index Expression
+	// Optional index expressions written as a?.[k] skip the rest of their
+	// optional chain if a is null
+	Optional bool
 }
 
 func (ie *IndexExpression) expressionNode()      {}
@@ -305,6 +429,9 @@ func (ie *IndexExpression) String() string {
 
 	out.WriteString("(")
 	out.WriteString(ie.Left.String())
+	if ie.Optional {
+		out.WriteString("?.")
+	}
 	out.WriteString("[")
 	out.WriteString(ie.Index.String())
 	out.WriteString("]")
@@ -313,6 +440,39 @@ func (ie *IndexExpression) String() string {
 	return out.String()
 }
 
+type SliceExpression struct {
+	Token token.Token
+	Left  Expression
+	// Start and End are nil if the bound was omitted
+	Start    Expression
+	End      Expression
+	Optional bool
+}
+
+func (se *SliceExpression) expressionNode()      {}
+func (se *SliceExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SliceExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(se.Left.String())
+	if se.Optional {
+		out.WriteString("?.")
+	}
+	out.WriteString("[")
+	if se.Start != nil {
+		out.WriteString(se.Start.String())
+	}
+	out.WriteString(":")
+	if se.End != nil {
+		out.WriteString(se.End.String())
+	}
+	out.WriteString("]")
+	out.WriteString(")")
+
+	return out.String()
+}
+
 type HashLiteral struct {
 	Token token.Token
 	Pairs map[Expression]Expression
@@ -334,3 +494,113 @@ func (hl *HashLiteral) String() string {
 
 	return out.String()
 }
+
+// FieldExpression reads the field Field of Left, written left.field. Called
+// as left.field(args) it calls the method of that name.
+type FieldExpression struct {
+	Token token.Token
+	Left  Expression
+	Field *Identifier
+	// Optional field expressions written as a?.field skip the rest of their
+	// optional chain if a is null
+	Optional bool
+}
+
+func (fe *FieldExpression) expressionNode()      {}
+func (fe *FieldExpression) TokenLiteral() string { return fe.Token.Literal }
+func (fe *FieldExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(fe.Left.String())
+	if fe.Optional {
+		out.WriteString("?.")
+	} else {
+		out.WriteString(".")
+	}
+	out.WriteString(fe.Field.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
+// OptionalChainExpression is a chain of calls, index and field expressions
+// containing optional links like a?.b. Once the left side of an optional link
+// is null, the rest of the chain is skipped and the chain evaluates to null, so
+// a?.b.c is null if a is.
+type OptionalChainExpression struct {
+	Token      token.Token // the first ?. token
+	Expression Expression
+}
+
+func (oce *OptionalChainExpression) expressionNode()      {}
+func (oce *OptionalChainExpression) TokenLiteral() string { return oce.Token.Literal }
+func (oce *OptionalChainExpression) String() string {
+	return oce.Expression.String()
+}
+
+// SpawnExpression runs a function call in a new task and evaluates to the
+// task. Written as spawn f(args), f and its arguments are evaluated right away
+// and f is called in the task. Any other Call is evaluated to a function which
+// the task calls without arguments.
+type SpawnExpression struct {
+	Token token.Token
+	Call  Expression
+}
+
+func (se *SpawnExpression) expressionNode()      {}
+func (se *SpawnExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SpawnExpression) String() string {
+	return "(" + se.TokenLiteral() + " " + se.Call.String() + ")"
+}
+
+// AwaitExpression waits for Task to finish and evaluates to its result. If the
+// task failed, its error is raised.
+type AwaitExpression struct {
+	Token token.Token
+	Task  Expression
+}
+
+func (ae *AwaitExpression) expressionNode()      {}
+func (ae *AwaitExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AwaitExpression) String() string {
+	return "(" + ae.TokenLiteral() + " " + ae.Task.String() + ")"
+}
+
+// ForExpression evaluates Body once for every element of Iterable, which is
+// bound to Variable in the enclosing scope. Arrays, strings, hashes and
+// generators can be iterated. The loop evaluates to null.
+type ForExpression struct {
+	Token    token.Token
+	Variable *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fe *ForExpression) expressionNode()      {}
+func (fe *ForExpression) TokenLiteral() string { return fe.Token.Literal }
+func (fe *ForExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for (")
+	out.WriteString(fe.Variable.String())
+	out.WriteString(" in ")
+	out.WriteString(fe.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(fe.Body.String())
+
+	return out.String()
+}
+
+// ImportExpression evaluates to the module Name, which is loaded and run the
+// first time it is imported.
+type ImportExpression struct {
+	Token token.Token
+	Name  string
+}
+
+func (ie *ImportExpression) expressionNode()      {}
+func (ie *ImportExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *ImportExpression) String() string {
+	return fmt.Sprintf("import %q", ie.Name)
+}
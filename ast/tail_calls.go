@@ -0,0 +1,39 @@
+package ast
+
+// MarkTailCalls marks the calls in tail position within the body of function,
+// that is calls whose result is returned from the function right away. Engines
+// can make these calls without growing the stack. Calls within try expressions
+// are never in tail position, as their handlers must stay active.
+func MarkTailCalls(function *FunctionLiteral) {
+	markTailCallsInBlock(function.Body, true)
+}
+
+func markTailCallsInBlock(block *BlockStatement, tail bool) {
+	for i, statement := range block.Statements {
+		switch statement := statement.(type) {
+		case *ReturnStatement:
+			markTailCalls(statement.ReturnValue, true)
+		case *ExpressionStatement:
+			markTailCalls(statement.Expression, tail && i == len(block.Statements)-1)
+		case *LetStatement:
+			markTailCalls(statement.Value, false)
+		}
+	}
+}
+
+// markTailCalls marks expression if it is a call in tail position and looks
+// for return statements in the branches of conditionals.
+func markTailCalls(expression Expression, tail bool) {
+	switch expression := expression.(type) {
+	case *CallExpression:
+		// method calls are not tail calls, the VM has no tail call
+		// instruction for them
+		_, method := expression.Function.(*FieldExpression)
+		expression.Tail = tail && !expression.Optional && !method
+	case *IfExpression:
+		markTailCallsInBlock(expression.Consequence, tail)
+		if expression.Alternative != nil {
+			markTailCallsInBlock(expression.Alternative, tail)
+		}
+	}
+}
@@ -53,6 +53,30 @@ const (
 	OpArray
 	OpHash
 	OpIndex
+	OpJumpNull
+	OpJumpNotNull
+	OpSlice
+	OpGetBuiltin
+	OpCall
+	OpReturnValue
+	OpReturn
+	OpGetLocal
+	OpSetLocal
+	OpClosure
+	OpGetFree
+	OpCurrentClosure
+	OpThrow
+	OpJumpNotError
+	OpTailCall
+	OpGetField
+	OpCallMethod
+	OpSpawn
+	OpAwait
+	OpYield
+	OpIter
+	OpIterNext
+	OpImport
+	OpImportError
 )
 
 type Definition struct {
@@ -61,27 +85,51 @@ type Definition struct {
 }
 
 var definitions = map[Opcode]*Definition{
-	OpConstant:      {"OpConstant", []int{2}},
-	OpPop:           {"OpPop", []int{}},
-	OpAdd:           {"OpAdd", []int{}},
-	OpSubtract:      {"OpSubtract", []int{}},
-	OpMultiply:      {"OpMultiply", []int{}},
-	OpDivide:        {"OpDivide", []int{}},
-	OpTrue:          {"OpTrue", []int{}},
-	OpFalse:         {"OpFalse", []int{}},
-	OpEqual:         {"OpEqual", []int{}},
-	OpNotEqual:      {"OpNotEqual", []int{}},
-	OpGreaterThan:   {"OpGreaterThan", []int{}},
-	OpMinus:         {"OpMinus", []int{}},
-	OpBang:          {"OpBang", []int{}},
-	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
-	OpJump:          {"OpJump", []int{2}},
-	OpNull:          {"OpNull", []int{}},
-	OpSetGlobal:     {"OpSetGlobal", []int{2}},
-	OpGetGlobal:     {"OpGetGlobal", []int{2}},
-	OpArray:         {"OpArray", []int{2}},
-	OpHash:          {"OpHash", []int{2}},
-	OpIndex:         {"OpIndex", []int{}},
+	OpConstant:       {"OpConstant", []int{2}},
+	OpPop:            {"OpPop", []int{}},
+	OpAdd:            {"OpAdd", []int{}},
+	OpSubtract:       {"OpSubtract", []int{}},
+	OpMultiply:       {"OpMultiply", []int{}},
+	OpDivide:         {"OpDivide", []int{}},
+	OpTrue:           {"OpTrue", []int{}},
+	OpFalse:          {"OpFalse", []int{}},
+	OpEqual:          {"OpEqual", []int{}},
+	OpNotEqual:       {"OpNotEqual", []int{}},
+	OpGreaterThan:    {"OpGreaterThan", []int{}},
+	OpMinus:          {"OpMinus", []int{}},
+	OpBang:           {"OpBang", []int{}},
+	OpJumpNotTruthy:  {"OpJumpNotTruthy", []int{2}},
+	OpJump:           {"OpJump", []int{2}},
+	OpNull:           {"OpNull", []int{}},
+	OpSetGlobal:      {"OpSetGlobal", []int{2}},
+	OpGetGlobal:      {"OpGetGlobal", []int{2}},
+	OpArray:          {"OpArray", []int{2}},
+	OpHash:           {"OpHash", []int{2}},
+	OpIndex:          {"OpIndex", []int{}},
+	OpJumpNull:       {"OpJumpNull", []int{2}},
+	OpJumpNotNull:    {"OpJumpNotNull", []int{2}},
+	OpSlice:          {"OpSlice", []int{}},
+	OpGetBuiltin:     {"OpGetBuiltin", []int{1}},
+	OpCall:           {"OpCall", []int{1}},
+	OpReturnValue:    {"OpReturnValue", []int{}},
+	OpReturn:         {"OpReturn", []int{}},
+	OpGetLocal:       {"OpGetLocal", []int{1}},
+	OpSetLocal:       {"OpSetLocal", []int{1}},
+	OpClosure:        {"OpClosure", []int{2, 1}},
+	OpGetFree:        {"OpGetFree", []int{1}},
+	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	OpThrow:          {"OpThrow", []int{}},
+	OpJumpNotError:   {"OpJumpNotError", []int{2}},
+	OpTailCall:       {"OpTailCall", []int{1}},
+	OpGetField:       {"OpGetField", []int{2}},
+	OpCallMethod:     {"OpCallMethod", []int{2, 1}},
+	OpSpawn:          {"OpSpawn", []int{1}},
+	OpAwait:          {"OpAwait", []int{}},
+	OpYield:          {"OpYield", []int{}},
+	OpIter:           {"OpIter", []int{}},
+	OpIterNext:       {"OpIterNext", []int{2}},
+	OpImport:         {"OpImport", []int{2}},
+	OpImportError:    {"OpImportError", []int{2}},
 }
 
 func Lookup(op byte) (*Definition, error) {
@@ -114,6 +162,8 @@ func Make(op Opcode, operands ...int) []byte {
 		switch width {
 		case 2:
 			binary.BigEndian.PutUint16(instruction[offset:], uint16(operand))
+		case 1:
+			instruction[offset] = byte(operand)
 		}
 		offset += width
 	}
@@ -129,6 +179,8 @@ func ReadOperands(definition *Definition, instructions Instructions) ([]int, int
 		switch width {
 		case 2:
 			operands[i] = int(ReadUint16(instructions[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(instructions[offset:]))
 		}
 
 		offset += width
@@ -141,6 +193,10 @@ func ReadUint16(instructions Instructions) uint16 {
 	return binary.BigEndian.Uint16(instructions)
 }
 
+func ReadUint8(instructions Instructions) uint8 {
+	return uint8(instructions[0])
+}
+
 func fmtInstruction(definition *Definition, operands []int) string {
 	operandCount := len(definition.OperandWidths)
 
@@ -157,7 +213,39 @@ func fmtInstruction(definition *Definition, operands []int) string {
 		return definition.Name
 	case 1:
 		return fmt.Sprintf("%s %d", definition.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", definition.Name, operands[0], operands[1])
 	}
 
 	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", definition.Name)
 }
+
+// StackEffect returns the change in the number of values on the stack caused by
+// executing the instruction with the given operands. Instructions leaving the
+// function (OpReturnValue, OpReturn, OpThrow) report the values they consume,
+// OpImportError reports the module the failed import would have pushed.
+// OpIterNext reports the effect of continuing the loop, it pops the iterator
+// when jumping.
+func StackEffect(op Opcode, operands []int) int {
+	switch op {
+	case OpConstant, OpTrue, OpFalse, OpNull, OpGetGlobal, OpGetBuiltin,
+		OpGetLocal, OpGetFree, OpCurrentClosure, OpIterNext, OpImport, OpImportError:
+		return 1
+	case OpPop, OpAdd, OpSubtract, OpMultiply, OpDivide, OpEqual, OpNotEqual,
+		OpGreaterThan, OpJumpNotTruthy, OpSetGlobal, OpSetLocal, OpIndex,
+		OpReturnValue, OpThrow, OpYield:
+		return -1
+	case OpSlice:
+		return -2
+	case OpArray, OpHash:
+		return 1 - operands[0]
+	case OpCall, OpTailCall, OpSpawn:
+		return -operands[0]
+	case OpCallMethod:
+		return -operands[1]
+	case OpClosure:
+		return 1 - operands[1]
+	default:
+		return 0
+	}
+}
@@ -10,6 +10,11 @@ func TestMake(t *testing.T) {
 	}{
 		{OpConstant, []int{65534}, []byte{byte(OpConstant), 255, 254}},
 		{OpAdd, []int{}, []byte{byte(OpAdd)}},
+		{OpGetBuiltin, []int{255}, []byte{byte(OpGetBuiltin), 255}},
+		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
+		{OpCallMethod, []int{258, 2}, []byte{byte(OpCallMethod), 1, 2, 2}},
+		{OpSpawn, []int{3}, []byte{byte(OpSpawn), 3}},
+		{OpIterNext, []int{258}, []byte{byte(OpIterNext), 1, 2}},
 	}
 
 	for _, test := range tests {
@@ -41,11 +46,15 @@ func TestInstructionsString(t *testing.T) {
 		Make(OpAdd),
 		Make(OpConstant, 2),
 		Make(OpConstant, 65535),
+		Make(OpCall, 1),
+		Make(OpClosure, 65535, 255),
 	}
 
 	expected := `0000 OpAdd
 0001 OpConstant 2
 0004 OpConstant 65535
+0007 OpCall 1
+0009 OpClosure 65535 255
 `
 
 	concatted := Instructions{}
@@ -70,6 +79,12 @@ func TestReadOperands(t *testing.T) {
 		bytesRead int
 	}{
 		{OpConstant, []int{65535}, 2},
+		{OpGetBuiltin, []int{255}, 1},
+		{OpClosure, []int{65535, 255}, 3},
+		{OpGetField, []int{65535}, 2},
+		{OpCallMethod, []int{65535, 255}, 3},
+		{OpSpawn, []int{255}, 1},
+		{OpIterNext, []int{65535}, 2},
 	}
 
 	for _, test := range tests {
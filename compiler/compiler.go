@@ -11,9 +11,17 @@ import (
 
 const JUMP_PLACEHOLDER_POSITION = 9999
 
+// Bytecode is the result of a compilation. It is immutable: the VM only reads
+// the instructions and constants, and the constants themselves, numbers,
+// strings and compiled functions, are never modified. A single Bytecode can
+// therefore be run by any number of VMs in different goroutines at once.
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	// Handlers are the exception handlers of the main program
+	Handlers []object.ExceptionHandler
+	// NumGlobals is the number of global bindings the instructions use
+	NumGlobals int
 }
 
 type EmittedInstruction struct {
@@ -21,24 +29,65 @@ type EmittedInstruction struct {
 	Position int
 }
 
-type Compiler struct {
-	instructions code.Instructions
-	constants    []object.Object
-
+type CompilationScope struct {
+	instructions        code.Instructions
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
 
+	handlers []pendingHandler
+	// finallyBlocks holds the finally blocks of the enclosing try expressions,
+	// which a return statement has to run before leaving the function
+	finallyBlocks []*ast.BlockStatement
+}
+
+type Compiler struct {
+	constants []object.Object
+
 	symbolTable *SymbolTable
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	// moduleLoader loads imported modules, nothing can be imported if it is
+	// nil
+	moduleLoader *object.ModuleLoader
+	// imports lists the modules being compiled, outermost first
+	imports []string
+	// exports lists the names exported by the module being compiled
+	exports []string
+	// optionalJumps holds the positions of the jumps of the optional links of
+	// the optional chain being compiled, which jump to its end
+	optionalJumps []int
 }
 
 func NewCompiler() *Compiler {
-	return &Compiler{
+	mainScope := CompilationScope{
 		instructions:        code.Instructions{},
-		constants:           []object.Object{},
-		symbolTable:         NewSymbolTable(),
 		lastInstruction:     EmittedInstruction{},
 		previousInstruction: EmittedInstruction{},
 	}
+
+	symbolTable := NewSymbolTable()
+
+	for i, definition := range object.Builtins {
+		symbolTable.DefineBuiltin(i, definition.Name)
+	}
+
+	return &Compiler{
+		constants:   []object.Object{},
+		symbolTable: symbolTable,
+		scopes:      []CompilationScope{mainScope},
+		scopeIndex:  0,
+	}
+}
+
+// NewCompilerWithBuiltins creates a compiler resolving builtins with the given
+// registry instead of the standard builtins. The bytecode has to be run by a
+// VM using the same registry.
+func NewCompilerWithBuiltins(builtins *object.Registry) *Compiler {
+	compiler := NewCompiler()
+	compiler.symbolTable = NewSymbolTableWithBuiltins(builtins)
+	return compiler
 }
 
 func NewCompilerWithState(st *SymbolTable, constants []object.Object) *Compiler {
@@ -48,6 +97,12 @@ func NewCompilerWithState(st *SymbolTable, constants []object.Object) *Compiler
 	return compiler
 }
 
+// SetModuleLoader sets the loader of the modules imported by the compiled
+// programs.
+func (c *Compiler) SetModuleLoader(loader *object.ModuleLoader) {
+	c.moduleLoader = loader
+}
+
 func (c *Compiler) Compile(node ast.Node) error {
 	switch node := node.(type) {
 	case *ast.Program:
@@ -92,6 +147,25 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpGreaterThan)
 			return nil
 		}
+
+		if node.Operator == "??" {
+			err := c.Compile(node.Left)
+			if err != nil {
+				return err
+			}
+
+			jumpNotNullPosition := c.emit(code.OpJumpNotNull, JUMP_PLACEHOLDER_POSITION)
+			c.emit(code.OpPop)
+
+			err = c.Compile(node.Right)
+			if err != nil {
+				return err
+			}
+
+			c.changeOperand(jumpNotNullPosition, len(c.currentInstructions()))
+			return nil
+		}
+
 		err := c.Compile(node.Left)
 		if err != nil {
 			return err
@@ -123,6 +197,11 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.IntegerLiteral:
 		integer := &object.Integer{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(integer))
+	case *ast.BigIntegerLiteral:
+		integer := &object.BigInteger{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(integer))
+	case *ast.NullLiteral:
+		c.emit(code.OpNull)
 	case *ast.StringLiteral:
 		str := &object.String{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(str))
@@ -140,34 +219,26 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		jumpNotTruthyPosition := c.emit(code.OpJumpNotTruthy, JUMP_PLACEHOLDER_POSITION)
 
-		err = c.Compile(node.Consequence)
+		err = c.compileBranch(node.Consequence)
 		if err != nil {
 			return err
 		}
 
-		if c.lastInstructionIsPop() {
-			c.removeLastPop()
-		}
-
 		jumpPosition := c.emit(code.OpJump, JUMP_PLACEHOLDER_POSITION)
 
-		afterConsequencePosition := len(c.instructions)
+		afterConsequencePosition := len(c.currentInstructions())
 		c.changeOperand(jumpNotTruthyPosition, afterConsequencePosition)
 
 		if node.Alternative == nil {
 			c.emit(code.OpNull)
 		} else {
-			err := c.Compile(node.Alternative)
+			err := c.compileBranch(node.Alternative)
 			if err != nil {
 				return err
 			}
-
-			if c.lastInstructionIsPop() {
-				c.removeLastPop()
-			}
 		}
 
-		afterAlternativePosition := len(c.instructions)
+		afterAlternativePosition := len(c.currentInstructions())
 		c.changeOperand(jumpPosition, afterAlternativePosition)
 	case *ast.BlockStatement:
 		for _, statement := range node.Statements {
@@ -177,20 +248,200 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 	case *ast.LetStatement:
+		// the name is only bound once the value is compiled, so the value
+		// cannot refer to the binding it initializes, except for functions,
+		// which are defined before their body is compiled
+		var symbol Symbol
+		if _, ok := node.Value.(*ast.FunctionLiteral); ok {
+			symbol = c.symbolTable.Define(node.Name.Value)
+		}
+
 		err := c.Compile(node.Value)
 		if err != nil {
 			return err
 		}
 
-		symbol := c.symbolTable.Define(node.Name.Value)
-		c.emit(code.OpSetGlobal, symbol.Index)
+		if _, ok := node.Value.(*ast.FunctionLiteral); !ok {
+			symbol = c.symbolTable.Define(node.Name.Value)
+		}
+
+		if symbol.Scope == GlobalScope {
+			c.emit(code.OpSetGlobal, symbol.Index)
+		} else {
+			c.emit(code.OpSetLocal, symbol.Index)
+		}
+	case *ast.ReturnStatement:
+		err := c.Compile(node.ReturnValue)
+		if err != nil {
+			return err
+		}
+
+		err = c.compileFinallyBlocks()
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpReturnValue)
+	case *ast.ThrowStatement:
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpThrow)
+	case *ast.ExportStatement:
+		err := c.Compile(node.Statement)
+		if err != nil {
+			return err
+		}
+
+		c.exports = append(c.exports, node.Statement.Name.Value)
+	case *ast.YieldStatement:
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpYield)
+	case *ast.ForExpression:
+		err := c.compileForExpression(node)
+		if err != nil {
+			return err
+		}
+	case *ast.TryExpression:
+		err := c.compileTryExpression(node)
+		if err != nil {
+			return err
+		}
+	case *ast.PropagateExpression:
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+
+		jumpNotErrorPosition := c.emit(code.OpJumpNotError, JUMP_PLACEHOLDER_POSITION)
+
+		err = c.compileFinallyBlocks()
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+
+		c.changeOperand(jumpNotErrorPosition, len(c.currentInstructions()))
+	case *ast.FunctionLiteral:
+		c.enterScope()
+
+		if node.Name != "" {
+			c.symbolTable.DefineFunctionName(node.Name)
+		}
+
+		for _, parameter := range node.Parameters {
+			c.symbolTable.Define(parameter.Value)
+		}
+
+		err := c.Compile(node.Body)
+		if err != nil {
+			return err
+		}
+
+		if c.lastInstructionIs(code.OpPop) {
+			c.replaceLastPopWithReturn()
+		}
+		if !c.lastInstructionIs(code.OpReturnValue) {
+			c.emit(code.OpReturn)
+		}
+
+		freeSymbols := c.symbolTable.FreeSymbols
+		numLocals := c.symbolTable.numberDefinitions
+		handlers := c.scopes[c.scopeIndex].handlers
+		instructions := c.leaveScope()
+
+		for _, symbol := range freeSymbols {
+			c.loadSymbol(symbol)
+		}
+
+		compiledFunction := &object.CompiledFunction{
+			Instructions:  instructions,
+			NumLocals:     numLocals,
+			NumParameters: len(node.Parameters),
+			Name:          node.Name,
+			Handlers:      resolveHandlers(instructions, handlers),
+			Generator:     node.Generator,
+		}
+
+		c.emit(code.OpClosure, c.addConstant(compiledFunction), len(freeSymbols))
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
 			return fmt.Errorf("undefined variable: %s", node.Value)
 		}
 
-		c.emit(code.OpGetGlobal, symbol.Index)
+		c.loadSymbol(symbol)
+	case *ast.CallExpression:
+		if field, ok := node.Function.(*ast.FieldExpression); ok && !node.Optional {
+			return c.compileMethodCall(field, node.Arguments)
+		}
+
+		err := c.Compile(node.Function)
+		if err != nil {
+			return err
+		}
+
+		if node.Optional {
+			c.emitOptionalJump()
+		}
+
+		for _, argument := range node.Arguments {
+			err := c.Compile(argument)
+			if err != nil {
+				return err
+			}
+		}
+
+		if node.Tail {
+			c.emit(code.OpTailCall, len(node.Arguments))
+		} else {
+			c.emit(code.OpCall, len(node.Arguments))
+		}
+	case *ast.SpawnExpression:
+		call, ok := node.Call.(*ast.CallExpression)
+		if !ok || call.Optional {
+			call = &ast.CallExpression{Function: node.Call}
+		}
+
+		err := c.Compile(call.Function)
+		if err != nil {
+			return err
+		}
+
+		for _, argument := range call.Arguments {
+			err := c.Compile(argument)
+			if err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpSpawn, len(call.Arguments))
+	case *ast.ImportExpression:
+		module, err := c.compileModule(node.Name)
+		if runtimeError, ok := err.(*object.Error); ok && runtimeError.Kind == object.ImportError {
+			// like in the evaluator, a module which cannot be imported is
+			// only an error once the import is executed
+			c.emit(code.OpImportError, c.addConstant(&object.String{Value: runtimeError.Message}))
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpImport, c.addConstant(module))
+	case *ast.AwaitExpression:
+		err := c.Compile(node.Task)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpAwait)
 	case *ast.ArrayLiteral:
 		for _, element := range node.Elements {
 			err := c.Compile(element)
@@ -229,21 +480,388 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
+		if node.Optional {
+			c.emitOptionalJump()
+		}
+
 		err = c.Compile(node.Index)
 		if err != nil {
 			return err
 		}
 
 		c.emit(code.OpIndex)
+	case *ast.FieldExpression:
+		err := c.Compile(node.Left)
+		if err != nil {
+			return err
+		}
+
+		if node.Optional {
+			c.emitOptionalJump()
+		}
+
+		name := c.addConstant(&object.String{Value: node.Field.Value})
+		c.emit(code.OpGetField, name)
+	case *ast.OptionalChainExpression:
+		jumps := c.optionalJumps
+		c.optionalJumps = nil
+
+		err := c.Compile(node.Expression)
+
+		for _, position := range c.optionalJumps {
+			c.changeOperand(position, len(c.currentInstructions()))
+		}
+		c.optionalJumps = jumps
+
+		if err != nil {
+			return err
+		}
+	case *ast.SliceExpression:
+		err := c.Compile(node.Left)
+		if err != nil {
+			return err
+		}
+
+		if node.Optional {
+			c.emitOptionalJump()
+		}
+
+		for _, bound := range []ast.Expression{node.Start, node.End} {
+			if bound == nil {
+				c.emit(code.OpNull)
+				continue
+			}
+
+			err = c.Compile(bound)
+			if err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpSlice)
 	}
 
 	return nil
 }
 
+// Bytecode returns the compiled program. The slices are capped at their
+// length, so compiling more code with the same constants, like the REPL does,
+// never writes to the memory of bytecode returned earlier.
 func (c *Compiler) Bytecode() *Bytecode {
+	instructions := c.currentInstructions()
+
 	return &Bytecode{
-		c.instructions,
-		c.constants,
+		Instructions: instructions[:len(instructions):len(instructions)],
+		Constants:    c.constants[:len(c.constants):len(c.constants)],
+		Handlers:     resolveHandlers(instructions, c.scopes[c.scopeIndex].handlers),
+		NumGlobals:   c.symbolTable.NumGlobals(),
+	}
+}
+
+// compileMethodCall compiles receiver.name(args). OpCallMethod looks up the
+// field or method name when the call is made, so the receiver does not have to
+// be bound to the method first.
+func (c *Compiler) compileMethodCall(field *ast.FieldExpression, arguments []ast.Expression) error {
+	err := c.Compile(field.Left)
+	if err != nil {
+		return err
+	}
+
+	if field.Optional {
+		c.emitOptionalJump()
+	}
+
+	for _, argument := range arguments {
+		err := c.Compile(argument)
+		if err != nil {
+			return err
+		}
+	}
+
+	name := c.addConstant(&object.String{Value: field.Field.Value})
+	c.emit(code.OpCallMethod, name, len(arguments))
+
+	return nil
+}
+
+// emitOptionalJump emits the jump of an optional link, which skips the rest of
+// the optional chain if the value on the stack is null. The jump is resolved
+// once the whole chain is compiled.
+func (c *Compiler) emitOptionalJump() {
+	c.optionalJumps = append(c.optionalJumps, c.emit(code.OpJumpNull, JUMP_PLACEHOLDER_POSITION))
+}
+
+// compileForExpression compiles a for loop. The iterator stays on the stack
+// while the loop runs, OpIterNext pushes the next element or pops the iterator
+// and leaves the loop once it is exhausted.
+func (c *Compiler) compileForExpression(node *ast.ForExpression) error {
+	err := c.Compile(node.Iterable)
+	if err != nil {
+		return err
+	}
+
+	c.emit(code.OpIter)
+
+	loopPosition := len(c.currentInstructions())
+	iterNextPosition := c.emit(code.OpIterNext, JUMP_PLACEHOLDER_POSITION)
+
+	symbol := c.symbolTable.Define(node.Variable.Value)
+	if symbol.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, symbol.Index)
+	} else {
+		c.emit(code.OpSetLocal, symbol.Index)
+	}
+
+	err = c.Compile(node.Body)
+	if err != nil {
+		return err
+	}
+
+	c.emit(code.OpJump, loopPosition)
+
+	afterLoopPosition := len(c.currentInstructions())
+	c.changeOperand(iterNextPosition, afterLoopPosition)
+
+	c.emit(code.OpNull)
+
+	return nil
+}
+
+// compileModule compiles the module name the first time it is imported by the
+// program. The body of the module is compiled like a function, but with a
+// global symbol table of its own.
+func (c *Compiler) compileModule(name string) (*object.CompiledModule, error) {
+	program := c.symbolTable.programTable()
+	if module, ok := program.modules[name]; ok {
+		return module, nil
+	}
+
+	if err := object.ImportCycleError(c.imports, name); err != nil {
+		return nil, err
+	}
+
+	if c.moduleLoader == nil {
+		return nil, object.NewError(object.ImportError, "module not found: %s", name)
+	}
+
+	source, err := c.moduleLoader.Load(name)
+	if err != nil {
+		return nil, err
+	}
+
+	symbolTable, exports := c.symbolTable, c.exports
+	c.symbolTable, c.exports = NewModuleSymbolTable(program), nil
+	c.imports = append(c.imports, name)
+	c.scopes = append(c.scopes, CompilationScope{instructions: code.Instructions{}})
+	c.scopeIndex++
+
+	err = c.Compile(source)
+	c.emit(code.OpReturn)
+
+	moduleTable, moduleExports := c.symbolTable, c.exports
+	handlers := c.scopes[c.scopeIndex].handlers
+	instructions := c.currentInstructions()
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+	c.imports = c.imports[:len(c.imports)-1]
+	c.symbolTable, c.exports = symbolTable, exports
+
+	if err != nil {
+		return nil, err
+	}
+
+	module := &object.CompiledModule{
+		Name: name,
+		Body: &object.CompiledFunction{
+			Instructions: instructions,
+			Name:         name,
+			Handlers:     resolveHandlers(instructions, handlers),
+		},
+		Slot: program.defineSlot(),
+	}
+
+	for _, export := range moduleExports {
+		symbol, _ := moduleTable.Resolve(export)
+		module.Exports = append(module.Exports, object.ModuleExport{Name: export, Global: symbol.Index})
+	}
+
+	if program.modules == nil {
+		program.modules = map[string]*object.CompiledModule{}
+	}
+	program.modules[name] = module
+
+	return module, nil
+}
+
+// compileTryExpression compiles the try block followed by the catch and
+// finally blocks, which are only entered through exception handlers. The
+// finally block is inlined wherever the try expression can be left normally.
+func (c *Compiler) compileTryExpression(node *ast.TryExpression) error {
+	if node.Finally != nil {
+		c.pushFinallyBlock(node.Finally)
+	}
+
+	tryPosition := len(c.currentInstructions())
+
+	err := c.compileBlockValue(node.Block)
+	if err != nil {
+		return err
+	}
+
+	protected := [][2]int{{tryPosition, len(c.currentInstructions())}}
+	jumpPositions := []int{}
+
+	if node.Finally != nil {
+		err := c.Compile(node.Finally)
+		if err != nil {
+			return err
+		}
+	}
+	jumpPositions = append(jumpPositions, c.emit(code.OpJump, JUMP_PLACEHOLDER_POSITION))
+
+	if node.Catch != nil {
+		catchPosition := len(c.currentInstructions())
+		c.addHandler(protected[0][0], protected[0][1], catchPosition, tryPosition)
+
+		symbol := c.symbolTable.Define(node.CatchParameter.Value)
+		if symbol.Scope == GlobalScope {
+			c.emit(code.OpSetGlobal, symbol.Index)
+		} else {
+			c.emit(code.OpSetLocal, symbol.Index)
+		}
+
+		err := c.compileBlockValue(node.Catch)
+		if err != nil {
+			return err
+		}
+
+		protected = [][2]int{{catchPosition, len(c.currentInstructions())}}
+
+		if node.Finally != nil {
+			err := c.Compile(node.Finally)
+			if err != nil {
+				return err
+			}
+		}
+		jumpPositions = append(jumpPositions, c.emit(code.OpJump, JUMP_PLACEHOLDER_POSITION))
+	}
+
+	if node.Finally != nil {
+		c.popFinallyBlock()
+
+		finallyPosition := len(c.currentInstructions())
+		for _, instructions := range protected {
+			c.addHandler(instructions[0], instructions[1], finallyPosition, tryPosition)
+		}
+
+		// the error stays on the stack while the finally block runs and is
+		// raised again afterwards
+		err := c.Compile(node.Finally)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+	}
+
+	afterPosition := len(c.currentInstructions())
+	for _, jumpPosition := range jumpPositions {
+		c.changeOperand(jumpPosition, afterPosition)
+	}
+
+	return nil
+}
+
+// compileBranch compiles a branch of an if expression so that it leaves its
+// value on the stack. Branches ending in a statement, like yield, evaluate to
+// null, branches leaving the function need no value.
+func (c *Compiler) compileBranch(block *ast.BlockStatement) error {
+	start := len(c.currentInstructions())
+
+	err := c.Compile(block)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case len(c.currentInstructions()) == start:
+		c.emit(code.OpNull)
+	case c.lastInstructionIs(code.OpPop):
+		c.removeLastPop()
+	case c.lastInstructionIs(code.OpReturnValue), c.lastInstructionIs(code.OpThrow):
+	default:
+		c.emit(code.OpNull)
+	}
+
+	return nil
+}
+
+// compileBlockValue compiles a block so that it leaves its value on the stack,
+// null if the block does not end with an expression.
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	start := len(c.currentInstructions())
+
+	err := c.Compile(block)
+	if err != nil {
+		return err
+	}
+
+	if len(c.currentInstructions()) > start && c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+
+	return nil
+}
+
+// compileFinallyBlocks inlines the finally blocks of all enclosing try
+// expressions of the current function, innermost first.
+func (c *Compiler) compileFinallyBlocks() error {
+	blocks := c.scopes[c.scopeIndex].finallyBlocks
+
+	for i := len(blocks) - 1; i >= 0; i-- {
+		// a return inside the finally block must only run the outer ones
+		c.scopes[c.scopeIndex].finallyBlocks = append([]*ast.BlockStatement{}, blocks[:i]...)
+
+		err := c.Compile(blocks[i])
+		if err != nil {
+			c.scopes[c.scopeIndex].finallyBlocks = blocks
+			return err
+		}
+	}
+
+	c.scopes[c.scopeIndex].finallyBlocks = blocks
+	return nil
+}
+
+func (c *Compiler) pushFinallyBlock(block *ast.BlockStatement) {
+	scope := &c.scopes[c.scopeIndex]
+	scope.finallyBlocks = append(scope.finallyBlocks, block)
+}
+
+func (c *Compiler) popFinallyBlock() {
+	scope := &c.scopes[c.scopeIndex]
+	scope.finallyBlocks = scope.finallyBlocks[:len(scope.finallyBlocks)-1]
+}
+
+func (c *Compiler) addHandler(start, end, target, tryPosition int) {
+	handler := pendingHandler{start: start, end: end, target: target, tryPosition: tryPosition}
+	c.scopes[c.scopeIndex].handlers = append(c.scopes[c.scopeIndex].handlers, handler)
+}
+
+func (c *Compiler) loadSymbol(symbol Symbol) {
+	switch symbol.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, symbol.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, symbol.Index)
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, symbol.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, symbol.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
 	}
 }
 
@@ -261,38 +879,82 @@ func (c *Compiler) emit(op code.Opcode, operands ...int) int {
 	return position
 }
 
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
 func (c *Compiler) addInstruction(instructions []byte) int {
-	positionOfNewInstruction := len(c.instructions)
-	c.instructions = append(c.instructions, instructions...)
+	positionOfNewInstruction := len(c.currentInstructions())
+	c.scopes[c.scopeIndex].instructions = append(c.currentInstructions(), instructions...)
 	return positionOfNewInstruction
 }
 
 func (c *Compiler) setLastInstruction(op code.Opcode, position int) {
-	previous := c.lastInstruction
+	previous := c.scopes[c.scopeIndex].lastInstruction
 	last := EmittedInstruction{Opcode: op, Position: position}
 
-	c.previousInstruction = previous
-	c.lastInstruction = last
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
 }
 
-func (c *Compiler) lastInstructionIsPop() bool {
-	return c.lastInstruction.Opcode == code.OpPop
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
 }
 
 func (c *Compiler) removeLastPop() {
-	c.instructions = c.instructions[:c.lastInstruction.Position]
-	c.lastInstruction = c.previousInstruction
+	last := c.scopes[c.scopeIndex].lastInstruction
+	previous := c.scopes[c.scopeIndex].previousInstruction
+
+	c.scopes[c.scopeIndex].instructions = c.currentInstructions()[:last.Position]
+	c.scopes[c.scopeIndex].lastInstruction = previous
+}
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPosition := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceInstruction(lastPosition, code.Make(code.OpReturnValue))
+
+	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
 }
 
-func (c Compiler) changeOperand(operandPosition, operand int) {
-	op := code.Opcode(c.instructions[operandPosition])
+func (c *Compiler) changeOperand(operandPosition, operand int) {
+	op := code.Opcode(c.currentInstructions()[operandPosition])
 	newInstruction := code.Make(op, operand)
 
 	c.replaceInstruction(operandPosition, newInstruction)
 }
 
 func (c *Compiler) replaceInstruction(position int, newInstruction []byte) {
+	instructions := c.currentInstructions()
+
 	for i := 0; i < len(newInstruction); i++ {
-		c.instructions[position+i] = newInstruction[i]
+		instructions[position+i] = newInstruction[i]
 	}
 }
+
+func (c *Compiler) enterScope() {
+	scope := CompilationScope{
+		instructions:        code.Instructions{},
+		lastInstruction:     EmittedInstruction{},
+		previousInstruction: EmittedInstruction{},
+	}
+
+	c.scopes = append(c.scopes, scope)
+	c.scopeIndex++
+
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() code.Instructions {
+	instructions := c.currentInstructions()
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+
+	c.symbolTable = c.symbolTable.Outer
+
+	return instructions
+}
@@ -1,6 +1,7 @@
 package compiler
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/nhoffmann/monkey/object"
@@ -15,6 +16,7 @@ type compilerTestCase struct {
 	input                string
 	expectedConstants    []interface{}
 	expectedInstructions []code.Instructions
+	expectedHandlers     []object.ExceptionHandler
 }
 
 func TestCompiler(t *testing.T) {
@@ -408,6 +410,660 @@ func TestCompiler(t *testing.T) {
 
 		runCompilerTests(t, tests)
 	})
+
+	t.Run("Slice expressions", func(t *testing.T) {
+		tests := []compilerTestCase{
+			{
+				input:             "[1, 2][1:]",
+				expectedConstants: []interface{}{1, 2, 1},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpArray, 2),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpNull),
+					code.Make(code.OpSlice),
+					code.Make(code.OpPop),
+				},
+			},
+			{
+				input:             `"monkey"[:-1]`,
+				expectedConstants: []interface{}{"monkey", 1},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpNull),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpMinus),
+					code.Make(code.OpSlice),
+					code.Make(code.OpPop),
+				},
+			},
+		}
+
+		runCompilerTests(t, tests)
+	})
+
+	t.Run("Field expressions", func(t *testing.T) {
+		tests := []compilerTestCase{
+			{
+				input:             `{"a": 1}.a`,
+				expectedConstants: []interface{}{"a", 1, "a"},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpHash, 2),
+					code.Make(code.OpGetField, 2),
+					code.Make(code.OpPop),
+				},
+			},
+			{
+				input:             `"a".repeat(2)`,
+				expectedConstants: []interface{}{"a", 2, "repeat"},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpCallMethod, 2, 1),
+					code.Make(code.OpPop),
+				},
+			},
+			{
+				input:             `null?.a; null?.b()`,
+				expectedConstants: []interface{}{"a", "b"},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpNull),             // 0000
+					code.Make(code.OpJumpNull, 7),      // 0001
+					code.Make(code.OpGetField, 0),      // 0004
+					code.Make(code.OpPop),              // 0007
+					code.Make(code.OpNull),             // 0008
+					code.Make(code.OpJumpNull, 16),     // 0009
+					code.Make(code.OpCallMethod, 1, 0), // 0012
+					code.Make(code.OpPop),              // 0016
+				},
+			},
+			{
+				input:             `null?.a.b()`,
+				expectedConstants: []interface{}{"a", "b"},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpNull),             // 0000
+					code.Make(code.OpJumpNull, 11),     // 0001
+					code.Make(code.OpGetField, 0),      // 0004
+					code.Make(code.OpCallMethod, 1, 0), // 0007
+					code.Make(code.OpPop),              // 0011
+				},
+			},
+			{
+				input:             `"a".upper?.()`,
+				expectedConstants: []interface{}{"a", "upper"},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpConstant, 0),  // 0000
+					code.Make(code.OpGetField, 1),  // 0003
+					code.Make(code.OpJumpNull, 11), // 0006
+					code.Make(code.OpCall, 0),      // 0009
+					code.Make(code.OpPop),          // 0011
+				},
+			},
+		}
+
+		runCompilerTests(t, tests)
+	})
+
+	t.Run("Spawn and await", func(t *testing.T) {
+		tests := []compilerTestCase{
+			{
+				input:             `await spawn len("a")`,
+				expectedConstants: []interface{}{"a"},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpGetBuiltin, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSpawn, 1),
+					code.Make(code.OpAwait),
+					code.Make(code.OpPop),
+				},
+			},
+			{
+				input:             `spawn len`,
+				expectedConstants: []interface{}{},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpGetBuiltin, 0),
+					code.Make(code.OpSpawn, 0),
+					code.Make(code.OpPop),
+				},
+			},
+		}
+
+		runCompilerTests(t, tests)
+	})
+
+	t.Run("Generators and for expressions", func(t *testing.T) {
+		tests := []compilerTestCase{
+			{
+				input:             `for (x in [1]) { x }`,
+				expectedConstants: []interface{}{1},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpArray, 1),
+					code.Make(code.OpIter),
+					code.Make(code.OpIterNext, 20),
+					code.Make(code.OpSetGlobal, 0),
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpPop),
+					code.Make(code.OpJump, 7),
+					code.Make(code.OpNull),
+					code.Make(code.OpPop),
+				},
+			},
+			{
+				input: `fn() { yield 1 }`,
+				expectedConstants: []interface{}{
+					1,
+					[]code.Instructions{
+						code.Make(code.OpConstant, 0),
+						code.Make(code.OpYield),
+						code.Make(code.OpReturn),
+					},
+				},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpClosure, 1, 0),
+					code.Make(code.OpPop),
+				},
+			},
+		}
+
+		runCompilerTests(t, tests)
+	})
+
+	t.Run("Modules", func(t *testing.T) {
+		c := NewCompiler()
+		c.SetModuleLoader(object.NewModuleLoader("../testdata/modules"))
+
+		program := parser.NewParser(lexer.NewLexer(`let pi = 1; import "math"; import "math";`)).ParseProgram()
+		if err := c.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := c.Bytecode()
+
+		expectedInstructions := concatInstructions([]code.Instructions{
+			code.Make(code.OpConstant, 0),
+			code.Make(code.OpSetGlobal, 0),
+			code.Make(code.OpImport, 4),
+			code.Make(code.OpPop),
+			code.Make(code.OpImport, 5),
+			code.Make(code.OpPop),
+		})
+		if bytecode.Instructions.String() != expectedInstructions.String() {
+			t.Errorf("wrong instructions.\nWant %s\ngot  %s", expectedInstructions, bytecode.Instructions)
+		}
+
+		module, ok := bytecode.Constants[4].(*object.CompiledModule)
+		if !ok {
+			t.Fatalf("constant is not a CompiledModule. Got %T", bytecode.Constants[4])
+		}
+
+		if bytecode.Constants[5] != module {
+			t.Errorf("module compiled twice")
+		}
+
+		expectedExports := []object.ModuleExport{{Name: "pi", Global: 2}, {Name: "area", Global: 3}}
+		if fmt.Sprint(module.Exports) != fmt.Sprint(expectedExports) {
+			t.Errorf("wrong exports. Want %v, got %v", expectedExports, module.Exports)
+		}
+
+		if module.Name != "math" || module.Slot != 4 || bytecode.NumGlobals != 5 {
+			t.Errorf("wrong module %s in slot %d of %d globals", module.Name, module.Slot, bytecode.NumGlobals)
+		}
+
+		// modules which cannot be imported raise an ImportError when run
+		runCompilerTests(t, []compilerTestCase{
+			{
+				input:             `import "missing"`,
+				expectedConstants: []interface{}{"module not found: missing"},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpImportError, 0),
+					code.Make(code.OpPop),
+				},
+			},
+		})
+	})
+
+	t.Run("Builtins", func(t *testing.T) {
+		tests := []compilerTestCase{
+			{
+				input:             `len([]); push([], 1);`,
+				expectedConstants: []interface{}{1},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpGetBuiltin, 0),
+					code.Make(code.OpArray, 0),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetBuiltin, 5),
+					code.Make(code.OpArray, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpCall, 2),
+					code.Make(code.OpPop),
+				},
+			},
+			{
+				input:             `let len = 1; len`,
+				expectedConstants: []interface{}{1},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetGlobal, 0),
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpPop),
+				},
+			},
+			{
+				input:             `upper?.("a")`,
+				expectedConstants: []interface{}{"a"},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpGetBuiltin, 9), // 0000
+					code.Make(code.OpJumpNull, 10),  // 0002
+					code.Make(code.OpConstant, 0),   // 0005
+					code.Make(code.OpCall, 1),       // 0008
+					code.Make(code.OpPop),           // 0010
+				},
+			},
+		}
+
+		runCompilerTests(t, tests)
+	})
+
+	t.Run("Functions", func(t *testing.T) {
+		tests := []compilerTestCase{
+			{
+				input: "fn() { return 5 + 10 }",
+				expectedConstants: []interface{}{
+					5,
+					10,
+					[]code.Instructions{
+						code.Make(code.OpConstant, 0),
+						code.Make(code.OpConstant, 1),
+						code.Make(code.OpAdd),
+						code.Make(code.OpReturnValue),
+					},
+				},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpClosure, 2, 0),
+					code.Make(code.OpPop),
+				},
+			},
+			{
+				input: "fn() { 1; 2 }",
+				expectedConstants: []interface{}{
+					1,
+					2,
+					[]code.Instructions{
+						code.Make(code.OpConstant, 0),
+						code.Make(code.OpPop),
+						code.Make(code.OpConstant, 1),
+						code.Make(code.OpReturnValue),
+					},
+				},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpClosure, 2, 0),
+					code.Make(code.OpPop),
+				},
+			},
+			{
+				input: "fn() { }",
+				expectedConstants: []interface{}{
+					[]code.Instructions{
+						code.Make(code.OpReturn),
+					},
+				},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpClosure, 0, 0),
+					code.Make(code.OpPop),
+				},
+			},
+			{
+				input: "let oneArg = fn(a) { a }; oneArg(24);",
+				expectedConstants: []interface{}{
+					[]code.Instructions{
+						code.Make(code.OpGetLocal, 0),
+						code.Make(code.OpReturnValue),
+					},
+					24,
+				},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpClosure, 0, 0),
+					code.Make(code.OpSetGlobal, 0),
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpPop),
+				},
+			},
+			{
+				input: "let num = 55; fn() { let a = num; a }",
+				expectedConstants: []interface{}{
+					55,
+					[]code.Instructions{
+						code.Make(code.OpGetGlobal, 0),
+						code.Make(code.OpSetLocal, 0),
+						code.Make(code.OpGetLocal, 0),
+						code.Make(code.OpReturnValue),
+					},
+				},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetGlobal, 0),
+					code.Make(code.OpClosure, 1, 0),
+					code.Make(code.OpPop),
+				},
+			},
+		}
+
+		runCompilerTests(t, tests)
+	})
+
+	t.Run("Closures", func(t *testing.T) {
+		tests := []compilerTestCase{
+			{
+				input: "fn(a) { fn(b) { a + b } }",
+				expectedConstants: []interface{}{
+					[]code.Instructions{
+						code.Make(code.OpGetFree, 0),
+						code.Make(code.OpGetLocal, 0),
+						code.Make(code.OpAdd),
+						code.Make(code.OpReturnValue),
+					},
+					[]code.Instructions{
+						code.Make(code.OpGetLocal, 0),
+						code.Make(code.OpClosure, 0, 1),
+						code.Make(code.OpReturnValue),
+					},
+				},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpClosure, 1, 0),
+					code.Make(code.OpPop),
+				},
+			},
+			{
+				input: "let countDown = fn(x) { countDown(x - 1); }; countDown(1);",
+				expectedConstants: []interface{}{
+					1,
+					[]code.Instructions{
+						code.Make(code.OpCurrentClosure),
+						code.Make(code.OpGetLocal, 0),
+						code.Make(code.OpConstant, 0),
+						code.Make(code.OpSubtract),
+						code.Make(code.OpTailCall, 1),
+						code.Make(code.OpReturnValue),
+					},
+					1,
+				},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpClosure, 1, 0),
+					code.Make(code.OpSetGlobal, 0),
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpPop),
+				},
+			},
+		}
+
+		runCompilerTests(t, tests)
+	})
+
+	t.Run("Tail calls", func(t *testing.T) {
+		tests := []compilerTestCase{
+			{
+				input: "fn(f) { if (f) { f(1) } else { return f(2) } }",
+				expectedConstants: []interface{}{
+					1,
+					2,
+					[]code.Instructions{
+						code.Make(code.OpGetLocal, 0),       // 0000
+						code.Make(code.OpJumpNotTruthy, 15), // 0002
+						code.Make(code.OpGetLocal, 0),       // 0005
+						code.Make(code.OpConstant, 0),       // 0007
+						code.Make(code.OpTailCall, 1),       // 0010
+						code.Make(code.OpJump, 23),          // 0012
+						code.Make(code.OpGetLocal, 0),       // 0015
+						code.Make(code.OpConstant, 1),       // 0017
+						code.Make(code.OpTailCall, 1),       // 0020
+						code.Make(code.OpReturnValue),       // 0022
+						code.Make(code.OpReturnValue),       // 0023
+					},
+				},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpClosure, 2, 0),
+					code.Make(code.OpPop),
+				},
+			},
+			{
+				input: "fn(f) { f(1) + 1 }",
+				expectedConstants: []interface{}{
+					1,
+					1,
+					[]code.Instructions{
+						code.Make(code.OpGetLocal, 0),
+						code.Make(code.OpConstant, 0),
+						code.Make(code.OpCall, 1),
+						code.Make(code.OpConstant, 1),
+						code.Make(code.OpAdd),
+						code.Make(code.OpReturnValue),
+					},
+				},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpClosure, 2, 0),
+					code.Make(code.OpPop),
+				},
+			},
+			{
+				input: "fn(f) { try { return f(1) } catch (e) { 2 } }",
+				expectedConstants: []interface{}{
+					1,
+					2,
+					[]code.Instructions{
+						code.Make(code.OpGetLocal, 0), // 0000
+						code.Make(code.OpConstant, 0), // 0002
+						code.Make(code.OpCall, 1),     // 0005
+						code.Make(code.OpReturnValue), // 0007
+						code.Make(code.OpNull),        // 0008
+						code.Make(code.OpJump, 20),    // 0009
+						code.Make(code.OpSetLocal, 1), // 0012
+						code.Make(code.OpConstant, 1), // 0014
+						code.Make(code.OpJump, 20),    // 0017
+						code.Make(code.OpReturnValue), // 0020
+					},
+				},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpClosure, 2, 0),
+					code.Make(code.OpPop),
+				},
+			},
+		}
+
+		runCompilerTests(t, tests)
+	})
+
+	t.Run("Null and optional chaining", func(t *testing.T) {
+		tests := []compilerTestCase{
+			{
+				input:             "null",
+				expectedConstants: []interface{}{},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpNull),
+					code.Make(code.OpPop),
+				},
+			},
+			{
+				input:             "1 ?? 2",
+				expectedConstants: []interface{}{1, 2},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpConstant, 0),     // 0000
+					code.Make(code.OpJumpNotNull, 10), // 0003
+					code.Make(code.OpPop),             // 0006
+					code.Make(code.OpConstant, 1),     // 0007
+					code.Make(code.OpPop),             // 0010
+				},
+			},
+			{
+				input:             "[1]?.[0]",
+				expectedConstants: []interface{}{1, 0},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpConstant, 0),  // 0000
+					code.Make(code.OpArray, 1),     // 0003
+					code.Make(code.OpJumpNull, 13), // 0006
+					code.Make(code.OpConstant, 1),  // 0009
+					code.Make(code.OpIndex),        // 0012
+					code.Make(code.OpPop),          // 0013
+				},
+			},
+			{
+				input:             "[1]?.[0][1]",
+				expectedConstants: []interface{}{1, 0, 1},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpConstant, 0),  // 0000
+					code.Make(code.OpArray, 1),     // 0003
+					code.Make(code.OpJumpNull, 17), // 0006
+					code.Make(code.OpConstant, 1),  // 0009
+					code.Make(code.OpIndex),        // 0012
+					code.Make(code.OpConstant, 2),  // 0013
+					code.Make(code.OpIndex),        // 0016
+					code.Make(code.OpPop),          // 0017
+				},
+			},
+		}
+
+		runCompilerTests(t, tests)
+	})
+
+	t.Run("Try expressions", func(t *testing.T) {
+		tests := []compilerTestCase{
+			{
+				input:             "try { 1 } catch (e) { 2 }",
+				expectedConstants: []interface{}{1, 2},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpConstant, 0),  // 0000
+					code.Make(code.OpJump, 15),     // 0003
+					code.Make(code.OpSetGlobal, 0), // 0006
+					code.Make(code.OpConstant, 1),  // 0009
+					code.Make(code.OpJump, 15),     // 0012
+					code.Make(code.OpPop),          // 0015
+				},
+				expectedHandlers: []object.ExceptionHandler{
+					{Start: 0, End: 3, Target: 6, StackDepth: 0},
+				},
+			},
+			{
+				input:             "try { 1 } catch (e) { 2 } finally { 3 }",
+				expectedConstants: []interface{}{1, 3, 2, 3, 3},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpConstant, 0),  // 0000
+					code.Make(code.OpConstant, 1),  // 0003
+					code.Make(code.OpPop),          // 0006
+					code.Make(code.OpJump, 28),     // 0007
+					code.Make(code.OpSetGlobal, 0), // 0010
+					code.Make(code.OpConstant, 2),  // 0013
+					code.Make(code.OpConstant, 3),  // 0016
+					code.Make(code.OpPop),          // 0019
+					code.Make(code.OpJump, 28),     // 0020
+					code.Make(code.OpConstant, 4),  // 0023
+					code.Make(code.OpPop),          // 0026
+					code.Make(code.OpThrow),        // 0027
+					code.Make(code.OpPop),          // 0028
+				},
+				expectedHandlers: []object.ExceptionHandler{
+					{Start: 0, End: 3, Target: 10, StackDepth: 0},
+					{Start: 10, End: 16, Target: 23, StackDepth: 0},
+				},
+			},
+			{
+				input:             "1 + try { 2 } finally { }",
+				expectedConstants: []interface{}{1, 2},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpConstant, 0), // 0000
+					code.Make(code.OpConstant, 1), // 0003
+					code.Make(code.OpJump, 10),    // 0006
+					code.Make(code.OpThrow),       // 0009
+					code.Make(code.OpAdd),         // 0010
+					code.Make(code.OpPop),         // 0011
+				},
+				expectedHandlers: []object.ExceptionHandler{
+					{Start: 3, End: 6, Target: 9, StackDepth: 1},
+				},
+			},
+			{
+				input:             `throw "boom"`,
+				expectedConstants: []interface{}{"boom"},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpConstant, 0), // 0000
+					code.Make(code.OpThrow),       // 0003
+				},
+			},
+		}
+
+		runCompilerTests(t, tests)
+	})
+
+	t.Run("Error propagation", func(t *testing.T) {
+		tests := []compilerTestCase{
+			{
+				input: "fn(x) { x? }",
+				expectedConstants: []interface{}{
+					[]code.Instructions{
+						code.Make(code.OpGetLocal, 0),     // 0000
+						code.Make(code.OpJumpNotError, 6), // 0002
+						code.Make(code.OpReturnValue),     // 0005
+						code.Make(code.OpReturnValue),     // 0006
+					},
+				},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpClosure, 0, 0), // 0000
+					code.Make(code.OpPop),           // 0004
+				},
+			},
+		}
+
+		runCompilerTests(t, tests)
+	})
+}
+
+func TestBytecode(t *testing.T) {
+	symbolTable := NewSymbolTable()
+	constants := []object.Object{}
+
+	compiler := NewCompilerWithState(symbolTable, constants)
+	if err := compiler.Compile(parse("let a = 1; let f = fn(x) { let y = x; y }; let a = 2;")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	first := compiler.Bytecode()
+	if first.NumGlobals != 2 {
+		t.Errorf("wrong number of globals. Want 2, got %d", first.NumGlobals)
+	}
+	if cap(first.Constants) != len(first.Constants) || cap(first.Instructions) != len(first.Instructions) {
+		t.Errorf("bytecode slices must be capped at their length")
+	}
+
+	compiler = NewCompilerWithState(symbolTable, first.Constants)
+	if err := compiler.Compile(parse("let b = 3;")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	second := compiler.Bytecode()
+	if second.NumGlobals != 3 {
+		t.Errorf("wrong number of globals. Want 3, got %d", second.NumGlobals)
+	}
+
+	// compiling from the same constants again must not overwrite the
+	// constants of the second bytecode
+	compiler = NewCompilerWithState(symbolTable, first.Constants)
+	if err := compiler.Compile(parse("let c = 4;")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	assertConstants(t, []interface{}{1, []code.Instructions{
+		code.Make(code.OpGetLocal, 0),
+		code.Make(code.OpSetLocal, 1),
+		code.Make(code.OpGetLocal, 1),
+		code.Make(code.OpReturnValue),
+	}, 2, 3}, second.Constants)
 }
 
 func runCompilerTests(t *testing.T, tests []compilerTestCase) {
@@ -426,6 +1082,21 @@ func runCompilerTests(t *testing.T, tests []compilerTestCase) {
 
 		assertInstructions(t, test.expectedInstructions, bytecode.Instructions)
 		assertConstants(t, test.expectedConstants, bytecode.Constants)
+		assertHandlers(t, test.expectedHandlers, bytecode.Handlers)
+	}
+}
+
+func assertHandlers(t *testing.T, expected, actual []object.ExceptionHandler) {
+	t.Helper()
+
+	if len(actual) != len(expected) {
+		t.Fatalf("wrong number of handlers. Want %d, got %d: %+v", len(expected), len(actual), actual)
+	}
+
+	for i, handler := range expected {
+		if actual[i] != handler {
+			t.Errorf("wrong handler at %d. Want %+v, got %+v", i, handler, actual[i])
+		}
 	}
 }
 
@@ -480,6 +1151,13 @@ func assertConstants(t *testing.T, expected []interface{}, actual []object.Objec
 			assertIntegerObject(t, actual[i], int64(expectedConstant))
 		case string:
 			assertStringObject(t, actual[i], expectedConstant)
+		case []code.Instructions:
+			function, ok := actual[i].(*object.CompiledFunction)
+			if !ok {
+				t.Fatalf("Constant %d is not a function. Got %T", i, actual[i])
+			}
+
+			assertInstructions(t, expectedConstant, function.Instructions)
 		}
 	}
 }
@@ -511,3 +1189,25 @@ func assertStringObject(t *testing.T, actual object.Object, expected string) {
 		t.Errorf("Object has wrong value: Expected %s, got %s", expected, stringObject.Value)
 	}
 }
+
+func TestCompilerErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = a; a + 1", "undefined variable: a"},
+		{"fn() { let b = [b] }", "undefined variable: b"},
+		{"missing(1)", "undefined variable: missing"},
+	}
+
+	for _, test := range tests {
+		err := NewCompiler().Compile(parse(test.input))
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("%s: wrong error. Want %q, got %v", test.input, test.expected, err)
+		}
+	}
+
+	if err := NewCompiler().Compile(parse("let f = fn(n) { f(n) }; let a = 1; let a = a + 1;")); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
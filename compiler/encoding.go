@@ -0,0 +1,364 @@
+package compiler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+
+	"github.com/nhoffmann/monkey/code"
+	"github.com/nhoffmann/monkey/object"
+)
+
+// bytecodeMagic starts every encoded program, bytecodeVersion is incremented
+// whenever the format or the meaning of the instructions changes.
+const (
+	bytecodeMagic   = "MKBC"
+	bytecodeVersion = 1
+)
+
+// Tags of the encoded constants.
+const (
+	integerTag byte = iota + 1
+	bigIntegerTag
+	stringTag
+	functionTag
+	moduleTag
+)
+
+// Encode writes bytecode to w in the binary format Decode reads. symbolTable
+// is the global table the bytecode was compiled with, its globals are written
+// along with the bytecode so programs compiled later can refer to them. The
+// builtins the table refers to are recorded too, the registry the bytecode is
+// decoded with has to hold them at the same indices.
+//
+// Integers are written as varints, everything else the bytecode is made of is
+// prefixed by its length or count, so the same bytecode is always encoded the
+// same way.
+func Encode(w io.Writer, bytecode *Bytecode, symbolTable *SymbolTable) error {
+	e := &encoder{}
+	e.buf.WriteString(bytecodeMagic)
+	e.uint(bytecodeVersion)
+
+	builtins, globals := []Symbol{}, []Symbol{}
+	for _, symbol := range symbolTable.programTable().store {
+		switch symbol.Scope {
+		case BuiltinScope:
+			builtins = append(builtins, symbol)
+		case GlobalScope:
+			globals = append(globals, symbol)
+		}
+	}
+
+	for _, symbols := range [][]Symbol{builtins, globals} {
+		sort.Slice(symbols, func(i, j int) bool { return symbols[i].Index < symbols[j].Index })
+
+		e.uint(len(symbols))
+		for _, symbol := range symbols {
+			e.string(symbol.Name)
+			e.uint(symbol.Index)
+		}
+	}
+
+	e.uint(bytecode.NumGlobals)
+	e.bytes(bytecode.Instructions)
+	e.handlers(bytecode.Handlers)
+
+	e.uint(len(bytecode.Constants))
+	for _, constant := range bytecode.Constants {
+		if err := e.constant(constant); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write(e.buf.Bytes())
+	return err
+}
+
+// Decode reads bytecode written by Encode. It returns the bytecode together
+// with a global symbol table holding the builtins of builtins and the globals
+// and compiled modules of the encoded program, to compile further programs
+// sharing its globals with.
+func Decode(r io.Reader, builtins *object.Registry) (*Bytecode, *SymbolTable, error) {
+	d := &decoder{r: bufio.NewReader(r)}
+
+	magic := make([]byte, len(bytecodeMagic))
+	if _, err := io.ReadFull(d.r, magic); err != nil || string(magic) != bytecodeMagic {
+		return nil, nil, errors.New("invalid bytecode: not a compiled Monkey program")
+	}
+	if version := d.uint(); d.err == nil && version != bytecodeVersion {
+		return nil, nil, fmt.Errorf("invalid bytecode: unsupported version %d", version)
+	}
+
+	symbolTable := NewSymbolTableWithBuiltins(builtins)
+
+	for i, count := 0, d.uint(); i < count && d.err == nil; i++ {
+		name, index := d.string(), d.uint()
+		if builtin := builtins.Get(index); d.err == nil && (builtin == nil || builtin.Name != name) {
+			return nil, nil, fmt.Errorf("invalid bytecode: builtin %s is not registered at index %d", name, index)
+		}
+	}
+
+	globals := []Symbol{}
+	for i, count := 0, d.uint(); i < count && d.err == nil; i++ {
+		globals = append(globals, Symbol{Name: d.string(), Scope: GlobalScope, Index: d.uint()})
+	}
+
+	bytecode := &Bytecode{NumGlobals: d.uint()}
+	bytecode.Instructions = d.bytes()
+	bytecode.Handlers = d.handlers()
+
+	for i, count := 0, d.uint(); i < count && d.err == nil; i++ {
+		bytecode.Constants = append(bytecode.Constants, d.constant())
+	}
+
+	if d.err != nil {
+		return nil, nil, fmt.Errorf("invalid bytecode: %w", d.err)
+	}
+
+	for _, symbol := range globals {
+		if symbol.Index >= bytecode.NumGlobals {
+			return nil, nil, fmt.Errorf("invalid bytecode: global %s out of range", symbol.Name)
+		}
+		symbolTable.store[symbol.Name] = symbol
+	}
+	symbolTable.numberDefinitions = bytecode.NumGlobals
+
+	for _, constant := range bytecode.Constants {
+		if module, ok := constant.(*object.CompiledModule); ok {
+			if symbolTable.modules == nil {
+				symbolTable.modules = map[string]*object.CompiledModule{}
+			}
+			if _, ok := symbolTable.modules[module.Name]; !ok {
+				symbolTable.modules[module.Name] = module
+			}
+		}
+	}
+
+	return bytecode, symbolTable, nil
+}
+
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) uint(value int) {
+	var buf [binary.MaxVarintLen64]byte
+	e.buf.Write(buf[:binary.PutUvarint(buf[:], uint64(value))])
+}
+
+func (e *encoder) int(value int64) {
+	var buf [binary.MaxVarintLen64]byte
+	e.buf.Write(buf[:binary.PutVarint(buf[:], value)])
+}
+
+func (e *encoder) bytes(value []byte) {
+	e.uint(len(value))
+	e.buf.Write(value)
+}
+
+func (e *encoder) string(value string) {
+	e.uint(len(value))
+	e.buf.WriteString(value)
+}
+
+func (e *encoder) bool(value bool) {
+	if value {
+		e.buf.WriteByte(1)
+	} else {
+		e.buf.WriteByte(0)
+	}
+}
+
+func (e *encoder) handlers(handlers []object.ExceptionHandler) {
+	e.uint(len(handlers))
+	for _, handler := range handlers {
+		e.uint(handler.Start)
+		e.uint(handler.End)
+		e.uint(handler.Target)
+		e.uint(handler.StackDepth)
+	}
+}
+
+func (e *encoder) function(fn *object.CompiledFunction) {
+	e.bytes(fn.Instructions)
+	e.uint(fn.NumLocals)
+	e.uint(fn.NumParameters)
+	e.string(fn.Name)
+	e.handlers(fn.Handlers)
+	e.bool(fn.Generator)
+}
+
+func (e *encoder) constant(constant object.Object) error {
+	switch constant := constant.(type) {
+	case *object.Integer:
+		e.buf.WriteByte(integerTag)
+		e.int(constant.Value)
+	case *object.BigInteger:
+		e.buf.WriteByte(bigIntegerTag)
+		e.string(constant.Value.String())
+	case *object.String:
+		e.buf.WriteByte(stringTag)
+		e.string(constant.Value)
+	case *object.CompiledFunction:
+		e.buf.WriteByte(functionTag)
+		e.function(constant)
+	case *object.CompiledModule:
+		e.buf.WriteByte(moduleTag)
+		e.string(constant.Name)
+		e.function(constant.Body)
+		e.uint(constant.Slot)
+		e.uint(len(constant.Exports))
+		for _, export := range constant.Exports {
+			e.string(export.Name)
+			e.uint(export.Global)
+		}
+	default:
+		return fmt.Errorf("cannot encode constant of type %s", constant.Type())
+	}
+
+	return nil
+}
+
+// decoder reads what encoder wrote. Once reading fails, err is set and all
+// further reads return zero values, so it only has to be checked at the end.
+type decoder struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (d *decoder) fail(err error) {
+	if d.err != nil {
+		return
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	d.err = err
+}
+
+func (d *decoder) uint() int {
+	if d.err != nil {
+		return 0
+	}
+
+	value, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		d.fail(err)
+		return 0
+	}
+	if value > uint64(int(^uint(0)>>1)) {
+		d.fail(errors.New("value out of range"))
+		return 0
+	}
+
+	return int(value)
+}
+
+func (d *decoder) int() int64 {
+	if d.err != nil {
+		return 0
+	}
+
+	value, err := binary.ReadVarint(d.r)
+	if err != nil {
+		d.fail(err)
+	}
+
+	return value
+}
+
+// bytes reads a length prefixed byte slice. The slice grows while it is read,
+// so a corrupted length cannot allocate more memory than the input holds.
+func (d *decoder) bytes() []byte {
+	length := d.uint()
+	if d.err != nil {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, d.r, int64(length)); err != nil {
+		d.fail(err)
+		return nil
+	}
+
+	return buf.Bytes()
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) bool() bool {
+	if d.err != nil {
+		return false
+	}
+
+	value, err := d.r.ReadByte()
+	if err != nil {
+		d.fail(err)
+	}
+
+	return value != 0
+}
+
+func (d *decoder) handlers() []object.ExceptionHandler {
+	var handlers []object.ExceptionHandler
+	for i, count := 0, d.uint(); i < count && d.err == nil; i++ {
+		handlers = append(handlers, object.ExceptionHandler{
+			Start:      d.uint(),
+			End:        d.uint(),
+			Target:     d.uint(),
+			StackDepth: d.uint(),
+		})
+	}
+
+	return handlers
+}
+
+func (d *decoder) function() *object.CompiledFunction {
+	return &object.CompiledFunction{
+		Instructions:  code.Instructions(d.bytes()),
+		NumLocals:     d.uint(),
+		NumParameters: d.uint(),
+		Name:          d.string(),
+		Handlers:      d.handlers(),
+		Generator:     d.bool(),
+	}
+}
+
+func (d *decoder) constant() object.Object {
+	tag, err := d.r.ReadByte()
+	if err != nil {
+		d.fail(err)
+		return nil
+	}
+
+	switch tag {
+	case integerTag:
+		return &object.Integer{Value: d.int()}
+	case bigIntegerTag:
+		value, ok := new(big.Int).SetString(d.string(), 10)
+		if !ok {
+			d.fail(errors.New("malformed integer constant"))
+		}
+		return &object.BigInteger{Value: value}
+	case stringTag:
+		return &object.String{Value: d.string()}
+	case functionTag:
+		return d.function()
+	case moduleTag:
+		module := &object.CompiledModule{Name: d.string(), Body: d.function(), Slot: d.uint()}
+		for i, count := 0, d.uint(); i < count && d.err == nil; i++ {
+			module.Exports = append(module.Exports, object.ModuleExport{Name: d.string(), Global: d.uint()})
+		}
+		return module
+	}
+
+	d.fail(fmt.Errorf("unknown constant tag %d", tag))
+	return nil
+}
@@ -0,0 +1,108 @@
+package compiler
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nhoffmann/monkey/object"
+)
+
+func TestEncoding(t *testing.T) {
+	registry := object.NewRegistry()
+	symbolTable := NewSymbolTableWithBuiltins(registry)
+
+	c := NewCompilerWithState(symbolTable, []object.Object{})
+	c.SetModuleLoader(object.NewModuleLoader("../testdata/modules"))
+	err := c.Compile(parse(`
+		let math = import "math";
+		let big = 99999999999999999999;
+		let safe = fn(x) { try { len(x) } catch (e) { "none" } };
+		let numbers = fn() { yield -1; yield 2 };
+	`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := c.Bytecode()
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, bytecode, symbolTable); err != nil {
+		t.Fatalf("encoding failed: %s", err)
+	}
+
+	var again bytes.Buffer
+	if err := Encode(&again, bytecode, symbolTable); err != nil || !bytes.Equal(buf.Bytes(), again.Bytes()) {
+		t.Errorf("encoding should be deterministic")
+	}
+
+	decoded, decodedTable, err := Decode(bytes.NewReader(buf.Bytes()), registry)
+	if err != nil {
+		t.Fatalf("decoding failed: %s", err)
+	}
+
+	if !reflect.DeepEqual(decoded, bytecode) {
+		t.Errorf("wrong bytecode.\nWant %#v\ngot  %#v", bytecode, decoded)
+	}
+
+	for _, name := range []string{"math", "big", "safe", "numbers", "len"} {
+		expected, _ := symbolTable.Resolve(name)
+		symbol, ok := decodedTable.Resolve(name)
+		if !ok || symbol != expected {
+			t.Errorf("wrong symbol %s. Want %+v, got %+v", name, expected, symbol)
+		}
+	}
+
+	// programs compiled later share the globals and modules of the decoded
+	// program
+	c = NewCompilerWithState(decodedTable, decoded.Constants)
+	c.SetModuleLoader(object.NewModuleLoader("../testdata/modules"))
+	if err := c.Compile(parse(`let next = import "math";`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	next := c.Bytecode()
+	if next.NumGlobals != bytecode.NumGlobals+1 {
+		t.Errorf("wrong number of globals. Want %d, got %d", bytecode.NumGlobals+1, next.NumGlobals)
+	}
+
+	module, ok := next.Constants[len(next.Constants)-1].(*object.CompiledModule)
+	if !ok || module != decodedTable.modules["math"] || module.Slot >= bytecode.NumGlobals {
+		t.Errorf("module compiled again")
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		other := object.NewRegistry()
+		if err := other.Register("double", 1, "", nil); err != nil {
+			t.Fatal(err)
+		}
+
+		var withDouble bytes.Buffer
+		if err := Encode(&withDouble, bytecode, NewSymbolTableWithBuiltins(other)); err != nil {
+			t.Fatalf("encoding failed: %s", err)
+		}
+
+		data := buf.Bytes()
+		tests := []struct {
+			input    []byte
+			expected string
+		}{
+			{[]byte("let a = 1;"), "invalid bytecode: not a compiled Monkey program"},
+			{append([]byte("MKBC"), 2), "invalid bytecode: unsupported version 2"},
+			{data[:len(data)-3], "invalid bytecode: unexpected EOF"},
+			{withDouble.Bytes(), "invalid bytecode: builtin double is not registered at index"},
+		}
+
+		for _, test := range tests {
+			_, _, err := Decode(bytes.NewReader(test.input), registry)
+			if err == nil || !strings.HasPrefix(err.Error(), test.expected) {
+				t.Errorf("wrong error. Want %q, got %v", test.expected, err)
+			}
+		}
+
+		err := Encode(&bytes.Buffer{}, &Bytecode{Constants: []object.Object{object.True}}, symbolTable)
+		if err == nil || err.Error() != "cannot encode constant of type BOOLEAN" {
+			t.Errorf("wrong error, got %v", err)
+		}
+	})
+}
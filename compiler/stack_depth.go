@@ -0,0 +1,96 @@
+package compiler
+
+import (
+	"github.com/nhoffmann/monkey/code"
+	"github.com/nhoffmann/monkey/object"
+)
+
+// pendingHandler is an exception handler whose stack depth is not yet known.
+// The depth is the one at tryPosition, the first instruction of the try block.
+type pendingHandler struct {
+	start, end, target int
+	tryPosition        int
+}
+
+// resolveHandlers computes the stack depth of every handler by following all
+// paths through the instructions, including those entered through handlers.
+func resolveHandlers(instructions code.Instructions, pending []pendingHandler) []object.ExceptionHandler {
+	if len(pending) == 0 {
+		return nil
+	}
+
+	depths := map[int]int{0: 0}
+	work := []int{0}
+
+	for len(work) > 0 {
+		for len(work) > 0 {
+			position := work[len(work)-1]
+			work = work[:len(work)-1]
+
+			for _, next := range successors(instructions, position, depths[position]) {
+				if _, seen := depths[next.position]; !seen {
+					depths[next.position] = next.depth
+					work = append(work, next.position)
+				}
+			}
+		}
+
+		// handler targets are only reachable once the depth at the start of
+		// their try block is known
+		for _, handler := range pending {
+			depth, ok := depths[handler.tryPosition]
+			if _, seen := depths[handler.target]; ok && !seen {
+				depths[handler.target] = depth + 1
+				work = append(work, handler.target)
+			}
+		}
+	}
+
+	handlers := make([]object.ExceptionHandler, len(pending))
+	for i, handler := range pending {
+		handlers[i] = object.ExceptionHandler{
+			Start:      handler.start,
+			End:        handler.end,
+			Target:     handler.target,
+			StackDepth: depths[handler.tryPosition],
+		}
+	}
+
+	return handlers
+}
+
+type instructionDepth struct {
+	position int
+	depth    int
+}
+
+// successors returns the instructions that may execute after the one at
+// position together with the stack depth they start with.
+func successors(instructions code.Instructions, position, depth int) []instructionDepth {
+	if position >= len(instructions) {
+		return nil
+	}
+
+	op := code.Opcode(instructions[position])
+
+	definition, err := code.Lookup(byte(op))
+	if err != nil {
+		return nil
+	}
+
+	operands, read := code.ReadOperands(definition, instructions[position+1:])
+	next := instructionDepth{position + 1 + read, depth + code.StackEffect(op, operands)}
+
+	switch op {
+	case code.OpJump:
+		return []instructionDepth{{operands[0], depth}}
+	case code.OpJumpNotTruthy, code.OpJumpNull, code.OpJumpNotNull, code.OpJumpNotError:
+		return []instructionDepth{next, {operands[0], next.depth}}
+	case code.OpIterNext:
+		return []instructionDepth{next, {operands[0], depth - 1}}
+	case code.OpReturnValue, code.OpReturn, code.OpThrow:
+		return nil
+	}
+
+	return []instructionDepth{next}
+}
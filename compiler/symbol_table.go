@@ -1,9 +1,15 @@
 package compiler
 
+import "github.com/nhoffmann/monkey/object"
+
 type SymbolScope string
 
 const (
-	GlobalScope SymbolScope = "GLOBAL"
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	BuiltinScope  SymbolScope = "BUILTIN"
+	FreeScope     SymbolScope = "FREE"
+	FunctionScope SymbolScope = "FUNCTION"
 )
 
 type Symbol struct {
@@ -13,23 +19,174 @@ type Symbol struct {
 }
 
 type SymbolTable struct {
+	Outer *SymbolTable
+
 	store             map[string]Symbol
 	numberDefinitions int
+
+	FreeSymbols []Symbol
+
+	// program is set for the global tables of imported modules, their
+	// globals are allocated in the table of the program importing them
+	program *SymbolTable
+	// modules holds the modules compiled into the program, it is only used
+	// by the global table of the program
+	modules map[string]*object.CompiledModule
 }
 
 func NewSymbolTable() *SymbolTable {
 	s := make(map[string]Symbol)
-	return &SymbolTable{store: s}
+	free := []Symbol{}
+	return &SymbolTable{store: s, FreeSymbols: free}
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	st := NewSymbolTable()
+	st.Outer = outer
+	return st
 }
 
+// Define defines a global or local symbol. Defining a name twice in the same
+// table reuses its slot, like the evaluator overwrites the binding. This also
+// keeps the slots stable when a block, like finally, is compiled more than
+// once.
 func (st *SymbolTable) Define(symbolName string) Symbol {
-	symbol := Symbol{Name: symbolName, Scope: GlobalScope, Index: st.numberDefinitions}
+	symbol := Symbol{Name: symbolName, Index: st.numberDefinitions}
+	if st.Outer == nil {
+		symbol.Scope = GlobalScope
+	} else {
+		symbol.Scope = LocalScope
+	}
+
+	if existing, ok := st.store[symbolName]; ok && existing.Scope == symbol.Scope {
+		return existing
+	}
+
+	if st.program != nil {
+		symbol.Index = st.program.defineSlot()
+	} else {
+		st.numberDefinitions++
+	}
+
 	st.store[symbolName] = symbol
-	st.numberDefinitions++
 	return symbol
 }
 
+// NewModuleSymbolTable creates the global table of a module imported by the
+// program of the global table program. The module has globals of its own,
+// which are allocated in the globals of the program so they do not collide
+// with the globals of the program or other modules. The builtins of the
+// program are defined in the module as well.
+func NewModuleSymbolTable(program *SymbolTable) *SymbolTable {
+	program = program.programTable()
+
+	st := NewSymbolTable()
+	st.program = program
+	for name, symbol := range program.store {
+		if symbol.Scope == BuiltinScope {
+			st.store[name] = symbol
+		}
+	}
+
+	return st
+}
+
+// Copy returns a copy of the global table st. Symbols and modules defined in
+// the copy are not defined in st, so a failed compilation can be discarded.
+func (st *SymbolTable) Copy() *SymbolTable {
+	table := NewSymbolTable()
+	table.numberDefinitions = st.numberDefinitions
+	table.program = st.program
+
+	for name, symbol := range st.store {
+		table.store[name] = symbol
+	}
+
+	if st.modules != nil {
+		table.modules = make(map[string]*object.CompiledModule, len(st.modules))
+		for name, module := range st.modules {
+			table.modules[name] = module
+		}
+	}
+
+	return table
+}
+
+// defineSlot allocates a global which has no name in the table.
+func (st *SymbolTable) defineSlot() int {
+	index := st.numberDefinitions
+	st.numberDefinitions++
+	return index
+}
+
+// programTable returns the global table of the program being compiled.
+func (st *SymbolTable) programTable() *SymbolTable {
+	for st.Outer != nil {
+		st = st.Outer
+	}
+	if st.program != nil {
+		return st.program
+	}
+
+	return st
+}
+
+// NumGlobals returns the number of globals defined in the outermost table,
+// including the globals of the modules imported by the program.
+func (st *SymbolTable) NumGlobals() int {
+	return st.programTable().numberDefinitions
+}
+
 func (st *SymbolTable) Resolve(symbolName string) (Symbol, bool) {
 	symbol, ok := st.store[symbolName]
+	if !ok && st.Outer != nil {
+		symbol, ok = st.Outer.Resolve(symbolName)
+		if !ok {
+			return symbol, ok
+		}
+
+		if symbol.Scope == GlobalScope || symbol.Scope == BuiltinScope {
+			return symbol, ok
+		}
+
+		free := st.defineFree(symbol)
+		return free, true
+	}
+
 	return symbol, ok
 }
+
+// NewSymbolTableWithBuiltins creates a global symbol table with the builtins
+// of the given registry defined.
+func NewSymbolTableWithBuiltins(builtins *object.Registry) *SymbolTable {
+	st := NewSymbolTable()
+	for i, builtin := range builtins.Builtins() {
+		st.DefineBuiltin(i, builtin.Name)
+	}
+
+	return st
+}
+
+func (st *SymbolTable) DefineBuiltin(index int, symbolName string) Symbol {
+	symbol := Symbol{Name: symbolName, Scope: BuiltinScope, Index: index}
+	st.store[symbolName] = symbol
+	return symbol
+}
+
+// DefineFunctionName makes the name of the function currently being compiled
+// resolvable within its own body, which allows closures to call themselves.
+func (st *SymbolTable) DefineFunctionName(symbolName string) Symbol {
+	symbol := Symbol{Name: symbolName, Scope: FunctionScope, Index: 0}
+	st.store[symbolName] = symbol
+	return symbol
+}
+
+func (st *SymbolTable) defineFree(original Symbol) Symbol {
+	st.FreeSymbols = append(st.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Index: len(st.FreeSymbols) - 1}
+	symbol.Scope = FreeScope
+
+	st.store[original.Name] = symbol
+	return symbol
+}
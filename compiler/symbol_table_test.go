@@ -22,6 +22,59 @@ func TestSymbolTable(t *testing.T) {
 		}
 	})
 
+	t.Run("Redefine", func(t *testing.T) {
+		global := NewSymbolTable()
+		global.Define("a")
+		global.Define("b")
+
+		expected := Symbol{Name: "a", Scope: GlobalScope, Index: 0}
+		if a := global.Define("a"); a != expected {
+			t.Errorf("Expected a=%+v, got %+v", expected, a)
+		}
+
+		local := NewEnclosedSymbolTable(global)
+		expected = Symbol{Name: "a", Scope: LocalScope, Index: 0}
+		if a := local.Define("a"); a != expected {
+			t.Errorf("Expected a=%+v, got %+v", expected, a)
+		}
+	})
+
+	t.Run("Module tables", func(t *testing.T) {
+		program := NewSymbolTable()
+		program.DefineBuiltin(0, "len")
+		program.Define("a")
+
+		module := NewModuleSymbolTable(program)
+		expected := Symbol{Name: "a", Scope: GlobalScope, Index: 1}
+		if a := module.Define("a"); a != expected {
+			t.Errorf("Expected a=%+v, got %+v", expected, a)
+		}
+
+		local := NewEnclosedSymbolTable(module)
+		if a, ok := local.Resolve("a"); !ok || a != expected {
+			t.Errorf("Expected a=%+v, got %+v", expected, a)
+		}
+
+		nested := NewModuleSymbolTable(module)
+		expected = Symbol{Name: "b", Scope: GlobalScope, Index: 2}
+		if b := nested.Define("b"); b != expected {
+			t.Errorf("Expected b=%+v, got %+v", expected, b)
+		}
+
+		if _, ok := nested.Resolve("a"); ok {
+			t.Errorf("Expected globals of other modules not to resolve")
+		}
+
+		expected = Symbol{Name: "len", Scope: BuiltinScope, Index: 0}
+		if builtin, ok := nested.Resolve("len"); !ok || builtin != expected {
+			t.Errorf("Expected len=%+v, got %+v", expected, builtin)
+		}
+
+		if program.NumGlobals() != 3 || local.NumGlobals() != 3 {
+			t.Errorf("Expected 3 globals, got %d", program.NumGlobals())
+		}
+	})
+
 	t.Run("Resolve global", func(t *testing.T) {
 		global := NewSymbolTable()
 		global.Define("a")
@@ -50,4 +103,170 @@ func TestSymbolTable(t *testing.T) {
 			}
 		}
 	})
+
+	t.Run("Define and resolve builtins", func(t *testing.T) {
+		global := NewSymbolTable()
+
+		expected := []Symbol{
+			Symbol{Name: "a", Scope: BuiltinScope, Index: 0},
+			Symbol{Name: "c", Scope: BuiltinScope, Index: 1},
+			Symbol{Name: "e", Scope: BuiltinScope, Index: 2},
+		}
+
+		for i, symbol := range expected {
+			global.DefineBuiltin(i, symbol.Name)
+		}
+
+		for _, symbol := range expected {
+			result, ok := global.Resolve(symbol.Name)
+
+			if !ok {
+				t.Errorf("Could not resolve name: %s", symbol.Name)
+				continue
+			}
+
+			if result != symbol {
+				t.Errorf(
+					"Expected %s to resolve to %+v, got %+v",
+					symbol.Name,
+					symbol,
+					result,
+				)
+			}
+		}
+	})
+
+	t.Run("Resolve nested locals", func(t *testing.T) {
+		global := NewSymbolTable()
+		global.Define("a")
+		global.Define("b")
+
+		firstLocal := NewEnclosedSymbolTable(global)
+		firstLocal.Define("c")
+		firstLocal.Define("d")
+
+		secondLocal := NewEnclosedSymbolTable(firstLocal)
+		secondLocal.Define("e")
+		secondLocal.Define("f")
+
+		tests := []struct {
+			table           *SymbolTable
+			expectedSymbols []Symbol
+		}{
+			{
+				firstLocal,
+				[]Symbol{
+					Symbol{Name: "a", Scope: GlobalScope, Index: 0},
+					Symbol{Name: "b", Scope: GlobalScope, Index: 1},
+					Symbol{Name: "c", Scope: LocalScope, Index: 0},
+					Symbol{Name: "d", Scope: LocalScope, Index: 1},
+				},
+			},
+			{
+				secondLocal,
+				[]Symbol{
+					Symbol{Name: "a", Scope: GlobalScope, Index: 0},
+					Symbol{Name: "b", Scope: GlobalScope, Index: 1},
+					Symbol{Name: "e", Scope: LocalScope, Index: 0},
+					Symbol{Name: "f", Scope: LocalScope, Index: 1},
+				},
+			},
+		}
+
+		for _, test := range tests {
+			for _, symbol := range test.expectedSymbols {
+				result, ok := test.table.Resolve(symbol.Name)
+				if !ok {
+					t.Errorf("Could not resolve name: %s", symbol.Name)
+					continue
+				}
+
+				if result != symbol {
+					t.Errorf("Expected %s to resolve to %+v, got %+v", symbol.Name, symbol, result)
+				}
+			}
+		}
+	})
+
+	t.Run("Resolve free", func(t *testing.T) {
+		global := NewSymbolTable()
+		global.Define("a")
+
+		firstLocal := NewEnclosedSymbolTable(global)
+		firstLocal.Define("c")
+
+		secondLocal := NewEnclosedSymbolTable(firstLocal)
+		secondLocal.Define("e")
+
+		expected := []Symbol{
+			Symbol{Name: "a", Scope: GlobalScope, Index: 0},
+			Symbol{Name: "c", Scope: FreeScope, Index: 0},
+			Symbol{Name: "e", Scope: LocalScope, Index: 0},
+		}
+
+		for _, symbol := range expected {
+			result, ok := secondLocal.Resolve(symbol.Name)
+			if !ok {
+				t.Errorf("Could not resolve name: %s", symbol.Name)
+				continue
+			}
+
+			if result != symbol {
+				t.Errorf("Expected %s to resolve to %+v, got %+v", symbol.Name, symbol, result)
+			}
+		}
+
+		expectedFree := []Symbol{
+			Symbol{Name: "c", Scope: LocalScope, Index: 0},
+		}
+
+		if len(secondLocal.FreeSymbols) != len(expectedFree) {
+			t.Fatalf("Wrong number of free symbols. Got %d, want %d", len(secondLocal.FreeSymbols), len(expectedFree))
+		}
+
+		for i, symbol := range expectedFree {
+			if secondLocal.FreeSymbols[i] != symbol {
+				t.Errorf("Wrong free symbol. Got %+v, want %+v", secondLocal.FreeSymbols[i], symbol)
+			}
+		}
+
+		if _, ok := secondLocal.Resolve("unknown"); ok {
+			t.Errorf("Name unknown resolved, but was expected not to")
+		}
+	})
+
+	t.Run("Define and resolve function name", func(t *testing.T) {
+		global := NewSymbolTable()
+		global.DefineFunctionName("a")
+
+		expected := Symbol{Name: "a", Scope: FunctionScope, Index: 0}
+
+		result, ok := global.Resolve(expected.Name)
+		if !ok {
+			t.Fatalf("Function name %s not resolvable", expected.Name)
+		}
+
+		if result != expected {
+			t.Errorf("Expected %s to resolve to %+v, got %+v", expected.Name, expected, result)
+		}
+	})
+
+	t.Run("Copy", func(t *testing.T) {
+		global := NewSymbolTable()
+		a := global.Define("a")
+
+		table := global.Copy()
+		b := table.Define("b")
+		NewModuleSymbolTable(table).Define("c")
+
+		if symbol, ok := table.Resolve("a"); !ok || symbol != a {
+			t.Errorf("Expected a to resolve to %+v in the copy, got %+v", a, symbol)
+		}
+		if b.Index != 1 || table.NumGlobals() != 3 {
+			t.Errorf("Wrong globals of the copy. Got b at %d of %d", b.Index, table.NumGlobals())
+		}
+		if _, ok := global.Resolve("b"); ok || global.NumGlobals() != 1 {
+			t.Errorf("Defining symbols in the copy should leave the original unchanged")
+		}
+	})
 }
@@ -1,111 +0,0 @@
-package evaluator
-
-import (
-	"fmt"
-
-	"github.com/nhoffmann/monkey/object"
-)
-
-var builtins = map[string]*object.Builtin{
-	"len": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. Got %d, want 1.", len(args))
-			}
-
-			switch arg := args[0].(type) {
-			case *object.String:
-				return &object.Integer{Value: int64(len(arg.Value))}
-			case *object.Array:
-				return &object.Integer{Value: int64(len(arg.Elements))}
-			default:
-				return newError("argument to `len` not supported, got %s.", arg.Type())
-			}
-		},
-	},
-	"first": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of argument, expected 1, got %d", len(args))
-			}
-
-			if args[0].Type() != object.ARRAY {
-				return newError("argument must be ARRAY, got %s", args[0].Type())
-			}
-
-			array := args[0].(*object.Array)
-			if len(array.Elements) > 0 {
-				return array.Elements[0]
-			}
-			return NULL
-		},
-	},
-	"last": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of argument, expected 1, got %d", len(args))
-			}
-
-			if args[0].Type() != object.ARRAY {
-				return newError("argument must be ARRAY, got %s", args[0].Type())
-			}
-
-			array := args[0].(*object.Array)
-			length := len(array.Elements)
-			if length > 0 {
-				return array.Elements[length-1]
-			}
-			return NULL
-		},
-	},
-	"rest": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of argument, expected 1, got %d", len(args))
-			}
-
-			if args[0].Type() != object.ARRAY {
-				return newError("argument must be ARRAY, got %s", args[0].Type())
-			}
-
-			array := args[0].(*object.Array)
-			length := len(array.Elements)
-			if length > 0 {
-				newElements := make([]object.Object, length-1, length-1)
-				copy(newElements, array.Elements[1:length])
-
-				return &object.Array{Elements: newElements}
-			}
-			return NULL
-		},
-	},
-	"push": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 2 {
-				return newError("wrong number of argument, expected 2, got %d", len(args))
-			}
-
-			if args[0].Type() != object.ARRAY {
-				return newError("argument must be ARRAY, got %s", args[0].Type())
-			}
-
-			array := args[0].(*object.Array)
-			length := len(array.Elements)
-
-			newElements := make([]object.Object, length+1, length+1)
-			copy(newElements, array.Elements)
-			newElements[length] = args[1]
-
-			return &object.Array{Elements: newElements}
-		},
-	},
-	"puts": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
-			for _, arg := range args {
-				fmt.Println(arg.Inspect())
-			}
-
-			return NULL
-		},
-	},
-}
@@ -1,99 +1,265 @@
 package evaluator
 
 import (
-	"fmt"
+	"context"
+	"io"
 
 	"github.com/nhoffmann/monkey/ast"
 	"github.com/nhoffmann/monkey/object"
 )
 
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL  = object.NullValue
+	TRUE  = object.True
+	FALSE = object.False
 )
 
+const (
+	TAIL_CALL = "TAIL_CALL"
+	INTERRUPT = "INTERRUPT"
+	SKIP      = "SKIP"
+)
+
+// EvalContext evaluates node like Eval. The evaluation stops with
+// object.ErrTimeout once ctx is done, with object.ErrBudgetExceeded once the
+// step budget of env is used up and with object.ErrMemoryLimitExceeded once
+// the memory limit of env is exceeded. Every function call takes a step.
+// Uncaught exceptions are returned as *object.Error, like by the VM.
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment) (object.Object, error) {
+	return runContext(ctx, env, func() object.Object {
+		if program, ok := node.(*ast.Program); ok {
+			return evalProgram(program.Statements, env)
+		}
+
+		return Eval(node, env)
+	})
+}
+
+// CallContext calls fn with args from env and returns the result. It stops
+// for the same reasons as EvalContext.
+func CallContext(ctx context.Context, fn object.Object, args []object.Object, env *object.Environment) (object.Object, error) {
+	return runContext(ctx, env, func() object.Object {
+		return applyFunction(fn, args, env)
+	})
+}
+
+// runContext makes a run in env limited by ctx and the budget settings of env.
+// A panic caused by a bug in the evaluator is recovered and returned as a
+// RuntimeError, like by the VM.
+func runContext(ctx context.Context, env *object.Environment, run func() object.Object) (result object.Object, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, object.NewError(object.RuntimeError, "internal error: %v", r)
+		}
+	}()
+
+	previous, previousScheduler := env.Budget(), env.Scheduler()
+	env.SetBudget(object.NewBudget(ctx, env.StepBudget(), env.MemoryLimit()))
+	env.SetScheduler(object.NewScheduler(env.SchedulerMode()))
+	defer env.SetScheduler(previousScheduler)
+	defer env.SetBudget(previous)
+	defer env.Scheduler().Stop()
+
+	switch result := run().(type) {
+	case *interrupt:
+		return nil, result.err
+	case *object.Exception:
+		return nil, result.Error
+	default:
+		return result, nil
+	}
+}
+
 func Eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	// Statements
 	case *ast.Program:
-		return evalProgram(node.Statements, env)
+		if env.Scheduler() == nil {
+			env.SetScheduler(object.NewScheduler(env.SchedulerMode()))
+			defer env.SetScheduler(nil)
+			defer env.Scheduler().Stop()
+		}
+
+		result := evalProgram(node.Statements, env)
+		if exception, ok := result.(*object.Exception); ok {
+			return exception.Error
+		}
+		return result
 	case *ast.BlockStatement:
 		return evalBlockStatement(node, env)
 	case *ast.ExpressionStatement:
 		return Eval(node.Expression, env)
 	case *ast.ReturnStatement:
 		value := Eval(node.ReturnValue, env)
-		if isError(value) {
+		if isAbrupt(value) {
 			return value
 		}
 		return &object.ReturnValue{Value: value}
 	case *ast.LetStatement:
 		value := Eval(node.Value, env)
-		if isError(value) {
+		if isAbrupt(value) {
 			return value
 		}
 		env.Set(node.Name.Value, value)
+	case *ast.ThrowStatement:
+		value := Eval(node.Value, env)
+		if isAbrupt(value) {
+			return value
+		}
+		return &object.Exception{Error: object.AsError(value)}
+	case *ast.YieldStatement:
+		return evalYieldStatement(node, env)
+	case *ast.ExportStatement:
+		return Eval(node.Statement, env)
 
 	// Expressions
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
+	case *ast.BigIntegerLiteral:
+		return &object.BigInteger{Value: node.Value}
 	case *ast.BooleanLiteral:
 		return nativeBoolToBooleanObject(node.Value)
+	case *ast.NullLiteral:
+		return NULL
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isAbrupt(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return allocate(evalPrefixExpression(node.Operator, right), env)
 	case *ast.InfixExpression:
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isAbrupt(left) {
 			return left
 		}
+		if node.Operator == "??" {
+			if left != NULL {
+				return left
+			}
+			return Eval(node.Right, env)
+		}
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isAbrupt(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return evalInfixExpression(node.Operator, left, right, env)
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
+	case *ast.ForExpression:
+		return evalForExpression(node, env)
+	case *ast.ImportExpression:
+		return evalImportExpression(node, env)
+	case *ast.TryExpression:
+		return evalTryExpression(node, env)
+	case *ast.PropagateExpression:
+		value := Eval(node.Value, env)
+		if isAbrupt(value) {
+			return value
+		}
+		if value.Type() == object.ERROR {
+			return &object.ReturnValue{Value: value}
+		}
+		return value
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Body: body, Env: env}
+		return &object.Function{Parameters: params, Body: body, Env: env, Name: node.Name, Generator: node.Generator}
 	case *ast.CallExpression:
+		if field, ok := node.Function.(*ast.FieldExpression); ok && !node.Optional {
+			return evalMethodCall(field, node.Arguments, env)
+		}
+
 		function := Eval(node.Function, env)
-		if isError(function) {
+		if isAbrupt(function) {
 			return function
 		}
+		if node.Optional && function == NULL {
+			return skipChain
+		}
 		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
+		if len(args) == 1 && isAbrupt(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args)
+		if node.Tail && isTailCallable(function, args) {
+			return &tailCall{function: function, args: args}
+		}
+		return applyFunction(function, args, env)
+	case *ast.SpawnExpression:
+		return evalSpawnExpression(node, env)
+	case *ast.AwaitExpression:
+		task := Eval(node.Task, env)
+		if isAbrupt(task) {
+			return task
+		}
+		return awaitTask(task, env)
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
-		if len(elements) == 1 && isError(elements[0]) {
+		if len(elements) == 1 && isAbrupt(elements[0]) {
 			return elements[0]
 		}
 
-		return &object.Array{Elements: elements}
+		return allocate(&object.Array{Elements: elements}, env)
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isAbrupt(left) {
 			return left
 		}
+		if node.Optional && left == NULL {
+			return skipChain
+		}
 		index := Eval(node.Index, env)
-		if isError(index) {
+		if isAbrupt(index) {
 			return index
 		}
 
 		return evalIndexExpression(left, index)
+	case *ast.FieldExpression:
+		left := Eval(node.Left, env)
+		if isAbrupt(left) {
+			return left
+		}
+		if node.Optional && left == NULL {
+			return skipChain
+		}
+
+		value, method, err := object.ResolveField(left, node.Field.Value, env.Method)
+		if err != nil {
+			return &object.Exception{Error: err}
+		}
+		if method {
+			return object.BindMethod(value.(*object.Builtin), left)
+		}
+
+		return value
+	case *ast.SliceExpression:
+		left := Eval(node.Left, env)
+		if isAbrupt(left) {
+			return left
+		}
+		if node.Optional && left == NULL {
+			return skipChain
+		}
+		start := evalOptionalExpression(node.Start, env)
+		if isAbrupt(start) {
+			return start
+		}
+		end := evalOptionalExpression(node.End, env)
+		if isAbrupt(end) {
+			return end
+		}
+
+		return allocate(evalSliceExpression(left, start, end), env)
+	case *ast.OptionalChainExpression:
+		result := Eval(node.Expression, env)
+		if result == skipChain {
+			return NULL
+		}
+
+		return result
 	case *ast.HashLiteral:
 		return evalHashLiteral(node, env)
 	}
@@ -110,7 +276,9 @@ func evalProgram(statements []ast.Statement, env *object.Environment) object.Obj
 		switch result := result.(type) {
 		case *object.ReturnValue:
 			return result.Value
-		case *object.Error:
+		case *object.Exception:
+			return withStackTrace(result, env)
+		case *interrupt:
 			return result
 		}
 	}
@@ -124,11 +292,8 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 	for _, statement := range block.Statements {
 		result = Eval(statement, env)
 
-		if result != nil {
-			rt := result.Type()
-			if rt == object.RETURN_VALUE || rt == object.ERROR {
-				return result
-			}
+		if isAbrupt(result) {
+			return result
 		}
 	}
 
@@ -142,35 +307,35 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 	case "-":
 		return evalMinusPrefixOperatorExpression(right)
 	default:
-		return newError("unknown operator: %s%s", operator, right.Type())
+		return newError(object.TypeError, "unknown operator: %s%s", operator, right.Type())
 	}
 }
 
-func evalInfixExpression(operator string, left, right object.Object) object.Object {
+func evalInfixExpression(operator string, left, right object.Object, env *object.Environment) object.Object {
 	switch {
 	case left.Type() == object.INTEGER && right.Type() == object.INTEGER:
-		return evalIntegerInfixExpression(operator, left, right)
+		return allocate(evalIntegerInfixExpression(operator, left, right), env)
 	case left.Type() == object.STRING && right.Type() == object.STRING:
-		return evalStringInfixExpression(operator, left, right)
+		return evalStringInfixExpression(operator, left, right, env)
 	case operator == "==":
 		return nativeBoolToBooleanObject(left == right)
 	case operator == "!=":
 		return nativeBoolToBooleanObject(left != right)
 	case left.Type() != right.Type():
-		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
+		return newError(object.TypeError, "type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newError(object.TypeError, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
 func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
 	condition := Eval(ie.Condition, env)
 
-	if isError(condition) {
+	if isAbrupt(condition) {
 		return condition
 	}
 
-	if isTruthy(condition) {
+	if object.IsTruthy(condition) {
 		return Eval(ie.Consequence, env)
 	} else if ie.Alternative != nil {
 		return Eval(ie.Alternative, env)
@@ -179,16 +344,42 @@ func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Obje
 	}
 }
 
+func evalTryExpression(te *ast.TryExpression, env *object.Environment) object.Object {
+	result := Eval(te.Block, env)
+
+	if exception, ok := result.(*object.Exception); ok && te.Catch != nil {
+		env.Set(te.CatchParameter.Value, withStackTrace(exception, env).Error)
+		result = Eval(te.Catch, env)
+	}
+
+	if _, ok := result.(*interrupt); ok {
+		return result
+	}
+
+	if te.Finally != nil {
+		finally := Eval(te.Finally, env)
+		if isAbrupt(finally) {
+			return finally
+		}
+	}
+
+	if result == nil {
+		return NULL
+	}
+
+	return result
+}
+
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
 	if value, ok := env.Get(node.Value); ok {
 		return value
 	}
 
-	if builtin, ok := builtins[node.Value]; ok {
+	if builtin, ok := env.Builtin(node.Value); ok {
 		return builtin
 	}
 
-	return newError("identifier not found: %s", node.Value)
+	return newError(object.NameError, "identifier not found: %s", node.Value)
 }
 
 func evalExpressions(expressions []ast.Expression, env *object.Environment) []object.Object {
@@ -196,7 +387,7 @@ func evalExpressions(expressions []ast.Expression, env *object.Environment) []ob
 
 	for _, e := range expressions {
 		evaluated := Eval(e, env)
-		if isError(evaluated) {
+		if isAbrupt(evaluated) {
 			return []object.Object{evaluated}
 		}
 		result = append(result, evaluated)
@@ -206,49 +397,48 @@ func evalExpressions(expressions []ast.Expression, env *object.Environment) []ob
 }
 
 func evalIntegerInfixExpression(operator string, left, right object.Object) object.Object {
-	leftValue := left.(*object.Integer).Value
-	rightValue := right.(*object.Integer).Value
-
 	switch operator {
-	case "+":
-		return &object.Integer{Value: leftValue + rightValue}
-	case "-":
-		return &object.Integer{Value: leftValue - rightValue}
-	case "*":
-		return &object.Integer{Value: leftValue * rightValue}
-	case "/":
-		return &object.Integer{Value: leftValue / rightValue}
 	case "<":
-		return nativeBoolToBooleanObject(leftValue < rightValue)
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) < 0)
 	case ">":
-		return nativeBoolToBooleanObject(leftValue > rightValue)
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) > 0)
 	case "==":
-		return nativeBoolToBooleanObject(leftValue == rightValue)
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) == 0)
 	case "!=":
-		return nativeBoolToBooleanObject(leftValue != rightValue)
-	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) != 0)
+	}
+
+	result := object.IntegerOperation(operator, left, right)
+	if err, ok := result.(*object.Error); ok {
+		return &object.Exception{Error: err}
 	}
+
+	return result
 }
 
-func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
+// evalStringInfixExpression concatenates strings. Like on the VM, the result
+// is charged to the memory budget before it is allocated.
+func evalStringInfixExpression(operator string, left, right object.Object, env *object.Environment) object.Object {
 	if operator != "+" {
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newError(object.TypeError, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 
 	leftValue := left.(*object.String).Value
 	rightValue := right.(*object.String).Value
 
+	if err := env.Budget().Allocate(object.SizeOf(&object.String{}) + len(leftValue) + len(rightValue)); err != nil {
+		return &interrupt{err: err}
+	}
+
 	return &object.String{Value: leftValue + rightValue}
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
 	if right.Type() != object.INTEGER {
-		return newError("unknown operator: -%s", right.Type())
+		return newError(object.TypeError, "unknown operator: -%s", right.Type())
 	}
 
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
+	return object.NegateInteger(right)
 }
 
 func evalBangOperatorExpression(right object.Object) object.Object {
@@ -268,16 +458,62 @@ func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY && index.Type() == object.INTEGER:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.STRING && index.Type() == object.INTEGER:
+		return evalStringIndexExpression(left, index)
 	case left.Type() == object.HASH:
 		return evalHashIndexExpression(left, index)
+	case left.Type() == object.ERROR:
+		if value, ok := left.(*object.Error).Field(index); ok {
+			return value
+		}
+		return NULL
 	default:
-		return newError("index operator not supported: %s", left.Type())
+		if host, ok := left.(*object.HostObject); ok {
+			value, ok := host.Field(index)
+			if !ok {
+				return &object.Exception{Error: object.MissingFieldError(host, index)}
+			}
+			return value
+		}
+		return newError(object.TypeError, "index operator not supported: %s", left.Type())
 	}
 }
 
+// evalMethodCall calls the field or method name of the receiver, methods get
+// the receiver as their first argument.
+func evalMethodCall(field *ast.FieldExpression, arguments []ast.Expression, env *object.Environment) object.Object {
+	receiver := Eval(field.Left, env)
+	if isAbrupt(receiver) {
+		return receiver
+	}
+	if field.Optional && receiver == NULL {
+		return skipChain
+	}
+
+	args := evalExpressions(arguments, env)
+	if len(args) == 1 && isAbrupt(args[0]) {
+		return args[0]
+	}
+
+	function, method, err := object.ResolveField(receiver, field.Field.Value, env.Method)
+	if err != nil {
+		return &object.Exception{Error: err}
+	}
+	if method {
+		args = append([]object.Object{receiver}, args...)
+	}
+
+	return applyFunction(function, args, env)
+}
+
 func evalArrayIndexExpression(array, index object.Object) object.Object {
 	arrayObject := array.(*object.Array)
-	idx := index.(*object.Integer).Value
+	integer, ok := index.(*object.Integer)
+	if !ok {
+		return NULL
+	}
+
+	idx := integer.Value
 	max := int64(len(arrayObject.Elements) - 1)
 
 	if idx < 0 || idx > max {
@@ -287,20 +523,51 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 	return arrayObject.Elements[idx]
 }
 
+func evalStringIndexExpression(str, index object.Object) object.Object {
+	integer, ok := index.(*object.Integer)
+	if !ok {
+		return NULL
+	}
+
+	character, ok := object.StringIndex(str.(*object.String), integer.Value)
+	if !ok {
+		return NULL
+	}
+
+	return character
+}
+
 func evalHashIndexExpression(hash, index object.Object) object.Object {
 	hashObject := hash.(*object.Hash)
 
-	key, ok := index.(object.Hashable)
+	key, ok := object.AsHashable(index)
 	if !ok {
-		return newError("unusuable as hash key: %s", index.Type())
+		return newError(object.TypeError, "unusuable as hash key: %s", index.Type())
 	}
 
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	value, ok := hashObject.Get(key)
 	if !ok {
 		return NULL
 	}
 
-	return pair.Value
+	return value
+}
+
+func evalOptionalExpression(expression ast.Expression, env *object.Environment) object.Object {
+	if expression == nil {
+		return NULL
+	}
+
+	return Eval(expression, env)
+}
+
+func evalSliceExpression(left, start, end object.Object) object.Object {
+	result, err := object.Slice(left, start, end)
+	if err != nil {
+		return &object.Exception{Error: err}
+	}
+
+	return result
 }
 
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
@@ -308,17 +575,17 @@ func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Obje
 
 	for keyNode, valueNode := range node.Pairs {
 		key := Eval(keyNode, env)
-		if isError(key) {
+		if isAbrupt(key) {
 			return key
 		}
 
-		hashKey, ok := key.(object.Hashable)
+		hashKey, ok := object.AsHashable(key)
 		if !ok {
-			return newError("unusuable as hash key: %s", key.Type())
+			return newError(object.TypeError, "unusuable as hash key: %s", key.Type())
 		}
 
 		value := Eval(valueNode, env)
-		if isError(value) {
+		if isAbrupt(value) {
 			return value
 		}
 
@@ -326,7 +593,7 @@ func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Obje
 		pairs[hashed] = object.HashPair{Key: key, Value: value}
 	}
 
-	return &object.Hash{Pairs: pairs}
+	return allocate(&object.Hash{Pairs: pairs}, env)
 }
 
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
@@ -337,21 +604,181 @@ func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	return FALSE
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// builtinRuntime gives builtin functions access to the evaluator
+type builtinRuntime struct {
+	env *object.Environment
+	// raised holds the exception or interrupt raised by a function applied by
+	// the builtin, so it can keep propagating once the builtin is done
+	raised object.Object
+	err    *object.Error
+}
+
+func (r *builtinRuntime) Apply(fn object.Object, args ...object.Object) (object.Object, *object.Error) {
+	if r.raised != nil {
+		return nil, r.err
+	}
+
+	result := applyFunction(fn, args, r.env)
+	switch raised := result.(type) {
+	case *object.Exception:
+		r.raised, r.err = raised, raised.Error
+		return nil, r.err
+	case *interrupt:
+		r.raised, r.err = raised, object.NewError(object.RuntimeError, "%s", raised.err)
+		return nil, r.err
+	}
+
+	return result, nil
+}
+
+func (r *builtinRuntime) Allocate(size int) *object.Error {
+	if r.raised != nil {
+		return r.err
+	}
+
+	if err := r.env.Budget().Allocate(size); err != nil {
+		r.raised, r.err = &interrupt{err: err}, object.NewError(object.RuntimeError, "%s", err)
+		return r.err
+	}
+
+	return nil
+}
+
+func (r *builtinRuntime) Stdout() io.Writer {
+	return r.env.Scheduler().Writer(r.env.Stdout())
+}
+
+func (r *builtinRuntime) Scheduler() *object.Scheduler {
+	return r.env.Scheduler()
+}
+
+func (r *builtinRuntime) Raise(err error) *object.Error {
+	if r.raised != nil {
+		return r.err
+	}
+
+	if runtimeError, ok := err.(*object.Error); ok {
+		exception := withStackTrace(&object.Exception{Error: runtimeError}, r.env)
+		r.raised, r.err = exception, exception.Error
+	} else {
+		r.raised, r.err = &interrupt{err: err}, object.NewError(object.RuntimeError, "%s", err)
+	}
+
+	return r.err
+}
+
+// allocate accounts obj, which was just created, against the memory limit of
+// the run.
+func allocate(obj object.Object, env *object.Environment) object.Object {
+	if err := env.Budget().Allocate(object.SizeOf(obj)); err != nil {
+		return &interrupt{err: err}
+	}
+
+	return obj
+}
+
+// interrupt stops the evaluation once the budget of the run is exceeded.
+// Unlike exceptions, interrupts cannot be caught and skip finally blocks.
+type interrupt struct {
+	err error
+}
+
+func (i *interrupt) Type() object.ObjectType { return INTERRUPT }
+func (i *interrupt) Inspect() string         { return i.err.Error() }
+
+// skip is the result of an optional link whose left side is null, it skips
+// the rest of the optional chain, which evaluates to null.
+type skip struct{}
+
+func (s *skip) Type() object.ObjectType { return SKIP }
+func (s *skip) Inspect() string         { return "skip" }
+
+var skipChain = &skip{}
+
+// tailCall is the result of a call in tail position. applyFunction makes the
+// call once the function containing it has returned, so tail recursion does
+// not grow the Go stack.
+type tailCall struct {
+	function object.Object
+	args     []object.Object
+}
+
+func (tc *tailCall) Type() object.ObjectType { return TAIL_CALL }
+func (tc *tailCall) Inspect() string         { return "tail call" }
+
+// isTailCallable reports whether calling fn with args can be deferred until the
+// calling function has returned. Like in the VM, this is only done for valid
+// calls of Monkey functions, so builtins and errors keep the caller on the
+// stack trace.
+func isTailCallable(fn object.Object, args []object.Object) bool {
+	function, ok := fn.(*object.Function)
+	return ok && len(args) == len(function.Parameters)
+}
+
+func applyFunction(fn object.Object, args []object.Object, env *object.Environment) object.Object {
+	for {
+		err := env.Budget().Step()
+		if err != nil {
+			return &interrupt{err: err}
+		}
+
+		result := callFunction(fn, args, env)
+
+		call, ok := result.(*tailCall)
+		if !ok {
+			return result
+		}
+
+		fn, args = call.function, call.args
+	}
+}
+
+func callFunction(fn object.Object, args []object.Object, env *object.Environment) object.Object {
 	switch function := fn.(type) {
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(function, args)
+		if len(args) != len(function.Parameters) {
+			return newError(object.ArgumentError, "wrong number of arguments: want=%d, got=%d", len(function.Parameters), len(args))
+		}
+
+		if env.CallDepth() >= env.MaxCallDepth() {
+			return withStackTrace(newError(object.RecursionError, "maximum recursion depth exceeded"), env)
+		}
+
+		extendedEnv := extendFunctionEnv(function, args, env)
+		if function.Generator {
+			return newGenerator(function, extendedEnv)
+		}
+
 		evaluated := Eval(function.Body, extendedEnv)
+		if exception, ok := evaluated.(*object.Exception); ok {
+			return withStackTrace(exception, extendedEnv)
+		}
 		return unwrapReturnValue(evaluated)
 	case *object.Builtin:
-		return function.Fn(args...)
+		runtime := &builtinRuntime{env: env}
+		result := function.Fn(runtime, args...)
+		if runtime.raised != nil {
+			return runtime.raised
+		}
+		if err, ok := object.BuiltinError(result); ok {
+			return withStackTrace(&object.Exception{Error: err}, env)
+		}
+		if result != nil {
+			return result
+		}
+		return NULL
 	default:
-		return newError("not a function: %s", fn.Type())
+		return newError(object.TypeError, "not a function: %s", fn.Type())
 	}
 }
 
-func extendFunctionEnv(function *object.Function, args []object.Object) *object.Environment {
-	env := object.NewEnclosedEnvironment(function.Env)
+func extendFunctionEnv(function *object.Function, args []object.Object, caller *object.Environment) *object.Environment {
+	name := function.Name
+	if name == "" {
+		name = "<anonymous>"
+	}
+
+	env := object.NewFunctionEnvironment(function.Env, caller, name)
 
 	for paramIndex, param := range function.Parameters {
 		env.Set(param.Value, args[paramIndex])
@@ -362,33 +789,37 @@ func extendFunctionEnv(function *object.Function, args []object.Object) *object.
 
 func unwrapReturnValue(obj object.Object) object.Object {
 	if returnValue, ok := obj.(*object.ReturnValue); ok {
-		return returnValue
+		return returnValue.Value
 	}
 
 	return obj
 }
 
-func isTruthy(object object.Object) bool {
-	switch object {
-	case NULL:
-		return false
-	case TRUE:
-		return true
-	case FALSE:
-		return false
-	default:
-		return false
-	}
+func newError(kind object.ErrorKind, format string, a ...interface{}) *object.Exception {
+	return &object.Exception{Error: object.NewError(kind, format, a...)}
 }
 
-func newError(format string, a ...interface{}) *object.Error {
-	return &object.Error{Message: fmt.Sprintf(format, a...)}
+// withStackTrace records the stack trace of env in the error of the exception
+// unless it already has one.
+func withStackTrace(exception *object.Exception, env *object.Environment) *object.Exception {
+	if exception.Error.Stack == nil {
+		exception.Error = exception.Error.WithStack(env.StackTrace())
+	}
+
+	return exception
 }
 
-func isError(obj object.Object) bool {
+// isAbrupt reports whether evaluation has to stop because an exception was
+// raised, a value is being returned or the run was interrupted.
+func isAbrupt(obj object.Object) bool {
 	if obj == nil {
 		return false
 	}
 
-	return obj.Type() == object.ERROR
+	switch obj.Type() {
+	case object.EXCEPTION, object.RETURN_VALUE, INTERRUPT, SKIP:
+		return true
+	default:
+		return false
+	}
 }
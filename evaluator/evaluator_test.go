@@ -1,7 +1,13 @@
 package evaluator
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/nhoffmann/monkey/lexer"
 	"github.com/nhoffmann/monkey/object"
@@ -112,7 +118,8 @@ func TestEvaluator(t *testing.T) {
 		}{
 			{"if (true) { 10 }", 10},
 			{"if (false) { 10 }", nil},
-			// {"if (1) { 10 }", 10},
+			{"if (1) { 10 }", 10},
+			{`if ("") { 10 } else { 20 }`, 10},
 			{"if (1 < 2) { 10 }", 10},
 			{"if (1 > 2) { 10 }", nil},
 			{"if (1 > 2) { 10 } else { 20 }", 20},
@@ -228,6 +235,18 @@ func TestEvaluator(t *testing.T) {
 				`"Hello" - "World"`,
 				"unknown operator: STRING - STRING",
 			},
+			{
+				`{[1, {}]: 5}`,
+				"unusuable as hash key: ARRAY",
+			},
+			{
+				`[1, 2]["a":]`,
+				"slice bound must be INTEGER, got STRING",
+			},
+			{
+				`{}[1:2]`,
+				"slice operator not supported: HASH",
+			},
 		}
 
 		for _, test := range tests {
@@ -250,6 +269,389 @@ func TestEvaluator(t *testing.T) {
 		}
 	})
 
+	t.Run("Error kinds", func(t *testing.T) {
+		tests := []struct {
+			input           string
+			expectedKind    object.ErrorKind
+			expectedMessage string
+		}{
+			{"1 / 0", object.ZeroDivisionError, "division by zero"},
+			{"let x = 0; 10 / x", object.ZeroDivisionError, "division by zero"},
+			{"99999999999999999999 / 0", object.ZeroDivisionError, "division by zero"},
+			{"5 + true", object.TypeError, "type mismatch: INTEGER + BOOLEAN"},
+			{"foobar", object.NameError, "identifier not found: foobar"},
+			{"fn(x) { x }()", object.ArgumentError, "wrong number of arguments: want=1, got=0"},
+			{"len(1, 2)", object.ArgumentError, "wrong number of arguments. Got 2, want 1."},
+			{`repeat("a", -1)`, object.ValueError, "argument to `repeat` must not be negative, got -1"},
+			{`repeat("ab", 9223372036854775807)`, object.ValueError, "result of `repeat` is too large"},
+			{`repeat("ab", 4000000000)`, object.ValueError, "result of `repeat` is too large"},
+			{`throw "boom"`, object.UserError, "boom"},
+			{`try { throw "boom" } finally { 1 }`, object.UserError, "boom"},
+			{`let f = fn() { try { 1 / 0 } finally { 1 } }; f()`, object.ZeroDivisionError, "division by zero"},
+		}
+
+		for _, test := range tests {
+			evaluated := evaluateInput(t, test.input)
+
+			errorObject, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("Expected object.Error, got %T: %+v", evaluated, evaluated)
+				continue
+			}
+
+			if errorObject.Kind != test.expectedKind {
+				t.Errorf("Wrong error kind. Expected %s, got %s", test.expectedKind, errorObject.Kind)
+			}
+
+			if errorObject.Message != test.expectedMessage {
+				t.Errorf("Wrong error message. Expected %q, got %q", test.expectedMessage, errorObject.Message)
+			}
+		}
+	})
+
+	t.Run("Try and catch", func(t *testing.T) {
+		type errorMessage string
+
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{"try { 1 } catch (e) { 2 }", 1},
+			{`try { throw "boom"; 1 } catch (e) { e["message"] }`, "boom"},
+			{`try { throw "boom" } catch (e) { e["kind"] }`, "Error"},
+			{`try { 1 / 0 } catch (e) { e["kind"] }`, "ZeroDivisionError"},
+			{`try { 1 / 0 } catch (e) { e["unknown"] }`, nil},
+			{"1 + try { throw 1 } catch (e) { 2 }", 3},
+			{"[1, 2, try { [3, 4][5 / 0] } catch (e) { 9 }][2]", 9},
+			{"try { } catch (e) { 1 }", nil},
+			{"let x = 0; try { 1 } finally { let x = 5; }; x", 5},
+			{"let x = 0; try { try { throw 1 } finally { let x = 1; } } catch (e) { x }", 1},
+			{`try { try { throw "a" } catch (e) { throw e } } catch (e) { e["message"] }`, "a"},
+			{`try { try { throw "a" } catch (e) { throw "b" } finally { 1 } } catch (e) { e["message"] }`, "b"},
+			{"fn() { try { return 1; } finally { return 2; } }()", 2},
+			{"fn() { let x = try { 1 } finally { 2 }; x }()", 1},
+			{
+				`let f = fn() { 1 / 0 };
+				let g = fn() { try { f() } catch (e) { e["message"] } };
+				g()`,
+				"division by zero",
+			},
+			{
+				`let f = fn() { throw "inner" };
+				let g = fn() { let result = f(); result };
+				try { g() } catch (e) { e["stack"] }`,
+				[]string{"f", "g", "<main>"},
+			},
+			{
+				`let f = fn(n) { if (n == 0) { throw "bottom" } 1 + f(n - 1) };
+				try { f(5) } catch (e) { len(e["stack"]) }`,
+				7,
+			},
+			{
+				`let inner = fn(x) { x / 0 };
+				let outer = fn() { map([1], inner) };
+				try { outer() } catch (e) { e["stack"] }`,
+				[]string{"inner", "outer", "<main>"},
+			},
+			{`try { map([1], fn(x) { x / 0 }) } catch (e) { e["kind"] }`, "ZeroDivisionError"},
+			{`try { throw [1, 2] } catch (e) { e["message"] }`, "[1, 2]"},
+			{`try { throw "boom" } catch (e) { e }`, errorMessage("boom")},
+		}
+
+		for _, test := range tests {
+			evaluated := evaluateInput(t, test.input)
+
+			switch expected := test.expected.(type) {
+			case int:
+				assertIntegerObject(t, evaluated, int64(expected))
+			case string:
+				assertStringObject(t, evaluated, expected)
+			case []string:
+				array, ok := evaluated.(*object.Array)
+				if !ok {
+					t.Errorf("Object is not Array. Got %T: %+v", evaluated, evaluated)
+					continue
+				}
+
+				if len(array.Elements) != len(expected) {
+					t.Errorf("Wrong number of elements. Want %d, got %d", len(expected), len(array.Elements))
+					continue
+				}
+
+				for i, element := range expected {
+					assertStringObject(t, array.Elements[i], element)
+				}
+			case errorMessage:
+				errorObject, ok := evaluated.(*object.Error)
+				if !ok {
+					t.Errorf("Object is not Error. Got %T: %+v", evaluated, evaluated)
+					continue
+				}
+
+				if errorObject.Message != string(expected) {
+					t.Errorf("wrong error message. Expected %q, got %q", expected, errorObject.Message)
+				}
+			default:
+				assertNullObject(t, evaluated)
+			}
+		}
+	})
+
+	t.Run("Error values", func(t *testing.T) {
+		type errorMessage string
+
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{`is_error(error("bad"))`, true},
+			{"is_error(1)", false},
+			{`error("bad")["message"]`, "bad"},
+			{`error("bad")["kind"]`, "Error"},
+			{`try { len(1) } catch (e) { "caught" }`, "caught"},
+			{`let r = try { upper(1) } catch (e) { e }; if (is_error(r)) { "failed" } else { r }`, "failed"},
+			{`try { upper(1) } catch (e) { e["kind"] }`, "TypeError"},
+			{"5? + 1", 6},
+			{
+				`let parse = fn(x) { if (x < 0) { return error("negative") } x };
+				let double = fn(x) { parse(x)? * 2 };
+				double(2)`,
+				4,
+			},
+			{
+				`let parse = fn(x) { if (x < 0) { return error("negative") } x };
+				let double = fn(x) { parse(x)? * 2 };
+				double(-1)["message"]`,
+				"negative",
+			},
+			{`let f = fn() { let x = error("a")?; 1 }; is_error(f())`, true},
+			{`fn() { [1, error("a")?, 3] }()["message"]`, "a"},
+			{`fn() { try { error("a")? } finally { return 2 } }()`, 2},
+			{
+				`let check = fn(x) { if (x < 0) { error("negative") } else { x } };
+				len(filter(map([1, -1, 2], check), is_error))`,
+				1,
+			},
+			{`error("stop")?; 1`, errorMessage("stop")},
+			{`try { fn() { len(1)?; 1 }() } catch (e) { e["kind"] }`, "TypeError"},
+			{`let f = fn() { upper(1)? }; try { is_error(f()) } catch (e) { "raised" }`, "raised"},
+		}
+
+		for _, test := range tests {
+			evaluated := evaluateInput(t, test.input)
+
+			switch expected := test.expected.(type) {
+			case int:
+				assertIntegerObject(t, evaluated, int64(expected))
+			case bool:
+				assertBooleanObject(t, evaluated, expected)
+			case string:
+				assertStringObject(t, evaluated, expected)
+			case errorMessage:
+				errorObject, ok := evaluated.(*object.Error)
+				if !ok {
+					t.Errorf("Object is not Error. Got %T: %+v", evaluated, evaluated)
+					continue
+				}
+
+				if errorObject.Message != string(expected) {
+					t.Errorf("wrong error message. Expected %q, got %q", expected, errorObject.Message)
+				}
+			}
+		}
+	})
+
+	t.Run("Call depth", func(t *testing.T) {
+		recursive := "let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } };"
+
+		type errorMessage string
+
+		tests := []struct {
+			input        string
+			maxCallDepth int
+			expected     interface{}
+		}{
+			{recursive + "f(5000)", object.DefaultMaxCallDepth, 5000},
+			{recursive + "f(9)", 10, 9},
+			{recursive + `try { f(10) } catch (e) { e["kind"] }`, 10, "RecursionError"},
+			{recursive + `try { f(20) } catch (e) { len(e["stack"]) }`, 10, 11},
+			{
+				`let f = fn(n) { if (n == 0) { 0 } else { 1 + first(map([n - 1], f)) } };
+				try { f(20) } catch (e) { e["kind"] }`,
+				10,
+				"RecursionError",
+			},
+			{"let f = fn() { 1 + f() }; f()", object.DefaultMaxCallDepth, errorMessage("maximum recursion depth exceeded")},
+		}
+
+		for _, test := range tests {
+			program := parser.NewParser(lexer.NewLexer(test.input)).ParseProgram()
+			env := object.NewEnvironment()
+			env.SetMaxCallDepth(test.maxCallDepth)
+
+			evaluated := Eval(program, env)
+
+			switch expected := test.expected.(type) {
+			case int:
+				assertIntegerObject(t, evaluated, int64(expected))
+			case string:
+				assertStringObject(t, evaluated, expected)
+			case errorMessage:
+				errorObject, ok := evaluated.(*object.Error)
+				if !ok {
+					t.Errorf("Object is not Error. Got %T: %+v", evaluated, evaluated)
+					continue
+				}
+
+				if errorObject.Kind != object.RecursionError || errorObject.Message != string(expected) {
+					t.Errorf("wrong error. Expected RecursionError %q, got %s %q", expected, errorObject.Kind, errorObject.Message)
+				}
+			}
+		}
+	})
+
+	t.Run("Tail calls", func(t *testing.T) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{
+				`let loop = fn(n, acc) { if (n == 0) { acc } else { loop(n - 1, acc + 1) } };
+				loop(1000000, 0)`,
+				1000000,
+			},
+			{
+				`let even = fn(n, odd) { if (n == 0) { return true; } odd(n - 1, even) };
+				let odd = fn(n, even) { if (n == 0) { return false; } even(n - 1, odd) };
+				even(100001, odd)`,
+				false,
+			},
+			{
+				`let sum = fn(xs, acc) { if (len(xs) == 0) { acc } else { sum(rest(xs), acc + first(xs)) } };
+				sum([1, 2, 3, 4, 5], 0)`,
+				15,
+			},
+			{"let adder = fn(x) { fn(y) { x + y } }; let apply = fn(f, v) { f(v) }; apply(adder(1), 2)", 3},
+			{"let f = fn(x) { x }; let g = fn() { f(1, 2) }; try { g() } catch (e) { e[\"stack\"] }", []string{"g", "<main>"}},
+			{
+				`let f = fn() { throw "inner" };
+				let g = fn() { f() };
+				try { g() } catch (e) { e["stack"] }`,
+				[]string{"f", "<main>"},
+			},
+		}
+
+		for _, test := range tests {
+			evaluated := evaluateInput(t, test.input)
+
+			switch expected := test.expected.(type) {
+			case int:
+				assertIntegerObject(t, evaluated, int64(expected))
+			case bool:
+				assertBooleanObject(t, evaluated, expected)
+			case []string:
+				array, ok := evaluated.(*object.Array)
+				if !ok {
+					t.Errorf("Object is not Array. Got %T: %+v", evaluated, evaluated)
+					continue
+				}
+
+				if len(array.Elements) != len(expected) {
+					t.Errorf("Wrong number of elements. Want %d, got %d", len(expected), len(array.Elements))
+					continue
+				}
+
+				for i, element := range expected {
+					assertStringObject(t, array.Elements[i], element)
+				}
+			}
+		}
+	})
+
+	t.Run("Budgets", func(t *testing.T) {
+		canceled, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		timeout, cancelTimeout := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancelTimeout()
+
+		tests := []struct {
+			input      string
+			ctx        context.Context
+			stepBudget int
+			expected   error
+		}{
+			{"let add = fn(a, b) { a + b }; add(1, 2)", context.Background(), 1, nil},
+			{"let f = fn() { f() }; f()", context.Background(), 1000, object.ErrBudgetExceeded},
+			{"let f = fn() { 1 + f() }; f()", context.Background(), 1000, object.ErrBudgetExceeded},
+			{"let f = fn() { f() }; try { f() } catch (e) { 1 } finally { 2 }", context.Background(), 1000, object.ErrBudgetExceeded},
+			{"map([1], fn(x) { let f = fn() { f() }; f() })", context.Background(), 1000, object.ErrBudgetExceeded},
+			{"let f = fn() { f() }; f()", timeout, 0, object.ErrTimeout},
+			{"len([])", canceled, 0, object.ErrTimeout},
+		}
+
+		for _, test := range tests {
+			program := parser.NewParser(lexer.NewLexer(test.input)).ParseProgram()
+			env := object.NewEnvironment()
+			env.SetStepBudget(test.stepBudget)
+
+			_, err := EvalContext(test.ctx, program, env)
+			if !errors.Is(err, test.expected) {
+				t.Errorf("%s: wrong error. Want %v, got %v", test.input, test.expected, err)
+			}
+		}
+	})
+
+	t.Run("Memory limits", func(t *testing.T) {
+		tests := []struct {
+			input       string
+			memoryLimit int
+			expected    error
+		}{
+			{`let s = "ab"; s + s`, 10000, nil},
+			{`len(repeat("a", 100000))`, 0, nil},
+			{`let grow = fn(s) { grow(s + s) }; grow("a")`, 10000, object.ErrMemoryLimitExceeded},
+			{`let grow = fn(x) { grow(x * x) }; grow(2)`, 10000, object.ErrMemoryLimitExceeded},
+			{`let fill = fn(a, n) { if (n == 0) { a } else { fill(push(a, n), n - 1) } }; fill([], 1000)`, 10000, object.ErrMemoryLimitExceeded},
+			{`let fill = fn(n) { if (n == 0) { 0 } else { let h = {n: [n]}; fill(n - 1) } }; fill(1000)`, 10000, object.ErrMemoryLimitExceeded},
+			{`repeat("ab", 1000000000000)`, 10000, object.ErrMemoryLimitExceeded},
+			{`replace(repeat("a", 100), "a", repeat("b", 1000))`, 10000, object.ErrMemoryLimitExceeded},
+			{`join([1, 2, 3, 4, 5, 6], repeat("-", 2000))`, 10000, object.ErrMemoryLimitExceeded},
+			{`let s = repeat("a", 2000); format("{}{}{}{}{}{}", s, s, s, s, s, s)`, 10000, object.ErrMemoryLimitExceeded},
+			{`let s = repeat("a", 2000); format("{}{}", s, s)`, 10000, nil},
+			{`map([1, 2, 3], fn(x) { repeat("a", 100000) })`, 10000, object.ErrMemoryLimitExceeded},
+			{`try { repeat("a", 100000) } catch (e) { 1 }`, 10000, object.ErrMemoryLimitExceeded},
+		}
+
+		for _, test := range tests {
+			program := parser.NewParser(lexer.NewLexer(test.input)).ParseProgram()
+			env := object.NewEnvironment()
+			env.SetMemoryLimit(test.memoryLimit)
+
+			_, err := EvalContext(context.Background(), program, env)
+			if !errors.Is(err, test.expected) {
+				t.Errorf("%s: wrong error. Want %v, got %v", test.input, test.expected, err)
+			}
+		}
+
+		// strings are only concatenated once the memory limit allows it
+		env := object.NewEnvironment()
+		env.SetMemoryLimit(1 << 20)
+		env.Set("large", &object.String{Value: strings.Repeat("a", 32<<20)})
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err := EvalContext(context.Background(), parser.NewParser(lexer.NewLexer("large + large")).ParseProgram(), env)
+		runtime.ReadMemStats(&after)
+
+		if !errors.Is(err, object.ErrMemoryLimitExceeded) {
+			t.Errorf("wrong error. Want %v, got %v", object.ErrMemoryLimitExceeded, err)
+		}
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated >= 64<<20 {
+			t.Errorf("strings were concatenated before checking the memory limit, allocated %d bytes", allocated)
+		}
+	})
+
 	t.Run("Let Statements", func(t *testing.T) {
 		tests := []struct {
 			input    string
@@ -282,59 +684,348 @@ func TestEvaluator(t *testing.T) {
 			t.Fatalf("Function has wrong parameters: %+v", function.Parameters)
 		}
 
-		if function.Parameters[0].String() != "x" {
-			t.Fatalf("Parameter is not 'x'. Got %q", function.Parameters[0])
-		}
+		if function.Parameters[0].String() != "x" {
+			t.Fatalf("Parameter is not 'x'. Got %q", function.Parameters[0])
+		}
+
+		expectedBody := "(x + 2)"
+
+		if function.Body.String() != expectedBody {
+			t.Fatalf("Expected body to be %q, got %q", expectedBody, function.Body.String())
+		}
+	})
+
+	t.Run("Function Application", func(t *testing.T) {
+		tests := []struct {
+			input    string
+			expected int64
+		}{
+			{"let identity = fn(x) { x; }; identity(5);", 5},
+			{"let identity = fn(x) { return x; }; identity(5);", 5},
+			{"let double = fn(x) { x * 2; }; double(5);", 10},
+			{"let add = fn(x, y) { x + y; }; add(5, 5);", 10},
+			{"let add = fn(x, y) { x + y; }; add(5 + 5, add(5, 5));", 20},
+			{"fn(x) { x; }(5)", 5},
+		}
+
+		for _, test := range tests {
+			evaluated := evaluateInput(t, test.input)
+			assertIntegerObject(t, evaluated, test.expected)
+		}
+	})
+
+	t.Run("Closures", func(t *testing.T) {
+		input := `
+		let newAdder = fn(x) {
+			fn(y) { x + y };
+		};
+
+		let addTwo = newAdder(2);
+		addTwo(2)`
+
+		assertIntegerObject(t, evaluateInput(t, input), 4)
+	})
+
+	t.Run("Builtin Function", func(t *testing.T) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{`len("")`, 0},
+			{`len("four")`, 4},
+			{`len("hello world")`, 11},
+			{`len(1)`, "argument to `len` not supported, got INTEGER."},
+			{`len("one", "two")`, "wrong number of arguments. Got 2, want 1."},
+			{"let a = [1, 2, 3]; len(a)", 3},
+		}
+
+		for _, test := range tests {
+			evaluated := evaluateInput(t, test.input)
+
+			switch expected := test.expected.(type) {
+			case int:
+				assertIntegerObject(t, evaluated, int64(expected))
+			case string:
+				errorObject, ok := evaluated.(*object.Error)
+
+				if !ok {
+					t.Errorf("Object is not Error. Got %T: %+v", evaluated, evaluated)
+					continue
+				}
+
+				if errorObject.Message != expected {
+					t.Errorf("wrong error message. Expected %q, got %q", expected, errorObject.Message)
+				}
+			}
+		}
+	})
+
+	t.Run("Registered builtins", func(t *testing.T) {
+		builtins := object.NewRegistry()
+		builtins.Register("answer", 0, "Returns the answer.", func(runtime object.Runtime, args ...object.Object) object.Object {
+			return &object.Integer{Value: 42}
+		})
+		builtins.Register("twice", 2, "Calls a function twice.", func(runtime object.Runtime, args ...object.Object) object.Object {
+			result, err := runtime.Apply(args[0], args[1])
+			if err != nil {
+				return err
+			}
+			result, err = runtime.Apply(args[0], result)
+			if err != nil {
+				return err
+			}
+			return result
+		})
+
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{"answer()", 42},
+			{"answer() + len([1, 2])", 44},
+			{"twice(fn(x) { x * 3 }, 2)", 18},
+			{"let answer = fn() { 1 }; answer()", 1},
+			{"answer(1)", "wrong number of arguments. Got 1, want 0."},
+		}
+
+		for _, test := range tests {
+			env := object.NewEnvironment()
+			env.SetBuiltins(builtins)
+
+			evaluated := Eval(parser.NewParser(lexer.NewLexer(test.input)).ParseProgram(), env)
+
+			switch expected := test.expected.(type) {
+			case int:
+				assertIntegerObject(t, evaluated, int64(expected))
+			case string:
+				errorObject, ok := evaluated.(*object.Error)
+				if !ok {
+					t.Errorf("Object is not Error. Got %T: %+v", evaluated, evaluated)
+					continue
+				}
+
+				if errorObject.Message != expected {
+					t.Errorf("wrong error message. Expected %q, got %q", expected, errorObject.Message)
+				}
+			}
+		}
+
+		evaluated := evaluateInput(t, "answer()")
+		if errorObject, ok := evaluated.(*object.Error); !ok || errorObject.Kind != object.NameError {
+			t.Errorf("registered builtins must not be visible to other environments, got %+v", evaluated)
+		}
+	})
+
+	t.Run("String builtins", func(t *testing.T) {
+		type errorMessage string
+
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{`"monkey"[0]`, "m"},
+			{`let s = "monkey"; s[len(s) - 1]`, "y"},
+			{`"monkey"[6]`, nil},
+			{`"monkey"[-1]`, nil},
+			{`"héllo"[1]`, "é"},
+			{`"héllo"[4]`, "o"},
+			{`"héllo"[5]`, nil},
+			{`len("héllo")`, 5},
+			{`index_of("héllo", "llo")`, 2},
+			{`split("a,b,c", ",")`, []string{"a", "b", "c"}},
+			{`split("abc", "")`, []string{"a", "b", "c"}},
+			{`join(["a", "b", "c"], "-")`, "a-b-c"},
+			{`join([1, 2], ", ")`, "1, 2"},
+			{`join([], ",")`, ""},
+			{`join(["a"], ",")`, "a"},
+			{"trim(\"  monkey \t\n\")", "monkey"},
+			{`upper("monkey")`, "MONKEY"},
+			{`lower("MoNkEy")`, "monkey"},
+			{`contains("monkey", "key")`, true},
+			{`contains("monkey", "dog")`, false},
+			{`if (contains("monkey", "mon")) { 1 } else { 2 }`, 1},
+			{`replace("banana", "a", "o")`, "bonono"},
+			{`index_of("monkey", "key")`, 3},
+			{`index_of("monkey", "dog")`, -1},
+			{`starts_with("monkey", "mon")`, true},
+			{`starts_with("monkey", "key")`, false},
+			{`ends_with("monkey", "key")`, true},
+			{`ends_with("monkey", "mon")`, false},
+			{`repeat("ab", 3)`, "ababab"},
+			{`repeat("ab", 0)`, ""},
+			{`format("{} + {} = {}", 1, 2, "three")`, "1 + 2 = three"},
+			{`format("no placeholders")`, "no placeholders"},
+			{`upper(1)`, errorMessage("argument 1 to `upper` must be STRING, got INTEGER")},
+			{`split("a")`, errorMessage("wrong number of arguments. Got 1, want 2.")},
+			{`repeat("a", -1)`, errorMessage("argument to `repeat` must not be negative, got -1")},
+			{`format("{} {}", 1)`, errorMessage("wrong number of values for `format`. Got 1, want 2.")},
+		}
+
+		for _, test := range tests {
+			evaluated := evaluateInput(t, test.input)
+
+			switch expected := test.expected.(type) {
+			case int:
+				assertIntegerObject(t, evaluated, int64(expected))
+			case bool:
+				assertBooleanObject(t, evaluated, expected)
+			case string:
+				assertStringObject(t, evaluated, expected)
+			case []string:
+				array, ok := evaluated.(*object.Array)
+				if !ok {
+					t.Errorf("Object is not Array. Got %T: %+v", evaluated, evaluated)
+					continue
+				}
+
+				if len(array.Elements) != len(expected) {
+					t.Errorf("Wrong number of elements. Want %d, got %d", len(expected), len(array.Elements))
+					continue
+				}
+
+				for i, element := range expected {
+					assertStringObject(t, array.Elements[i], element)
+				}
+			case errorMessage:
+				errorObject, ok := evaluated.(*object.Error)
+				if !ok {
+					t.Errorf("Object is not Error. Got %T: %+v", evaluated, evaluated)
+					continue
+				}
+
+				if errorObject.Message != string(expected) {
+					t.Errorf("wrong error message. Expected %q, got %q", expected, errorObject.Message)
+				}
+			default:
+				assertNullObject(t, evaluated)
+			}
+		}
+	})
+
+	t.Run("Big integers", func(t *testing.T) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{"9223372036854775807 + 1", "9223372036854775808"},
+			{"-9223372036854775807 - 2", "-9223372036854775809"},
+			{"4294967296 * 4294967296", "18446744073709551616"},
+			{"-9223372036854775808", -9223372036854775808},
+			{"(-9223372036854775807 - 1) / -1", "9223372036854775808"},
+			{"99999999999999999999", "99999999999999999999"},
+			{"99999999999999999999 - 99999999999999999998", 1},
+			{"99999999999999999999 > 1", true},
+			{"-99999999999999999999 < 1", true},
+			{"99999999999999999999 == 99999999999999999999", true},
+			{"{99999999999999999999: 1}[99999999999999999999]", 1},
+			{"sort_by([99999999999999999999, 1, -99999999999999999999], fn(x) { x })[0]", "-99999999999999999999"},
+			{
+				`let fib = fn(n) {
+					let iter = fn(a, b, i) { if (i == 0) { return a; } iter(b, a + b, i - 1) };
+					iter(0, 1, n)
+				};
+				fib(100)`,
+				"354224848179261915075",
+			},
+		}
+
+		for _, test := range tests {
+			evaluated := evaluateInput(t, test.input)
 
-		expectedBody := "(x + 2)"
+			switch expected := test.expected.(type) {
+			case int:
+				assertIntegerObject(t, evaluated, int64(expected))
+			case bool:
+				assertBooleanObject(t, evaluated, expected)
+			case string:
+				integer, ok := evaluated.(*object.BigInteger)
+				if !ok {
+					t.Errorf("Object is not BigInteger. Got %T: %+v", evaluated, evaluated)
+					continue
+				}
 
-		if function.Body.String() != expectedBody {
-			t.Fatalf("Expected body to be %q, got %q", expectedBody, function.Body.String())
+				if integer.Inspect() != expected {
+					t.Errorf("wrong value. Expected %s, got %s", expected, integer.Inspect())
+				}
+			}
 		}
 	})
 
-	t.Run("Function Application", func(t *testing.T) {
+	t.Run("Hash builtins", func(t *testing.T) {
+		type errorMessage string
+
 		tests := []struct {
 			input    string
-			expected int64
+			expected interface{}
 		}{
-			{"let identity = fn(x) { x; }; identity(5);", 5},
-			{"let identity = fn(x) { return x; }; identity(5);", 5},
-			{"let double = fn(x) { x * 2; }; double(5);", 10},
-			{"let add = fn(x, y) { x + y; }; add(5, 5);", 10},
-			{"let add = fn(x, y) { x + y; }; add(5 + 5, add(5, 5));", 20},
-			{"fn(x) { x; }(5)", 5},
+			{`len({})`, 0},
+			{`len({"a": 1, "b": 2})`, 2},
+			{`keys({"b": 2, "a": 1})`, "[a, b]"},
+			{`keys({10: 1, 2: 2, true: 3})`, "[true, 2, 10]"},
+			{`values({"b": 2, "a": 1})`, "[1, 2]"},
+			{`entries({"b": 2, "a": 1})`, "[[a, 1], [b, 2]]"},
+			{`entries({})`, "[]"},
+			{`has({"a": 1}, "a")`, true},
+			{`has({"a": 1}, "b")`, false},
+			{`has({[1, 2]: 1}, [1, 2])`, true},
+			{`let h = {"a": 1, "b": 2}; let d = delete(h, "a"); [keys(d), len(h)]`, "[[b], 2]"},
+			{`keys(delete({"a": 1}, "z"))`, "[a]"},
+			{`let m = merge({"a": 1, "b": 2}, {"b": 3}, {"c": 4}); [keys(m), values(m)]`, "[[a, b, c], [1, 3, 4]]"},
+			{`let h = {"a": 1}; merge(h, {"b": 2}); len(h)`, 1},
+			{`keys([1])`, errorMessage("argument 1 to `keys` must be HASH, got ARRAY")},
+			{`has({}, fn(x) { x })`, errorMessage("unusable as hash key: FUNCTION")},
+			{`delete({})`, errorMessage("wrong number of arguments. Got 1, want 2.")},
+			{`merge({}, 1)`, errorMessage("argument 2 to `merge` must be HASH, got INTEGER")},
 		}
 
 		for _, test := range tests {
 			evaluated := evaluateInput(t, test.input)
-			assertIntegerObject(t, evaluated, test.expected)
-		}
-	})
-
-	t.Run("Closures", func(t *testing.T) {
-		input := `
-		let newAdder = fn(x) {
-			fn(y) { x + y };
-		};
 
-		let addTwo = newAdder(2);
-		addTwo(2)`
+			switch expected := test.expected.(type) {
+			case int:
+				assertIntegerObject(t, evaluated, int64(expected))
+			case bool:
+				assertBooleanObject(t, evaluated, expected)
+			case string:
+				if evaluated.Inspect() != expected {
+					t.Errorf("wrong result. Expected %s, got %s", expected, evaluated.Inspect())
+				}
+			case errorMessage:
+				errorObject, ok := evaluated.(*object.Error)
+				if !ok {
+					t.Errorf("Object is not Error. Got %T: %+v", evaluated, evaluated)
+					continue
+				}
 
-		assertIntegerObject(t, evaluateInput(t, input), 4)
+				if errorObject.Message != string(expected) {
+					t.Errorf("wrong error message. Expected %q, got %q", expected, errorObject.Message)
+				}
+			}
+		}
 	})
 
-	t.Run("Builtin Function", func(t *testing.T) {
+	t.Run("Higher-order builtins", func(t *testing.T) {
 		tests := []struct {
 			input    string
 			expected interface{}
 		}{
-			{`len("")`, 0},
-			{`len("four")`, 4},
-			{`len("hello world")`, 11},
-			{`len(1)`, "argument to `len` not supported, got INTEGER."},
-			{`len("one", "two")`, "wrong number of arguments. Got 2, want 1."},
-			{"let a = [1, 2, 3]; len(a)", 3},
+			{"map([1, 2, 3], fn(x) { x * 2 })", []int64{2, 4, 6}},
+			{"let factor = 3; map([1, 2], fn(x) { return x * factor })", []int64{3, 6}},
+			{`map(["a", "b"], len)`, []int64{1, 1}},
+			{"map([], fn(x) { x })", []int64{}},
+			{"filter([1, 2, 3, 4], fn(x) { x > 2 })", []int64{3, 4}},
+			{"reduce([1, 2, 3, 4], 0, fn(acc, x) { acc + x })", 10},
+			{"reduce([], 5, fn(acc, x) { acc + x })", 5},
+			{"sort_by([3, 1, 2], fn(x) { x })", []int64{1, 2, 3}},
+			{"sort_by([1, 2, 3], fn(x) { -x })", []int64{3, 2, 1}},
+			{"any([1, 2, 3], fn(x) { x > 2 })", true},
+			{"any([1, 2, 3], fn(x) { x > 5 })", false},
+			{"all([1, 2, 3], fn(x) { x > 0 })", true},
+			{"all([1, 2, 3], fn(x) { x > 1 })", false},
+			{"map(map([1, 2], fn(x) { x + 1 }), fn(x) { x * 10 })", []int64{20, 30}},
+			{"map(1, fn(x) { x })", "argument 1 to `map` must be ARRAY, got INTEGER"},
+			{"map([1], fn(x, y) { x })", "wrong number of arguments: want=2, got=1"},
+			{"map([1], fn(x) { x + true })", "type mismatch: INTEGER + BOOLEAN"},
 		}
 
 		for _, test := range tests {
@@ -343,9 +1034,25 @@ func TestEvaluator(t *testing.T) {
 			switch expected := test.expected.(type) {
 			case int:
 				assertIntegerObject(t, evaluated, int64(expected))
+			case bool:
+				assertBooleanObject(t, evaluated, expected)
+			case []int64:
+				array, ok := evaluated.(*object.Array)
+				if !ok {
+					t.Errorf("Object is not Array. Got %T: %+v", evaluated, evaluated)
+					continue
+				}
+
+				if len(array.Elements) != len(expected) {
+					t.Errorf("Wrong number of elements. Want %d, got %d", len(expected), len(array.Elements))
+					continue
+				}
+
+				for i, element := range expected {
+					assertIntegerObject(t, array.Elements[i], element)
+				}
 			case string:
 				errorObject, ok := evaluated.(*object.Error)
-
 				if !ok {
 					t.Errorf("Object is not Error. Got %T: %+v", evaluated, evaluated)
 					continue
@@ -406,6 +1113,65 @@ func TestEvaluator(t *testing.T) {
 		}
 	})
 
+	t.Run("Slice expressions", func(t *testing.T) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{"[1, 2, 3, 4][1:3]", []int64{2, 3}},
+			{"[1, 2, 3, 4][:2]", []int64{1, 2}},
+			{"[1, 2, 3, 4][2:]", []int64{3, 4}},
+			{"[1, 2, 3, 4][:]", []int64{1, 2, 3, 4}},
+			{"[1, 2, 3, 4][-2:]", []int64{3, 4}},
+			{"[1, 2, 3, 4][:-1]", []int64{1, 2, 3}},
+			{"[1, 2, 3, 4][-10:10]", []int64{1, 2, 3, 4}},
+			{"[1, 2, 3, 4][3:1]", []int64{}},
+			{"let a = [1, 2, 3]; let i = 1; a[i:i + 1]", []int64{2}},
+			{"[1, 2, 3][null:2]", []int64{1, 2}},
+			{`"monkey"[1:4]`, "onk"},
+			{`"monkey"[:-3]`, "mon"},
+			{`"héllo"[1:-1]`, "éll"},
+			{`"日本語"[:2]`, "日本"},
+			{`"monkey"[3:]`, "key"},
+			{`"monkey"[4:2]`, ""},
+			{"let a = null; a?.[1:]", nil},
+		}
+
+		for _, test := range tests {
+			evaluated := evaluateInput(t, test.input)
+
+			switch expected := test.expected.(type) {
+			case []int64:
+				array, ok := evaluated.(*object.Array)
+				if !ok {
+					t.Errorf("Object is not Array. Got %T: %+v", evaluated, evaluated)
+					continue
+				}
+
+				if len(array.Elements) != len(expected) {
+					t.Errorf("Wrong number of elements. Want %d, got %d", len(expected), len(array.Elements))
+					continue
+				}
+
+				for i, element := range expected {
+					assertIntegerObject(t, array.Elements[i], element)
+				}
+			case string:
+				str, ok := evaluated.(*object.String)
+				if !ok {
+					t.Errorf("Object is not a string. Got %T: %+v", evaluated, evaluated)
+					continue
+				}
+
+				if str.Value != expected {
+					t.Errorf("String has wrong value. Want %q, got %q", expected, str.Value)
+				}
+			default:
+				assertNullObject(t, evaluated)
+			}
+		}
+	})
+
 	t.Run("Hash literals", func(t *testing.T) {
 		input := `let two = "two";
 		{
@@ -451,6 +1217,312 @@ func TestEvaluator(t *testing.T) {
 		}
 	})
 
+	t.Run("Null literal and optional chaining", func(t *testing.T) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{"null", nil},
+			{"null == null", true},
+			{"null != 1", true},
+			{"null ?? 5", 5},
+			{"3 ?? 5", 3},
+			{"false ?? 5", false},
+			{"null ?? null", nil},
+			{`let config = {"port": 80}; config["host"] ?? 8080`, 8080},
+			{`let config = {"db": {"port": 5432}}; config?.["db"]?.["port"]`, 5432},
+			{`let config = {"db": {"port": 5432}}; config["cache"]?.["port"] ?? 6379`, 6379},
+			{`let config = null; config?.["db"]`, nil},
+			{"let f = fn(x) { x * 2 }; f?.(2)", 4},
+			{"let f = null; f?.(2)", nil},
+			{"let f = null; f?.(unknown)", nil},
+			{"let x = null; x?.[1][2]", nil},
+			{"let x = null; x?.[1:][0]", nil},
+			{"let f = null; f?.(1)(2)", nil},
+			{"let x = null; x?.[0]?.[1][2] ?? 3", 3},
+			{"let x = [[1, [2, 3]]]; x?.[0][1][1]", 3},
+			{"let f = fn(x) { fn(y) { x + y } }; f?.(1)(2)", 3},
+		}
+
+		for _, test := range tests {
+			evaluated := evaluateInput(t, test.input)
+
+			switch expected := test.expected.(type) {
+			case int:
+				assertIntegerObject(t, evaluated, int64(expected))
+			case bool:
+				assertBooleanObject(t, evaluated, expected)
+			default:
+				assertNullObject(t, evaluated)
+			}
+		}
+	})
+
+	t.Run("Field expressions and method calls", func(t *testing.T) {
+		type errorMessage string
+
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{`let config = {"db": {"port": 5432}}; config.db.port`, 5432},
+			{`{"a": 1}.b`, nil},
+			{`let config = null; config?.db?.port`, nil},
+			{`let config = {"db": null}; config.db?.port ?? 6379`, 6379},
+			{`"monkey".upper()`, "MONKEY"},
+			{`" a,b ".trim().split(",").join("-")`, "a-b"},
+			{`"{} + {}".format(1, 2)`, "1 + 2"},
+			{`[1, 2].push(3).map(fn(x) { x * 2 }).len()`, 3},
+			{`[1, 2, 3].reduce(0, fn(acc, x) { acc + x })`, 6},
+			{`{"a": 1, "b": 2}.keys().len()`, 2},
+			{`{"len": fn() { 42 }}.len()`, 42},
+			{`let upper = "abc".upper; upper()`, "ABC"},
+			{`let s = null; s?.upper()`, nil},
+			{`let x = null; x?.y.z`, nil},
+			{`let x = null; x?.y.z(1).w`, nil},
+			{`let s = null; s?.trim().upper()`, nil},
+			{`let x = {"y": {"z": 1}}; x?.y.z`, 1},
+			{`let x = {"y": null}; x.y?.z.w ?? 2`, 2},
+			{`let x = null; try { (x?.y).z } catch (e) { e.kind }`, "AttributeError"},
+			{`let f = null; f?.upper(unknown)`, nil},
+			{`fn(s) { s.upper() }("tail")`, "TAIL"},
+			{`try { throw error("failed") } catch (e) { e.message }`, "failed"},
+			{`try { 1.upper() } catch (e) { e.kind + ": " + e.message }`, "AttributeError: INTEGER has no property or method upper"},
+			{`try { "a".first } catch (e) { e.message }`, "STRING has no property or method first"},
+			{`"a".upper(1)`, errorMessage("wrong number of arguments. Got 2, want 1.")},
+		}
+
+		for _, test := range tests {
+			evaluated := evaluateInput(t, test.input)
+
+			switch expected := test.expected.(type) {
+			case int:
+				assertIntegerObject(t, evaluated, int64(expected))
+			case string:
+				assertStringObject(t, evaluated, expected)
+			case errorMessage:
+				errorObject, ok := evaluated.(*object.Error)
+				if !ok {
+					t.Errorf("Object is not Error. Got %T: %+v", evaluated, evaluated)
+					continue
+				}
+
+				if errorObject.Message != string(expected) {
+					t.Errorf("wrong error message. Expected %q, got %q", expected, errorObject.Message)
+				}
+			default:
+				assertNullObject(t, evaluated)
+			}
+		}
+
+		builtins := object.NewRegistry()
+		builtins.RegisterMethod(object.INTEGER, "double", 0, "Doubles an integer.", func(runtime object.Runtime, args ...object.Object) object.Object {
+			return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+		})
+
+		env := object.NewEnvironment()
+		env.SetBuiltins(builtins)
+
+		evaluated := Eval(parser.NewParser(lexer.NewLexer("21.double() + 2.double()")).ParseProgram(), env)
+		assertIntegerObject(t, evaluated, 46)
+
+		builtins.Register("crash", 0, "", func(runtime object.Runtime, args ...object.Object) object.Object {
+			panic("crashed")
+		})
+
+		_, err := EvalContext(context.Background(), parser.NewParser(lexer.NewLexer("crash()")).ParseProgram(), env)
+		if err == nil || err.Error() != "internal error: crashed" {
+			t.Errorf("panics should be returned as errors, got %v", err)
+		}
+	})
+
+	t.Run("Tasks and channels", func(t *testing.T) {
+		type errorMessage string
+
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{`let t = spawn fn() { 40 + 2 }; await t`, 42},
+			{`let add = fn(a, b) { a + b }; await spawn add(40, 2)`, 42},
+			{`let double = fn(x) { x * 2 }; [1, 2, 3].map(fn(x) { spawn double(x) }).map(fn(t) { await t }).reduce(0, fn(a, b) { a + b })`, 12},
+			{`let x = 1; let t = spawn fn() { x + 1 }; await t`, 2},
+			{`let x = 1; let t = spawn fn() { x }(); let x = 2; await t`, 1},
+			{`let x = 1; let get = fn() { x }; let t = spawn fn() { spawn get() }(); let x = 2; await await t`, 1},
+			{`await spawn "abc".upper`, "ABC"},
+			{`let ch = chan(); spawn fn() { send(ch, 1); send(ch, 2); close(ch) }; recv(ch) + recv(ch) + (recv(ch) ?? 10)`, 13},
+			{`let ch = chan(2); ch.send(1); ch.send(2); ch.close(); ch.recv() + ch.recv()`, 3},
+			{`let ch = chan(); let results = chan(); let worker = fn() { let n = recv(ch); send(results, n * n) }; spawn worker(); spawn worker(); send(ch, 3); send(ch, 4); recv(results) + recv(results)`, 25},
+			{`let ch = chan(1); select([[ch, 5]])["index"]`, 0},
+			{`let a = chan(); let b = chan(1); send(b, 2); select([a, b])["value"]`, 2},
+			{`select([chan()], "none")["value"]`, "none"},
+			{`let ch = chan(); spawn fn() { send(ch, 1) }; select([ch])["value"]`, 1},
+			{`let t = spawn fn() { throw error("failed") }; try { await t } catch (e) { e.message }`, "failed"},
+			{`try { recv(chan()) } catch (e) { e.kind + ": " + e.message }`, "DeadlockError: all tasks are waiting"},
+			{`let ch = chan(); let t = spawn fn() { recv(ch) }; try { await t } catch (e) { e.kind }`, "DeadlockError"},
+			{`let ch = chan(); close(ch); try { send(ch, 1) } catch (e) { e.message }`, "send on closed channel"},
+			{`try { spawn 1 } catch (e) { e.message }`, "cannot spawn INTEGER"},
+			{`try { await 1 } catch (e) { e.message }`, "cannot await INTEGER"},
+			{"chan(\"a\")", errorMessage("argument to `chan` must be INTEGER, got STRING")},
+		}
+
+		for _, test := range tests {
+			evaluated := evaluateInput(t, test.input)
+
+			switch expected := test.expected.(type) {
+			case int:
+				assertIntegerObject(t, evaluated, int64(expected))
+			case string:
+				assertStringObject(t, evaluated, expected)
+			case errorMessage:
+				errorObject, ok := evaluated.(*object.Error)
+				if !ok {
+					t.Errorf("Object is not Error. Got %T: %+v", evaluated, evaluated)
+					continue
+				}
+
+				if errorObject.Message != string(expected) {
+					t.Errorf("wrong error message. Expected %q, got %q", expected, errorObject.Message)
+				}
+			}
+		}
+
+		var out bytes.Buffer
+		env := object.NewEnvironment()
+		env.SetStdout(&out)
+		env.SetSchedulerMode(object.Deterministic)
+
+		Eval(parser.NewParser(lexer.NewLexer(`
+			let ch = chan();
+			let worker = fn(name) { puts(name + " started"); send(ch, name); puts(name + " done") };
+			let a = spawn worker("a");
+			let b = spawn worker("b");
+			puts("main");
+			puts(recv(ch));
+			puts(recv(ch));
+			await a;
+			await b;
+		`)).ParseProgram(), env)
+
+		expected := "main\na started\na done\nb started\na\nb\nb done\n"
+		if out.String() != expected {
+			t.Errorf("wrong output. Want %q, got %q", expected, out.String())
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		for _, input := range []string{
+			"let spin = fn() { spin() }; spawn spin(); recv(chan())",
+			"let spin = fn() { spin() }; let t = spawn spin(); await t",
+		} {
+			program := parser.NewParser(lexer.NewLexer(input)).ParseProgram()
+
+			_, err := EvalContext(ctx, program, object.NewEnvironment())
+			if !errors.Is(err, object.ErrTimeout) {
+				t.Errorf("%s: expected ErrTimeout, got %v", input, err)
+			}
+		}
+	})
+
+	t.Run("Generators and for loops", func(t *testing.T) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{`let count = fn() { yield 1; yield 2; yield 3 }; let sum = 0; for (x in count()) { let sum = sum + x }; sum`, 6},
+			{`let collect = fn(it) { let out = []; for (x in it) { let out = out.push(x) }; out }; let g = fn() { let a = 1; yield a; let b = a + 1; yield b; yield a + b }; collect(g())`, []int{1, 2, 3}},
+			{`let collect = fn(it) { let out = []; for (x in it) { let out = out.push(x) }; out }; let evens = fn(xs) { for (x in xs) { if (x / 2 * 2 == x) { yield x } } }; collect(evens([1, 2, 3, 4]))`, []int{2, 4}},
+			{`let collect = fn(it) { let out = []; for (x in it) { let out = out.push(x) }; out }; let count = fn(n) { for (x in [1, 2, 3, 4]) { if (n > x - 1) { yield x } } }; let double = fn(it) { for (x in it) { yield x * 2 } }; collect(double(count(3)))`, []int{2, 4, 6}},
+			{`let collect = fn(it) { let out = []; for (x in it) { let out = out.push(x) }; out }; let g = fn() { yield 1 }; let it = g(); collect(it).len() + collect(it).len()`, 1},
+			{`let g = fn() { yield 1; throw error("unreachable") }; let first = fn(it) { for (x in it) { return x } }; first(g())`, 1},
+			{`let g = fn() { yield 1; throw error("failed") }; try { for (x in g()) { x } } catch (e) { e.message }`, "failed"},
+			{`let g = fn() { try { yield "a"; throw error("b") } catch (e) { yield e.message } }; let s = ""; for (x in g()) { let s = s + x }; s`, "ab"},
+			{`let s = ""; for (c in "abc") { let s = c + s }; s`, "cba"},
+			{`let s = []; for (c in "héllo") { let s = push(s, c) }; join(s, "|")`, "h|é|l|l|o"},
+			{`let s = ""; for (k in {"b": 1, "a": 2}) { let s = s + k }; s`, "ab"},
+			{`let find = fn(xs) { for (x in xs) { if (x > 1) { return x } } }; find([1, 2, 3])`, 2},
+			{`let xs = [1, 2]; for (x in xs) { let xs = xs.push(x) }; xs`, []int{1, 2, 1, 2}},
+			{`(for (x in [1]) { x }) ?? "null"`, "null"},
+			{`try { for (x in 1) { x } } catch (e) { e.kind + ": " + e.message }`, "TypeError: INTEGER is not iterable"},
+		}
+
+		for _, test := range tests {
+			evaluated := evaluateInput(t, test.input)
+
+			switch expected := test.expected.(type) {
+			case int:
+				assertIntegerObject(t, evaluated, int64(expected))
+			case string:
+				assertStringObject(t, evaluated, expected)
+			case []int:
+				array, ok := evaluated.(*object.Array)
+				if !ok {
+					t.Errorf("Object is not Array. Got %T: %+v", evaluated, evaluated)
+					continue
+				}
+
+				if len(array.Elements) != len(expected) {
+					t.Errorf("wrong number of elements. Want %d, got %d", len(expected), len(array.Elements))
+					continue
+				}
+
+				for i, element := range expected {
+					assertIntegerObject(t, array.Elements[i], int64(element))
+				}
+			}
+		}
+	})
+
+	t.Run("Modules", func(t *testing.T) {
+		type errorMessage string
+
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{`let math = import "math"; math.area(2)`, 12},
+			{`let math = import "math"; let square = 1; math.area(2) + square`, 13},
+			{`let pi = 100; let circle = import "geometry/circle"; circle.circumference(1) + pi`, 106},
+			{`let circle = import "geometry/circle"; let math = import "math"; math.pi`, 3},
+			{`if ((import "math") == (import "math")) { "same" }`, "same"},
+			{`try { (import "math").square } catch (e) { e.kind }`, "AttributeError"},
+			{`try { import "failing" } catch (e) { e.message }`, "module failed"},
+			{`export let x = 1; x`, 1},
+			{`try { import "missing" } catch (e) { e.kind }`, "ImportError"},
+			{`try { import "cycle/a" } catch (e) { e.message }`, "import cycle: cycle/a -> cycle/b -> cycle/a"},
+			{`if (false) { import "missing" } else { 1 }`, 1},
+			{`import "cycle/a"`, errorMessage("import cycle: cycle/a -> cycle/b -> cycle/a")},
+			{`import "missing"`, errorMessage("module not found: missing")},
+			{`import "../math"`, errorMessage(`invalid module name "../math"`)},
+			{`import "broken"`, errorMessage(`module broken: Expected next token to be "IDENT", but got "="; No prefixParseFunction for given token: "="`)},
+		}
+
+		for _, test := range tests {
+			env := object.NewEnvironment()
+			env.SetModuleLoader(object.NewModuleLoader("../testdata/modules"))
+
+			evaluated := Eval(parser.NewParser(lexer.NewLexer(test.input)).ParseProgram(), env)
+
+			switch expected := test.expected.(type) {
+			case int:
+				assertIntegerObject(t, evaluated, int64(expected))
+			case string:
+				assertStringObject(t, evaluated, expected)
+			case errorMessage:
+				errorObject, ok := evaluated.(*object.Error)
+				if !ok {
+					t.Errorf("Object is not Error. Got %T: %+v", evaluated, evaluated)
+					continue
+				}
+
+				if errorObject.Message != string(expected) {
+					t.Errorf("wrong error message. Expected %q, got %q", expected, errorObject.Message)
+				}
+			}
+		}
+	})
+
 	t.Run("Hash index expressions", func(t *testing.T) {
 		tests := []struct {
 			input    string
@@ -488,6 +1560,22 @@ func TestEvaluator(t *testing.T) {
 				`{false: 5}[false]`,
 				5,
 			},
+			{
+				`{[1, 2]: 5}[[1, 2]]`,
+				5,
+			},
+			{
+				`let x = 1; let y = 2; {[x, y]: 5, [y, x]: 6}[[2, 1]]`,
+				6,
+			},
+			{
+				`{[1, [2, "three"]]: 5}[[1, [2, "three"]]]`,
+				5,
+			},
+			{
+				`{[1, 2]: 5}[[1, 2, 3]]`,
+				nil,
+			},
 		}
 
 		for _, test := range tests {
@@ -532,6 +1620,20 @@ func assertBooleanObject(t *testing.T, evaluated object.Object, want bool) {
 	}
 }
 
+func assertStringObject(t *testing.T, evaluated object.Object, want string) {
+	t.Helper()
+
+	stringObject, ok := evaluated.(*object.String)
+
+	if !ok {
+		t.Errorf("Object is not a string. Got %T: %+v", evaluated, evaluated)
+	} else {
+		if stringObject.Value != want {
+			t.Errorf("Object has improper value. Expected %q, got %q", want, stringObject.Value)
+		}
+	}
+}
+
 func assertNullObject(t *testing.T, evaluated object.Object) {
 	if evaluated != NULL {
 		t.Errorf("Object is not NULL. Got %T:%+v", evaluated, evaluated)
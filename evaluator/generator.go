@@ -0,0 +1,247 @@
+package evaluator
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/nhoffmann/monkey/ast"
+	"github.com/nhoffmann/monkey/object"
+)
+
+// errGeneratorStopped unwinds the body of a generator which is no longer
+// referenced.
+var errGeneratorStopped = errors.New("generator stopped")
+
+// Generator is returned by calls of generator functions. Its body is
+// evaluated in a goroutine of its own, which is suspended while the generator
+// is not resumed. A generator belongs to the run which created it: the
+// goroutine is stopped once the run ends, or earlier once the generator is
+// garbage collected, and the generator is exhausted from then on. A generator
+// must not be resumed by several tasks at once.
+type Generator struct {
+	*generatorState
+}
+
+func (g *Generator) Type() object.ObjectType { return object.GENERATOR }
+func (g *Generator) Inspect() string         { return fmt.Sprintf("Generator[%p]", g) }
+
+// generatorState is shared by a generator and the goroutine evaluating its
+// body, which does not reference the generator itself, so the generator can
+// be collected while the goroutine is suspended.
+type generatorState struct {
+	function *object.Function
+	env      *object.Environment
+
+	resumed chan struct{}
+	results chan generatorResult
+	stop    chan struct{}
+
+	closeOnce  sync.Once
+	unregister func()
+
+	started bool
+	running bool
+	done    bool
+}
+
+// generatorResult is a value yielded by the body of a generator or, once done
+// is set, the exception or interrupt the body failed with, if any.
+type generatorResult struct {
+	value object.Object
+	done  bool
+}
+
+// newGenerator creates a generator evaluating the body of function in env,
+// the environment of the call.
+func newGenerator(function *object.Function, env *object.Environment) *Generator {
+	state := &generatorState{
+		function: function,
+		env:      env,
+		resumed:  make(chan struct{}),
+		results:  make(chan generatorResult),
+		stop:     make(chan struct{}),
+	}
+	env.SetGenerator(state)
+	state.unregister = env.Scheduler().OnStop(state.close)
+
+	generator := &Generator{state}
+	runtime.SetFinalizer(generator, func(g *Generator) { g.close() })
+
+	return generator
+}
+
+// close stops the goroutine evaluating the body.
+func (g *generatorState) close() {
+	g.closeOnce.Do(func() { close(g.stop) })
+}
+
+// resume evaluates the body of g until it yields the next value. ok is false
+// once the body returned. Exceptions and interrupts of the body are returned
+// and exhaust g. The body is evaluated with the budget and the scheduler of
+// env, the environment resuming g.
+func (g *generatorState) resume(env *object.Environment) (value object.Object, ok bool) {
+	select {
+	case <-g.stop:
+		g.done = true
+	default:
+	}
+
+	if g.done {
+		return nil, false
+	}
+	if g.running {
+		return newError(object.ValueError, "generator already running"), false
+	}
+
+	g.env.SetBudget(env.Budget())
+	g.env.SetScheduler(env.Scheduler())
+
+	if !g.started {
+		g.started = true
+		go g.run()
+	}
+
+	g.running = true
+	var result generatorResult
+	select {
+	case g.resumed <- struct{}{}:
+		select {
+		case result = <-g.results:
+		case <-g.stop:
+			result.done = true
+		}
+	case <-g.stop:
+		result.done = true
+	}
+	g.running = false
+
+	if result.done {
+		g.done = true
+		g.unregister()
+		return result.value, false
+	}
+
+	return result.value, true
+}
+
+// run evaluates the body once the generator is resumed for the first time.
+func (g *generatorState) run() {
+	var result object.Object
+
+	defer func() {
+		if r := recover(); r != nil {
+			result = newError(object.RuntimeError, "internal error: %v", r)
+		}
+
+		switch result.(type) {
+		case *object.Exception, *interrupt:
+		default:
+			result = nil
+		}
+
+		select {
+		case g.results <- generatorResult{value: result, done: true}:
+		case <-g.stop:
+		}
+	}()
+
+	select {
+	case <-g.resumed:
+	case <-g.stop:
+		return
+	}
+
+	result = Eval(g.function.Body, g.env)
+	if exception, ok := result.(*object.Exception); ok {
+		result = withStackTrace(exception, g.env)
+	}
+}
+
+// yield passes value to the loop resuming the generator and waits until it is
+// resumed again. Once the generator is stopped an interrupt is returned,
+// which unwinds the body without running finally blocks.
+func (g *generatorState) yield(value object.Object) object.Object {
+	select {
+	case g.results <- generatorResult{value: value}:
+	case <-g.stop:
+		return &interrupt{err: errGeneratorStopped}
+	}
+
+	select {
+	case <-g.resumed:
+		return nil
+	case <-g.stop:
+		return &interrupt{err: errGeneratorStopped}
+	}
+}
+
+func evalYieldStatement(node *ast.YieldStatement, env *object.Environment) object.Object {
+	value := Eval(node.Value, env)
+	if isAbrupt(value) {
+		return value
+	}
+
+	g, ok := env.Generator().(*generatorState)
+	if !ok {
+		return newError(object.RuntimeError, "yield outside of a generator")
+	}
+
+	return g.yield(value)
+}
+
+// evalForExpression evaluates the body of the loop for every element of the
+// iterable, binding it in env. Like jumping back in the VM, every iteration
+// takes a step.
+func evalForExpression(node *ast.ForExpression, env *object.Environment) object.Object {
+	iterable := Eval(node.Iterable, env)
+	if isAbrupt(iterable) {
+		return iterable
+	}
+
+	next, err := iterate(iterable, env)
+	if err != nil {
+		return err
+	}
+
+	for {
+		element, ok := next()
+		if !ok {
+			if element != nil {
+				// the generator failed
+				return element
+			}
+			return NULL
+		}
+
+		env.Set(node.Variable.Value, element)
+
+		result := Eval(node.Body, env)
+		if isAbrupt(result) {
+			return result
+		}
+
+		if err := env.Budget().Step(); err != nil {
+			return &interrupt{err: err}
+		}
+	}
+}
+
+// iterate returns a function returning the elements of iterable. Once all
+// elements have been returned it reports false, together with the exception
+// or interrupt a generator failed with.
+func iterate(iterable object.Object, env *object.Environment) (func() (object.Object, bool), object.Object) {
+	if g, ok := iterable.(*Generator); ok {
+		return func() (object.Object, bool) {
+			return g.resume(env)
+		}, nil
+	}
+
+	iterator, ok := object.Iterate(iterable)
+	if !ok {
+		return nil, &object.Exception{Error: object.NotIterableError(iterable)}
+	}
+
+	return iterator.Next, nil
+}
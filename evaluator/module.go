@@ -0,0 +1,56 @@
+package evaluator
+
+import (
+	"github.com/nhoffmann/monkey/ast"
+	"github.com/nhoffmann/monkey/object"
+)
+
+// evalImportExpression returns the module node.Name. The first import
+// evaluates the module in an environment of its own, later imports from env
+// and the environments sharing its modules return the same module.
+func evalImportExpression(node *ast.ImportExpression, env *object.Environment) object.Object {
+	if module, ok := env.ImportedModule(node.Name); ok {
+		return module
+	}
+
+	if err := object.ImportCycleError(env.Imports(), node.Name); err != nil {
+		return &object.Exception{Error: err}
+	}
+
+	loader := env.ModuleLoader()
+	if loader == nil {
+		return newError(object.ImportError, "module not found: %s", node.Name)
+	}
+
+	program, err := loader.Load(node.Name)
+	if err != nil {
+		if runtimeError, ok := err.(*object.Error); ok {
+			return &object.Exception{Error: runtimeError}
+		}
+		return newError(object.ImportError, "%s", err)
+	}
+
+	moduleEnv := object.NewModuleEnvironment(env, node.Name)
+
+	result := evalProgram(program.Statements, moduleEnv)
+	switch result.(type) {
+	case *object.Exception, *interrupt:
+		return result
+	}
+
+	exports := map[string]object.Object{}
+	for _, statement := range program.Statements {
+		if export, ok := statement.(*ast.ExportStatement); ok {
+			value, ok := moduleEnv.Get(export.Statement.Name.Value)
+			if !ok {
+				value = NULL
+			}
+			exports[export.Statement.Name.Value] = value
+		}
+	}
+
+	module := &object.Module{Name: node.Name, Exports: exports}
+	env.SetImportedModule(module)
+
+	return module
+}
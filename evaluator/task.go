@@ -0,0 +1,88 @@
+package evaluator
+
+import (
+	"github.com/nhoffmann/monkey/ast"
+	"github.com/nhoffmann/monkey/object"
+)
+
+// evalSpawnExpression evaluates the function and the arguments of the spawned
+// call and starts a task calling the function. The task evaluates in an
+// environment of its own, but shares the environments the function closes
+// over with the spawning task, except for the globals, which it sees as they
+// were when it was spawned.
+func evalSpawnExpression(node *ast.SpawnExpression, env *object.Environment) object.Object {
+	var function object.Object
+	var args []object.Object
+
+	if call, ok := node.Call.(*ast.CallExpression); ok && !call.Optional {
+		function = Eval(call.Function, env)
+		if isAbrupt(function) {
+			return function
+		}
+
+		args = evalExpressions(call.Arguments, env)
+		if len(args) == 1 && isAbrupt(args[0]) {
+			return args[0]
+		}
+	} else {
+		function = Eval(node.Call, env)
+		if isAbrupt(function) {
+			return function
+		}
+	}
+
+	switch function.(type) {
+	case *object.Function, *object.Builtin:
+	default:
+		return newError(object.TypeError, "cannot spawn %s", function.Type())
+	}
+
+	scheduler := env.Scheduler()
+	if scheduler == nil {
+		return newError(object.RuntimeError, "tasks can only be spawned while running a program")
+	}
+
+	taskEnv := object.NewTaskEnvironment(env, nil)
+	return scheduler.Spawn(env.Budget(), func(budget *object.Budget) (object.Object, error) {
+		taskEnv.SetBudget(budget)
+		return runTask(function, args, taskEnv)
+	})
+}
+
+// runTask calls the function of a task. Like a run, it returns uncaught
+// exceptions as *object.Error and recovers from panics.
+func runTask(function object.Object, args []object.Object, env *object.Environment) (result object.Object, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = object.NewError(object.RuntimeError, "internal error: %v", r)
+		}
+	}()
+
+	switch result := applyFunction(function, args, env).(type) {
+	case *interrupt:
+		return nil, result.err
+	case *object.Exception:
+		return nil, result.Error
+	default:
+		return result, nil
+	}
+}
+
+// awaitTask waits for task to finish and returns its result. The error the
+// task failed with is raised.
+func awaitTask(task object.Object, env *object.Environment) object.Object {
+	t, ok := task.(*object.Task)
+	if !ok {
+		return newError(object.TypeError, "cannot await %s", task.Type())
+	}
+
+	result, err := env.Scheduler().Await(t)
+	if err != nil {
+		if runtimeError, ok := err.(*object.Error); ok {
+			return &object.Exception{Error: runtimeError}
+		}
+		return &interrupt{err: err}
+	}
+
+	return result
+}
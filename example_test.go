@@ -0,0 +1,84 @@
+package monkey_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nhoffmann/monkey"
+	"github.com/nhoffmann/monkey/object"
+)
+
+func Example() {
+	interpreter, err := monkey.NewInterpreter(monkey.WithStdout(os.Stdout))
+	if err != nil {
+		panic(err)
+	}
+	interpreter.SetGlobal("name", &object.String{Value: "monkey"})
+
+	err = interpreter.Compile(`puts(format("Hello {}!", name))`)
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = interpreter.Run(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	// Output: Hello monkey!
+}
+
+func ExampleInterpreter_Call() {
+	interpreter, err := monkey.NewInterpreter(monkey.WithEngine(monkey.Evaluator), monkey.WithStepBudget(1000))
+	if err != nil {
+		panic(err)
+	}
+
+	err = interpreter.Compile(`let discount = fn(price) { if (price > 100) { price - 10 } else { price } };`)
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = interpreter.Run(context.Background())
+	if err != nil {
+		panic(err)
+	}
+
+	for _, price := range []int64{50, 150} {
+		result, err := interpreter.Call("discount", &object.Integer{Value: price})
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(result.Inspect())
+	}
+	// Output:
+	// 50
+	// 140
+}
+
+func ExampleInterpreter_RegisterFunc() {
+	interpreter, err := monkey.NewInterpreter()
+	if err != nil {
+		panic(err)
+	}
+
+	err = interpreter.RegisterFunc("title", "Capitalizes the words of s.", strings.Title)
+	if err != nil {
+		panic(err)
+	}
+
+	err = interpreter.Compile(`title("hello monkey")`)
+	if err != nil {
+		panic(err)
+	}
+
+	result, err := interpreter.Run(context.Background())
+	if err != nil {
+		panic(err)
+	}
+
+	value, _ := object.ToGo(result)
+	fmt.Printf("%q\n", value)
+	// Output: "Hello Monkey"
+}
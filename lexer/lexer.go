@@ -1,6 +1,8 @@
 package lexer
 
 import (
+	"fmt"
+
 	"github.com/nhoffmann/monkey/token"
 )
 
@@ -9,6 +11,17 @@ type Lexer struct {
 	position     int
 	readPosition int
 	char         byte
+	errors       []error
+}
+
+// IllegalTokenError describes input that could not be turned into a token.
+type IllegalTokenError struct {
+	Literal string
+	Reason  string
+}
+
+func (ite *IllegalTokenError) Error() string {
+	return fmt.Sprintf("Illegal token %q: %s", ite.Literal, ite.Reason)
 }
 
 func NewLexer(input string) *Lexer {
@@ -39,6 +52,18 @@ func (l *Lexer) NextToken() token.Token {
 		} else {
 			tok = newToken(token.BANG, l.char)
 		}
+	case '?':
+		if l.peakChar() == '?' {
+			ch := l.char
+			l.readChar()
+			tok = token.Token{Type: token.NULLISH, Literal: string(ch) + string(l.char)}
+		} else if l.peakChar() == '.' {
+			ch := l.char
+			l.readChar()
+			tok = token.Token{Type: token.OPTIONAL_CHAIN, Literal: string(ch) + string(l.char)}
+		} else {
+			tok = newToken(token.QUESTION, l.char)
+		}
 	case '/':
 		tok = newToken(token.SLASH, l.char)
 	case '*':
@@ -47,6 +72,8 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.LT, l.char)
 	case '>':
 		tok = newToken(token.GT, l.char)
+	case '.':
+		tok = newToken(token.DOT, l.char)
 	case ',':
 		tok = newToken(token.COMMA, l.char)
 	case ';':
@@ -81,11 +108,9 @@ func (l *Lexer) NextToken() token.Token {
 			tok.Type = token.LookupIdent(tok.Literal)
 			return tok
 		} else if isDigit(l.char) {
-			tok.Type = token.INT
-			tok.Literal = l.readNumber()
-			return tok
+			return l.readNumber()
 		} else {
-			tok = newToken(token.ILLEGAL, l.char)
+			tok = l.illegalToken(string(l.char), "unexpected character")
 		}
 	}
 
@@ -93,6 +118,17 @@ func (l *Lexer) NextToken() token.Token {
 	return tok
 }
 
+// Errors returns the errors for all illegal tokens read so far.
+func (l *Lexer) Errors() []error {
+	return l.errors
+}
+
+func (l *Lexer) illegalToken(literal, reason string) token.Token {
+	l.errors = append(l.errors, &IllegalTokenError{Literal: literal, Reason: reason})
+
+	return token.Token{Type: token.ILLEGAL, Literal: literal}
+}
+
 func isLetter(char byte) bool {
 	return 'a' <= char && char <= 'z' || 'A' <= char && char <= 'Z' || char == '_'
 }
@@ -138,13 +174,70 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
-func (l *Lexer) readNumber() string {
+// readNumber reads a decimal, hexadecimal (0x), octal (0o) or binary (0b)
+// integer literal. Digits may be separated by single underscores.
+func (l *Lexer) readNumber() token.Token {
 	position := l.position
-	for isDigit(l.char) {
+	base, name := 10, "decimal"
+
+	if l.char == '0' {
+		switch l.peakChar() {
+		case 'x', 'X':
+			base, name = 16, "hexadecimal"
+		case 'o', 'O':
+			base, name = 8, "octal"
+		case 'b', 'B':
+			base, name = 2, "binary"
+		}
+
+		if base != 10 {
+			l.readChar()
+			l.readChar()
+		}
+	}
+
+	digits := 0
+	reason := ""
+	for isLetter(l.char) || isDigit(l.char) {
+		switch {
+		case l.char == '_':
+			if reason == "" && !isDigitOfBase(l.peakChar(), base) {
+				reason = "'_' must separate successive digits"
+			}
+		case isDigitOfBase(l.char, base):
+			digits++
+		default:
+			if reason == "" {
+				reason = fmt.Sprintf("invalid digit %q in %s literal", l.char, name)
+			}
+		}
+
 		l.readChar()
 	}
 
-	return l.input[position:l.position]
+	literal := l.input[position:l.position]
+
+	if reason == "" && digits == 0 {
+		reason = fmt.Sprintf("%s literal has no digits", name)
+	}
+	if reason != "" {
+		return l.illegalToken(literal, reason)
+	}
+
+	return token.Token{Type: token.INT, Literal: literal}
+}
+
+func isDigitOfBase(char byte, base int) bool {
+	switch base {
+	case 2:
+		return char == '0' || char == '1'
+	case 8:
+		return '0' <= char && char <= '7'
+	case 16:
+		return isDigit(char) || 'a' <= char && char <= 'f' || 'A' <= char && char <= 'F'
+	default:
+		return isDigit(char)
+	}
 }
 
 func (l *Lexer) readString() string {
@@ -30,6 +30,13 @@ if (5 < 10) {
 "foo bar"
 [1, 2];
 {"foo": "bar"}
+null ?? a?.[1];
+f?.(x)
+v?
+s.upper() h?.key
+await spawn f(x)
+for (x in xs) { yield x }
+export let m = import "m";
 `
 
 	tests := []struct {
@@ -134,6 +141,52 @@ if (5 < 10) {
 		{token.COLON, ":"},
 		{token.STRING, "bar"},
 		{token.RBRACE, "}"},
+		{token.NULL, "null"},
+		{token.NULLISH, "??"},
+		{token.IDENT, "a"},
+		{token.OPTIONAL_CHAIN, "?."},
+		{token.LBRACKET, "["},
+		{token.INT, "1"},
+		{token.RBRACKET, "]"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "f"},
+		{token.OPTIONAL_CHAIN, "?."},
+		{token.LPAREN, "("},
+		{token.IDENT, "x"},
+		{token.RPAREN, ")"},
+		{token.IDENT, "v"},
+		{token.QUESTION, "?"},
+		{token.IDENT, "s"},
+		{token.DOT, "."},
+		{token.IDENT, "upper"},
+		{token.LPAREN, "("},
+		{token.RPAREN, ")"},
+		{token.IDENT, "h"},
+		{token.OPTIONAL_CHAIN, "?."},
+		{token.IDENT, "key"},
+		{token.AWAIT, "await"},
+		{token.SPAWN, "spawn"},
+		{token.IDENT, "f"},
+		{token.LPAREN, "("},
+		{token.IDENT, "x"},
+		{token.RPAREN, ")"},
+		{token.FOR, "for"},
+		{token.LPAREN, "("},
+		{token.IDENT, "x"},
+		{token.IN, "in"},
+		{token.IDENT, "xs"},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.YIELD, "yield"},
+		{token.IDENT, "x"},
+		{token.RBRACE, "}"},
+		{token.EXPORT, "export"},
+		{token.LET, "let"},
+		{token.IDENT, "m"},
+		{token.ASSIGN, "="},
+		{token.IMPORT, "import"},
+		{token.STRING, "m"},
+		{token.SEMICOLON, ";"},
 		{token.EOF, ""},
 	}
 
@@ -162,3 +215,55 @@ func assertLiteral(t *testing.T, got, want string) {
 		t.Errorf("Wrong literal. Expected %q, got %q", want, got)
 	}
 }
+
+func TestIntegerLiterals(t *testing.T) {
+	input := `0xFF 0Xff 0o755 0b1010 1_000_000 0x_FF_FF 0755 0`
+
+	expected := []string{"0xFF", "0Xff", "0o755", "0b1010", "1_000_000", "0x_FF_FF", "0755", "0"}
+
+	l := NewLexer(input)
+
+	for _, literal := range expected {
+		tok := l.NextToken()
+
+		assertTokenType(t, tok.Type, token.INT)
+		assertLiteral(t, tok.Literal, literal)
+	}
+
+	if len(l.Errors()) != 0 {
+		t.Errorf("unexpected errors: %v", l.Errors())
+	}
+}
+
+func TestMalformedIntegerLiterals(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"0b102", `Illegal token "0b102": invalid digit '2' in binary literal`},
+		{"0o78", `Illegal token "0o78": invalid digit '8' in octal literal`},
+		{"0xFG", `Illegal token "0xFG": invalid digit 'G' in hexadecimal literal`},
+		{"12abc", `Illegal token "12abc": invalid digit 'a' in decimal literal`},
+		{"0x", `Illegal token "0x": hexadecimal literal has no digits`},
+		{"0b_", `Illegal token "0b_": '_' must separate successive digits`},
+		{"1__000", `Illegal token "1__000": '_' must separate successive digits`},
+		{"1000_", `Illegal token "1000_": '_' must separate successive digits`},
+	}
+
+	for _, test := range tests {
+		l := NewLexer(test.input + ";")
+
+		assertTokenType(t, l.NextToken().Type, token.ILLEGAL)
+		assertTokenType(t, l.NextToken().Type, token.SEMICOLON)
+
+		errors := l.Errors()
+		if len(errors) != 1 {
+			t.Errorf("expected 1 error for %q, got %d", test.input, len(errors))
+			continue
+		}
+
+		if errors[0].Error() != test.expectedError {
+			t.Errorf("wrong error. Expected %q, got %q", test.expectedError, errors[0])
+		}
+	}
+}
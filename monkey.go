@@ -0,0 +1,432 @@
+// Package monkey embeds the Monkey programming language into Go programs.
+//
+// An Interpreter compiles and runs programs, either on the virtual machine or
+// with the tree-walking evaluator. Globals survive between runs, so a host can
+// define functions once and call them repeatedly:
+//
+//	interpreter, err := monkey.NewInterpreter(monkey.WithStepBudget(10000))
+//	...
+//	err = interpreter.Compile(`let double = fn(x) { x * 2 };`)
+//	...
+//	_, err = interpreter.Run(ctx)
+//	...
+//	result, err := interpreter.Call("double", &object.Integer{Value: 21})
+package monkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/nhoffmann/monkey/ast"
+	"github.com/nhoffmann/monkey/compiler"
+	"github.com/nhoffmann/monkey/evaluator"
+	"github.com/nhoffmann/monkey/lexer"
+	"github.com/nhoffmann/monkey/object"
+	"github.com/nhoffmann/monkey/parser"
+	"github.com/nhoffmann/monkey/stdlib"
+	"github.com/nhoffmann/monkey/vm"
+)
+
+// Engine selects how an Interpreter executes programs.
+type Engine int
+
+const (
+	// VM compiles programs to bytecode and runs them on the virtual machine.
+	VM Engine = iota
+	// Evaluator interprets the syntax tree of programs directly.
+	Evaluator
+)
+
+// String returns the name of the engine.
+func (e Engine) String() string {
+	switch e {
+	case VM:
+		return "vm"
+	case Evaluator:
+		return "evaluator"
+	default:
+		return fmt.Sprintf("Engine(%d)", int(e))
+	}
+}
+
+// ErrNotCompiled is returned by Run if no program has been compiled yet.
+var ErrNotCompiled = errors.New("no program compiled")
+
+// ParseError holds the errors found while parsing a program.
+type ParseError struct {
+	Errors []error
+}
+
+func (pe *ParseError) Error() string {
+	messages := make([]string, len(pe.Errors))
+	for i, err := range pe.Errors {
+		messages[i] = err.Error()
+	}
+
+	return "parse error: " + strings.Join(messages, "; ")
+}
+
+// Option configures an Interpreter.
+type Option func(*Interpreter)
+
+// WithEngine selects the engine, VM by default.
+func WithEngine(engine Engine) Option {
+	return func(i *Interpreter) {
+		i.engine = engine
+	}
+}
+
+// WithMaxCallDepth limits the number of nested function calls, which is
+// object.DefaultMaxCallDepth by default.
+func WithMaxCallDepth(depth int) Option {
+	return func(i *Interpreter) {
+		i.maxCallDepth = depth
+	}
+}
+
+// WithStepBudget limits the number of function calls and loop iterations of
+// each run. Runs exceeding it fail with object.ErrBudgetExceeded.
+func WithStepBudget(steps int) Option {
+	return func(i *Interpreter) {
+		i.stepBudget = steps
+	}
+}
+
+// WithMemoryLimit limits the number of bytes each run may allocate. Runs
+// exceeding it fail with object.ErrMemoryLimitExceeded.
+func WithMemoryLimit(bytes int) Option {
+	return func(i *Interpreter) {
+		i.memoryLimit = bytes
+	}
+}
+
+// WithStdout sets the writer builtins like puts print to, which is os.Stdout by
+// default.
+func WithStdout(w io.Writer) Option {
+	return func(i *Interpreter) {
+		i.stdout = w
+	}
+}
+
+// WithBuiltins sets the registry of builtins programs can call, which holds
+// the standard builtins by default. Builtins registered with the registry
+// after the interpreter was created are only visible to the evaluator, use
+// RegisterBuiltin instead.
+func WithBuiltins(builtins *object.Registry) Option {
+	return func(i *Interpreter) {
+		i.builtins = builtins
+	}
+}
+
+// WithSchedulerMode sets the mode tasks spawned by programs run in, which is
+// object.Parallel by default. object.Deterministic makes the order tasks run
+// in reproducible, for tests.
+func WithSchedulerMode(mode object.SchedulerMode) Option {
+	return func(i *Interpreter) {
+		i.schedulerMode = mode
+	}
+}
+
+// WithModulePaths sets the directories modules imported by programs are
+// looked up in, in order. The modules of the standard library are found
+// first, by default nothing else can be imported.
+func WithModulePaths(paths ...string) Option {
+	return func(i *Interpreter) {
+		i.modulePaths = paths
+	}
+}
+
+// WithPrelude sets whether the prelude of the standard library is run when
+// the interpreter is created, which it is by default. The prelude defines
+// globals like sum and range, see the stdlib package.
+func WithPrelude(enabled bool) Option {
+	return func(i *Interpreter) {
+		i.prelude = enabled
+	}
+}
+
+// Interpreter compiles and runs Monkey programs. It keeps the globals defined
+// by programs and by the host between runs. An Interpreter must not be used
+// from more than one goroutine at a time.
+type Interpreter struct {
+	engine       Engine
+	maxCallDepth int
+	stepBudget   int
+	memoryLimit  int
+	stdout       io.Writer
+	builtins     *object.Registry
+	modulePaths  []string
+	moduleLoader *object.ModuleLoader
+	prelude      bool
+
+	schedulerMode object.SchedulerMode
+
+	// program is the program compiled last
+	program *ast.Program
+
+	// state of the VM engine
+	bytecode    *compiler.Bytecode
+	symbolTable *compiler.SymbolTable
+	constants   []object.Object
+	globals     []object.Object
+
+	// state of the evaluator engine
+	env *object.Environment
+}
+
+// NewInterpreter creates an interpreter configured by options. It fails if the
+// prelude cannot be run, for example because a builtin the prelude relies on
+// was replaced.
+func NewInterpreter(options ...Option) (*Interpreter, error) {
+	interpreter := &Interpreter{
+		engine:       VM,
+		maxCallDepth: object.DefaultMaxCallDepth,
+		stdout:       os.Stdout,
+		prelude:      true,
+	}
+
+	for _, option := range options {
+		option(interpreter)
+	}
+
+	if interpreter.builtins == nil {
+		interpreter.builtins = object.NewRegistry()
+	}
+
+	roots := []fs.FS{stdlib.Modules}
+	for _, path := range interpreter.modulePaths {
+		roots = append(roots, os.DirFS(path))
+	}
+	interpreter.moduleLoader = object.NewModuleLoaderFS(roots...)
+
+	switch interpreter.engine {
+	case Evaluator:
+		interpreter.env = object.NewEnvironment()
+		interpreter.env.SetMaxCallDepth(interpreter.maxCallDepth)
+		interpreter.env.SetStdout(interpreter.stdout)
+		interpreter.env.SetBuiltins(interpreter.builtins)
+		interpreter.env.SetSchedulerMode(interpreter.schedulerMode)
+		interpreter.env.SetModuleLoader(interpreter.moduleLoader)
+	default:
+		interpreter.symbolTable = compiler.NewSymbolTableWithBuiltins(interpreter.builtins)
+		interpreter.constants = []object.Object{}
+		interpreter.globals = make([]object.Object, vm.GlobalsSize)
+	}
+
+	if interpreter.prelude {
+		err := interpreter.runPrelude()
+		if err != nil {
+			return nil, fmt.Errorf("monkey: running the prelude failed: %w", err)
+		}
+
+		// the globals of the prelude never shadow builtins of the host
+		for _, builtin := range interpreter.builtins.Builtins() {
+			interpreter.defineBuiltin(builtin.Name)
+		}
+	}
+
+	if interpreter.engine == Evaluator {
+		interpreter.env.SetStepBudget(interpreter.stepBudget)
+		interpreter.env.SetMemoryLimit(interpreter.memoryLimit)
+	}
+
+	return interpreter, nil
+}
+
+// runPrelude runs the prelude of the standard library, which is not limited
+// by the step budget and the memory limit of the interpreter. The VM runs the
+// prelude precompiled by the stdlib package, programs compiled afterwards
+// continue its symbol table and constants.
+func (i *Interpreter) runPrelude() error {
+	if i.engine == Evaluator {
+		program, err := stdlib.ParsePrelude()
+		if err != nil {
+			return err
+		}
+
+		_, err = evaluator.EvalContext(context.Background(), program, i.env)
+		return err
+	}
+
+	bytecode, symbolTable, err := stdlib.Prelude(i.builtins)
+	if err != nil {
+		return err
+	}
+	i.symbolTable, i.constants = symbolTable, bytecode.Constants
+
+	machine := vm.NewVmWithGlobalsStore(bytecode, i.globals)
+	machine.SetMaxCallDepth(i.maxCallDepth)
+	machine.SetStdout(i.stdout)
+	machine.SetBuiltins(i.builtins)
+
+	return machine.Run(context.Background())
+}
+
+// Compile parses src and, for the VM engine, compiles it. The program is
+// executed by the next calls to Run. If compiling fails, the globals and
+// constants defined so far are left unchanged.
+func (i *Interpreter) Compile(src string) error {
+	p := parser.NewParser(lexer.NewLexer(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return &ParseError{Errors: p.Errors()}
+	}
+
+	if i.engine == Evaluator {
+		i.program = program
+		return nil
+	}
+
+	symbolTable := i.symbolTable.Copy()
+	c := compiler.NewCompilerWithState(symbolTable, i.constants)
+	c.SetModuleLoader(i.moduleLoader)
+	err := c.Compile(program)
+	if err != nil {
+		return err
+	}
+
+	i.symbolTable = symbolTable
+	i.program = program
+	i.bytecode = c.Bytecode()
+	i.constants = i.bytecode.Constants
+
+	return nil
+}
+
+// Run executes the program compiled last and returns the value of its last
+// expression statement. Uncaught exceptions are returned as *object.Error,
+// exceeded limits as the errors documented on the options. The run stops
+// with object.ErrTimeout once ctx is done.
+func (i *Interpreter) Run(ctx context.Context) (object.Object, error) {
+	if i.program == nil {
+		return nil, ErrNotCompiled
+	}
+
+	if i.engine == Evaluator {
+		return evaluator.EvalContext(ctx, i.program, i.env)
+	}
+
+	machine := i.newVm(i.bytecode)
+	err := machine.Run(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return machine.LastPoppedStackElement(), nil
+}
+
+// RegisterBuiltin makes fn available to programs compiled afterwards as the
+// builtin name, see object.Registry.Register. It replaces a global of the
+// same name, like the ones the prelude defines.
+func (i *Interpreter) RegisterBuiltin(name string, arity int, doc string, fn object.BuiltinFunction) error {
+	err := i.builtins.Register(name, arity, doc, fn)
+	if err != nil {
+		return err
+	}
+
+	i.defineBuiltin(name)
+	return nil
+}
+
+// RegisterFunc makes the Go function fn available to programs compiled
+// afterwards as the builtin name, see object.NewGoFunction. Like
+// RegisterBuiltin, it replaces a global of the same name.
+func (i *Interpreter) RegisterFunc(name string, doc string, fn interface{}) error {
+	err := i.builtins.RegisterFunc(name, doc, fn)
+	if err != nil {
+		return err
+	}
+
+	i.defineBuiltin(name)
+	return nil
+}
+
+// RegisterMethod makes fn available as the method name of values of type
+// objectType, see object.Registry.RegisterMethod. Methods are looked up when
+// they are called, so this affects programs that are already compiled as well.
+func (i *Interpreter) RegisterMethod(objectType object.ObjectType, name string, arity int, doc string, fn object.BuiltinFunction) error {
+	return i.builtins.RegisterMethod(objectType, name, arity, doc, fn)
+}
+
+// defineBuiltin makes name refer to the builtin of that name, replacing a
+// global of the same name like the prelude defines, so the builtin is called
+// on both engines.
+func (i *Interpreter) defineBuiltin(name string) {
+	if i.engine == Evaluator {
+		i.env.Delete(name)
+		return
+	}
+
+	index, _ := i.builtins.Index(name)
+	i.symbolTable.DefineBuiltin(index, name)
+}
+
+// SetGlobal binds value to name, replacing any previous binding. Programs
+// compiled afterwards can refer to it like to a global defined with let.
+func (i *Interpreter) SetGlobal(name string, value object.Object) {
+	if i.engine == Evaluator {
+		i.env.Set(name, value)
+		return
+	}
+
+	symbol := i.symbolTable.Define(name)
+	i.globals[symbol.Index] = value
+}
+
+// GetGlobal returns the value bound to the global name.
+func (i *Interpreter) GetGlobal(name string) (object.Object, bool) {
+	if i.engine == Evaluator {
+		return i.env.Get(name)
+	}
+
+	symbol, ok := i.symbolTable.Resolve(name)
+	if !ok || symbol.Scope != compiler.GlobalScope {
+		return nil, false
+	}
+
+	value := i.globals[symbol.Index]
+	return value, value != nil
+}
+
+// Call calls the global function name with args and returns its result.
+func (i *Interpreter) Call(name string, args ...object.Object) (object.Object, error) {
+	return i.CallContext(context.Background(), name, args...)
+}
+
+// CallContext is like Call, but stops with object.ErrTimeout once ctx is done.
+func (i *Interpreter) CallContext(ctx context.Context, name string, args ...object.Object) (object.Object, error) {
+	fn, ok := i.GetGlobal(name)
+	if !ok {
+		return nil, object.NewError(object.NameError, "identifier not found: %s", name)
+	}
+
+	switch fn.Type() {
+	case object.FUNCTION, object.CLOSURE, object.BUILTIN:
+	default:
+		return nil, object.NewError(object.TypeError, "not a function: %s", fn.Type())
+	}
+
+	if i.engine == Evaluator {
+		return evaluator.CallContext(ctx, fn, args, i.env)
+	}
+
+	bytecode := &compiler.Bytecode{Constants: i.constants, NumGlobals: i.symbolTable.NumGlobals()}
+	return i.newVm(bytecode).Call(ctx, fn, args...)
+}
+
+func (i *Interpreter) newVm(bytecode *compiler.Bytecode) *vm.VM {
+	machine := vm.NewVmWithGlobalsStore(bytecode, i.globals)
+	machine.SetMaxCallDepth(i.maxCallDepth)
+	machine.SetStepBudget(i.stepBudget)
+	machine.SetMemoryLimit(i.memoryLimit)
+	machine.SetStdout(i.stdout)
+	machine.SetBuiltins(i.builtins)
+	machine.SetSchedulerMode(i.schedulerMode)
+
+	return machine
+}
@@ -0,0 +1,595 @@
+package monkey
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nhoffmann/monkey/object"
+)
+
+var engines = []Engine{VM, Evaluator}
+
+func TestInterpreter(t *testing.T) {
+	for _, engine := range engines {
+		t.Run(engine.String(), func(t *testing.T) {
+			t.Run("Run", func(t *testing.T) {
+				interpreter := newInterpreter(t, WithEngine(engine))
+
+				_, err := interpreter.Run(context.Background())
+				if err != ErrNotCompiled {
+					t.Fatalf("expected ErrNotCompiled, got %v", err)
+				}
+
+				result := run(t, interpreter, "let a = 20; a + 22")
+				assertInteger(t, result, 42)
+
+				result = run(t, interpreter, "a * 2")
+				assertInteger(t, result, 40)
+			})
+
+			t.Run("Truthiness", func(t *testing.T) {
+				interpreter := newInterpreter(t, WithEngine(engine))
+
+				tests := []struct {
+					input    string
+					expected string
+				}{
+					{`if (1) { "t" } else { "f" }`, "t"},
+					{`if (0) { "t" } else { "f" }`, "t"},
+					{`if ("") { "t" } else { "f" }`, "t"},
+					{`if ([]) { "t" } else { "f" }`, "t"},
+					{`if (null) { "t" } else { "f" }`, "f"},
+					{`if (false) { "t" } else { "f" }`, "f"},
+					{`if (!1) { "t" } else { "f" }`, "f"},
+					{`len(filter([0, 1, null, false, ""], fn(x) { x }))`, "3"},
+				}
+
+				for _, test := range tests {
+					if result := run(t, interpreter, test.input); result.Inspect() != test.expected {
+						t.Errorf("%s: want %s, got %s", test.input, test.expected, result.Inspect())
+					}
+				}
+			})
+
+			t.Run("Globals", func(t *testing.T) {
+				interpreter := newInterpreter(t, WithEngine(engine))
+				interpreter.SetGlobal("limit", &object.Integer{Value: 10})
+
+				run(t, interpreter, "let doubled = limit * 2;")
+
+				value, ok := interpreter.GetGlobal("doubled")
+				if !ok {
+					t.Fatalf("global doubled not found")
+				}
+				assertInteger(t, value, 20)
+
+				interpreter.SetGlobal("limit", &object.Integer{Value: 5})
+				assertInteger(t, run(t, interpreter, "limit + doubled"), 25)
+
+				if _, ok := interpreter.GetGlobal("missing"); ok {
+					t.Errorf("expected missing global to be reported")
+				}
+				if _, ok := interpreter.GetGlobal("len"); ok {
+					t.Errorf("expected builtins not to be reported as globals")
+				}
+			})
+
+			t.Run("Call", func(t *testing.T) {
+				interpreter := newInterpreter(t, WithEngine(engine))
+				run(t, interpreter, `
+					let factor = 3;
+					let scale = fn(x) { x * factor };
+					let fail = fn() { throw error("failed") };
+					let size = len;
+					let answer = 42;
+				`)
+
+				result, err := interpreter.Call("scale", &object.Integer{Value: 14})
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				assertInteger(t, result, 42)
+
+				result, err = interpreter.Call("size", &object.String{Value: "monkey"})
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				assertInteger(t, result, 6)
+
+				tests := []struct {
+					name     string
+					args     []object.Object
+					expected string
+				}{
+					{"fail", nil, "failed"},
+					{"scale", nil, "wrong number of arguments: want=1, got=0"},
+					{"missing", nil, "identifier not found: missing"},
+					{"answer", nil, "not a function: INTEGER"},
+				}
+
+				for _, test := range tests {
+					_, err := interpreter.Call(test.name, test.args...)
+
+					var runtimeError *object.Error
+					if !errors.As(err, &runtimeError) {
+						t.Errorf("%s: expected *object.Error, got %T: %v", test.name, err, err)
+						continue
+					}
+					if runtimeError.Message != test.expected {
+						t.Errorf("%s: wrong message. Want %q, got %q", test.name, test.expected, runtimeError.Message)
+					}
+				}
+			})
+
+			t.Run("Builtins", func(t *testing.T) {
+				interpreter := newInterpreter(t, WithEngine(engine))
+				err := interpreter.RegisterBuiltin("square", 1, "Squares an integer.", func(runtime object.Runtime, args ...object.Object) object.Object {
+					integer, ok := args[0].(*object.Integer)
+					if !ok {
+						return object.NewError(object.TypeError, "argument to `square` must be INTEGER, got %s", args[0].Type())
+					}
+					return &object.Integer{Value: integer.Value * integer.Value}
+				})
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+
+				assertInteger(t, run(t, interpreter, "square(4) + len([1])"), 17)
+
+				err = interpreter.RegisterMethod(object.INTEGER, "square", 0, "Squares an integer.", func(runtime object.Runtime, args ...object.Object) object.Object {
+					value := args[0].(*object.Integer).Value
+					return &object.Integer{Value: value * value}
+				})
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+
+				assertInteger(t, run(t, interpreter, "3.square() + [1, 2].len()"), 11)
+
+				builtins := object.NewRegistry()
+				builtins.Register("answer", 0, "", func(runtime object.Runtime, args ...object.Object) object.Object {
+					return &object.Integer{Value: 42}
+				})
+				interpreter = newInterpreter(t, WithEngine(engine), WithBuiltins(builtins))
+				assertInteger(t, run(t, interpreter, "answer()"), 42)
+
+				other := newInterpreter(t, WithEngine(engine))
+				err = other.Compile("square(2)")
+				if err == nil {
+					_, err = other.Run(context.Background())
+				}
+				if err == nil {
+					t.Errorf("builtins must not be shared between interpreters")
+				}
+			})
+
+			t.Run("Go functions", func(t *testing.T) {
+				type order struct {
+					Items []string `monkey:"items"`
+					Total int      `monkey:"total"`
+				}
+
+				interpreter := newInterpreter(t, WithEngine(engine))
+				err := interpreter.RegisterFunc("summarize", "Summarizes an order.", func(o order) map[string]interface{} {
+					return map[string]interface{}{"count": len(o.Items), "large": o.Total > 100}
+				})
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+
+				value, err := object.FromGo(order{Items: []string{"a", "b"}, Total: 150})
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				interpreter.SetGlobal("order", value)
+
+				result, err := object.ToGo(run(t, interpreter, "let summary = summarize(order); summary"))
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+
+				summary, ok := result.(map[string]interface{})
+				if !ok || summary["count"] != int64(2) || summary["large"] != true {
+					t.Errorf("wrong summary %#v", result)
+				}
+
+				if err := interpreter.Compile("summarize(1)"); err != nil {
+					t.Fatalf("compile error: %v", err)
+				}
+				_, err = interpreter.Run(context.Background())
+				var runtimeError *object.Error
+				if !errors.As(err, &runtimeError) || runtimeError.Kind != object.TypeError {
+					t.Errorf("expected TypeError, got %T: %v", err, err)
+				}
+			})
+
+			t.Run("Host objects", func(t *testing.T) {
+				type request struct {
+					Path    string
+					Headers map[string]string
+				}
+
+				requestType := &object.HostType{
+					Name: "REQUEST",
+					Properties: map[string]object.HostProperty{
+						"path": func(value interface{}) object.Object {
+							return &object.String{Value: value.(*request).Path}
+						},
+					},
+					Methods: map[string]object.HostMethod{
+						"header": func(runtime object.Runtime, value interface{}, args ...object.Object) object.Object {
+							name, ok := args[0].(*object.String)
+							if !ok {
+								return object.NewError(object.TypeError, "header name must be STRING, got %s", args[0].Type())
+							}
+							return &object.String{Value: value.(*request).Headers[name.Value]}
+						},
+					},
+				}
+
+				req := &request{Path: "/monkey", Headers: map[string]string{"Accept": "text/plain"}}
+
+				interpreter := newInterpreter(t, WithEngine(engine))
+				interpreter.SetGlobal("req", object.NewHostObject(requestType, req))
+				err := interpreter.RegisterFunc("is_request", "", func(r *request) bool { return r == req })
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+
+				tests := []struct {
+					input    string
+					expected string
+				}{
+					{`req["path"]`, "/monkey"},
+					{`req.path`, "/monkey"},
+					{`req.header("Accept").lower()`, "text/plain"},
+					{`req["header"]("Accept")`, "text/plain"},
+					{`let header = req["header"]; header("Accept") + req["path"]`, "text/plain/monkey"},
+					{`is_request(req)`, "true"},
+					{`try { req["method"] } catch (e) { e["kind"] + ": " + e["message"] }`, `AttributeError: REQUEST has no property or method method`},
+					{`try { req.method() } catch (e) { e.message }`, `REQUEST has no property or method method`},
+					{`try { req[1] } catch (e) { e["message"] }`, `REQUEST has no property or method 1`},
+				}
+
+				for _, test := range tests {
+					result := run(t, interpreter, test.input)
+					if result.Inspect() != test.expected {
+						t.Errorf("%s: want %s, got %s", test.input, test.expected, result.Inspect())
+					}
+				}
+			})
+
+			t.Run("Tasks", func(t *testing.T) {
+				var out bytes.Buffer
+				interpreter := newInterpreter(t, WithEngine(engine), WithStdout(&out), WithSchedulerMode(object.Deterministic))
+				run(t, interpreter, `
+					let jobs = chan(10);
+					let worker = fn(name) {
+						let job = recv(jobs);
+						if (job == null) { return 0; }
+						puts(name + ": " + job);
+						1 + worker(name)
+					};
+					let process = fn(items) {
+						items.map(fn(item) { send(jobs, item) });
+						close(jobs);
+						let a = spawn worker("a");
+						let b = spawn worker("b");
+						await a + await b
+					};
+				`)
+
+				result, err := interpreter.Call("process", mustFromGo(t, []string{"x", "y", "z"}))
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				assertInteger(t, result, 3)
+
+				if out.String() != "a: x\na: y\na: z\n" {
+					t.Errorf("wrong output, got %q", out.String())
+				}
+			})
+
+			t.Run("Generators", func(t *testing.T) {
+				interpreter := newInterpreter(t, WithEngine(engine))
+				result := run(t, interpreter, `
+					let numbers = fn() { yield 1; yield 2; yield 3 };
+					let g = numbers();
+					let take = fn(n) {
+						let out = [];
+						for (x in g) {
+							let out = out.push(x);
+							if (out.len() == n) { return out; }
+						}
+						out
+					};
+					[take(2), take(2), take(2)]
+				`)
+				if result.Inspect() != "[[1, 2], [3], []]" {
+					t.Errorf("wrong result, got %s", result.Inspect())
+				}
+
+				// generators are exhausted once the run which created them ended
+				run(t, interpreter, `let g = numbers();`)
+				result, err := interpreter.Call("take", mustFromGo(t, 2))
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				if result.Inspect() != "[]" {
+					t.Errorf("wrong result. Want [], got %s", result.Inspect())
+				}
+			})
+
+			t.Run("Generators are stopped", func(t *testing.T) {
+				before := runtime.NumGoroutine()
+
+				for i := 0; i < 50; i++ {
+					interpreter := newInterpreter(t, WithEngine(engine))
+					run(t, interpreter, `
+						let nat = fn() { for (x in range(0, 100)) { yield x } };
+						let it = nat();
+						let first = fn(it) { for (x in it) { return x } };
+						first(it)
+					`)
+					if _, err := interpreter.Call("first", mustGlobal(t, interpreter, "it")); err != nil {
+						t.Fatalf("unexpected error %v", err)
+					}
+				}
+
+				for i := 0; runtime.NumGoroutine() > before && i < 100; i++ {
+					runtime.GC()
+					time.Sleep(10 * time.Millisecond)
+				}
+				if after := runtime.NumGoroutine(); after > before {
+					t.Errorf("generators leaked %d goroutines", after-before)
+				}
+			})
+
+			t.Run("Modules", func(t *testing.T) {
+				var out bytes.Buffer
+				interpreter := newInterpreter(t, WithEngine(engine), WithStdout(&out), WithModulePaths("testdata/modules"))
+				run(t, interpreter, `let greeting = import "greeting";`)
+
+				result := run(t, interpreter, `let again = import "greeting"; again.greet("Monkey")`)
+				if result.Inspect() != "Hello, Monkey!" {
+					t.Errorf("wrong result, got %s", result.Inspect())
+				}
+
+				if out.String() != "loading greeting\n" {
+					t.Errorf("module should be run once, got output %q", out.String())
+				}
+
+				// modules are shared by the tasks of a run
+				out.Reset()
+				interpreter = newInterpreter(t, WithEngine(engine), WithStdout(&out), WithModulePaths("testdata/modules"), WithSchedulerMode(object.Deterministic))
+				result = run(t, interpreter, `
+					let area = fn(r) { puts((import "greeting").greet("task")); (import "math").area(r) };
+					let a = spawn area(1);
+					let b = spawn area(2);
+					[await a, await b, area(3)]
+				`)
+				if result.Inspect() != "[3, 12, 27]" {
+					t.Errorf("wrong result, got %s", result.Inspect())
+				}
+				if strings.Count(out.String(), "loading greeting") != 1 {
+					t.Errorf("module should be run once by the tasks of a run, got output %q", out.String())
+				}
+
+				err := interpreter.Compile(`import "missing"; 1`)
+				if err == nil {
+					_, err = interpreter.Run(context.Background())
+				}
+				if err == nil || err.Error() != "module not found: missing" {
+					t.Errorf("wrong error, got %v", err)
+				}
+
+				other := newInterpreter(t, WithEngine(engine))
+				err = other.Compile(`import "greeting"`)
+				if err == nil {
+					_, err = other.Run(context.Background())
+				}
+				if err == nil || err.Error() != "module not found: greeting" {
+					t.Errorf("modules should only be imported from the module paths, got %v", err)
+				}
+			})
+
+			t.Run("Prelude", func(t *testing.T) {
+				interpreter := newInterpreter(t, WithEngine(engine), WithStepBudget(100))
+				result := run(t, interpreter, `sum(range(1, 5))`)
+				if result.Inspect() != "10" {
+					t.Errorf("wrong result, got %s", result.Inspect())
+				}
+
+				result, err := interpreter.Call("max", mustFromGo(t, []int{2, 7, 3}))
+				if err != nil || result.Inspect() != "7" {
+					t.Errorf("wrong result of max, got %v, %v", result, err)
+				}
+
+				interpreter = newInterpreter(t, WithEngine(engine), WithPrelude(false))
+				if _, ok := interpreter.GetGlobal("sum"); ok {
+					t.Errorf("sum should not be defined without the prelude")
+				}
+
+				result = run(t, interpreter, `let list = import "list"; list.sum([1, 2])`)
+				if result.Inspect() != "3" {
+					t.Errorf("the standard library should be importable without the prelude, got %s", result.Inspect())
+				}
+
+				// builtins of the host take precedence over the prelude
+				larger := func(runtime object.Runtime, args ...object.Object) object.Object {
+					if object.CompareIntegers(args[0], args[1]) > 0 {
+						return args[0]
+					}
+					return args[1]
+				}
+
+				builtins := object.NewRegistry()
+				if err := builtins.Register("max", 2, "", larger); err != nil {
+					t.Fatal(err)
+				}
+				interpreter = newInterpreter(t, WithEngine(engine), WithBuiltins(builtins))
+				assertInteger(t, run(t, interpreter, `max(1, 2) + sum([1])`), 3)
+
+				interpreter = newInterpreter(t, WithEngine(engine))
+				if err := interpreter.RegisterBuiltin("max", 2, "", larger); err != nil {
+					t.Fatal(err)
+				}
+				assertInteger(t, run(t, interpreter, `max(1, 2) + sum([1])`), 3)
+			})
+
+			t.Run("Errors", func(t *testing.T) {
+				interpreter := newInterpreter(t, WithEngine(engine))
+
+				err := interpreter.Compile("let = 1;")
+				var parseError *ParseError
+				if !errors.As(err, &parseError) || len(parseError.Errors) == 0 {
+					t.Fatalf("expected *ParseError, got %T: %v", err, err)
+				}
+
+				err = interpreter.Compile(`throw error("uncaught")`)
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+
+				_, err = interpreter.Run(context.Background())
+				var runtimeError *object.Error
+				if !errors.As(err, &runtimeError) || runtimeError.Message != "uncaught" {
+					t.Fatalf("expected uncaught error, got %T: %v", err, err)
+				}
+
+				if engine == VM {
+					// a failed compilation defines no globals
+					err = interpreter.Compile(`let half = 1; let broken = unknown;`)
+					if err == nil || err.Error() != "undefined variable: unknown" {
+						t.Fatalf("wrong error, got %v", err)
+					}
+
+					err = interpreter.Compile(`half`)
+					if err == nil || err.Error() != "undefined variable: half" {
+						t.Errorf("globals of a failed compilation should be discarded, got %v", err)
+					}
+					assertInteger(t, run(t, interpreter, `let half = 2; half`), 2)
+
+					// the VM runs the bodies of the modules the prelude
+					// imports as calls
+					_, err = NewInterpreter(WithEngine(engine), WithMaxCallDepth(0))
+					if err == nil || !strings.HasPrefix(err.Error(), "monkey: running the prelude failed") {
+						t.Errorf("wrong error, got %v", err)
+					}
+				}
+			})
+
+			t.Run("Options", func(t *testing.T) {
+				var out bytes.Buffer
+				interpreter := newInterpreter(t, WithEngine(engine), WithStdout(&out))
+				run(t, interpreter, `puts("hello", 1)`)
+				if out.String() != "hello\n1\n" {
+					t.Errorf("wrong output, got %q", out.String())
+				}
+
+				tests := []struct {
+					option   Option
+					input    string
+					expected error
+				}{
+					{WithStepBudget(100), "let f = fn() { f() }; f()", object.ErrBudgetExceeded},
+					{WithMemoryLimit(1000), `repeat("a", 10000)`, object.ErrMemoryLimitExceeded},
+				}
+
+				for _, test := range tests {
+					interpreter := newInterpreter(t, WithEngine(engine), test.option)
+					if err := interpreter.Compile(test.input); err != nil {
+						t.Fatalf("unexpected error %v", err)
+					}
+
+					_, err := interpreter.Run(context.Background())
+					if !errors.Is(err, test.expected) {
+						t.Errorf("%s: wrong error. Want %v, got %v", test.input, test.expected, err)
+					}
+				}
+
+				interpreter = newInterpreter(t, WithEngine(engine), WithMaxCallDepth(10))
+				run(t, interpreter, "let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } };")
+				assertInteger(t, run(t, interpreter, "f(5)"), 5)
+
+				_, err := interpreter.Call("f", &object.Integer{Value: 20})
+				var runtimeError *object.Error
+				if !errors.As(err, &runtimeError) || runtimeError.Kind != object.RecursionError {
+					t.Errorf("expected RecursionError, got %T: %v", err, err)
+				}
+
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				_, err = interpreter.CallContext(ctx, "f", &object.Integer{Value: 1})
+				if !errors.Is(err, object.ErrTimeout) {
+					t.Errorf("expected ErrTimeout, got %v", err)
+				}
+			})
+		})
+	}
+}
+
+func newInterpreter(t *testing.T, options ...Option) *Interpreter {
+	t.Helper()
+
+	interpreter, err := NewInterpreter(options...)
+	if err != nil {
+		t.Fatalf("creating the interpreter failed: %v", err)
+	}
+
+	return interpreter
+}
+
+func run(t *testing.T, interpreter *Interpreter, input string) object.Object {
+	t.Helper()
+
+	if err := interpreter.Compile(input); err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+
+	result, err := interpreter.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+
+	return result
+}
+
+func mustGlobal(t *testing.T, interpreter *Interpreter, name string) object.Object {
+	t.Helper()
+
+	value, ok := interpreter.GetGlobal(name)
+	if !ok {
+		t.Fatalf("global %s is not defined", name)
+	}
+
+	return value
+}
+
+func mustFromGo(t *testing.T, value interface{}) object.Object {
+	t.Helper()
+
+	obj, err := object.FromGo(value)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	return obj
+}
+
+func assertInteger(t *testing.T, obj object.Object, expected int64) {
+	t.Helper()
+
+	integer, ok := obj.(*object.Integer)
+	if !ok {
+		t.Fatalf("object is not Integer, got %T (%+v)", obj, obj)
+	}
+	if integer.Value != expected {
+		t.Errorf("wrong value. Want %d, got %d", expected, integer.Value)
+	}
+}
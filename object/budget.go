@@ -0,0 +1,141 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"math/bits"
+	"sync/atomic"
+)
+
+var (
+	// ErrTimeout is returned by a run whose context is done, because its
+	// deadline passed or because it was canceled.
+	ErrTimeout = errors.New("execution timed out")
+	// ErrBudgetExceeded is returned by a run which used up its step budget.
+	ErrBudgetExceeded = errors.New("execution budget exceeded")
+	// ErrMemoryLimitExceeded is returned by a run which allocated more memory
+	// than its memory limit allows.
+	ErrMemoryLimitExceeded = errors.New("memory limit exceeded")
+)
+
+// Approximate sizes in bytes used by SizeOf. References are interface values,
+// hash pairs additionally hold their hash key and the map's bookkeeping.
+const (
+	objectSize    = 16
+	referenceSize = 16
+	hashPairSize  = 64
+)
+
+// Budget limits a single run of an engine. Engines take a step for every
+// function call and every backward jump, which bounds the work done between
+// two checks. Unlike runtime errors, exceeding a budget cannot be caught by
+// the script.
+//
+// Engines also account the approximate size of the strings, arrays, hashes
+// and big integers they create. Memory is never given back, so the memory
+// limit bounds the total allocations of a run rather than its live data.
+//
+// The tasks spawned by a run share its budget through forks, so a budget can
+// be used from several goroutines at once.
+type Budget struct {
+	ctx    context.Context
+	steps  int
+	memory int
+	usage  *usage
+}
+
+type usage struct {
+	steps     int64
+	allocated int64
+}
+
+// NewBudget creates a budget for a run that stops once ctx is done, steps
+// steps have been taken or more than memory bytes have been allocated. There
+// is no step limit if steps is 0 or less and no memory limit if memory is 0 or
+// less.
+func NewBudget(ctx context.Context, steps, memory int) *Budget {
+	return &Budget{ctx: ctx, steps: steps, memory: memory, usage: &usage{}}
+}
+
+// Fork returns a budget sharing the limits and the usage of b, which stops
+// once ctx is done. Tasks spawned by a run use a fork of the run's budget. A
+// nil budget forks into a budget without limits.
+func (b *Budget) Fork(ctx context.Context) *Budget {
+	if b == nil {
+		return NewBudget(ctx, 0, 0)
+	}
+
+	return &Budget{ctx: ctx, steps: b.steps, memory: b.memory, usage: b.usage}
+}
+
+// Context returns the context the run stops with, which is
+// context.Background() for a nil budget.
+func (b *Budget) Context() context.Context {
+	if b == nil {
+		return context.Background()
+	}
+
+	return b.ctx
+}
+
+// Step takes a step. It returns ErrTimeout or ErrBudgetExceeded if the run has
+// to stop. A nil budget never stops a run.
+func (b *Budget) Step() error {
+	if b == nil {
+		return nil
+	}
+
+	used := atomic.AddInt64(&b.usage.steps, 1)
+	if b.steps > 0 && used > int64(b.steps) {
+		return ErrBudgetExceeded
+	}
+
+	select {
+	case <-b.ctx.Done():
+		return ErrTimeout
+	default:
+		return nil
+	}
+}
+
+// Allocate accounts size bytes. It returns ErrMemoryLimitExceeded if the run
+// has to stop. A nil budget never stops a run.
+func (b *Budget) Allocate(size int) error {
+	if b == nil {
+		return nil
+	}
+
+	allocated := atomic.AddInt64(&b.usage.allocated, int64(size))
+	if b.memory > 0 && allocated > int64(b.memory) {
+		return ErrMemoryLimitExceeded
+	}
+
+	return nil
+}
+
+// Allocated returns the number of bytes accounted so far.
+func (b *Budget) Allocated() int {
+	if b == nil {
+		return 0
+	}
+
+	return int(atomic.LoadInt64(&b.usage.allocated))
+}
+
+// SizeOf returns the approximate number of bytes allocated for obj itself,
+// not counting the objects it refers to. Only strings, arrays, hashes and big
+// integers are accounted, as they are the only objects that can grow.
+func SizeOf(obj Object) int {
+	switch obj := obj.(type) {
+	case *String:
+		return objectSize + len(obj.Value)
+	case *Array:
+		return objectSize + len(obj.Elements)*referenceSize
+	case *Hash:
+		return objectSize + len(obj.Pairs)*hashPairSize
+	case *BigInteger:
+		return objectSize + len(obj.Value.Bits())*bits.UintSize/8
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,896 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	True  = &Boolean{Value: true}
+	False = &Boolean{Value: false}
+	// NullValue is the null both engines use, compare objects to it to check
+	// for null.
+	NullValue = &Null{}
+)
+
+// MaxStringLength is the length of the longest string builtins like repeat
+// build. Longer results fail with a ValueError, whether or not a memory limit
+// is set.
+const MaxStringLength = 1 << 30
+
+// Builtins is the table of builtin functions shared by the evaluator and the
+// compiler. The compiler refers to builtins by their index, so new entries
+// must only ever be appended.
+var Builtins = []struct {
+	Name    string
+	Builtin *Builtin
+}{
+	{
+		"len",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if len(args) != 1 {
+				return newError(ArgumentError, "wrong number of arguments. Got %d, want 1.", len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *String:
+				return &Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
+			case *Array:
+				return &Integer{Value: int64(len(arg.Elements))}
+			case *Hash:
+				return &Integer{Value: int64(len(arg.Pairs))}
+			default:
+				return newError(TypeError, "argument to `len` not supported, got %s.", arg.Type())
+			}
+		}},
+	},
+	{
+		"puts",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			for _, arg := range args {
+				fmt.Fprintln(runtime.Stdout(), arg.Inspect())
+			}
+
+			return nil
+		}},
+	},
+	{
+		"first",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if len(args) != 1 {
+				return newError(ArgumentError, "wrong number of argument, expected 1, got %d", len(args))
+			}
+
+			if args[0].Type() != ARRAY {
+				return newError(TypeError, "argument must be ARRAY, got %s", args[0].Type())
+			}
+
+			array := args[0].(*Array)
+			if len(array.Elements) > 0 {
+				return array.Elements[0]
+			}
+			return nil
+		}},
+	},
+	{
+		"last",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if len(args) != 1 {
+				return newError(ArgumentError, "wrong number of argument, expected 1, got %d", len(args))
+			}
+
+			if args[0].Type() != ARRAY {
+				return newError(TypeError, "argument must be ARRAY, got %s", args[0].Type())
+			}
+
+			array := args[0].(*Array)
+			length := len(array.Elements)
+			if length > 0 {
+				return array.Elements[length-1]
+			}
+			return nil
+		}},
+	},
+	{
+		"rest",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if len(args) != 1 {
+				return newError(ArgumentError, "wrong number of argument, expected 1, got %d", len(args))
+			}
+
+			if args[0].Type() != ARRAY {
+				return newError(TypeError, "argument must be ARRAY, got %s", args[0].Type())
+			}
+
+			array := args[0].(*Array)
+			length := len(array.Elements)
+			if length > 0 {
+				newElements := make([]Object, length-1, length-1)
+				copy(newElements, array.Elements[1:length])
+
+				return allocate(runtime, &Array{Elements: newElements})
+			}
+			return nil
+		}},
+	},
+	{
+		"push",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if len(args) != 2 {
+				return newError(ArgumentError, "wrong number of argument, expected 2, got %d", len(args))
+			}
+
+			if args[0].Type() != ARRAY {
+				return newError(TypeError, "argument must be ARRAY, got %s", args[0].Type())
+			}
+
+			array := args[0].(*Array)
+			length := len(array.Elements)
+
+			newElements := make([]Object, length+1, length+1)
+			copy(newElements, array.Elements)
+			newElements[length] = args[1]
+
+			return allocate(runtime, &Array{Elements: newElements})
+		}},
+	},
+	{
+		"split",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if err := checkArguments("split", args, STRING, STRING); err != nil {
+				return err
+			}
+
+			parts := strings.Split(args[0].(*String).Value, args[1].(*String).Value)
+
+			elements := make([]Object, len(parts))
+			for i, part := range parts {
+				elements[i] = &String{Value: part}
+			}
+
+			return allocateAll(runtime, &Array{Elements: elements})
+		}},
+	},
+	{
+		"join",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if err := checkArguments("join", args, ARRAY, STRING); err != nil {
+				return err
+			}
+
+			array := args[0].(*Array)
+			separator := args[1].(*String).Value
+
+			// Account for the result before building it, a long separator
+			// is repeated between all elements.
+			size := 0
+			if len(array.Elements) > 1 {
+				size = len(separator) * (len(array.Elements) - 1)
+			}
+
+			parts := make([]string, len(array.Elements))
+			for i, element := range array.Elements {
+				parts[i] = element.Inspect()
+				size += len(parts[i])
+			}
+
+			if err := runtime.Allocate(SizeOf(&String{}) + size); err != nil {
+				return err
+			}
+
+			return &String{Value: strings.Join(parts, separator)}
+		}},
+	},
+	{
+		"trim",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if err := checkArguments("trim", args, STRING); err != nil {
+				return err
+			}
+
+			return allocate(runtime, &String{Value: strings.TrimSpace(args[0].(*String).Value)})
+		}},
+	},
+	{
+		"upper",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if err := checkArguments("upper", args, STRING); err != nil {
+				return err
+			}
+
+			return allocate(runtime, &String{Value: strings.ToUpper(args[0].(*String).Value)})
+		}},
+	},
+	{
+		"lower",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if err := checkArguments("lower", args, STRING); err != nil {
+				return err
+			}
+
+			return allocate(runtime, &String{Value: strings.ToLower(args[0].(*String).Value)})
+		}},
+	},
+	{
+		"contains",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if err := checkArguments("contains", args, STRING, STRING); err != nil {
+				return err
+			}
+
+			return nativeBoolToBooleanObject(strings.Contains(args[0].(*String).Value, args[1].(*String).Value))
+		}},
+	},
+	{
+		"replace",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if err := checkArguments("replace", args, STRING, STRING, STRING); err != nil {
+				return err
+			}
+
+			value, old, replacement := args[0].(*String).Value, args[1].(*String).Value, args[2].(*String).Value
+
+			// Account for the result before building it, replacing short
+			// strings with long ones multiplies the size of the value.
+			size := len(value) + strings.Count(value, old)*(len(replacement)-len(old))
+			if err := runtime.Allocate(SizeOf(&String{}) + size); err != nil {
+				return err
+			}
+
+			return &String{Value: strings.Replace(value, old, replacement, -1)}
+		}},
+	},
+	{
+		"index_of",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if err := checkArguments("index_of", args, STRING, STRING); err != nil {
+				return err
+			}
+
+			value := args[0].(*String).Value
+			index := strings.Index(value, args[1].(*String).Value)
+			if index > 0 {
+				index = utf8.RuneCountInString(value[:index])
+			}
+
+			return &Integer{Value: int64(index)}
+		}},
+	},
+	{
+		"starts_with",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if err := checkArguments("starts_with", args, STRING, STRING); err != nil {
+				return err
+			}
+
+			return nativeBoolToBooleanObject(strings.HasPrefix(args[0].(*String).Value, args[1].(*String).Value))
+		}},
+	},
+	{
+		"ends_with",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if err := checkArguments("ends_with", args, STRING, STRING); err != nil {
+				return err
+			}
+
+			return nativeBoolToBooleanObject(strings.HasSuffix(args[0].(*String).Value, args[1].(*String).Value))
+		}},
+	},
+	{
+		"repeat",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if err := checkArguments("repeat", args, STRING, INTEGER); err != nil {
+				return err
+			}
+
+			if CompareIntegers(args[1], &Integer{Value: 0}) < 0 {
+				return newError(ValueError, "argument to `repeat` must not be negative, got %s", args[1].Inspect())
+			}
+
+			count, ok := args[1].(*Integer)
+			if !ok {
+				return newError(ValueError, "argument to `repeat` is too large, got %s", args[1].Inspect())
+			}
+
+			value := args[0].(*String).Value
+
+			size := int64(len(value)) * count.Value
+			if len(value) > 0 && size/int64(len(value)) != count.Value {
+				return newError(ValueError, "result of `repeat` is too large")
+			}
+
+			// Account for the result before building it, small arguments
+			// can ask for huge strings.
+			accounted := size
+			if accounted > math.MaxInt32 {
+				accounted = math.MaxInt32
+			}
+			if err := runtime.Allocate(SizeOf(&String{}) + int(accounted)); err != nil {
+				return err
+			}
+			if size > MaxStringLength {
+				return newError(ValueError, "result of `repeat` is too large")
+			}
+
+			return &String{Value: strings.Repeat(value, int(count.Value))}
+		}},
+	},
+	{
+		"format",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if len(args) < 1 {
+				return newError(ArgumentError, "wrong number of arguments. Got %d, want at least 1.", len(args))
+			}
+
+			if args[0].Type() != STRING {
+				return newError(TypeError, "first argument to `format` must be STRING, got %s", args[0].Type())
+			}
+
+			parts := strings.Split(args[0].(*String).Value, "{}")
+			if len(parts)-1 != len(args)-1 {
+				return newError(
+					ValueError,
+					"wrong number of values for `format`. Got %d, want %d.",
+					len(args)-1,
+					len(parts)-1,
+				)
+			}
+
+			// Account for the result before building it, each placeholder
+			// is replaced by the representation of its value.
+			values := make([]string, len(args)-1)
+			size := len(args[0].(*String).Value) - len(values)*len("{}")
+			for i, arg := range args[1:] {
+				values[i] = arg.Inspect()
+				size += len(values[i])
+			}
+
+			if err := runtime.Allocate(SizeOf(&String{}) + size); err != nil {
+				return err
+			}
+
+			var out strings.Builder
+			out.Grow(size)
+			for i, part := range parts {
+				out.WriteString(part)
+
+				if i < len(values) {
+					out.WriteString(values[i])
+				}
+			}
+
+			return &String{Value: out.String()}
+		}},
+	},
+	{
+		"map",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if err := checkCallbackArguments("map", args); err != nil {
+				return err
+			}
+
+			array := args[0].(*Array)
+
+			elements := make([]Object, len(array.Elements))
+			for i, element := range array.Elements {
+				result, err := runtime.Apply(args[1], element)
+				if err != nil {
+					return err
+				}
+
+				elements[i] = result
+			}
+
+			return allocate(runtime, &Array{Elements: elements})
+		}},
+	},
+	{
+		"filter",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if err := checkCallbackArguments("filter", args); err != nil {
+				return err
+			}
+
+			array := args[0].(*Array)
+
+			elements := []Object{}
+			for _, element := range array.Elements {
+				result, err := runtime.Apply(args[1], element)
+				if err != nil {
+					return err
+				}
+
+				if IsTruthy(result) {
+					elements = append(elements, element)
+				}
+			}
+
+			return allocate(runtime, &Array{Elements: elements})
+		}},
+	},
+	{
+		"reduce",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if len(args) != 3 {
+				return newError(ArgumentError, "wrong number of arguments. Got %d, want 3.", len(args))
+			}
+
+			if args[0].Type() != ARRAY {
+				return newError(TypeError, "argument 1 to `reduce` must be ARRAY, got %s", args[0].Type())
+			}
+
+			accumulator := args[1]
+			for _, element := range args[0].(*Array).Elements {
+				var err *Error
+				accumulator, err = runtime.Apply(args[2], accumulator, element)
+				if err != nil {
+					return err
+				}
+			}
+
+			return accumulator
+		}},
+	},
+	{
+		"sort_by",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if err := checkCallbackArguments("sort_by", args); err != nil {
+				return err
+			}
+
+			array := args[0].(*Array)
+
+			keys := make([]Object, len(array.Elements))
+			for i, element := range array.Elements {
+				key, err := runtime.Apply(args[1], element)
+				if err != nil {
+					return err
+				}
+
+				if key.Type() != INTEGER && key.Type() != STRING {
+					return newError(TypeError, "sort key must be INTEGER or STRING, got %s", key.Type())
+				}
+
+				if i > 0 && key.Type() != keys[0].Type() {
+					return newError(TypeError, "sort keys must be of the same type, got %s and %s", keys[0].Type(), key.Type())
+				}
+
+				keys[i] = key
+			}
+
+			indices := make([]int, len(array.Elements))
+			for i := range indices {
+				indices[i] = i
+			}
+
+			sort.SliceStable(indices, func(i, j int) bool {
+				return lessThan(keys[indices[i]], keys[indices[j]])
+			})
+
+			elements := make([]Object, len(array.Elements))
+			for i, index := range indices {
+				elements[i] = array.Elements[index]
+			}
+
+			return allocate(runtime, &Array{Elements: elements})
+		}},
+	},
+	{
+		"any",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if err := checkCallbackArguments("any", args); err != nil {
+				return err
+			}
+
+			for _, element := range args[0].(*Array).Elements {
+				result, err := runtime.Apply(args[1], element)
+				if err != nil {
+					return err
+				}
+
+				if IsTruthy(result) {
+					return True
+				}
+			}
+
+			return False
+		}},
+	},
+	{
+		"all",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if err := checkCallbackArguments("all", args); err != nil {
+				return err
+			}
+
+			for _, element := range args[0].(*Array).Elements {
+				result, err := runtime.Apply(args[1], element)
+				if err != nil {
+					return err
+				}
+
+				if !IsTruthy(result) {
+					return False
+				}
+			}
+
+			return True
+		}},
+	},
+	{
+		"keys",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if err := checkArguments("keys", args, HASH); err != nil {
+				return err
+			}
+
+			pairs := args[0].(*Hash).SortedPairs()
+			elements := make([]Object, len(pairs))
+			for i, pair := range pairs {
+				elements[i] = pair.Key
+			}
+
+			return allocate(runtime, &Array{Elements: elements})
+		}},
+	},
+	{
+		"values",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if err := checkArguments("values", args, HASH); err != nil {
+				return err
+			}
+
+			pairs := args[0].(*Hash).SortedPairs()
+			elements := make([]Object, len(pairs))
+			for i, pair := range pairs {
+				elements[i] = pair.Value
+			}
+
+			return allocate(runtime, &Array{Elements: elements})
+		}},
+	},
+	{
+		"entries",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if err := checkArguments("entries", args, HASH); err != nil {
+				return err
+			}
+
+			pairs := args[0].(*Hash).SortedPairs()
+			elements := make([]Object, len(pairs))
+			for i, pair := range pairs {
+				elements[i] = &Array{Elements: []Object{pair.Key, pair.Value}}
+			}
+
+			return allocateAll(runtime, &Array{Elements: elements})
+		}},
+	},
+	{
+		"has",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if len(args) != 2 {
+				return newError(ArgumentError, "wrong number of arguments. Got %d, want 2.", len(args))
+			}
+
+			hash, ok := args[0].(*Hash)
+			if !ok {
+				return newError(TypeError, "argument 1 to `has` must be HASH, got %s", args[0].Type())
+			}
+
+			key, ok := AsHashable(args[1])
+			if !ok {
+				return newError(TypeError, "unusable as hash key: %s", args[1].Type())
+			}
+
+			_, ok = hash.Get(key)
+			return nativeBoolToBooleanObject(ok)
+		}},
+	},
+	{
+		"delete",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if len(args) != 2 {
+				return newError(ArgumentError, "wrong number of arguments. Got %d, want 2.", len(args))
+			}
+
+			hash, ok := args[0].(*Hash)
+			if !ok {
+				return newError(TypeError, "argument 1 to `delete` must be HASH, got %s", args[0].Type())
+			}
+
+			key, ok := AsHashable(args[1])
+			if !ok {
+				return newError(TypeError, "unusable as hash key: %s", args[1].Type())
+			}
+
+			pairs := make(map[HashKey]HashPair, len(hash.Pairs))
+			for hashKey, pair := range hash.Pairs {
+				pairs[hashKey] = pair
+			}
+
+			if _, ok := hash.Get(key); ok {
+				delete(pairs, key.HashKey())
+			}
+
+			return allocate(runtime, &Hash{Pairs: pairs})
+		}},
+	},
+	{
+		"merge",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if len(args) == 0 {
+				return newError(ArgumentError, "wrong number of arguments. Got 0, want at least 1.")
+			}
+
+			pairs := make(map[HashKey]HashPair)
+			for i, arg := range args {
+				hash, ok := arg.(*Hash)
+				if !ok {
+					return newError(TypeError, "argument %d to `merge` must be HASH, got %s", i+1, arg.Type())
+				}
+
+				for hashKey, pair := range hash.Pairs {
+					pairs[hashKey] = pair
+				}
+			}
+
+			return allocate(runtime, &Hash{Pairs: pairs})
+		}},
+	},
+	{
+		"error",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if err := checkArguments("error", args, STRING); err != nil {
+				return err
+			}
+
+			return NewError(UserError, "%s", args[0].(*String).Value)
+		}},
+	},
+	{
+		"is_error",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if len(args) != 1 {
+				return newError(ArgumentError, "wrong number of arguments. Got %d, want 1.", len(args))
+			}
+
+			return nativeBoolToBooleanObject(isError(args[0]))
+		}},
+	},
+	{
+		"chan",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if len(args) > 1 {
+				return newError(ArgumentError, "wrong number of arguments. Got %d, want 0 or 1.", len(args))
+			}
+
+			capacity := 0
+			if len(args) == 1 {
+				integer, ok := args[0].(*Integer)
+				if !ok {
+					return newError(TypeError, "argument to `chan` must be INTEGER, got %s", args[0].Type())
+				}
+				if integer.Value < 0 {
+					return newError(ValueError, "channel capacity must not be negative, got %d", integer.Value)
+				}
+				capacity = int(integer.Value)
+			}
+
+			return NewChannel(capacity)
+		}},
+	},
+	{
+		"send",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if len(args) != 2 {
+				return newError(ArgumentError, "wrong number of arguments. Got %d, want 2.", len(args))
+			}
+
+			channel, ok := args[0].(*Channel)
+			if !ok {
+				return newError(TypeError, "argument 1 to `send` must be CHANNEL, got %s", args[0].Type())
+			}
+
+			if err := runtime.Scheduler().Send(channel, args[1]); err != nil {
+				return runtime.Raise(err)
+			}
+			return nil
+		}},
+	},
+	{
+		"recv",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if err := checkArguments("recv", args, CHANNEL); err != nil {
+				return err
+			}
+
+			value, _, err := runtime.Scheduler().Receive(args[0].(*Channel))
+			if err != nil {
+				return runtime.Raise(err)
+			}
+			return value
+		}},
+	},
+	{
+		"close",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if err := checkArguments("close", args, CHANNEL); err != nil {
+				return err
+			}
+
+			if err := runtime.Scheduler().Close(args[0].(*Channel)); err != nil {
+				return runtime.Raise(err)
+			}
+			return nil
+		}},
+	},
+	{
+		"select",
+		&Builtin{Fn: func(runtime Runtime, args ...Object) Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError(ArgumentError, "wrong number of arguments. Got %d, want 1 or 2.", len(args))
+			}
+
+			array, ok := args[0].(*Array)
+			if !ok {
+				return newError(TypeError, "argument 1 to `select` must be ARRAY, got %s", args[0].Type())
+			}
+
+			cases := make([]SelectCase, len(array.Elements))
+			for i, element := range array.Elements {
+				switch element := element.(type) {
+				case *Channel:
+					cases[i] = SelectCase{Channel: element}
+				case *Array:
+					if len(element.Elements) != 2 {
+						return newError(TypeError, "send case %d of `select` must be [CHANNEL, value]", i)
+					}
+					channel, ok := element.Elements[0].(*Channel)
+					if !ok {
+						return newError(TypeError, "send case %d of `select` must be [CHANNEL, value]", i)
+					}
+					cases[i] = SelectCase{Channel: channel, Send: true, Value: element.Elements[1]}
+				default:
+					return newError(TypeError, "case %d of `select` must be CHANNEL or ARRAY, got %s", i, element.Type())
+				}
+			}
+
+			index, value, _, err := runtime.Scheduler().Select(cases, len(args) == 1)
+			if err != nil {
+				return runtime.Raise(err)
+			}
+			if index == -1 {
+				value = args[1]
+			}
+			if value == nil {
+				value = NullValue
+			}
+
+			return allocate(runtime, selected(index, value))
+		}},
+	},
+}
+
+// GetBuiltinByName returns the builtin with the given name or nil if there is
+// no such builtin.
+func GetBuiltinByName(name string) *Builtin {
+	for _, definition := range Builtins {
+		if definition.Name == name {
+			return definition.Builtin
+		}
+	}
+
+	return nil
+}
+
+// selected returns the result of select, a hash holding the index of the
+// selected case and the received value.
+func selected(index int, value Object) *Hash {
+	indexKey, valueKey := &String{Value: "index"}, &String{Value: "value"}
+
+	return &Hash{Pairs: map[HashKey]HashPair{
+		indexKey.HashKey(): {Key: indexKey, Value: &Integer{Value: int64(index)}},
+		valueKey.HashKey(): {Key: valueKey, Value: value},
+	}}
+}
+
+// allocate accounts obj, which was just created by a builtin, and returns it.
+// The error raised by the runtime is returned once the memory limit is
+// exceeded.
+func allocate(runtime Runtime, obj Object) Object {
+	if err := runtime.Allocate(SizeOf(obj)); err != nil {
+		return err
+	}
+
+	return obj
+}
+
+// allocateAll is like allocate, but accounts the elements of array as well.
+func allocateAll(runtime Runtime, array *Array) Object {
+	size := SizeOf(array)
+	for _, element := range array.Elements {
+		size += SizeOf(element)
+	}
+
+	if err := runtime.Allocate(size); err != nil {
+		return err
+	}
+
+	return array
+}
+
+// newError creates an error which is raised once the builtin returns it.
+func newError(kind ErrorKind, format string, a ...interface{}) *Error {
+	err := NewError(kind, format, a...)
+	err.raise = true
+	return err
+}
+
+// checkArguments verifies the number and the types of the arguments passed to
+// the builtin with the given name.
+func checkArguments(name string, args []Object, types ...ObjectType) *Error {
+	if len(args) != len(types) {
+		return newError(ArgumentError, "wrong number of arguments. Got %d, want %d.", len(args), len(types))
+	}
+
+	for i, objectType := range types {
+		if args[i].Type() != objectType {
+			return newError(TypeError, "argument %d to `%s` must be %s, got %s", i+1, name, objectType, args[i].Type())
+		}
+	}
+
+	return nil
+}
+
+// checkCallbackArguments verifies the arguments of higher-order builtins taking
+// an array and a function.
+func checkCallbackArguments(name string, args []Object) *Error {
+	if len(args) != 2 {
+		return newError(ArgumentError, "wrong number of arguments. Got %d, want 2.", len(args))
+	}
+
+	if args[0].Type() != ARRAY {
+		return newError(TypeError, "argument 1 to `%s` must be ARRAY, got %s", name, args[0].Type())
+	}
+
+	return nil
+}
+
+func lessThan(left, right Object) bool {
+	switch left := left.(type) {
+	case *Integer, *BigInteger:
+		return CompareIntegers(left, right) < 0
+	case *String:
+		return left.Value < right.(*String).Value
+	default:
+		return false
+	}
+}
+
+func isError(obj Object) bool {
+	return obj != nil && obj.Type() == ERROR
+}
+
+// IsTruthy reports whether obj counts as true in conditions. Only false and
+// null are false, every other value is true.
+func IsTruthy(obj Object) bool {
+	switch obj := obj.(type) {
+	case *Boolean:
+		return obj.Value
+	case *Null, nil:
+		return false
+	default:
+		return true
+	}
+}
+
+func nativeBoolToBooleanObject(input bool) *Boolean {
+	if input {
+		return True
+	}
+
+	return False
+}
@@ -0,0 +1,473 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+)
+
+var (
+	objectType  = reflect.TypeOf((*Object)(nil)).Elem()
+	runtimeType = reflect.TypeOf((*Runtime)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	bigIntType  = reflect.TypeOf((*big.Int)(nil))
+)
+
+// FromGo converts a Go value to an object. Booleans, integers, strings, slices,
+// arrays, maps and structs are converted recursively, pointers and interfaces
+// are followed and nil becomes NullValue. Floats are converted if they hold an
+// integer, as Monkey has no floating point numbers. Functions are wrapped with
+// NewGoFunction. Objects are returned unchanged.
+//
+// Structs become hashes keyed by the names of their exported fields. A
+// `monkey:"name"` tag changes the key, `monkey:"-"` skips the field.
+func FromGo(value interface{}) (Object, error) {
+	if value == nil {
+		return NullValue, nil
+	}
+
+	return fromValue(reflect.ValueOf(value))
+}
+
+func fromValue(value reflect.Value) (Object, error) {
+	if !value.IsValid() {
+		return NullValue, nil
+	}
+
+	if value.Type().Implements(objectType) {
+		if (value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface) && value.IsNil() {
+			return NullValue, nil
+		}
+		return value.Interface().(Object), nil
+	}
+
+	if value.Type() == bigIntType {
+		if value.IsNil() {
+			return NullValue, nil
+		}
+		return NewInteger(new(big.Int).Set(value.Interface().(*big.Int))), nil
+	}
+
+	switch value.Kind() {
+	case reflect.Bool:
+		return nativeBoolToBooleanObject(value.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Integer{Value: value.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return NewInteger(new(big.Int).SetUint64(value.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		float := value.Float()
+		if math.IsInf(float, 0) || math.IsNaN(float) || float != math.Trunc(float) {
+			return nil, fmt.Errorf("cannot convert %v to INTEGER", float)
+		}
+		integer, _ := big.NewFloat(float).Int(nil)
+		return NewInteger(integer), nil
+	case reflect.String:
+		return &String{Value: value.String()}, nil
+	case reflect.Slice, reflect.Array:
+		if value.Kind() == reflect.Slice && value.IsNil() {
+			return NullValue, nil
+		}
+		elements := make([]Object, value.Len())
+		for i := range elements {
+			element, err := fromValue(value.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = element
+		}
+		return &Array{Elements: elements}, nil
+	case reflect.Map:
+		if value.IsNil() {
+			return NullValue, nil
+		}
+		pairs := make(map[HashKey]HashPair, value.Len())
+		iter := value.MapRange()
+		for iter.Next() {
+			key, err := fromValue(iter.Key())
+			if err != nil {
+				return nil, err
+			}
+			hashable, ok := AsHashable(key)
+			if !ok {
+				return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+			}
+			element, err := fromValue(iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			pairs[hashable.HashKey()] = HashPair{Key: key, Value: element}
+		}
+		return &Hash{Pairs: pairs}, nil
+	case reflect.Struct:
+		pairs := make(map[HashKey]HashPair)
+		for _, field := range structFields(value.Type()) {
+			element, err := fromValue(value.Field(field.index))
+			if err != nil {
+				return nil, err
+			}
+			key := &String{Value: field.name}
+			pairs[key.HashKey()] = HashPair{Key: key, Value: element}
+		}
+		return &Hash{Pairs: pairs}, nil
+	case reflect.Ptr, reflect.Interface:
+		if value.IsNil() {
+			return NullValue, nil
+		}
+		return fromValue(value.Elem())
+	case reflect.Func:
+		if value.IsNil() {
+			return NullValue, nil
+		}
+		return NewGoFunction("", value.Interface())
+	default:
+		return nil, fmt.Errorf("cannot convert %s", value.Type())
+	}
+}
+
+// ToGo converts an object to a Go value. Integers become int64 or *big.Int,
+// strings, booleans and null become string, bool and nil. Arrays become
+// []interface{}, hashes map[string]interface{} if all keys are strings and
+// map[interface{}]interface{} otherwise. Host objects return the value they
+// wrap. Other objects, like functions, are returned unchanged.
+func ToGo(obj Object) (interface{}, error) {
+	switch obj := obj.(type) {
+	case nil, *Null:
+		return nil, nil
+	case *Integer:
+		return obj.Value, nil
+	case *BigInteger:
+		return new(big.Int).Set(obj.Value), nil
+	case *String:
+		return obj.Value, nil
+	case *Boolean:
+		return obj.Value, nil
+	case *Array:
+		elements := make([]interface{}, len(obj.Elements))
+		for i, element := range obj.Elements {
+			value, err := ToGo(element)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = value
+		}
+		return elements, nil
+	case *HostObject:
+		return obj.Value, nil
+	case *Hash:
+		if hasStringKeys(obj) {
+			values := make(map[string]interface{}, len(obj.Pairs))
+			for _, pair := range obj.Pairs {
+				value, err := ToGo(pair.Value)
+				if err != nil {
+					return nil, err
+				}
+				values[pair.Key.(*String).Value] = value
+			}
+			return values, nil
+		}
+
+		values := make(map[interface{}]interface{}, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			key, err := ToGo(pair.Key)
+			if err != nil {
+				return nil, err
+			}
+			if key != nil && !reflect.TypeOf(key).Comparable() {
+				return nil, fmt.Errorf("cannot convert hash key %s", pair.Key.Inspect())
+			}
+			value, err := ToGo(pair.Value)
+			if err != nil {
+				return nil, err
+			}
+			values[key] = value
+		}
+		return values, nil
+	default:
+		return obj, nil
+	}
+}
+
+func hasStringKeys(hash *Hash) bool {
+	for _, pair := range hash.Pairs {
+		if _, ok := pair.Key.(*String); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// toType converts obj to a value of the Go type t, as needed for the
+// arguments of functions wrapped by NewGoFunction.
+func toType(obj Object, t reflect.Type) (reflect.Value, error) {
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		value, err := ToGo(obj)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if value == nil {
+			return reflect.Zero(t), nil
+		}
+		return reflect.ValueOf(value), nil
+	}
+
+	if reflect.TypeOf(obj).AssignableTo(t) {
+		return reflect.ValueOf(obj), nil
+	}
+
+	if host, ok := obj.(*HostObject); ok && host.Value != nil && reflect.TypeOf(host.Value).AssignableTo(t) {
+		return reflect.ValueOf(host.Value), nil
+	}
+
+	if t == bigIntType {
+		if obj.Type() != INTEGER {
+			return reflect.Value{}, conversionError(obj, t)
+		}
+		return reflect.ValueOf(new(big.Int).Set(toBigInt(obj))), nil
+	}
+
+	if _, ok := obj.(*Null); ok {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+			return reflect.Zero(t), nil
+		}
+	}
+
+	value := reflect.New(t).Elem()
+
+	switch t.Kind() {
+	case reflect.Bool:
+		boolean, ok := obj.(*Boolean)
+		if !ok {
+			return reflect.Value{}, conversionError(obj, t)
+		}
+		value.SetBool(boolean.Value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		integer, ok := obj.(*Integer)
+		if !ok || value.OverflowInt(integer.Value) {
+			return reflect.Value{}, conversionError(obj, t)
+		}
+		value.SetInt(integer.Value)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if obj.Type() != INTEGER {
+			return reflect.Value{}, conversionError(obj, t)
+		}
+		integer := toBigInt(obj)
+		if integer.Sign() < 0 || !integer.IsUint64() || value.OverflowUint(integer.Uint64()) {
+			return reflect.Value{}, conversionError(obj, t)
+		}
+		value.SetUint(integer.Uint64())
+	case reflect.Float32, reflect.Float64:
+		if obj.Type() != INTEGER {
+			return reflect.Value{}, conversionError(obj, t)
+		}
+		float, _ := new(big.Float).SetInt(toBigInt(obj)).Float64()
+		value.SetFloat(float)
+	case reflect.String:
+		str, ok := obj.(*String)
+		if !ok {
+			return reflect.Value{}, conversionError(obj, t)
+		}
+		value.SetString(str.Value)
+	case reflect.Slice, reflect.Array:
+		array, ok := obj.(*Array)
+		if !ok || (t.Kind() == reflect.Array && t.Len() != len(array.Elements)) {
+			return reflect.Value{}, conversionError(obj, t)
+		}
+		if t.Kind() == reflect.Slice {
+			value.Set(reflect.MakeSlice(t, len(array.Elements), len(array.Elements)))
+		}
+		for i, element := range array.Elements {
+			converted, err := toType(element, t.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			value.Index(i).Set(converted)
+		}
+	case reflect.Map:
+		hash, ok := obj.(*Hash)
+		if !ok {
+			return reflect.Value{}, conversionError(obj, t)
+		}
+		value.Set(reflect.MakeMapWithSize(t, len(hash.Pairs)))
+		for _, pair := range hash.Pairs {
+			key, err := toType(pair.Key, t.Key())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			element, err := toType(pair.Value, t.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			value.SetMapIndex(key, element)
+		}
+	case reflect.Struct:
+		hash, ok := obj.(*Hash)
+		if !ok {
+			return reflect.Value{}, conversionError(obj, t)
+		}
+		for _, field := range structFields(t) {
+			element, ok := hash.Get(&String{Value: field.name})
+			if !ok {
+				continue
+			}
+			converted, err := toType(element, t.Field(field.index).Type)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			value.Field(field.index).Set(converted)
+		}
+	case reflect.Ptr:
+		element, err := toType(obj, t.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		value.Set(reflect.New(t.Elem()))
+		value.Elem().Set(element)
+	default:
+		return reflect.Value{}, conversionError(obj, t)
+	}
+
+	return value, nil
+}
+
+func conversionError(obj Object, t reflect.Type) error {
+	return fmt.Errorf("cannot convert %s to %s", obj.Type(), t)
+}
+
+type structField struct {
+	index int
+	name  string
+}
+
+// structFields returns the exported fields of a struct type with the keys
+// they are stored with in hashes.
+func structFields(t reflect.Type) []structField {
+	fields := []structField{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("monkey"); ok {
+			tag = strings.Split(tag, ",")[0]
+			if tag == "-" {
+				continue
+			}
+			if tag != "" {
+				name = tag
+			}
+		}
+
+		fields = append(fields, structField{index: i, name: name})
+	}
+
+	return fields
+}
+
+// NewGoFunction wraps fn, which has to be a Go function, in a builtin named
+// name. Arguments are converted to the parameter types of fn, see FromGo for
+// the supported types, and calls with the wrong number of arguments fail with
+// an ArgumentError. If the first parameter is a Runtime, it receives the
+// runtime calling the builtin and is not counted as an argument.
+//
+// fn may return nothing, a value, an error or a value and an error. The value
+// is converted with FromGo, a non-nil error is returned as an error value.
+func NewGoFunction(name string, fn interface{}) (*Builtin, error) {
+	function := reflect.ValueOf(fn)
+	if function.Kind() != reflect.Func || function.IsNil() {
+		return nil, fmt.Errorf("cannot wrap %T as builtin, it is not a function", fn)
+	}
+
+	t := function.Type()
+
+	withRuntime := t.NumIn() > 0 && t.In(0) == runtimeType
+	first := 0
+	if withRuntime {
+		first = 1
+	}
+
+	switch {
+	case t.NumOut() > 2,
+		t.NumOut() == 2 && t.Out(1) != errorType:
+		return nil, fmt.Errorf("cannot wrap %s as builtin, it must return at most a value and an error", t)
+	}
+
+	arity := t.NumIn() - first
+	if t.IsVariadic() {
+		arity = Variadic
+	}
+
+	if name == "" {
+		name = "function"
+	}
+
+	builtin := &Builtin{Name: name, Arity: arity}
+	builtin.Fn = func(runtime Runtime, args ...Object) Object {
+		minimum := t.NumIn() - first
+		if t.IsVariadic() {
+			minimum--
+			if len(args) < minimum {
+				return newError(ArgumentError, "wrong number of arguments. Got %d, want at least %d.", len(args), minimum)
+			}
+		} else if len(args) != minimum {
+			return newError(ArgumentError, "wrong number of arguments. Got %d, want %d.", len(args), minimum)
+		}
+
+		in := make([]reflect.Value, 0, first+len(args))
+		if withRuntime {
+			in = append(in, reflect.ValueOf(&runtime).Elem())
+		}
+
+		for i, arg := range args {
+			var parameter reflect.Type
+			if t.IsVariadic() && i >= minimum {
+				parameter = t.In(t.NumIn() - 1).Elem()
+			} else {
+				parameter = t.In(first + i)
+			}
+
+			value, err := toType(arg, parameter)
+			if err != nil {
+				return newError(TypeError, "argument %d to `%s`: %s", i+1, name, err)
+			}
+			in = append(in, value)
+		}
+
+		return goFunctionResult(name, function.Call(in))
+	}
+
+	return builtin, nil
+}
+
+func goFunctionResult(name string, out []reflect.Value) Object {
+	if len(out) == 0 {
+		return nil
+	}
+
+	last := out[len(out)-1]
+	if last.Type() == errorType {
+		if !last.IsNil() {
+			if err, ok := last.Interface().(*Error); ok {
+				return err
+			}
+			return NewError(RuntimeError, "%s", last.Interface())
+		}
+		if len(out) == 1 {
+			return nil
+		}
+	}
+
+	result, err := fromValue(out[0])
+	if err != nil {
+		return newError(TypeError, "result of `%s`: %s", name, err)
+	}
+
+	return result
+}
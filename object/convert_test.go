@@ -0,0 +1,216 @@
+package object
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type account struct {
+	Owner   string `monkey:"owner"`
+	Balance int64  `monkey:"balance,omitempty"`
+	Tags    []string
+	Secret  string `monkey:"-"`
+	note    string
+}
+
+func TestFromGo(t *testing.T) {
+	huge, _ := new(big.Int).SetString("99999999999999999999", 10)
+	var nilPointer *account
+
+	tests := []struct {
+		input    interface{}
+		expected string
+	}{
+		{nil, "null"},
+		{true, "true"},
+		{42, "42"},
+		{int8(-8), "-8"},
+		{uint64(math.MaxUint64), "18446744073709551615"},
+		{huge, "99999999999999999999"},
+		{3.0, "3"},
+		{"monkey", "monkey"},
+		{[]int{1, 2, 3}, "[1, 2, 3]"},
+		{[2]bool{true, false}, "[true, false]"},
+		{[]interface{}{1, "a", nil}, "[1, a, null]"},
+		{map[string]int{"a": 1}, "{a: 1}"},
+		{account{Owner: "ann", Balance: 10, Tags: []string{"x"}, Secret: "s", note: "n"}, "{Tags: [x], balance: 10, owner: ann}"},
+		{&account{Owner: "bob"}, "{Tags: null, balance: 0, owner: bob}"},
+		{nilPointer, "null"},
+		{&Integer{Value: 7}, "7"},
+	}
+
+	for _, test := range tests {
+		obj, err := FromGo(test.input)
+		if err != nil {
+			t.Errorf("%#v: unexpected error %v", test.input, err)
+			continue
+		}
+
+		if inspect := inspectSorted(obj); inspect != test.expected {
+			t.Errorf("%#v: want %s, got %s", test.input, test.expected, inspect)
+		}
+	}
+
+	if obj, _ := FromGo(nil); obj != NullValue {
+		t.Errorf("nil should become NullValue, got %#v", obj)
+	}
+
+	for _, input := range []interface{}{1.5, math.Inf(1), make(chan int), map[interface{}]int{nil: 1}} {
+		if _, err := FromGo(input); err == nil {
+			t.Errorf("%#v: expected an error", input)
+		}
+	}
+
+	obj, err := FromGo(strings.ToUpper)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, ok := obj.(*Builtin); !ok {
+		t.Errorf("functions should become builtins, got %T", obj)
+	}
+}
+
+func TestToGo(t *testing.T) {
+	huge, _ := new(big.Int).SetString("99999999999999999999", 10)
+
+	tests := []struct {
+		input    Object
+		expected interface{}
+	}{
+		{NullValue, nil},
+		{&Integer{Value: 1}, int64(1)},
+		{&BigInteger{Value: huge}, huge},
+		{&String{Value: "monkey"}, "monkey"},
+		{True, true},
+		{mustFromGo(t, []interface{}{1, "a"}), []interface{}{int64(1), "a"}},
+		{mustFromGo(t, map[string]interface{}{"a": []int{1}}), map[string]interface{}{"a": []interface{}{int64(1)}}},
+		{mustFromGo(t, map[int]bool{1: true}), map[interface{}]interface{}{int64(1): true}},
+	}
+
+	for _, test := range tests {
+		value, err := ToGo(test.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.input.Inspect(), err)
+			continue
+		}
+
+		if !reflect.DeepEqual(value, test.expected) {
+			t.Errorf("%s: want %#v, got %#v", test.input.Inspect(), test.expected, value)
+		}
+	}
+
+	key := &Array{Elements: []Object{&Integer{Value: 1}}}
+	hash := &Hash{Pairs: map[HashKey]HashPair{key.HashKey(): {Key: key, Value: True}}}
+	if _, err := ToGo(hash); err == nil {
+		t.Errorf("expected an error for array keys")
+	}
+
+	builtin := &Builtin{}
+	if value, _ := ToGo(builtin); value != builtin {
+		t.Errorf("functions should be returned unchanged, got %#v", value)
+	}
+}
+
+func TestNewGoFunction(t *testing.T) {
+	tests := []struct {
+		fn       interface{}
+		args     []Object
+		expected string
+	}{
+		{strings.Repeat, []Object{&String{Value: "ab"}, &Integer{Value: 2}}, "abab"},
+		{func(a, b int) int { return a + b }, []Object{&Integer{Value: 1}, &Integer{Value: 2}}, "3"},
+		{func(values ...int) int { return len(values) }, []Object{&Integer{Value: 1}, &Integer{Value: 2}}, "2"},
+		{func(prefix string, values ...int) string { return prefix }, []Object{&String{Value: "p"}}, "p"},
+		{func(value interface{}) interface{} { return value }, []Object{mustFromGo(t, []int{1})}, "[1]"},
+		{func(a account) string { return a.Owner + a.Tags[0] }, []Object{mustFromGo(t, account{Owner: "ann", Tags: []string{"!"}})}, "ann!"},
+		{func(a *account) bool { return a == nil }, []Object{NullValue}, "true"},
+		{func(m map[string]uint8) uint8 { return m["a"] }, []Object{mustFromGo(t, map[string]int{"a": 255})}, "255"},
+		{func(f float64) float64 { return f * 2 }, []Object{&Integer{Value: 21}}, "42"},
+		{func(obj Object) Object { return obj }, []Object{True}, "true"},
+		{func() {}, nil, "null"},
+		{func() error { return nil }, nil, "null"},
+		{func() (int, error) { return 0, errors.New("failed") }, nil, "RuntimeError: failed"},
+		{func(n int8) int8 { return n }, []Object{&Integer{Value: 300}}, "TypeError: argument 1 to `test`: cannot convert INTEGER to int8"},
+		{func(s string) string { return s }, []Object{&Integer{Value: 1}}, "TypeError: argument 1 to `test`: cannot convert INTEGER to string"},
+		{func(a, b int) int { return a + b }, []Object{&Integer{Value: 1}}, "ArgumentError: wrong number of arguments. Got 1, want 2."},
+		{func(s string, values ...int) int { return 0 }, nil, "ArgumentError: wrong number of arguments. Got 0, want at least 1."},
+		{func() float64 { return 0.5 }, nil, "TypeError: result of `test`: cannot convert 0.5 to INTEGER"},
+	}
+
+	for _, test := range tests {
+		builtin, err := NewGoFunction("test", test.fn)
+		if err != nil {
+			t.Fatalf("%T: unexpected error %v", test.fn, err)
+		}
+
+		result := builtin.Fn(nil, test.args...)
+
+		var inspect string
+		switch result := result.(type) {
+		case nil:
+			inspect = "null"
+		case *Error:
+			inspect = string(result.Kind) + ": " + result.Message
+		default:
+			inspect = result.Inspect()
+		}
+
+		if inspect != test.expected {
+			t.Errorf("%T: want %s, got %s", test.fn, test.expected, inspect)
+		}
+	}
+
+	builtin, _ := NewGoFunction("apply", func(runtime Runtime, fn Object) (Object, error) {
+		if runtime == nil {
+			return nil, errors.New("no runtime")
+		}
+		result, err := runtime.Apply(fn)
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+	})
+	if builtin.Arity != 1 {
+		t.Errorf("the runtime should not count as argument, got arity %d", builtin.Arity)
+	}
+	if result, ok := builtin.Fn(nil, True).(*Error); !ok || result.Message != "no runtime" {
+		t.Errorf("wrong result %+v", result)
+	}
+
+	for _, fn := range []interface{}{42, func() (int, int) { return 0, 0 }} {
+		if _, err := NewGoFunction("test", fn); err == nil {
+			t.Errorf("%T: expected an error", fn)
+		}
+	}
+}
+
+func mustFromGo(t *testing.T, value interface{}) Object {
+	t.Helper()
+
+	obj, err := FromGo(value)
+	if err != nil {
+		t.Fatalf("%#v: unexpected error %v", value, err)
+	}
+
+	return obj
+}
+
+// inspectSorted inspects obj with the pairs of hashes sorted, so the result
+// does not depend on the iteration order of maps.
+func inspectSorted(obj Object) string {
+	hash, ok := obj.(*Hash)
+	if !ok {
+		return obj.Inspect()
+	}
+
+	pairs := []string{}
+	for _, pair := range hash.SortedPairs() {
+		pairs = append(pairs, pair.Key.Inspect()+": "+inspectSorted(pair.Value))
+	}
+
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
@@ -1,30 +1,349 @@
 package object
 
+import (
+	"io"
+	"os"
+	"sync"
+)
+
 func NewEnclosedEnvironment(outer *Environment) *Environment {
-	env := NewEnvironment()
+	env := newEnvironment()
 	env.outer = outer
+	env.inherit(outer)
 	return env
 }
 
 func NewEnvironment() *Environment {
+	env := newEnvironment()
+	env.modules = newModuleCache()
+	return env
+}
+
+// newEnvironment creates an environment without a module cache, which
+// environments created from others share.
+func newEnvironment() *Environment {
 	store := make(map[string]Object)
-	return &Environment{store: store, outer: nil}
+	return &Environment{store: store, outer: nil, maxCallDepth: DefaultMaxCallDepth, stdout: os.Stdout}
+}
+
+// NewFunctionEnvironment creates the environment for a call of the function
+// with the given name. outer is the environment the function was defined in,
+// caller the environment it is called from.
+func NewFunctionEnvironment(outer, caller *Environment, name string) *Environment {
+	env := NewEnclosedEnvironment(outer)
+	env.call = &call{name: name, caller: caller.call, depth: caller.CallDepth() + 1}
+	env.inherit(caller)
+	return env
+}
+
+// NewTaskEnvironment creates the environment a task spawned from env calls
+// its function from. The task shares the settings and the scheduler of env,
+// but starts with an empty call stack and uses budget. Like on the VM, the task
+// sees the globals of env as they are when it is spawned, globals defined later
+// are not visible to it.
+func NewTaskEnvironment(env *Environment, budget *Budget) *Environment {
+	task := newEnvironment()
+	task.inherit(env)
+	task.budget = budget
+
+	globals := env
+	for globals.outer != nil {
+		globals = globals.outer
+	}
+	if task.snapshot == nil || task.snapshot.env != globals {
+		if globals.scheduler.Concurrent() {
+			globals.mu.RLock()
+			defer globals.mu.RUnlock()
+		}
+
+		store := make(map[string]Object, len(globals.store))
+		for name, value := range globals.store {
+			store[name] = value
+		}
+		task.snapshot = &snapshot{env: globals, store: store}
+	}
+
+	return task
+}
+
+// snapshot is a copy of the store of the top level environment env, taken
+// when a task is spawned.
+type snapshot struct {
+	env   *Environment
+	store map[string]Object
+}
+
+func (e *Environment) inherit(from *Environment) {
+	e.maxCallDepth = from.maxCallDepth
+	e.stepBudget = from.stepBudget
+	e.memoryLimit = from.memoryLimit
+	e.stdout = from.stdout
+	e.builtins = from.builtins
+	e.budget = from.budget
+	e.schedulerMode = from.schedulerMode
+	e.scheduler = from.scheduler
+	e.moduleLoader = from.moduleLoader
+	e.modules = from.modules
+	e.imports = from.imports
+	e.snapshot = from.snapshot
 }
 
 type Environment struct {
+	// mu guards store once tasks were spawned
+	mu    sync.RWMutex
 	store map[string]Object
 	outer *Environment
+	// call describes the function call the environment was created for, it
+	// is nil for the top level
+	call *call
+	// maxCallDepth, stepBudget, memoryLimit, stdout, builtins, budget,
+	// schedulerMode, scheduler, moduleLoader, modules, imports and snapshot
+	// are passed on to the environments of all calls made from this one
+	maxCallDepth int
+	stepBudget   int
+	memoryLimit  int
+	stdout       io.Writer
+	// builtins resolves builtin names, the standard builtins are used if it
+	// is nil
+	builtins *Registry
+	// budget limits the current run, it is nil outside of runs with a
+	// context
+	budget        *Budget
+	schedulerMode SchedulerMode
+	// scheduler runs the tasks of the current run, it is nil outside of runs
+	scheduler *Scheduler
+	// moduleLoader loads imported modules, nothing can be imported if it is
+	// nil
+	moduleLoader *ModuleLoader
+	// modules holds the modules imported so far
+	modules *moduleCache
+	// imports lists the modules being imported, outermost first
+	imports []string
+	// snapshot holds the globals of the task the environment belongs to,
+	// lookups reaching the top level environment read it instead
+	snapshot *snapshot
+	// generator is set for the environment of a generator function call, it
+	// is suspended by the yield statements of the body
+	generator interface{}
+}
+
+type call struct {
+	name   string
+	caller *call
+	depth  int
+}
+
+// StackTrace returns the names of the active function calls, innermost first.
+func (e *Environment) StackTrace() []string {
+	stack := []string{}
+	for c := e.call; c != nil; c = c.caller {
+		stack = append(stack, c.name)
+	}
+
+	return append(stack, "<main>")
+}
+
+// CallDepth returns the number of active function calls.
+func (e *Environment) CallDepth() int {
+	if e.call == nil {
+		return 0
+	}
+
+	return e.call.depth
+}
+
+// MaxCallDepth returns the number of nested function calls allowed.
+func (e *Environment) MaxCallDepth() int {
+	return e.maxCallDepth
+}
+
+// SetMaxCallDepth limits the number of nested function calls made from the
+// environment.
+func (e *Environment) SetMaxCallDepth(depth int) {
+	e.maxCallDepth = depth
+}
+
+// StepBudget returns the number of steps runs in the environment may take.
+func (e *Environment) StepBudget() int {
+	return e.stepBudget
+}
+
+// SetStepBudget limits the number of steps runs in the environment may take.
+// There is no limit if steps is 0 or less, which is the default.
+func (e *Environment) SetStepBudget(steps int) {
+	e.stepBudget = steps
+}
+
+// MemoryLimit returns the number of bytes runs in the environment may
+// allocate.
+func (e *Environment) MemoryLimit() int {
+	return e.memoryLimit
+}
+
+// SetMemoryLimit limits the number of bytes runs in the environment may
+// allocate. There is no limit if bytes is 0 or less, which is the default.
+func (e *Environment) SetMemoryLimit(bytes int) {
+	e.memoryLimit = bytes
+}
+
+// Stdout returns the writer builtins print to.
+func (e *Environment) Stdout() io.Writer {
+	return e.stdout
+}
+
+// SetStdout sets the writer builtins print to, which is os.Stdout by default.
+func (e *Environment) SetStdout(w io.Writer) {
+	e.stdout = w
+}
+
+// Builtin returns the builtin with the given name.
+func (e *Environment) Builtin(name string) (*Builtin, bool) {
+	if e.builtins != nil {
+		return e.builtins.Lookup(name)
+	}
+
+	builtin := GetBuiltinByName(name)
+	return builtin, builtin != nil
+}
+
+// Method returns the method name of values of type objectType.
+func (e *Environment) Method(objectType ObjectType, name string) (*Builtin, bool) {
+	if e.builtins != nil {
+		return e.builtins.Method(objectType, name)
+	}
+
+	return GetMethod(objectType, name)
+}
+
+// SetBuiltins sets the registry builtin names and methods are resolved with.
+func (e *Environment) SetBuiltins(builtins *Registry) {
+	e.builtins = builtins
+}
+
+// Budget returns the budget of the current run.
+func (e *Environment) Budget() *Budget {
+	return e.budget
+}
+
+// SetBudget sets the budget of the current run.
+func (e *Environment) SetBudget(budget *Budget) {
+	e.budget = budget
+}
+
+// SchedulerMode returns the mode tasks spawned in the environment run in.
+func (e *Environment) SchedulerMode() SchedulerMode {
+	return e.schedulerMode
+}
+
+// SetSchedulerMode sets the mode tasks spawned in the environment run in,
+// which is Parallel by default.
+func (e *Environment) SetSchedulerMode(mode SchedulerMode) {
+	e.schedulerMode = mode
+}
+
+// Scheduler returns the scheduler of the current run.
+func (e *Environment) Scheduler() *Scheduler {
+	return e.scheduler
+}
+
+// SetScheduler sets the scheduler of the current run.
+func (e *Environment) SetScheduler(scheduler *Scheduler) {
+	e.scheduler = scheduler
+}
+
+// ModuleLoader returns the loader of imported modules.
+func (e *Environment) ModuleLoader() *ModuleLoader {
+	return e.moduleLoader
+}
+
+// SetModuleLoader sets the loader of imported modules.
+func (e *Environment) SetModuleLoader(loader *ModuleLoader) {
+	e.moduleLoader = loader
+}
+
+// Imports lists the modules being imported when the environment was
+// created, outermost first.
+func (e *Environment) Imports() []string {
+	return e.imports
+}
+
+// ImportedModule returns the module name if it was imported before.
+func (e *Environment) ImportedModule(name string) (*Module, bool) {
+	if e.modules == nil {
+		return nil, false
+	}
+
+	e.modules.mu.Lock()
+	defer e.modules.mu.Unlock()
+
+	module, ok := e.modules.modules[name]
+	return module, ok
+}
+
+// SetImportedModule records module as imported, later imports of it return
+// it without evaluating it again.
+func (e *Environment) SetImportedModule(module *Module) {
+	if e.modules == nil {
+		return
+	}
+
+	e.modules.mu.Lock()
+	defer e.modules.mu.Unlock()
+
+	e.modules.modules[module.Name] = module
+}
+
+// Generator returns the generator evaluating the body of the function call
+// the environment was created for.
+func (e *Environment) Generator() interface{} {
+	return e.generator
+}
+
+// SetGenerator sets the generator evaluating the body of the function call
+// the environment was created for. It is not passed on to other environments.
+func (e *Environment) SetGenerator(generator interface{}) {
+	e.generator = generator
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
-	object, ok := e.store[name]
-	if !ok && e.outer != nil {
-		object, ok = e.outer.Get(name)
+	for env := e; env != nil; env = env.outer {
+		var object Object
+		var ok bool
+		if e.snapshot != nil && env == e.snapshot.env {
+			object, ok = e.snapshot.store[name]
+		} else if env.scheduler.Concurrent() {
+			env.mu.RLock()
+			object, ok = env.store[name]
+			env.mu.RUnlock()
+		} else {
+			object, ok = env.store[name]
+		}
+
+		if ok {
+			return object, true
+		}
 	}
-	return object, ok
+
+	return nil, false
 }
 
 func (e *Environment) Set(name string, value Object) Object {
+	if e.scheduler.Concurrent() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+	}
+
 	e.store[name] = value
 	return value
 }
+
+// Delete removes the binding of name from the environment, bindings of outer
+// environments are kept.
+func (e *Environment) Delete(name string) {
+	if e.scheduler.Concurrent() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+	}
+
+	delete(e.store, name)
+}
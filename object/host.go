@@ -0,0 +1,76 @@
+package object
+
+import "fmt"
+
+// HostProperty returns the value of a property of the Go value wrapped by a
+// host object.
+type HostProperty func(value interface{}) Object
+
+// HostMethod implements a method of host objects. value is the Go value wrapped
+// by the host object the method is called on.
+type HostMethod func(runtime Runtime, value interface{}, args ...Object) Object
+
+// HostType describes host objects of one kind. Name is reported as their
+// type, Properties and Methods are what scripts can access as host.name or by
+// indexing them with the name. Properties take precedence over methods of the
+// same name.
+type HostType struct {
+	Name       string
+	Properties map[string]HostProperty
+	Methods    map[string]HostMethod
+	// Inspect formats host objects of the type, by default they show their
+	// type name and address
+	Inspect func(value interface{}) string
+}
+
+// HostObject gives scripts access to a Go value without copying it into
+// Monkey values.
+type HostObject struct {
+	HostType *HostType
+	Value    interface{}
+}
+
+// NewHostObject wraps value in a host object of the given type.
+func NewHostObject(hostType *HostType, value interface{}) *HostObject {
+	return &HostObject{HostType: hostType, Value: value}
+}
+
+func (h *HostObject) Type() ObjectType { return ObjectType(h.HostType.Name) }
+func (h *HostObject) Inspect() string {
+	if h.HostType.Inspect != nil {
+		return h.HostType.Inspect(h.Value)
+	}
+
+	return fmt.Sprintf("%s[%p]", h.HostType.Name, h)
+}
+
+// Field returns the property or the method with the given name. Methods are
+// returned as builtins bound to the host object.
+func (h *HostObject) Field(name Object) (Object, bool) {
+	key, ok := name.(*String)
+	if !ok {
+		return nil, false
+	}
+
+	if property, ok := h.HostType.Properties[key.Value]; ok {
+		value := property(h.Value)
+		if value == nil {
+			return NullValue, true
+		}
+		return value, true
+	}
+
+	if method, ok := h.HostType.Methods[key.Value]; ok {
+		return &Builtin{Name: key.Value, Arity: Variadic, Fn: func(runtime Runtime, args ...Object) Object {
+			return method(runtime, h.Value, args...)
+		}}, true
+	}
+
+	return nil, false
+}
+
+// MissingFieldError is raised when a script accesses a field obj does not
+// have.
+func MissingFieldError(obj Object, name Object) *Error {
+	return NewError(AttributeError, "%s has no property or method %s", obj.Type(), name.Inspect())
+}
@@ -0,0 +1,126 @@
+package object
+
+import (
+	"math"
+	"math/big"
+)
+
+// NewInteger returns an *Integer if value fits into an int64 and a
+// *BigInteger otherwise.
+func NewInteger(value *big.Int) Object {
+	if value.IsInt64() {
+		return &Integer{Value: value.Int64()}
+	}
+
+	return &BigInteger{Value: value}
+}
+
+// IntegerOperation applies one of the arithmetic operators +, -, * and / to
+// two integer objects. Results that overflow an int64 are promoted to a
+// BigInteger, big results that fit are demoted to an Integer again. Unknown
+// operators and divisions by zero result in an *Error.
+func IntegerOperation(operator string, left, right Object) Object {
+	if operator == "/" && CompareIntegers(right, &Integer{Value: 0}) == 0 {
+		return NewError(ZeroDivisionError, "division by zero")
+	}
+
+	l, lok := left.(*Integer)
+	r, rok := right.(*Integer)
+	if lok && rok {
+		if result, ok := smallIntegerOperation(operator, l.Value, r.Value); ok {
+			return &Integer{Value: result}
+		}
+	}
+
+	x, y := toBigInt(left), toBigInt(right)
+	result := new(big.Int)
+
+	switch operator {
+	case "+":
+		result.Add(x, y)
+	case "-":
+		result.Sub(x, y)
+	case "*":
+		result.Mul(x, y)
+	case "/":
+		result.Quo(x, y)
+	default:
+		return NewError(TypeError, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+
+	return NewInteger(result)
+}
+
+// smallIntegerOperation performs the operation on int64 values. It reports
+// false if the operation would overflow or the operator is unknown.
+func smallIntegerOperation(operator string, left, right int64) (int64, bool) {
+	switch operator {
+	case "+":
+		result := left + right
+		if (right > 0 && result < left) || (right < 0 && result > left) {
+			return 0, false
+		}
+		return result, true
+	case "-":
+		result := left - right
+		if (right < 0 && result < left) || (right > 0 && result > left) {
+			return 0, false
+		}
+		return result, true
+	case "*":
+		if left == 0 || right == 0 {
+			return 0, true
+		}
+		result := left * right
+		if result/right != left || (left == -1 && right == math.MinInt64) || (right == -1 && left == math.MinInt64) {
+			return 0, false
+		}
+		return result, true
+	case "/":
+		if left == math.MinInt64 && right == -1 {
+			return 0, false
+		}
+		return left / right, true
+	default:
+		return 0, false
+	}
+}
+
+// NegateInteger returns the negated value of an integer object.
+func NegateInteger(operand Object) Object {
+	if integer, ok := operand.(*Integer); ok && integer.Value != math.MinInt64 {
+		return &Integer{Value: -integer.Value}
+	}
+
+	return NewInteger(new(big.Int).Neg(toBigInt(operand)))
+}
+
+// CompareIntegers compares two integer objects and returns -1, 0 or +1 if left
+// is less than, equal to or greater than right.
+func CompareIntegers(left, right Object) int {
+	l, lok := left.(*Integer)
+	r, rok := right.(*Integer)
+	if lok && rok {
+		switch {
+		case l.Value < r.Value:
+			return -1
+		case l.Value > r.Value:
+			return 1
+		default:
+			return 0
+		}
+	}
+
+	return toBigInt(left).Cmp(toBigInt(right))
+}
+
+func toBigInt(obj Object) *big.Int {
+	switch obj := obj.(type) {
+	case *Integer:
+		return big.NewInt(obj.Value)
+	case *BigInteger:
+		return obj.Value
+	default:
+		return new(big.Int)
+	}
+}
@@ -0,0 +1,74 @@
+package object
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+const (
+	GENERATOR = "GENERATOR"
+	ITERATOR  = "ITERATOR"
+)
+
+// Iterator steps through the elements of a value iterated by a for loop.
+type Iterator interface {
+	Object
+	// Next returns the next element, ok is false once all elements have been
+	// returned.
+	Next() (element Object, ok bool)
+}
+
+// Iterate returns an iterator over the elements of obj: the elements of
+// arrays, the UTF-8 encoded characters of strings and the keys of hashes
+// in the order of SortedPairs. The elements are taken when Iterate is called,
+// changes to obj during the iteration are not seen. ok is false if obj cannot
+// be iterated this way, generators are iterated by the engine running them.
+func Iterate(obj Object) (iterator Iterator, ok bool) {
+	switch obj := obj.(type) {
+	case *Array:
+		elements := make([]Object, len(obj.Elements))
+		copy(elements, obj.Elements)
+		return &sliceIterator{elements: elements}, true
+	case *String:
+		elements := make([]Object, 0, utf8.RuneCountInString(obj.Value))
+		for value := obj.Value; value != ""; {
+			_, size := utf8.DecodeRuneInString(value)
+			elements = append(elements, &String{Value: value[:size]})
+			value = value[size:]
+		}
+		return &sliceIterator{elements: elements}, true
+	case *Hash:
+		pairs := obj.SortedPairs()
+		elements := make([]Object, len(pairs))
+		for i, pair := range pairs {
+			elements[i] = pair.Key
+		}
+		return &sliceIterator{elements: elements}, true
+	}
+
+	return nil, false
+}
+
+// NotIterableError is raised by for loops over values Iterate does not
+// support.
+func NotIterableError(obj Object) *Error {
+	return NewError(TypeError, "%s is not iterable", obj.Type())
+}
+
+type sliceIterator struct {
+	elements []Object
+	index    int
+}
+
+func (s *sliceIterator) Type() ObjectType { return ITERATOR }
+func (s *sliceIterator) Inspect() string  { return fmt.Sprintf("Iterator[%p]", s) }
+
+func (s *sliceIterator) Next() (Object, bool) {
+	if s.index >= len(s.elements) {
+		return nil, false
+	}
+
+	element := s.elements[s.index]
+	s.index++
+	return element, true
+}
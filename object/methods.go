@@ -0,0 +1,83 @@
+package object
+
+// Methods lists the standard builtins values of each type have as methods.
+// value.name(args) calls the builtin name with value as its first argument,
+// so "abc".upper() is upper("abc").
+var Methods = map[ObjectType][]string{
+	STRING:  {"len", "split", "trim", "upper", "lower", "contains", "replace", "index_of", "starts_with", "ends_with", "repeat", "format"},
+	ARRAY:   {"len", "first", "last", "rest", "push", "join", "map", "filter", "reduce", "sort_by", "any", "all"},
+	HASH:    {"len", "keys", "values", "entries", "has", "delete", "merge"},
+	CHANNEL: {"send", "recv", "close"},
+}
+
+var standardMethods = newMethodTable()
+
+func newMethodTable() map[ObjectType]map[string]*Builtin {
+	table := make(map[ObjectType]map[string]*Builtin, len(Methods))
+
+	for objectType, names := range Methods {
+		table[objectType] = make(map[string]*Builtin, len(names))
+		for _, name := range names {
+			table[objectType][name] = &Builtin{Name: name, Arity: Variadic, Fn: GetBuiltinByName(name).Fn}
+		}
+	}
+
+	return table
+}
+
+// GetMethod returns the standard method name of values of type objectType.
+func GetMethod(objectType ObjectType, name string) (*Builtin, bool) {
+	method, ok := standardMethods[objectType][name]
+	return method, ok
+}
+
+// MethodLookup returns the method name of values of type objectType.
+type MethodLookup func(objectType ObjectType, name string) (*Builtin, bool)
+
+// ResolveField resolves obj.name for both engines. Hash keys, the fields of
+// errors, the properties and methods of host objects and the exports of
+// modules are returned as they are. Otherwise the method of the type of obj
+// is returned with method set, callers pass obj as its first argument.
+// Missing fields are null for hashes and raise an AttributeError for other
+// values.
+func ResolveField(obj Object, name string, methods MethodLookup) (value Object, method bool, err *Error) {
+	key := &String{Value: name}
+
+	switch obj := obj.(type) {
+	case *Hash:
+		if value, ok := obj.Get(key); ok {
+			return value, false, nil
+		}
+	case *Error:
+		if value, ok := obj.Field(key); ok {
+			return value, false, nil
+		}
+	case *HostObject:
+		if value, ok := obj.Field(key); ok {
+			return value, false, nil
+		}
+	case *Module:
+		if value, ok := obj.Exports[name]; ok {
+			return value, false, nil
+		}
+	}
+
+	if builtin, ok := methods(obj.Type(), name); ok {
+		return builtin, true, nil
+	}
+
+	if obj.Type() == HASH {
+		return NullValue, false, nil
+	}
+
+	return nil, false, MissingFieldError(obj, key)
+}
+
+// BindMethod returns a builtin calling method with receiver as its first
+// argument, which is the value of receiver.name when the method is not called
+// right away.
+func BindMethod(method *Builtin, receiver Object) *Builtin {
+	return &Builtin{Name: method.Name, Arity: Variadic, Doc: method.Doc, Fn: func(runtime Runtime, args ...Object) Object {
+		return method.Fn(runtime, append([]Object{receiver}, args...)...)
+	}}
+}
@@ -0,0 +1,164 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nhoffmann/monkey/ast"
+	"github.com/nhoffmann/monkey/lexer"
+	"github.com/nhoffmann/monkey/parser"
+)
+
+const (
+	MODULE          = "MODULE"
+	COMPILED_MODULE = "COMPILED_MODULE"
+)
+
+// ImportError is the kind of errors raised when a module cannot be imported.
+const ImportError ErrorKind = "ImportError"
+
+// ModuleExtension is the extension of the files modules are loaded from.
+const ModuleExtension = ".mk"
+
+// ModuleLoader finds the modules imported by programs. Module names are
+// slash separated paths without extension, "text/wrap" is loaded from the
+// file text/wrap.mk in the first root containing it.
+type ModuleLoader struct {
+	roots []fs.FS
+}
+
+// NewModuleLoader creates a loader looking for modules in the given
+// directories, in order.
+func NewModuleLoader(paths ...string) *ModuleLoader {
+	roots := make([]fs.FS, len(paths))
+	for i, path := range paths {
+		roots[i] = os.DirFS(path)
+	}
+
+	return NewModuleLoaderFS(roots...)
+}
+
+// NewModuleLoaderFS creates a loader looking for modules in the given file
+// systems, in order, like the standard library embedded into the binary.
+func NewModuleLoaderFS(roots ...fs.FS) *ModuleLoader {
+	return &ModuleLoader{roots: roots}
+}
+
+// Load finds the module name and parses it.
+func (l *ModuleLoader) Load(name string) (*ast.Program, error) {
+	if !validModuleName(name) {
+		return nil, NewError(ImportError, "invalid module name %q", name)
+	}
+
+	for _, root := range l.roots {
+		source, err := fs.ReadFile(root, name+ModuleExtension)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, NewError(ImportError, "module %s: %s", name, err)
+		}
+
+		p := parser.NewParser(lexer.NewLexer(string(source)))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			messages := make([]string, len(p.Errors()))
+			for i, err := range p.Errors() {
+				messages[i] = err.Error()
+			}
+			return nil, NewError(ImportError, "module %s: %s", name, strings.Join(messages, "; "))
+		}
+
+		return program, nil
+	}
+
+	return nil, NewError(ImportError, "module not found: %s", name)
+}
+
+// validModuleName reports whether name is a relative, clean slash separated
+// path, so modules cannot be loaded from outside the roots.
+func validModuleName(name string) bool {
+	if name == "" || name != path.Clean(name) || path.IsAbs(name) || strings.Contains(name, `\`) {
+		return false
+	}
+
+	return name != ".." && !strings.HasPrefix(name, "../")
+}
+
+// ImportCycleError is returned when the module name is imported while it is
+// being imported. imports lists the modules being imported, outermost first.
+func ImportCycleError(imports []string, name string) *Error {
+	for i, imported := range imports {
+		if imported == name {
+			return NewError(ImportError, "import cycle: %s", strings.Join(append(imports[i:len(imports):len(imports)], name), " -> "))
+		}
+	}
+
+	return nil
+}
+
+// Module is the value of an import expression. Its exports are accessed like
+// fields, module.name.
+type Module struct {
+	Name    string
+	Exports map[string]Object
+}
+
+func (m *Module) Type() ObjectType { return MODULE }
+func (m *Module) Inspect() string {
+	names := make([]string, 0, len(m.Exports))
+	for name := range m.Exports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return fmt.Sprintf("Module[%s](%s)", m.Name, strings.Join(names, ", "))
+}
+
+// CompiledModule is a module compiled into the bytecode of a program. Body
+// defines the globals of the module, which are kept in the globals of the
+// program, the module object is stored in the global Slot once Body ran.
+type CompiledModule struct {
+	Name    string
+	Body    *CompiledFunction
+	Slot    int
+	Exports []ModuleExport
+}
+
+// ModuleExport is an exported global of a compiled module.
+type ModuleExport struct {
+	Name   string
+	Global int
+}
+
+func (cm *CompiledModule) Type() ObjectType { return COMPILED_MODULE }
+func (cm *CompiledModule) Inspect() string  { return fmt.Sprintf("CompiledModule[%s]", cm.Name) }
+
+// moduleCache holds the modules imported in an environment and the
+// environments created from it.
+type moduleCache struct {
+	mu      sync.Mutex
+	modules map[string]*Module
+}
+
+func newModuleCache() *moduleCache {
+	return &moduleCache{modules: map[string]*Module{}}
+}
+
+// NewModuleEnvironment creates the environment the module name imported from
+// env is evaluated in. It has globals of its own, but shares the settings and
+// the imported modules of env. Like on the VM, the module appears on stack
+// traces as a call of a function named like the module.
+func NewModuleEnvironment(env *Environment, name string) *Environment {
+	module := newEnvironment()
+	module.inherit(env)
+	module.call = &call{name: name, caller: env.call, depth: env.CallDepth() + 1}
+	module.imports = append(env.imports[:len(env.imports):len(env.imports)], name)
+	return module
+}
@@ -2,15 +2,43 @@ package object
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"hash/fnv"
+	"io"
+	"math/big"
+	"sort"
 	"strings"
 
 	"github.com/nhoffmann/monkey/ast"
+	"github.com/nhoffmann/monkey/code"
 )
 
 type ObjectType string
-type BuiltinFunction func(args ...Object) Object
+type BuiltinFunction func(runtime Runtime, args ...Object) Object
+
+// Runtime is provided by the engine calling a builtin function. It allows
+// builtins to call back into functions defined in Monkey, regardless of
+// whether these are evaluated or compiled, to account the memory they
+// allocate and to print output.
+type Runtime interface {
+	// Apply calls fn with the given arguments. Errors raised by the call are
+	// returned separately from the result, which may be an error value itself.
+	// The builtin should return right away, the engine keeps raising the error.
+	Apply(fn Object, args ...Object) (Object, *Error)
+	// Allocate accounts size bytes allocated by the builtin. Once the memory
+	// limit of the run is exceeded an error is returned, which the builtin
+	// should return right away, the engine stops the run.
+	Allocate(size int) *Error
+	// Stdout returns the writer builtins print to.
+	Stdout() io.Writer
+	// Scheduler returns the scheduler of the running tasks.
+	Scheduler() *Scheduler
+	// Raise raises err once the builtin returns. Errors are raised like
+	// thrown values, other errors such as ErrTimeout stop the run. It returns
+	// the raised error, which the builtin should return right away.
+	Raise(err error) *Error
+}
 
 const (
 	INTEGER      = "INTEGER"
@@ -23,6 +51,10 @@ const (
 	BUILTIN      = "BUILTIN"
 	ARRAY        = "ARRAY"
 	HASH         = "HASH"
+
+	COMPILED_FUNCTION = "COMPILED_FUNCTION"
+	CLOSURE           = "CLOSURE"
+	EXCEPTION         = "EXCEPTION"
 )
 
 type Object interface {
@@ -37,6 +69,16 @@ type Integer struct {
 func (i *Integer) Type() ObjectType { return INTEGER }
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
 
+// BigInteger holds integers that do not fit into an int64. It reports the
+// INTEGER type, so the language does not distinguish it from Integer. Use
+// NewInteger to create integers from big values.
+type BigInteger struct {
+	Value *big.Int
+}
+
+func (bi *BigInteger) Type() ObjectType { return INTEGER }
+func (bi *BigInteger) Inspect() string  { return bi.Value.String() }
+
 type String struct {
 	Value string
 }
@@ -63,17 +105,124 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// DefaultMaxCallDepth is the number of nested function calls both engines
+// allow unless configured otherwise. Exceeding it raises a RecursionError.
+const DefaultMaxCallDepth = 10000
+
+// ErrorKind classifies runtime errors.
+type ErrorKind string
+
+const (
+	RuntimeError      ErrorKind = "RuntimeError"
+	TypeError         ErrorKind = "TypeError"
+	ArgumentError     ErrorKind = "ArgumentError"
+	ValueError        ErrorKind = "ValueError"
+	NameError         ErrorKind = "NameError"
+	ZeroDivisionError ErrorKind = "ZeroDivisionError"
+	RecursionError    ErrorKind = "RecursionError"
+	AttributeError    ErrorKind = "AttributeError"
+	// UserError is the kind of errors raised by throwing a value which is not
+	// an error itself.
+	UserError ErrorKind = "Error"
+)
+
+// Error is a runtime error. It is a Monkey value as well as a Go error, so the
+// VM can return it from Run.
 type Error struct {
+	Kind    ErrorKind
 	Message string
+	// Stack holds the names of the functions that were active when the error
+	// was raised, innermost first.
+	Stack []string
+	// raise marks errors which builtins return because they were misused,
+	// see BuiltinError.
+	raise bool
+}
+
+// NewError creates an error of the given kind with a formatted message.
+func NewError(kind ErrorKind, format string, a ...interface{}) *Error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, a...)}
 }
 
 func (e *Error) Type() ObjectType { return ERROR }
 func (e *Error) Inspect() string  { return "Interpreter Error:" + e.Message }
+func (e *Error) Error() string    { return e.Message }
+
+// Field returns the value of the error field with the given name. Scripts can
+// access message, kind and stack of caught errors by indexing them.
+func (e *Error) Field(name Object) (Object, bool) {
+	key, ok := name.(*String)
+	if !ok {
+		return nil, false
+	}
+
+	switch key.Value {
+	case "message":
+		return &String{Value: e.Message}, true
+	case "kind":
+		return &String{Value: string(e.Kind)}, true
+	case "stack":
+		elements := make([]Object, len(e.Stack))
+		for i, name := range e.Stack {
+			elements[i] = &String{Value: name}
+		}
+		return &Array{Elements: elements}, true
+	default:
+		return nil, false
+	}
+}
+
+// BuiltinError returns the error a builtin raised by returning it as its
+// result, such as a TypeError for an argument of the wrong type. The engines
+// raise it like a thrown value. Errors created by the builtin error and by host
+// functions are values and are not returned.
+func BuiltinError(result Object) (*Error, bool) {
+	err, ok := result.(*Error)
+	if !ok || !err.raise {
+		return nil, false
+	}
+
+	raised := *err
+	raised.raise = false
+	return &raised, true
+}
+
+// WithStack returns a copy of e with the given stack trace. Errors are copied
+// instead of modified, because an error value may be thrown by runs in
+// different goroutines at once.
+func (e *Error) WithStack(stack []string) *Error {
+	traced := *e
+	traced.Stack = stack
+	return &traced
+}
+
+// AsError returns the error raised by throwing value. Errors are raised as they
+// are, any other value becomes the message of a new UserError.
+func AsError(value Object) *Error {
+	if err, ok := value.(*Error); ok {
+		return err
+	}
+
+	return NewError(UserError, "%s", value.Inspect())
+}
+
+// Exception wraps an error while it propagates through the evaluator, so it
+// can be told apart from error values.
+type Exception struct {
+	Error *Error
+}
+
+func (e *Exception) Type() ObjectType { return EXCEPTION }
+func (e *Exception) Inspect() string  { return e.Error.Inspect() }
 
 type Function struct {
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
+	Name       string
+	// Generator is set if calls of the function return a generator
+	// evaluating its body.
+	Generator bool
 }
 
 func (f *Function) Type() ObjectType { return FUNCTION }
@@ -94,8 +243,56 @@ func (f *Function) Inspect() string {
 	return out.String()
 }
 
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	NumLocals     int
+	NumParameters int
+	Name          string
+	// Handlers lists the exception handlers of the function, innermost first.
+	Handlers []ExceptionHandler
+	// Generator is set if calls of the function return a generator running
+	// its instructions.
+	Generator bool
+}
+
+// ExceptionHandler protects the instructions in [Start, End). When one of them
+// raises an error, the stack is reset to StackDepth values above the locals,
+// the error is pushed and execution continues at Target.
+type ExceptionHandler struct {
+	Start      int
+	End        int
+	Target     int
+	StackDepth int
+}
+
+// HandlerFor returns the innermost handler protecting the instruction at ip.
+func (cf *CompiledFunction) HandlerFor(ip int) (ExceptionHandler, bool) {
+	for _, handler := range cf.Handlers {
+		if handler.Start <= ip && ip < handler.End {
+			return handler, true
+		}
+	}
+
+	return ExceptionHandler{}, false
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION }
+func (cf *CompiledFunction) Inspect() string  { return fmt.Sprintf("CompiledFunction[%p]", cf) }
+
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+func (c *Closure) Type() ObjectType { return CLOSURE }
+func (c *Closure) Inspect() string  { return fmt.Sprintf("Closure[%p]", c) }
+
 type Builtin struct {
 	Fn BuiltinFunction
+	// Name, Arity and Doc describe builtins taken from a Registry
+	Name  string
+	Arity int
+	Doc   string
 }
 
 func (b *Builtin) Type() ObjectType { return BUILTIN }
@@ -127,9 +324,26 @@ type HashKey struct {
 }
 
 type Hashable interface {
+	Object
 	HashKey() HashKey
 }
 
+// AsHashable returns obj as Hashable if it can be used as a hash key. Arrays
+// are never mutated in place, which makes them usable as composite keys as
+// long as all of their elements are hashable themselves.
+func AsHashable(obj Object) (Hashable, bool) {
+	if array, ok := obj.(*Array); ok {
+		for _, element := range array.Elements {
+			if _, ok := AsHashable(element); !ok {
+				return nil, false
+			}
+		}
+	}
+
+	hashable, ok := obj.(Hashable)
+	return hashable, ok
+}
+
 func (b *Boolean) HashKey() HashKey {
 	var value uint64
 
@@ -146,6 +360,13 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+func (bi *BigInteger) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write([]byte(bi.Value.String()))
+
+	return HashKey{Type: bi.Type(), Value: h.Sum64()}
+}
+
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
@@ -153,6 +374,23 @@ func (s *String) HashKey() HashKey {
 	return HashKey{Type: s.Type(), Value: h.Sum64()}
 }
 
+// HashKey combines the hash keys of all elements. AsHashable must be used to
+// make sure all elements are hashable before calling it.
+func (a *Array) HashKey() HashKey {
+	h := fnv.New64a()
+	buffer := make([]byte, 8)
+
+	for _, element := range a.Elements {
+		key := element.(Hashable).HashKey()
+
+		h.Write([]byte(key.Type))
+		binary.BigEndian.PutUint64(buffer, key.Value)
+		h.Write(buffer)
+	}
+
+	return HashKey{Type: a.Type(), Value: h.Sum64()}
+}
+
 type HashPair struct {
 	Key   Object
 	Value Object
@@ -162,6 +400,43 @@ type Hash struct {
 	Pairs map[HashKey]HashPair
 }
 
+// Get returns the value stored for key. Pairs are stored by hash key only, keys
+// sharing a hash key replace each other.
+func (h *Hash) Get(key Hashable) (Object, bool) {
+	pair, ok := h.Pairs[key.HashKey()]
+	if !ok {
+		return nil, false
+	}
+
+	return pair.Value, true
+}
+
+// SortedPairs returns the pairs of the hash in a deterministic order: keys are
+// grouped by type, integers and strings are ordered by value and all other keys
+// by their inspected representation.
+func (h *Hash) SortedPairs() []HashPair {
+	pairs := make([]HashPair, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair)
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		left, right := pairs[i].Key, pairs[j].Key
+		if left.Type() != right.Type() {
+			return left.Type() < right.Type()
+		}
+
+		switch left.(type) {
+		case *Integer, *BigInteger, *String:
+			return lessThan(left, right)
+		default:
+			return left.Inspect() < right.Inspect()
+		}
+	})
+
+	return pairs
+}
+
 func (h *Hash) Type() ObjectType { return HASH }
 func (h *Hash) Inspect() string {
 	var out bytes.Buffer
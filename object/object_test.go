@@ -1,6 +1,14 @@
 package object
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -18,3 +26,605 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content should not have the same has key")
 	}
 }
+
+func TestArrayHashKey(t *testing.T) {
+	point1 := &Array{Elements: []Object{&Integer{Value: 1}, &Integer{Value: 2}}}
+	point2 := &Array{Elements: []Object{&Integer{Value: 1}, &Integer{Value: 2}}}
+	swapped := &Array{Elements: []Object{&Integer{Value: 2}, &Integer{Value: 1}}}
+	nested := &Array{Elements: []Object{point1, &String{Value: "x"}}}
+	unhashable := &Array{Elements: []Object{&Integer{Value: 1}, &Hash{}}}
+
+	if point1.HashKey() != point2.HashKey() {
+		t.Errorf("arrays with same content should have the same hash key")
+	}
+	if point1.HashKey() == swapped.HashKey() {
+		t.Errorf("arrays with different content should not have the same hash key")
+	}
+	if _, ok := AsHashable(nested); !ok {
+		t.Errorf("arrays of hashable elements should be hashable")
+	}
+	if _, ok := AsHashable(unhashable); ok {
+		t.Errorf("arrays containing a hash should not be hashable")
+	}
+}
+
+func TestHashGet(t *testing.T) {
+	key := &Array{Elements: []Object{&Integer{Value: 1}, &Integer{Value: 2}}}
+
+	hash := &Hash{Pairs: map[HashKey]HashPair{
+		key.HashKey(): {Key: key, Value: &Integer{Value: 3}},
+	}}
+
+	if _, ok := hash.Get(&Array{Elements: []Object{&Integer{Value: 2}, &Integer{Value: 1}}}); ok {
+		t.Errorf("lookup with a different array should not find the pair")
+	}
+
+	value, ok := hash.Get(&Array{Elements: []Object{&Integer{Value: 1}, &Integer{Value: 2}}})
+	if !ok || value.(*Integer).Value != 3 {
+		t.Errorf("lookup with an equal array should find the pair, got %+v", value)
+	}
+}
+
+func TestHashSortedPairs(t *testing.T) {
+	hash := &Hash{Pairs: map[HashKey]HashPair{}}
+	for _, key := range []Hashable{
+		&String{Value: "b"},
+		&Integer{Value: 10},
+		&String{Value: "a"},
+		&Integer{Value: 2},
+		True,
+	} {
+		hash.Pairs[key.HashKey()] = HashPair{Key: key, Value: key}
+	}
+
+	expected := []string{"true", "2", "10", "a", "b"}
+
+	pairs := hash.SortedPairs()
+	if len(pairs) != len(expected) {
+		t.Fatalf("wrong number of pairs. Want %d, got %d", len(expected), len(pairs))
+	}
+
+	for i, pair := range pairs {
+		if pair.Key.Inspect() != expected[i] {
+			t.Errorf("wrong key at %d. Want %s, got %s", i, expected[i], pair.Key.Inspect())
+		}
+	}
+}
+
+func TestIterate(t *testing.T) {
+	array := &Array{Elements: []Object{&Integer{Value: 1}, &Integer{Value: 2}}}
+	hash := &Hash{Pairs: map[HashKey]HashPair{}}
+	for _, key := range []Hashable{&String{Value: "b"}, &String{Value: "a"}} {
+		hash.Pairs[key.HashKey()] = HashPair{Key: key, Value: key}
+	}
+
+	tests := []struct {
+		iterable Object
+		expected []string
+	}{
+		{array, []string{"1", "2"}},
+		{&String{Value: "abc"}, []string{"a", "b", "c"}},
+		{&String{Value: "hé\xff"}, []string{"h", "é", "\xff"}},
+		{hash, []string{"a", "b"}},
+		{&Array{}, []string{}},
+	}
+
+	for _, test := range tests {
+		iterator, ok := Iterate(test.iterable)
+		if !ok {
+			t.Fatalf("%s is not iterable", test.iterable.Inspect())
+		}
+
+		elements := []string{}
+		for element, ok := iterator.Next(); ok; element, ok = iterator.Next() {
+			elements = append(elements, element.Inspect())
+		}
+
+		if strings.Join(elements, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("wrong elements of %s. Want %v, got %v", test.iterable.Inspect(), test.expected, elements)
+		}
+
+		if _, ok := iterator.Next(); ok {
+			t.Errorf("exhausted iterator returned another element")
+		}
+	}
+
+	iterator, _ := Iterate(array)
+	array.Elements = append(array.Elements, &Integer{Value: 3})
+	for i := 0; i < 2; i++ {
+		iterator.Next()
+	}
+	if _, ok := iterator.Next(); ok {
+		t.Errorf("elements added during the iteration should not be returned")
+	}
+
+	if _, ok := Iterate(&Integer{Value: 1}); ok {
+		t.Errorf("integers should not be iterable")
+	}
+}
+
+func TestModuleLoader(t *testing.T) {
+	loader := NewModuleLoader("../testdata/missing", "../testdata/modules")
+
+	program, err := loader.Load("geometry/circle")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(program.Statements) != 2 {
+		t.Errorf("wrong number of statements, got %d", len(program.Statements))
+	}
+
+	for name, expected := range map[string]string{
+		"missing":    "module not found: missing",
+		"":           `invalid module name ""`,
+		"/math":      `invalid module name "/math"`,
+		"a/../math":  `invalid module name "a/../math"`,
+		"../math":    `invalid module name "../math"`,
+		`geometry\x`: `invalid module name "geometry\\x"`,
+	} {
+		_, err := loader.Load(name)
+		if err == nil || err.Error() != expected {
+			t.Errorf("%s: wrong error. Want %q, got %v", name, expected, err)
+		}
+	}
+
+	if err := ImportCycleError([]string{"main", "a", "b"}, "a"); err == nil || err.Message != "import cycle: a -> b -> a" {
+		t.Errorf("wrong cycle error, got %v", err)
+	}
+	if err := ImportCycleError([]string{"a"}, "b"); err != nil {
+		t.Errorf("unexpected cycle error %v", err)
+	}
+}
+
+func TestIntegerOperation(t *testing.T) {
+	tests := []struct {
+		operator    string
+		left, right int64
+		expected    string
+		big         bool
+	}{
+		{"+", math.MaxInt64, 1, "9223372036854775808", true},
+		{"+", math.MaxInt64, -1, "9223372036854775806", false},
+		{"-", math.MinInt64, 1, "-9223372036854775809", true},
+		{"-", 0, math.MinInt64, "9223372036854775808", true},
+		{"*", math.MaxInt64, 2, "18446744073709551614", true},
+		{"*", -1, math.MinInt64, "9223372036854775808", true},
+		{"*", 0, math.MinInt64, "0", false},
+		{"/", math.MinInt64, -1, "9223372036854775808", true},
+		{"/", -7, 2, "-3", false},
+	}
+
+	for _, test := range tests {
+		result := IntegerOperation(test.operator, &Integer{Value: test.left}, &Integer{Value: test.right})
+
+		if _, isBig := result.(*BigInteger); isBig != test.big {
+			t.Errorf("%d %s %d: wrong type %T", test.left, test.operator, test.right, result)
+		}
+
+		if result.Inspect() != test.expected {
+			t.Errorf("%d %s %d: expected %s, got %s", test.left, test.operator, test.right, test.expected, result.Inspect())
+		}
+	}
+
+	demoted := IntegerOperation("-", &BigInteger{Value: new(big.Int).Lsh(big.NewInt(1), 64)}, &BigInteger{Value: new(big.Int).Lsh(big.NewInt(1), 64)})
+	if _, ok := demoted.(*Integer); !ok {
+		t.Errorf("result fitting into int64 should be demoted, got %T", demoted)
+	}
+
+	for _, operator := range []string{"/", "%"} {
+		result := IntegerOperation(operator, &Integer{Value: 1}, &Integer{Value: 0})
+		if _, ok := result.(*Error); !ok {
+			t.Errorf("1 %s 0 should result in an error, got %T", operator, result)
+		}
+	}
+}
+
+func TestBuiltinError(t *testing.T) {
+	failing, _ := NewGoFunction("failing", func() error { return errors.New("failed") })
+
+	tests := []struct {
+		builtin *Builtin
+		args    []Object
+		raised  bool
+	}{
+		{GetBuiltinByName("len"), []Object{&Integer{Value: 1}}, true},
+		{GetBuiltinByName("len"), nil, true},
+		{GetBuiltinByName("error"), []Object{&String{Value: "bad"}}, false},
+		{GetBuiltinByName("error"), nil, true},
+		{failing, nil, false},
+		{failing, []Object{NullValue}, true},
+	}
+
+	for i, test := range tests {
+		result := test.builtin.Fn(nil, test.args...)
+		if _, ok := result.(*Error); !ok {
+			t.Fatalf("test %d: expected an error, got %T", i, result)
+		}
+
+		err, raised := BuiltinError(result)
+		if raised != test.raised {
+			t.Errorf("test %d: want raised %t, got %t", i, test.raised, raised)
+		}
+
+		if raised {
+			if _, again := BuiltinError(err); again {
+				t.Errorf("test %d: a raised error should be a value once it is caught", i)
+			}
+		}
+	}
+}
+
+func TestSlice(t *testing.T) {
+	array := &Array{Elements: []Object{&Integer{Value: 1}, &Integer{Value: 2}, &Integer{Value: 3}}}
+	huge := &BigInteger{Value: new(big.Int).Lsh(big.NewInt(1), 64)}
+
+	tests := []struct {
+		left, start, end Object
+		expected         string
+	}{
+		{array, &Integer{Value: 1}, NullValue, "[2, 3]"},
+		{array, NullValue, &Integer{Value: -1}, "[1, 2]"},
+		{array, &Integer{Value: 2}, &Integer{Value: 1}, "[]"},
+		{array, &Integer{Value: -10}, huge, "[1, 2, 3]"},
+		{&String{Value: "monkey"}, &Integer{Value: 3}, NullValue, "key"},
+		{&String{Value: "héllo"}, &Integer{Value: 1}, &Integer{Value: -1}, "éll"},
+		{&String{Value: "héllo"}, &Integer{Value: -2}, huge, "lo"},
+		{&String{Value: "monkey"}, &BigInteger{Value: new(big.Int).Neg(huge.Value)}, &Integer{Value: 2}, "mo"},
+	}
+
+	for _, test := range tests {
+		result, err := Slice(test.left, test.start, test.end)
+		if err != nil {
+			t.Errorf("%s[%s:%s]: unexpected error %s", test.left.Inspect(), test.start.Inspect(), test.end.Inspect(), err)
+			continue
+		}
+		if result.Inspect() != test.expected {
+			t.Errorf("%s[%s:%s]: expected %s, got %s", test.left.Inspect(), test.start.Inspect(), test.end.Inspect(), test.expected, result.Inspect())
+		}
+	}
+
+	if _, err := Slice(&Integer{Value: 1}, NullValue, NullValue); err == nil || err.Kind != TypeError {
+		t.Errorf("slicing an integer should be a TypeError, got %v", err)
+	}
+	if _, err := Slice(array, &String{Value: "a"}, NullValue); err == nil || err.Message != "slice bound must be INTEGER, got STRING" {
+		t.Errorf("wrong error, got %v", err)
+	}
+}
+
+func TestBudget(t *testing.T) {
+	budget := NewBudget(context.Background(), 2, 0)
+	for i := 0; i < 2; i++ {
+		if err := budget.Step(); err != nil {
+			t.Fatalf("step %d: unexpected error %v", i, err)
+		}
+	}
+	if err := budget.Step(); err != ErrBudgetExceeded {
+		t.Errorf("expected ErrBudgetExceeded, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	budget = NewBudget(ctx, 0, 0)
+	if err := budget.Step(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	cancel()
+	if err := budget.Step(); err != ErrTimeout {
+		t.Errorf("expected ErrTimeout, got %v", err)
+	}
+
+	budget = NewBudget(context.Background(), 0, 100)
+	if err := budget.Allocate(100); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := budget.Allocate(1); err != ErrMemoryLimitExceeded {
+		t.Errorf("expected ErrMemoryLimitExceeded, got %v", err)
+	}
+	if budget.Allocated() != 101 {
+		t.Errorf("wrong number of allocated bytes, got %d", budget.Allocated())
+	}
+
+	forkCtx, cancelFork := context.WithCancel(context.Background())
+	fork := budget.Fork(forkCtx)
+	if err := fork.Allocate(1); err != ErrMemoryLimitExceeded || budget.Allocated() != 102 {
+		t.Errorf("forks must share the usage of their budget, got %v", err)
+	}
+	cancelFork()
+	if err := fork.Step(); err != ErrTimeout {
+		t.Errorf("expected ErrTimeout, got %v", err)
+	}
+
+	var unlimited *Budget
+	if err := unlimited.Step(); err != nil {
+		t.Errorf("nil budget returned %v", err)
+	}
+	if err := unlimited.Allocate(1 << 30); err != nil {
+		t.Errorf("nil budget returned %v", err)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	for _, mode := range []SchedulerMode{Parallel, Deterministic} {
+		scheduler := NewScheduler(mode)
+		channel := NewChannel(0)
+
+		task := scheduler.Spawn(nil, func(budget *Budget) (Object, error) {
+			for i := int64(1); i <= 3; i++ {
+				if err := scheduler.Send(channel, &Integer{Value: i}); err != nil {
+					return nil, err
+				}
+			}
+			return nil, scheduler.Close(channel)
+		})
+
+		sum := int64(0)
+		for {
+			value, ok, err := scheduler.Receive(channel)
+			if err != nil {
+				t.Fatalf("mode %d: unexpected error %v", mode, err)
+			}
+			if !ok {
+				break
+			}
+			sum += value.(*Integer).Value
+		}
+		if sum != 6 {
+			t.Errorf("mode %d: wrong sum %d", mode, sum)
+		}
+
+		if result, err := scheduler.Await(task); err != nil || result != NullValue {
+			t.Errorf("mode %d: wrong result %v, %v", mode, result, err)
+		}
+
+		if err := scheduler.Send(channel, True); err == nil {
+			t.Errorf("mode %d: expected an error sending on a closed channel", mode)
+		}
+
+		index, _, _, err := scheduler.Select([]SelectCase{{Channel: NewChannel(0)}}, false)
+		if index != -1 || err != nil {
+			t.Errorf("mode %d: expected no case to be selected, got %d, %v", mode, index, err)
+		}
+
+		blocked := scheduler.Spawn(nil, func(budget *Budget) (Object, error) {
+			_, _, err := scheduler.Receive(NewChannel(0))
+			return nil, err
+		})
+		_, err = scheduler.Await(blocked)
+		if err, ok := err.(*Error); !ok || err.Kind != DeadlockError {
+			t.Errorf("mode %d: expected DeadlockError, got %v", mode, err)
+		}
+
+		stopped := []string{}
+		scheduler.OnStop(func() { stopped = append(stopped, "a") })
+		remove := scheduler.OnStop(func() { stopped = append(stopped, "b") })
+		remove()
+
+		scheduler.Stop()
+		scheduler.OnStop(func() { stopped = append(stopped, "c") })
+
+		if strings.Join(stopped, ",") != "a,c" {
+			t.Errorf("mode %d: wrong functions called on stop, got %v", mode, stopped)
+		}
+	}
+}
+
+func TestSizeOf(t *testing.T) {
+	tests := []struct {
+		obj      Object
+		expected int
+	}{
+		{&Integer{Value: 1}, 0},
+		{&String{Value: "monkey"}, objectSize + 6},
+		{&Array{Elements: []Object{True, False}}, objectSize + 2*referenceSize},
+		{&Hash{Pairs: map[HashKey]HashPair{}}, objectSize},
+	}
+
+	for _, tt := range tests {
+		if size := SizeOf(tt.obj); size != tt.expected {
+			t.Errorf("wrong size for %s, want %d, got %d", tt.obj.Inspect(), tt.expected, size)
+		}
+	}
+
+	small := SizeOf(NewInteger(new(big.Int).Lsh(big.NewInt(1), 64)))
+	large := SizeOf(NewInteger(new(big.Int).Lsh(big.NewInt(1), 640)))
+	if large <= small {
+		t.Errorf("big integers should grow with their value, got %d and %d", small, large)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+
+	for i, definition := range Builtins {
+		builtin := registry.Get(i)
+		if builtin == nil || builtin.Name != definition.Name {
+			t.Fatalf("builtin %d should be %s, got %+v", i, definition.Name, builtin)
+		}
+	}
+
+	double := func(runtime Runtime, args ...Object) Object {
+		return &Integer{Value: args[0].(*Integer).Value * 2}
+	}
+	if err := registry.Register("double", 1, "Doubles an integer.", double); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	index, ok := registry.Index("double")
+	if !ok || index != len(Builtins) {
+		t.Fatalf("double should be appended, got index %d", index)
+	}
+
+	builtin, ok := registry.Lookup("double")
+	if !ok || builtin.Arity != 1 || builtin.Doc != "Doubles an integer." {
+		t.Fatalf("wrong builtin %+v", builtin)
+	}
+
+	result := builtin.Fn(nil, &Integer{Value: 21})
+	if integer, ok := result.(*Integer); !ok || integer.Value != 42 {
+		t.Errorf("wrong result %+v", result)
+	}
+
+	result = builtin.Fn(nil)
+	if err, ok := result.(*Error); !ok || err.Kind != ArgumentError || err.Message != "wrong number of arguments. Got 0, want 1." {
+		t.Errorf("expected ArgumentError, got %+v", result)
+	}
+
+	if err := registry.Register("len", Variadic, "", double); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if index, _ := registry.Index("len"); index != 0 {
+		t.Errorf("len should be replaced in place, got index %d", index)
+	}
+	if standard := GetBuiltinByName("len"); standard != Builtins[0].Builtin {
+		t.Errorf("registering must not change the standard builtins")
+	}
+	if _, ok := NewRegistry().Lookup("double"); ok {
+		t.Errorf("registries must not share builtins")
+	}
+
+	if err := registry.Register("broken", -2, "", double); err == nil {
+		t.Errorf("expected an error for an invalid arity")
+	}
+
+	for i := len(registry.Builtins()); i < MaxBuiltins; i++ {
+		if err := registry.Register(fmt.Sprintf("f%d", i), Variadic, "", double); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	if err := registry.Register("overflow", Variadic, "", double); err == nil {
+		t.Errorf("expected an error for a full registry")
+	}
+}
+
+func TestMethods(t *testing.T) {
+	for objectType, names := range Methods {
+		for _, name := range names {
+			if _, ok := GetMethod(objectType, name); !ok {
+				t.Errorf("%s should have the method %s", objectType, name)
+			}
+		}
+	}
+
+	registry := NewRegistry()
+	err := registry.RegisterMethod(INTEGER, "double", 0, "Doubles an integer.", func(runtime Runtime, args ...Object) Object {
+		return &Integer{Value: args[0].(*Integer).Value * 2}
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, ok := GetMethod(INTEGER, "double"); ok {
+		t.Errorf("registering must not change the standard methods")
+	}
+	if _, ok := registry.Method(STRING, "upper"); !ok {
+		t.Errorf("registries should hold the standard methods")
+	}
+
+	key := &String{Value: "len"}
+	hash := &Hash{Pairs: map[HashKey]HashPair{key.HashKey(): {Key: key, Value: True}}}
+
+	tests := []struct {
+		receiver Object
+		name     string
+		expected string
+		method   bool
+	}{
+		{hash, "len", "true", false},
+		{hash, "keys", "keys", true},
+		{hash, "missing", "null", false},
+		{&Integer{Value: 21}, "double", "double", true},
+		{&String{Value: "a"}, "upper", "upper", true},
+		{NewError(UserError, "failed"), "message", "failed", false},
+		{&String{Value: "a"}, "missing", "AttributeError: STRING has no property or method missing", false},
+		{NullValue, "upper", "AttributeError: NULL has no property or method upper", false},
+	}
+
+	for _, test := range tests {
+		value, method, err := ResolveField(test.receiver, test.name, registry.Method)
+
+		var inspect string
+		switch {
+		case err != nil:
+			inspect = string(err.Kind) + ": " + err.Message
+		case method:
+			inspect = value.(*Builtin).Name
+		default:
+			inspect = value.Inspect()
+		}
+
+		if inspect != test.expected || method != test.method {
+			t.Errorf("%s.%s: want %s (method %t), got %s (method %t)", test.receiver.Inspect(), test.name, test.expected, test.method, inspect, method)
+		}
+	}
+
+	double, _ := registry.Method(INTEGER, "double")
+	result := BindMethod(double, &Integer{Value: 21}).Fn(nil)
+	if integer, ok := result.(*Integer); !ok || integer.Value != 42 {
+		t.Errorf("wrong result %+v", result)
+	}
+
+	result = double.Fn(nil, &Integer{Value: 1}, &Integer{Value: 2})
+	if err, ok := result.(*Error); !ok || err.Message != "wrong number of arguments. Got 1, want 0." {
+		t.Errorf("expected ArgumentError, got %+v", result)
+	}
+}
+
+func TestHostObject(t *testing.T) {
+	type counter struct{ count int64 }
+
+	counterType := &HostType{
+		Name: "COUNTER",
+		Properties: map[string]HostProperty{
+			"count": func(value interface{}) Object {
+				return &Integer{Value: value.(*counter).count}
+			},
+			"missing": func(value interface{}) Object { return nil },
+		},
+		Methods: map[string]HostMethod{
+			"add": func(runtime Runtime, value interface{}, args ...Object) Object {
+				value.(*counter).count += args[0].(*Integer).Value
+				return nil
+			},
+		},
+	}
+
+	c := &counter{}
+	host := NewHostObject(counterType, c)
+
+	if host.Type() != "COUNTER" {
+		t.Errorf("wrong type %s", host.Type())
+	}
+	if !strings.HasPrefix(host.Inspect(), "COUNTER[0x") {
+		t.Errorf("wrong inspect %s", host.Inspect())
+	}
+
+	add, ok := host.Field(&String{Value: "add"})
+	if !ok {
+		t.Fatalf("method add not found")
+	}
+	add.(*Builtin).Fn(nil, &Integer{Value: 5})
+
+	count, ok := host.Field(&String{Value: "count"})
+	if !ok || count.(*Integer).Value != 5 || c.count != 5 {
+		t.Errorf("wrong count %+v", count)
+	}
+
+	if value, ok := host.Field(&String{Value: "missing"}); !ok || value != NullValue {
+		t.Errorf("nil properties should be null, got %+v", value)
+	}
+
+	for _, name := range []Object{&String{Value: "unknown"}, &Integer{Value: 1}} {
+		if _, ok := host.Field(name); ok {
+			t.Errorf("expected no field for %s", name.Inspect())
+		}
+	}
+
+	counterType.Inspect = func(value interface{}) string {
+		return fmt.Sprintf("counter(%d)", value.(*counter).count)
+	}
+	if host.Inspect() != "counter(5)" {
+		t.Errorf("wrong inspect %s", host.Inspect())
+	}
+
+	if value, _ := ToGo(host); value != c {
+		t.Errorf("ToGo should return the wrapped value, got %#v", value)
+	}
+}
@@ -0,0 +1,162 @@
+package object
+
+import "fmt"
+
+const (
+	// Variadic is the arity of builtins that check their arguments themselves.
+	Variadic = -1
+	// MaxBuiltins is the number of builtins a registry can hold, compiled
+	// code refers to builtins by a single byte.
+	MaxBuiltins = 256
+)
+
+// Registry holds the builtin functions and methods available to a runtime, so
+// hosts can add their own functions without changing the standard builtins.
+// Builtins are referred to by their index: registering a name again replaces
+// the builtin in place, new names are appended. A registry can be used by runs
+// in different goroutines at once, but must not be changed while they run.
+type Registry struct {
+	builtins []*Builtin
+	indices  map[string]int
+	methods  map[ObjectType]map[string]*Builtin
+}
+
+// NewRegistry creates a registry holding the standard builtins and methods.
+func NewRegistry() *Registry {
+	registry := &Registry{
+		indices: make(map[string]int, len(Builtins)),
+		methods: make(map[ObjectType]map[string]*Builtin, len(standardMethods)),
+	}
+
+	for _, definition := range Builtins {
+		registry.add(&Builtin{Name: definition.Name, Arity: Variadic, Fn: definition.Builtin.Fn})
+	}
+
+	for objectType, methods := range standardMethods {
+		registry.methods[objectType] = make(map[string]*Builtin, len(methods))
+		for name, method := range methods {
+			registry.methods[objectType][name] = method
+		}
+	}
+
+	return registry
+}
+
+// Register makes fn available as the builtin name. Unless arity is Variadic,
+// calls with a different number of arguments fail with an ArgumentError before
+// fn is called. doc describes the builtin to readers of the registry.
+func (r *Registry) Register(name string, arity int, doc string, fn BuiltinFunction) error {
+	if arity < Variadic {
+		return fmt.Errorf("invalid arity %d for builtin %s", arity, name)
+	}
+
+	builtin := &Builtin{Name: name, Arity: arity, Doc: doc, Fn: fn}
+	if arity != Variadic {
+		builtin.Fn = func(runtime Runtime, args ...Object) Object {
+			if len(args) != arity {
+				return newError(ArgumentError, "wrong number of arguments. Got %d, want %d.", len(args), arity)
+			}
+
+			return fn(runtime, args...)
+		}
+	}
+
+	return r.set(builtin)
+}
+
+// RegisterFunc makes the Go function fn available as the builtin name, see
+// NewGoFunction for how arguments and results are converted.
+func (r *Registry) RegisterFunc(name string, doc string, fn interface{}) error {
+	builtin, err := NewGoFunction(name, fn)
+	if err != nil {
+		return err
+	}
+
+	builtin.Doc = doc
+	return r.set(builtin)
+}
+
+// RegisterMethod makes fn available as the method name of values of type
+// objectType, which may be the name of a host type. fn is called with the
+// value followed by the arguments, arity does not count the value.
+func (r *Registry) RegisterMethod(objectType ObjectType, name string, arity int, doc string, fn BuiltinFunction) error {
+	if arity < Variadic {
+		return fmt.Errorf("invalid arity %d for method %s", arity, name)
+	}
+
+	method := &Builtin{Name: name, Arity: arity, Doc: doc, Fn: fn}
+	if arity != Variadic {
+		method.Fn = func(runtime Runtime, args ...Object) Object {
+			if len(args)-1 != arity {
+				return newError(ArgumentError, "wrong number of arguments. Got %d, want %d.", len(args)-1, arity)
+			}
+
+			return fn(runtime, args...)
+		}
+	}
+
+	if r.methods[objectType] == nil {
+		r.methods[objectType] = make(map[string]*Builtin)
+	}
+	r.methods[objectType][name] = method
+
+	return nil
+}
+
+func (r *Registry) set(builtin *Builtin) error {
+	if index, ok := r.indices[builtin.Name]; ok {
+		r.builtins[index] = builtin
+		return nil
+	}
+
+	if len(r.builtins) == MaxBuiltins {
+		return fmt.Errorf("cannot register builtin %s, the registry is full", builtin.Name)
+	}
+
+	r.add(builtin)
+	return nil
+}
+
+func (r *Registry) add(builtin *Builtin) {
+	r.indices[builtin.Name] = len(r.builtins)
+	r.builtins = append(r.builtins, builtin)
+}
+
+// Lookup returns the builtin with the given name.
+func (r *Registry) Lookup(name string) (*Builtin, bool) {
+	index, ok := r.indices[name]
+	if !ok {
+		return nil, false
+	}
+
+	return r.builtins[index], true
+}
+
+// Index returns the index of the builtin with the given name.
+func (r *Registry) Index(name string) (int, bool) {
+	index, ok := r.indices[name]
+	return index, ok
+}
+
+// Get returns the builtin at index or nil if there is no such builtin.
+func (r *Registry) Get(index int) *Builtin {
+	if index < 0 || index >= len(r.builtins) {
+		return nil
+	}
+
+	return r.builtins[index]
+}
+
+// Method returns the method name of values of type objectType.
+func (r *Registry) Method(objectType ObjectType, name string) (*Builtin, bool) {
+	method, ok := r.methods[objectType][name]
+	return method, ok
+}
+
+// Builtins returns all builtins ordered by their index.
+func (r *Registry) Builtins() []*Builtin {
+	builtins := make([]*Builtin, len(r.builtins))
+	copy(builtins, r.builtins)
+
+	return builtins
+}
@@ -0,0 +1,107 @@
+package object
+
+import "unicode/utf8"
+
+// Slice returns the elements of an array or the characters of a string from
+// start up to end, as written left[start:end]. Negative bounds count from the
+// end, bounds which are null are omitted and default to the start and the end.
+// Bounds out of range are clamped, so slicing never fails for integers.
+func Slice(left, start, end Object) (Object, *Error) {
+	var length int64
+	switch left := left.(type) {
+	case *Array:
+		length = int64(len(left.Elements))
+	case *String:
+		length = int64(utf8.RuneCountInString(left.Value))
+	default:
+		return nil, NewError(TypeError, "slice operator not supported: %s", left.Type())
+	}
+
+	from, err := sliceBound(start, 0, length)
+	if err != nil {
+		return nil, err
+	}
+	to, err := sliceBound(end, length, length)
+	if err != nil {
+		return nil, err
+	}
+	if from > to {
+		from = to
+	}
+
+	switch left := left.(type) {
+	case *Array:
+		elements := make([]Object, to-from)
+		copy(elements, left.Elements[from:to])
+
+		return &Array{Elements: elements}, nil
+	default:
+		value := left.(*String).Value
+		start := characterOffset(value, from)
+		return &String{Value: value[start : start+characterOffset(value[start:], to-from)]}, nil
+	}
+}
+
+// StringIndex returns the character at position index of str, characters are
+// counted like Iterate steps through them. ok is false if index is out of
+// range.
+func StringIndex(str *String, index int64) (character *String, ok bool) {
+	if index < 0 {
+		return nil, false
+	}
+
+	value := str.Value[characterOffset(str.Value, index):]
+	if value == "" {
+		return nil, false
+	}
+
+	_, size := utf8.DecodeRuneInString(value)
+	return &String{Value: value[:size]}, true
+}
+
+// characterOffset returns the byte offset of the character at position i of
+// value, or len(value) if value has no more than i characters.
+func characterOffset(value string, i int64) int {
+	offset := 0
+	for ; i > 0 && offset < len(value); i-- {
+		_, size := utf8.DecodeRuneInString(value[offset:])
+		offset += size
+	}
+
+	return offset
+}
+
+// sliceBound resolves a slice bound to a position within [0, length]. Negative
+// bounds count from the end, omitted bounds fall back to the given default.
+func sliceBound(bound Object, fallback, length int64) (int64, *Error) {
+	if bound == NullValue {
+		return fallback, nil
+	}
+
+	if bound.Type() != INTEGER {
+		return 0, NewError(TypeError, "slice bound must be INTEGER, got %s", bound.Type())
+	}
+
+	integer, ok := bound.(*Integer)
+	if !ok {
+		// Big integers are always out of range.
+		if CompareIntegers(bound, &Integer{Value: 0}) < 0 {
+			return 0, nil
+		}
+		return length, nil
+	}
+
+	position := integer.Value
+	if position < 0 {
+		position += length
+	}
+
+	if position < 0 {
+		return 0, nil
+	}
+	if position > length {
+		return length, nil
+	}
+
+	return position, nil
+}
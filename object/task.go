@@ -0,0 +1,519 @@
+package object
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+)
+
+const (
+	TASK    = "TASK"
+	CHANNEL = "CHANNEL"
+)
+
+// DeadlockError is the kind of errors raised in a task waiting for a channel
+// or another task once every task of the run is waiting.
+const DeadlockError ErrorKind = "DeadlockError"
+
+// SchedulerMode selects how the tasks of a run are executed.
+type SchedulerMode int
+
+const (
+	// Parallel runs every task in its own goroutine as soon as it is spawned.
+	Parallel SchedulerMode = iota
+	// Deterministic runs one task at a time. The running task keeps running
+	// until it waits or finishes, then the task which became ready first
+	// continues. Spawned tasks only start once the spawning task waits, so
+	// runs are reproducible, which is meant for tests.
+	Deterministic
+)
+
+// Scheduler runs the tasks spawned during a single run and synchronizes the
+// channels they communicate through. The state of all tasks and channels is
+// guarded by the lock of the scheduler using them, so a channel must not be
+// used by several runs at once.
+//
+// The engines create a scheduler for every run and stop it once the run ends,
+// which stops the tasks still running and waits for them.
+type Scheduler struct {
+	mode SchedulerMode
+
+	mu     sync.Mutex
+	ctx    context.Context
+	cancel context.CancelFunc
+	tasks  sync.WaitGroup
+	// spawned is set by the first spawn, from then on tasks may share the
+	// state of the run
+	spawned bool
+	// running counts the tasks which are not waiting, including the main
+	// program
+	running int
+	// parked holds the waiting tasks in the order they started waiting
+	parked []*waiter
+	// ready holds the tasks which may continue in deterministic mode, the
+	// first one continues once the running task waits or finishes
+	ready []*waiter
+	// output serializes the writes of tasks printing at the same time
+	output sync.Mutex
+	// onStop holds the functions registered with OnStop, stopped is set once
+	// they have been called
+	onStop   map[int]func()
+	nextHook int
+	stopped  bool
+}
+
+// NewScheduler creates a scheduler running tasks in the given mode.
+func NewScheduler(mode SchedulerMode) *Scheduler {
+	return &Scheduler{mode: mode, running: 1}
+}
+
+// Concurrent reports whether tasks have been spawned, from then on state the
+// tasks share has to be locked. A nil scheduler is never concurrent.
+func (s *Scheduler) Concurrent() bool {
+	return s != nil && s.spawned
+}
+
+// Writer returns w guarded by the scheduler once tasks have been spawned, so
+// tasks printing at the same time do not write to w at once.
+func (s *Scheduler) Writer(w io.Writer) io.Writer {
+	if !s.Concurrent() {
+		return w
+	}
+
+	return &lockedWriter{mu: &s.output, w: w}
+}
+
+// Spawn starts a task calling run and returns it right away. budget is the
+// budget of the spawning task, run is passed a fork of it which stops once
+// the run ends.
+func (s *Scheduler) Spawn(budget *Budget, run func(budget *Budget) (Object, error)) *Task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ctx == nil {
+		s.ctx, s.cancel = context.WithCancel(budget.Context())
+		s.spawned = true
+	}
+
+	task := &Task{}
+	start := newWaiter()
+	taskBudget := budget.Fork(s.ctx)
+
+	s.running++
+	if s.mode == Deterministic {
+		s.ready = append(s.ready, start)
+	}
+
+	s.tasks.Add(1)
+	go func() {
+		defer s.tasks.Done()
+
+		if s.mode == Deterministic {
+			select {
+			case <-start.wake:
+			case <-s.ctx.Done():
+				s.finish(task, nil, ErrTimeout)
+				return
+			}
+		}
+
+		result, err := run(taskBudget)
+		s.finish(task, result, err)
+	}()
+
+	return task
+}
+
+// Stop stops the tasks still running once the run ends and waits for them. A
+// nil scheduler has nothing to stop.
+func (s *Scheduler) Stop() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	cancel := s.cancel
+	s.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		s.tasks.Wait()
+	}
+
+	s.mu.Lock()
+	hooks := s.onStop
+	s.onStop, s.stopped = nil, true
+	s.mu.Unlock()
+
+	for _, fn := range hooks {
+		fn()
+	}
+}
+
+// OnStop registers fn to be called by Stop once the tasks of the run have
+// stopped, to release what is bound to the run, like the goroutines of
+// generators. fn is called right away if the scheduler already stopped. The
+// returned function unregisters fn. A nil scheduler never stops.
+func (s *Scheduler) OnStop(fn func()) (remove func()) {
+	if s == nil {
+		return func() {}
+	}
+
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		fn()
+		return func() {}
+	}
+
+	if s.onStop == nil {
+		s.onStop = map[int]func(){}
+	}
+	id := s.nextHook
+	s.nextHook++
+	s.onStop[id] = fn
+	s.mu.Unlock()
+
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		delete(s.onStop, id)
+	}
+}
+
+// Await waits for task to finish and returns its result or the error it
+// failed with.
+func (s *Scheduler) Await(task *Task) (Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !task.done {
+		w := newWaiter()
+		task.waiters = append(task.waiters, waitEntry{waiter: w})
+		if err := s.wait(w); err != nil {
+			return nil, err
+		}
+	}
+
+	if task.err != nil {
+		return nil, task.err
+	}
+	return task.result, nil
+}
+
+// Send sends value on c. It waits until a receiver takes the value or there
+// is room in the buffer of c.
+func (s *Scheduler) Send(c *Channel, value Object) error {
+	_, _, _, err := s.Select([]SelectCase{{Channel: c, Send: true, Value: value}}, true)
+	return err
+}
+
+// Receive receives a value from c, waiting until one is sent. ok is false
+// once c is closed and all values sent before have been received.
+func (s *Scheduler) Receive(c *Channel) (value Object, ok bool, err error) {
+	_, value, ok, err = s.Select([]SelectCase{{Channel: c}}, true)
+	return value, ok, err
+}
+
+// Close closes c. Waiting receivers receive null, waiting senders fail.
+func (s *Scheduler) Close(c *Channel) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c.closed {
+		return NewError(ValueError, "close of closed channel")
+	}
+	c.closed = true
+
+	for _, entry := range c.receivers {
+		if !entry.waiter.done {
+			s.complete(entry, NullValue, false)
+		}
+	}
+	for _, entry := range c.senders {
+		if !entry.waiter.done {
+			entry.waiter.err = NewError(ValueError, "send on closed channel")
+			s.complete(entry, nil, false)
+		}
+	}
+	c.receivers, c.senders = nil, nil
+
+	return nil
+}
+
+// SelectCase is a channel operation of Select, which receives from Channel
+// unless Send is set.
+type SelectCase struct {
+	Channel *Channel
+	Send    bool
+	Value   Object
+}
+
+// Select performs one of the operations of cases and returns its index. For
+// receiving cases value and ok are the results of Receive. If no operation
+// can be performed right away, Select waits for one unless block is false,
+// then it returns -1. In parallel mode the case to check first is chosen at
+// random, so no case is starved, in deterministic mode the cases are checked
+// in order.
+func (s *Scheduler) Select(cases []SelectCase, block bool) (index int, value Object, ok bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	offset := 0
+	if s.mode == Parallel && len(cases) > 1 {
+		offset = rand.Intn(len(cases))
+	}
+
+	for i := range cases {
+		index := (offset + i) % len(cases)
+		c := cases[index]
+
+		if c.Send {
+			if c.Channel.closed {
+				return -1, nil, false, NewError(ValueError, "send on closed channel")
+			}
+			if s.trySend(c.Channel, c.Value) {
+				return index, nil, false, nil
+			}
+			continue
+		}
+
+		if value, ok, ready := s.tryReceive(c.Channel); ready {
+			return index, value, ok, nil
+		}
+	}
+
+	if !block {
+		return -1, nil, false, nil
+	}
+
+	w := newWaiter()
+	for i, c := range cases {
+		entry := waitEntry{waiter: w, index: i, value: c.Value}
+		if c.Send {
+			c.Channel.senders = append(c.Channel.senders, entry)
+		} else {
+			c.Channel.receivers = append(c.Channel.receivers, entry)
+		}
+	}
+
+	if err := s.wait(w); err != nil {
+		return -1, nil, false, err
+	}
+	return w.index, w.value, w.ok, nil
+}
+
+func (s *Scheduler) trySend(c *Channel, value Object) bool {
+	if receiver, ok := pop(&c.receivers); ok {
+		s.complete(receiver, value, true)
+		return true
+	}
+
+	if len(c.buffer) < c.capacity {
+		c.buffer = append(c.buffer, value)
+		return true
+	}
+
+	return false
+}
+
+func (s *Scheduler) tryReceive(c *Channel) (value Object, ok bool, ready bool) {
+	if len(c.buffer) > 0 {
+		value = c.buffer[0]
+		c.buffer = c.buffer[1:]
+
+		if sender, ok := pop(&c.senders); ok {
+			c.buffer = append(c.buffer, sender.value)
+			s.complete(sender, nil, true)
+		}
+
+		return value, true, true
+	}
+
+	if sender, ok := pop(&c.senders); ok {
+		s.complete(sender, nil, true)
+		return sender.value, true, true
+	}
+
+	if c.closed {
+		return NullValue, false, true
+	}
+
+	return nil, false, false
+}
+
+// finish records the result of task and lets the tasks awaiting it continue.
+func (s *Scheduler) finish(task *Task, result Object, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if result == nil {
+		result = NullValue
+	}
+	task.done, task.result, task.err = true, result, err
+
+	for _, entry := range task.waiters {
+		if !entry.waiter.done {
+			s.complete(entry, nil, true)
+		}
+	}
+	task.waiters = nil
+
+	s.running--
+	s.schedule()
+}
+
+// wait blocks the calling task until w is completed. It is called with the
+// lock held and returns with it held. ErrTimeout is returned once the run
+// ends or its context is done.
+func (s *Scheduler) wait(w *waiter) error {
+	s.parked = append(s.parked, w)
+	s.running--
+	s.schedule()
+
+	var done <-chan struct{}
+	if s.ctx != nil {
+		done = s.ctx.Done()
+	}
+
+	s.mu.Unlock()
+	select {
+	case <-w.wake:
+	case <-done:
+	}
+	s.mu.Lock()
+
+	if !w.done {
+		w.done = true
+		s.unpark(w)
+		s.running++
+		return ErrTimeout
+	}
+
+	if w.err != nil {
+		return w.err
+	}
+	return nil
+}
+
+// schedule is called whenever a task stops running because it waits or
+// finished. If every task is waiting, the task which waited first fails with
+// a DeadlockError. In deterministic mode the next ready task continues.
+func (s *Scheduler) schedule() {
+	if s.running == 0 && len(s.parked) > 0 {
+		w := s.parked[0]
+		w.err = NewError(DeadlockError, "all tasks are waiting")
+		s.complete(waitEntry{waiter: w, index: -1}, nil, false)
+	}
+
+	if s.mode == Deterministic && len(s.ready) > 0 {
+		next := s.ready[0]
+		s.ready = s.ready[1:]
+		next.wake <- struct{}{}
+	}
+}
+
+// complete completes the operation entry is waiting for, the waiting task
+// continues with value.
+func (s *Scheduler) complete(entry waitEntry, value Object, ok bool) {
+	w := entry.waiter
+	w.done, w.index, w.value, w.ok = true, entry.index, value, ok
+
+	s.unpark(w)
+	s.running++
+
+	if s.mode == Deterministic {
+		s.ready = append(s.ready, w)
+	} else {
+		w.wake <- struct{}{}
+	}
+}
+
+func (s *Scheduler) unpark(w *waiter) {
+	for i, parked := range s.parked {
+		if parked == w {
+			s.parked = append(s.parked[:i], s.parked[i+1:]...)
+			return
+		}
+	}
+}
+
+// waiter is a task waiting for a channel operation or another task. A task
+// selecting several channels waits in the queues of all of them, entries of
+// completed waiters are skipped.
+type waiter struct {
+	wake  chan struct{}
+	done  bool
+	index int
+	value Object
+	ok    bool
+	err   *Error
+}
+
+func newWaiter() *waiter {
+	return &waiter{wake: make(chan struct{}, 1)}
+}
+
+type waitEntry struct {
+	waiter *waiter
+	// index is the select case the entry was queued for
+	index int
+	// value is the value to send
+	value Object
+}
+
+func pop(queue *[]waitEntry) (waitEntry, bool) {
+	for len(*queue) > 0 {
+		entry := (*queue)[0]
+		*queue = (*queue)[1:]
+		if !entry.waiter.done {
+			return entry, true
+		}
+	}
+
+	return waitEntry{}, false
+}
+
+// Task is a function call running concurrently to the task which spawned it.
+type Task struct {
+	done    bool
+	result  Object
+	err     error
+	waiters []waitEntry
+}
+
+func (t *Task) Type() ObjectType { return TASK }
+func (t *Task) Inspect() string  { return fmt.Sprintf("Task[%p]", t) }
+
+// Channel passes values between tasks. Sends wait for a receiver unless the
+// channel has room in its buffer.
+type Channel struct {
+	capacity  int
+	buffer    []Object
+	closed    bool
+	receivers []waitEntry
+	senders   []waitEntry
+}
+
+// NewChannel creates a channel buffering up to capacity values.
+func NewChannel(capacity int) *Channel {
+	return &Channel{capacity: capacity}
+}
+
+func (c *Channel) Type() ObjectType { return CHANNEL }
+func (c *Channel) Inspect() string  { return fmt.Sprintf("Channel[%p]", c) }
+
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.w.Write(p)
+}
@@ -1,8 +1,11 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
 	"strconv"
+	"strings"
 
 	"github.com/nhoffmann/monkey/ast"
 	"github.com/nhoffmann/monkey/lexer"
@@ -12,6 +15,7 @@ import (
 const (
 	_ int = iota
 	LOWEST
+	NULLISH
 	EQUALS
 	LESSGREATER
 	SUM
@@ -22,16 +26,20 @@ const (
 )
 
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTERISK: PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.NULLISH:        NULLISH,
+	token.EQ:             EQUALS,
+	token.NOT_EQ:         EQUALS,
+	token.LT:             LESSGREATER,
+	token.GT:             LESSGREATER,
+	token.PLUS:           SUM,
+	token.MINUS:          SUM,
+	token.SLASH:          PRODUCT,
+	token.ASTERISK:       PRODUCT,
+	token.LPAREN:         CALL,
+	token.LBRACKET:       INDEX,
+	token.DOT:            INDEX,
+	token.OPTIONAL_CHAIN: INDEX,
+	token.QUESTION:       INDEX,
 }
 
 type (
@@ -49,6 +57,12 @@ type Parser struct {
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
+
+	// yields records for each function literal being parsed whether its
+	// body yields, the innermost one is last
+	yields []bool
+	// blockDepth counts the blocks enclosing the current token
+	blockDepth int
 }
 
 // NewParser creates a new parser instance
@@ -65,12 +79,18 @@ func NewParser(l *lexer.Lexer) *Parser {
 	parser.registerPrefix(token.MINUS, parser.parsePrefixExpression)
 	parser.registerPrefix(token.TRUE, parser.parseBooleanLiteral)
 	parser.registerPrefix(token.FALSE, parser.parseBooleanLiteral)
+	parser.registerPrefix(token.NULL, parser.parseNullLiteral)
 	parser.registerPrefix(token.LPAREN, parser.parseGroupedExpression)
 	parser.registerPrefix(token.IF, parser.parseIfExpression)
 	parser.registerPrefix(token.FUNCTION, parser.parserFunctionLiteral)
 	parser.registerPrefix(token.STRING, parser.parseStringLiteral)
 	parser.registerPrefix(token.LBRACKET, parser.parseArrayLiteral)
 	parser.registerPrefix(token.LBRACE, parser.parseHashLiteral)
+	parser.registerPrefix(token.TRY, parser.parseTryExpression)
+	parser.registerPrefix(token.SPAWN, parser.parseSpawnExpression)
+	parser.registerPrefix(token.AWAIT, parser.parseAwaitExpression)
+	parser.registerPrefix(token.FOR, parser.parseForExpression)
+	parser.registerPrefix(token.IMPORT, parser.parseImportExpression)
 
 	parser.infixParseFns = make(map[token.TokenType]infixParseFn)
 	parser.registerInfix(token.PLUS, parser.parseInfixExpression)
@@ -81,8 +101,12 @@ func NewParser(l *lexer.Lexer) *Parser {
 	parser.registerInfix(token.GT, parser.parseInfixExpression)
 	parser.registerInfix(token.EQ, parser.parseInfixExpression)
 	parser.registerInfix(token.NOT_EQ, parser.parseInfixExpression)
+	parser.registerInfix(token.NULLISH, parser.parseInfixExpression)
 	parser.registerInfix(token.LPAREN, parser.parseCallExpression)
 	parser.registerInfix(token.LBRACKET, parser.parseIndexExpression)
+	parser.registerInfix(token.DOT, parser.parseFieldExpression)
+	parser.registerInfix(token.OPTIONAL_CHAIN, parser.parseOptionalChainExpression)
+	parser.registerInfix(token.QUESTION, parser.parsePropagateExpression)
 
 	parser.nextToken()
 	parser.nextToken()
@@ -106,9 +130,10 @@ func (p *Parser) ParseProgram() *ast.Program {
 	return program
 }
 
-// Errors returns all parsing errors
+// Errors returns all lexing and parsing errors
 func (p *Parser) Errors() []error {
-	return p.errors
+	errors := append([]error{}, p.lexer.Errors()...)
+	return append(errors, p.errors...)
 }
 
 func (p *Parser) registerPrefix(token token.TokenType, fn prefixParseFn) {
@@ -125,6 +150,12 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.THROW:
+		return p.parseThrowStatement()
+	case token.YIELD:
+		return p.parseYieldStatement()
+	case token.EXPORT:
+		return p.parseExportStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -147,7 +178,11 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 
 	statement.Value = p.parseExpression(LOWEST)
 
-	if !p.currentTokenIs(token.SEMICOLON) {
+	if functionLiteral, ok := statement.Value.(*ast.FunctionLiteral); ok {
+		functionLiteral.Name = statement.Name.Value
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 
@@ -161,7 +196,67 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 
 	statement.ReturnValue = p.parseExpression(LOWEST)
 
-	if !p.currentTokenIs(token.SEMICOLON) {
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return statement
+}
+
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
+	statement := &ast.ThrowStatement{Token: p.currentToken}
+
+	p.nextToken()
+
+	statement.Value = p.parseExpression(LOWEST)
+	if statement.Value == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return statement
+}
+
+func (p *Parser) parseExportStatement() ast.Statement {
+	statement := &ast.ExportStatement{Token: p.currentToken}
+
+	if p.blockDepth > 0 {
+		p.registerParseError(&ExportNotAtTopLevelError{})
+		return nil
+	}
+
+	if !p.expectPeek(token.LET) {
+		return nil
+	}
+
+	statement.Statement = p.parseLetStatement()
+	if statement.Statement == nil {
+		return nil
+	}
+
+	return statement
+}
+
+func (p *Parser) parseYieldStatement() *ast.YieldStatement {
+	statement := &ast.YieldStatement{Token: p.currentToken}
+
+	if len(p.yields) == 0 {
+		p.registerParseError(&YieldOutsideFunctionError{})
+		return nil
+	}
+	p.yields[len(p.yields)-1] = true
+
+	p.nextToken()
+
+	statement.Value = p.parseExpression(LOWEST)
+	if statement.Value == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 
@@ -184,7 +279,10 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	prefix := p.prefixParseFns[p.currentToken.Type]
 
 	if prefix == nil {
-		p.registerParseError(&NoPrefixParseFunctionError{p.currentToken.Type})
+		// The lexer already reported why the token is illegal.
+		if !p.currentTokenIs(token.ILLEGAL) {
+			p.registerParseError(&NoPrefixParseFunctionError{p.currentToken.Type})
+		}
 		return nil
 	}
 
@@ -211,8 +309,12 @@ func (p *Parser) parseIdentifier() ast.Expression {
 
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 	literal := &ast.IntegerLiteral{Token: p.currentToken}
+	digits, base := integerDigits(p.currentToken.Literal)
 
-	value, err := strconv.ParseInt(p.currentToken.Literal, 0, 64)
+	value, err := strconv.ParseInt(digits, base, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		return p.parseBigIntegerLiteral(digits, base)
+	}
 	if err != nil {
 		p.registerParseError(&UnparsableIntegerError{p.currentToken.Literal})
 		return nil
@@ -223,10 +325,47 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	return literal
 }
 
+func (p *Parser) parseBigIntegerLiteral(digits string, base int) ast.Expression {
+	value, ok := new(big.Int).SetString(digits, base)
+	if !ok {
+		p.registerParseError(&UnparsableIntegerError{p.currentToken.Literal})
+		return nil
+	}
+
+	return &ast.BigIntegerLiteral{Token: p.currentToken, Value: value}
+}
+
+// integerDigits strips the base prefix and digit separators from an integer
+// literal and returns the remaining digits together with their base.
+func integerDigits(literal string) (string, int) {
+	base := 10
+
+	if len(literal) > 2 && literal[0] == '0' {
+		switch literal[1] {
+		case 'x', 'X':
+			base = 16
+		case 'o', 'O':
+			base = 8
+		case 'b', 'B':
+			base = 2
+		}
+
+		if base != 10 {
+			literal = literal[2:]
+		}
+	}
+
+	return strings.ReplaceAll(literal, "_", ""), base
+}
+
 func (p *Parser) parseBooleanLiteral() ast.Expression {
 	return &ast.BooleanLiteral{Token: p.currentToken, Value: p.currentTokenIs(token.TRUE)}
 }
 
+func (p *Parser) parseNullLiteral() ast.Expression {
+	return &ast.NullLiteral{Token: p.currentToken}
+}
+
 func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.currentToken, Value: p.currentToken.Literal}
 }
@@ -277,6 +416,103 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return expression
 }
 
+func (p *Parser) parseForExpression() ast.Expression {
+	expression := &ast.ForExpression{Token: p.currentToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+
+	expression.Variable = &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
+
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+
+	p.nextToken()
+
+	expression.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	expression.Body = p.parseBlockStatement()
+
+	return expression
+}
+
+func (p *Parser) parseImportExpression() ast.Expression {
+	expression := &ast.ImportExpression{Token: p.currentToken}
+
+	if !p.expectPeek(token.STRING) {
+		return nil
+	}
+
+	expression.Name = p.currentToken.Literal
+
+	return expression
+}
+
+func (p *Parser) parseTryExpression() ast.Expression {
+	expression := &ast.TryExpression{Token: p.currentToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	expression.Block = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+
+		if !p.expectPeek(token.LPAREN) {
+			return nil
+		}
+
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+
+		expression.CatchParameter = &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
+
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+
+		expression.Catch = p.parseBlockStatement()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+
+		expression.Finally = p.parseBlockStatement()
+	}
+
+	if expression.Catch == nil && expression.Finally == nil {
+		p.registerParseError(&MissingHandlerError{p.peekToken.Type})
+		return nil
+	}
+
+	return expression
+}
+
 func (p *Parser) parserFunctionLiteral() ast.Expression {
 	functionLiteral := &ast.FunctionLiteral{Token: p.currentToken}
 
@@ -290,7 +526,15 @@ func (p *Parser) parserFunctionLiteral() ast.Expression {
 		return nil
 	}
 
+	p.yields = append(p.yields, false)
 	functionLiteral.Body = p.parseBlockStatement()
+	functionLiteral.Generator = p.yields[len(p.yields)-1]
+	p.yields = p.yields[:len(p.yields)-1]
+
+	// a tail call would replace the frame of the generator
+	if !functionLiteral.Generator {
+		ast.MarkTailCalls(functionLiteral)
+	}
 
 	return functionLiteral
 }
@@ -327,6 +571,9 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.currentToken}
 	block.Statements = []ast.Statement{}
 
+	p.blockDepth++
+	defer func() { p.blockDepth-- }()
+
 	p.nextToken()
 
 	for !p.currentTokenIs(token.RBRACE) && !p.currentTokenIs(token.EOF) {
@@ -363,6 +610,24 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 	return expression
 }
 
+func (p *Parser) parseSpawnExpression() ast.Expression {
+	expression := &ast.SpawnExpression{Token: p.currentToken}
+
+	p.nextToken()
+	expression.Call = p.parseExpression(PREFIX)
+
+	return expression
+}
+
+func (p *Parser) parseAwaitExpression() ast.Expression {
+	expression := &ast.AwaitExpression{Token: p.currentToken}
+
+	p.nextToken()
+	expression.Task = p.parseExpression(PREFIX)
+
+	return expression
+}
+
 func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	expression := &ast.InfixExpression{
 		Token:    p.currentToken,
@@ -437,6 +702,34 @@ func (uie *UnparsableIntegerError) Error() string {
 	return fmt.Sprintf("Could not parse input to integer: %q", uie.literal)
 }
 
+type OptionalChainError struct {
+	actualTokenType token.TokenType
+}
+
+func (oce *OptionalChainError) Error() string {
+	return fmt.Sprintf("Expected %q, %q or a field name after %q, but got %q", token.LBRACKET, token.LPAREN, token.OPTIONAL_CHAIN, oce.actualTokenType)
+}
+
+type MissingHandlerError struct {
+	actualTokenType token.TokenType
+}
+
+func (mhe *MissingHandlerError) Error() string {
+	return fmt.Sprintf("Expected %q or %q after try block, but got %q", token.CATCH, token.FINALLY, mhe.actualTokenType)
+}
+
+type ExportNotAtTopLevelError struct{}
+
+func (enatle *ExportNotAtTopLevelError) Error() string {
+	return fmt.Sprintf("%q must be at the top level", token.EXPORT)
+}
+
+type YieldOutsideFunctionError struct{}
+
+func (yofe *YieldOutsideFunctionError) Error() string {
+	return fmt.Sprintf("%q outside of a function", token.YIELD)
+}
+
 type NoPrefixParseFunctionError struct {
 	tokenType token.TokenType
 }
@@ -480,11 +773,37 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 }
 
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
-	expression := &ast.IndexExpression{Token: p.currentToken, Left: left}
+	startToken := p.currentToken
 
 	p.nextToken()
 
-	expression.Index = p.parseExpression(LOWEST)
+	var index ast.Expression
+	if !p.currentTokenIs(token.COLON) {
+		index = p.parseExpression(LOWEST)
+
+		if p.peekTokenIs(token.COLON) {
+			p.nextToken()
+		}
+	}
+
+	if p.currentTokenIs(token.COLON) {
+		return p.parseSliceExpression(startToken, left, index)
+	}
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+
+	return &ast.IndexExpression{Token: startToken, Left: left, Index: index}
+}
+
+func (p *Parser) parseSliceExpression(startToken token.Token, left, start ast.Expression) ast.Expression {
+	expression := &ast.SliceExpression{Token: startToken, Left: left, Start: start}
+
+	if !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		expression.End = p.parseExpression(LOWEST)
+	}
 
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
@@ -521,3 +840,86 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 
 	return hashLiteral
 }
+
+func (p *Parser) parsePropagateExpression(left ast.Expression) ast.Expression {
+	return &ast.PropagateExpression{Token: p.currentToken, Value: left}
+}
+
+func (p *Parser) parseFieldExpression(left ast.Expression) ast.Expression {
+	expression := &ast.FieldExpression{Token: p.currentToken, Left: left}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+
+	expression.Field = &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
+
+	return expression
+}
+
+// parseOptionalChainExpression parses an optional link together with the rest
+// of the chain of calls, index and field expressions following it, which is
+// skipped as a whole if the left side of the link is null.
+func (p *Parser) parseOptionalChainExpression(left ast.Expression) ast.Expression {
+	chain := &ast.OptionalChainExpression{Token: p.currentToken}
+
+	expression := p.parseOptionalLink(left)
+	for expression != nil && p.peekIsChainLink() {
+		p.nextToken()
+
+		if p.currentTokenIs(token.OPTIONAL_CHAIN) {
+			expression = p.parseOptionalLink(expression)
+		} else {
+			expression = p.infixParseFns[p.currentToken.Type](expression)
+		}
+	}
+
+	if expression == nil {
+		return nil
+	}
+	chain.Expression = expression
+
+	return chain
+}
+
+func (p *Parser) peekIsChainLink() bool {
+	switch p.peekToken.Type {
+	case token.LPAREN, token.LBRACKET, token.DOT, token.OPTIONAL_CHAIN:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *Parser) parseOptionalLink(left ast.Expression) ast.Expression {
+	switch {
+	case p.peekTokenIs(token.IDENT):
+		expression := p.parseFieldExpression(left).(*ast.FieldExpression)
+		expression.Optional = true
+
+		return expression
+	case p.peekTokenIs(token.LBRACKET):
+		p.nextToken()
+
+		switch expression := p.parseIndexExpression(left).(type) {
+		case *ast.IndexExpression:
+			expression.Optional = true
+			return expression
+		case *ast.SliceExpression:
+			expression.Optional = true
+			return expression
+		default:
+			return nil
+		}
+	case p.peekTokenIs(token.LPAREN):
+		p.nextToken()
+
+		expression := p.parseCallExpression(left).(*ast.CallExpression)
+		expression.Optional = true
+
+		return expression
+	default:
+		p.registerParseError(&OptionalChainError{p.peekToken.Type})
+		return nil
+	}
+}
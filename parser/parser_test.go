@@ -124,6 +124,78 @@ func TestParseProgram(t *testing.T) {
 		assertIntegerLiteral(t, expressionStatement.Expression, 5)
 	})
 
+	t.Run("Parse big integer expression", func(t *testing.T) {
+		input := "99999999999999999999;"
+
+		program := parseInput(t, input)
+
+		assertStatementsPresent(t, program)
+
+		expressionStatement, ok := program.Statements[0].(*ast.ExpressionStatement)
+
+		assertNodeType(t, ok, expressionStatement, "*ast.ExpressionStatement")
+
+		literal, ok := expressionStatement.Expression.(*ast.BigIntegerLiteral)
+		assertNodeType(t, ok, literal, "*ast.BigIntegerLiteral")
+
+		if literal.Value.String() != "99999999999999999999" {
+			t.Errorf("wrong value. Expected 99999999999999999999, got %s", literal.Value)
+		}
+	})
+
+	t.Run("Parse integer literal forms", func(t *testing.T) {
+		tests := []struct {
+			input    string
+			expected int64
+		}{
+			{"0xFF", 255},
+			{"0Xff", 255},
+			{"0o755", 493},
+			{"0b1010", 10},
+			{"1_000_000", 1000000},
+			{"0x_FF_FF", 65535},
+			{"0755", 755},
+		}
+
+		for _, test := range tests {
+			program := parseInput(t, test.input)
+
+			assertStatementsPresent(t, program)
+
+			expressionStatement, ok := program.Statements[0].(*ast.ExpressionStatement)
+
+			assertNodeType(t, ok, expressionStatement, "*ast.ExpressionStatement")
+
+			literal, ok := expressionStatement.Expression.(*ast.IntegerLiteral)
+			assertNodeType(t, ok, literal, "*ast.IntegerLiteral")
+
+			if literal.Value != test.expected {
+				t.Errorf("wrong value for %s. Expected %d, got %d", test.input, test.expected, literal.Value)
+			}
+		}
+
+		program := parseInput(t, "0xFFFF_FFFF_FFFF_FFFF_FF")
+		literal, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.BigIntegerLiteral)
+		assertNodeType(t, ok, literal, "*ast.BigIntegerLiteral")
+
+		if literal.Value.String() != "4722366482869645213695" {
+			t.Errorf("wrong value. Expected 4722366482869645213695, got %s", literal.Value)
+		}
+	})
+
+	t.Run("Parse malformed integer literal", func(t *testing.T) {
+		parser := NewParser(lexer.NewLexer("let x = 0b102;"))
+		parser.ParseProgram()
+
+		if len(parser.Errors()) != 1 {
+			t.Fatalf("Expected exactly 1 error, got %v", parser.Errors())
+		}
+
+		if _, ok := parser.Errors()[0].(*lexer.IllegalTokenError); !ok {
+			t.Errorf("Expected IllegalTokenError, got %T: %s", parser.Errors()[0], parser.Errors()[0])
+		}
+	})
+
 	t.Run("Parse boolean expression", func(t *testing.T) {
 		tests := []struct {
 			input           string
@@ -270,6 +342,69 @@ func TestParseProgram(t *testing.T) {
 		assertBlockExpression(t, ifExpression.Alternative, "y")
 	})
 
+	t.Run("Parse throw statement", func(t *testing.T) {
+		program := parseInput(t, "throw x;")
+		assertStatementsPresent(t, program)
+
+		throwStatement, ok := program.Statements[0].(*ast.ThrowStatement)
+		assertNodeType(t, ok, throwStatement, "*ast.ThrowStatement")
+		assertTokenLiteral(t, throwStatement, "throw")
+
+		assertLiteralExpression(t, throwStatement.Value, "x")
+	})
+
+	t.Run("Try Expression", func(t *testing.T) {
+		tests := []struct {
+			input          string
+			catchParameter string
+			hasCatch       bool
+			hasFinally     bool
+		}{
+			{"try { x } catch (e) { y }", "e", true, false},
+			{"try { x } finally { y }", "", false, true},
+			{"try { x } catch (err) { y } finally { y }", "err", true, true},
+		}
+
+		for _, test := range tests {
+			program := parseInput(t, test.input)
+			assertStatementsPresent(t, program)
+
+			expressionStatement, ok := program.Statements[0].(*ast.ExpressionStatement)
+			assertNodeType(t, ok, expressionStatement, "*ast.ExpressionStatement")
+
+			tryExpression, ok := expressionStatement.Expression.(*ast.TryExpression)
+			assertNodeType(t, ok, tryExpression, "*ast.TryExpression")
+
+			assertBlockExpression(t, tryExpression.Block, "x")
+
+			if test.hasCatch {
+				assertIdentifierValue(t, tryExpression.CatchParameter, test.catchParameter)
+				assertBlockExpression(t, tryExpression.Catch, "y")
+			} else if tryExpression.Catch != nil {
+				t.Errorf("Did not expect catch but got one: %+v", tryExpression.Catch)
+			}
+
+			if test.hasFinally {
+				assertBlockExpression(t, tryExpression.Finally, "y")
+			} else if tryExpression.Finally != nil {
+				t.Errorf("Did not expect finally but got one: %+v", tryExpression.Finally)
+			}
+		}
+	})
+
+	t.Run("Parse try without handler", func(t *testing.T) {
+		parser := NewParser(lexer.NewLexer("try { x }"))
+		parser.ParseProgram()
+
+		if len(parser.Errors()) == 0 {
+			t.Fatal("Expected errors to be present")
+		}
+
+		if _, ok := parser.Errors()[0].(*MissingHandlerError); !ok {
+			t.Errorf("Expected MissingHandlerError, got %T: %s", parser.Errors()[0], parser.Errors()[0])
+		}
+	})
+
 	t.Run("Function Literal", func(t *testing.T) {
 		input := `fn(x, y) { x + y; }`
 
@@ -297,6 +432,42 @@ func TestParseProgram(t *testing.T) {
 		assertInfixExpression(t, bodyStatement.Expression, "x", "+", "y")
 	})
 
+	t.Run("Tail calls", func(t *testing.T) {
+		tests := []struct {
+			input    string
+			expected []bool
+		}{
+			{"fn() { f() }", []bool{true}},
+			{"fn() { f(); 1 }", []bool{false}},
+			{"fn() { f() + 1 }", []bool{false}},
+			{"fn() { let x = f(); x }", []bool{false}},
+			{"fn() { if (x) { return f() } g() }", []bool{true, true}},
+			{"fn() { if (x) { f() } else { g() } }", []bool{true, true}},
+			{"fn() { if (x) { f() }; 1 }", []bool{false}},
+			{"fn() { f?.() }", []bool{false}},
+			{"fn() { try { return f() } catch (e) { g() } }", []bool{false, false}},
+			{"fn() { f(g()) }", []bool{true, false}},
+		}
+
+		for _, test := range tests {
+			program := parseInput(t, test.input)
+
+			calls := []bool{}
+			collectCalls(program.Statements[0].(*ast.ExpressionStatement).Expression, &calls)
+
+			if len(calls) != len(test.expected) {
+				t.Errorf("%s: wrong number of calls. Want %d, got %d", test.input, len(test.expected), len(calls))
+				continue
+			}
+
+			for i, tail := range test.expected {
+				if calls[i] != tail {
+					t.Errorf("%s: call %d has wrong tail flag. Want %t, got %t", test.input, i, tail, calls[i])
+				}
+			}
+		}
+	})
+
 	t.Run("Parse function parameters", func(t *testing.T) {
 		tests := []struct {
 			input          string
@@ -489,10 +660,54 @@ func TestParseProgram(t *testing.T) {
 				"a * [1, 2, 3, 4][b * c] * d",
 				"((a * ([1, 2, 3, 4][(b * c)])) * d)",
 			},
+			{
+				"map(a, fn(x) { return x * y })",
+				"map(a, fn(x) return (x * y);)",
+			},
 			{
 				"add(a * b[2], b[1], 2 * [1, 2][1])",
 				"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 			},
+			{
+				"a[1:2] + s[:i + 1][0]",
+				"((a[1:2]) + ((s[:(i + 1)])[0]))",
+			},
+			{
+				"a[-2:]?.[:]",
+				"((a[(-2):])?.[:])",
+			},
+			{
+				"a ?? b == c",
+				"(a ?? (b == c))",
+			},
+			{
+				"a ?? b ?? null",
+				"((a ?? b) ?? null)",
+			},
+			{
+				"a?.[b]?.[c] + 1",
+				"(((a?.[b])?.[c]) + 1)",
+			},
+			{
+				"f?.(a, b)[0]",
+				"(f?.(a, b)[0])",
+			},
+			{
+				"-a.b.c * 2",
+				"((-((a.b).c)) * 2)",
+			},
+			{
+				"s.upper()[0] + a?.b",
+				"(((s.upper)()[0]) + (a?.b))",
+			},
+			{
+				"-f(a)? + b",
+				"((-(f(a)?)) + b)",
+			},
+			{
+				"a[0]?[1]",
+				"(((a[0])?)[1])",
+			},
 		}
 
 		for _, test := range tests {
@@ -506,6 +721,256 @@ func TestParseProgram(t *testing.T) {
 		}
 	})
 
+	t.Run("Parse propagate expression", func(t *testing.T) {
+		program := parseInput(t, "parse(x)?;")
+		assertStatementsPresent(t, program)
+
+		expressionStatement, ok := program.Statements[0].(*ast.ExpressionStatement)
+		assertNodeType(t, ok, expressionStatement, "*ast.ExpressionStatement")
+
+		propagateExpression, ok := expressionStatement.Expression.(*ast.PropagateExpression)
+		assertNodeType(t, ok, propagateExpression, "*ast.PropagateExpression")
+		assertTokenLiteral(t, propagateExpression, "?")
+
+		callExpression, ok := propagateExpression.Value.(*ast.CallExpression)
+		assertNodeType(t, ok, callExpression, "*ast.CallExpression")
+		assertArgumentLength(t, callExpression, 1)
+	})
+
+	t.Run("Parse null literal", func(t *testing.T) {
+		program := parseInput(t, "null;")
+
+		assertStatementsPresent(t, program)
+
+		expressionStatement, ok := program.Statements[0].(*ast.ExpressionStatement)
+		nullLiteral, ok := expressionStatement.Expression.(*ast.NullLiteral)
+		assertNodeType(t, ok, nullLiteral, "*ast.NullLiteral")
+		assertTokenLiteral(t, nullLiteral, "null")
+	})
+
+	t.Run("Parse optional chain expressions", func(t *testing.T) {
+		program := parseInput(t, "config?.[key]; handler?.(1, 2); list?.[0][1].name")
+
+		assertLength(t, len(program.Statements), 3)
+
+		expressionStatement, ok := program.Statements[0].(*ast.ExpressionStatement)
+		chain, ok := expressionStatement.Expression.(*ast.OptionalChainExpression)
+		assertNodeType(t, ok, chain, "*ast.OptionalChainExpression")
+		indexExpression, ok := chain.Expression.(*ast.IndexExpression)
+		assertNodeType(t, ok, indexExpression, "*ast.IndexExpression")
+		assertIdentifierLiteral(t, indexExpression.Left, "config")
+		assertIdentifierLiteral(t, indexExpression.Index, "key")
+
+		if !indexExpression.Optional {
+			t.Errorf("Expected index expression to be optional")
+		}
+
+		expressionStatement, ok = program.Statements[1].(*ast.ExpressionStatement)
+		chain, ok = expressionStatement.Expression.(*ast.OptionalChainExpression)
+		assertNodeType(t, ok, chain, "*ast.OptionalChainExpression")
+		callExpression, ok := chain.Expression.(*ast.CallExpression)
+		assertNodeType(t, ok, callExpression, "*ast.CallExpression")
+		assertIdentifierLiteral(t, callExpression.Function, "handler")
+		assertArgumentLength(t, callExpression, 2)
+
+		if !callExpression.Optional {
+			t.Errorf("Expected call expression to be optional")
+		}
+
+		// the rest of the postfix chain belongs to the optional chain
+		expressionStatement, ok = program.Statements[2].(*ast.ExpressionStatement)
+		chain, ok = expressionStatement.Expression.(*ast.OptionalChainExpression)
+		assertNodeType(t, ok, chain, "*ast.OptionalChainExpression")
+		fieldExpression, ok := chain.Expression.(*ast.FieldExpression)
+		assertNodeType(t, ok, fieldExpression, "*ast.FieldExpression")
+		indexExpression, ok = fieldExpression.Left.(*ast.IndexExpression)
+		assertNodeType(t, ok, indexExpression, "*ast.IndexExpression")
+		indexExpression, ok = indexExpression.Left.(*ast.IndexExpression)
+		assertNodeType(t, ok, indexExpression, "*ast.IndexExpression")
+		assertIdentifierLiteral(t, indexExpression.Left, "list")
+
+		if fieldExpression.Optional || !indexExpression.Optional {
+			t.Errorf("Expected only the first link to be optional")
+		}
+	})
+
+	t.Run("Parse field expressions", func(t *testing.T) {
+		program := parseInput(t, "config.name; list.push(1, 2); config?.port")
+
+		assertLength(t, len(program.Statements), 3)
+
+		expressionStatement, ok := program.Statements[0].(*ast.ExpressionStatement)
+		fieldExpression, ok := expressionStatement.Expression.(*ast.FieldExpression)
+		assertNodeType(t, ok, fieldExpression, "*ast.FieldExpression")
+		assertTokenLiteral(t, fieldExpression, ".")
+		assertIdentifierLiteral(t, fieldExpression.Left, "config")
+		assertIdentifierLiteral(t, fieldExpression.Field, "name")
+
+		expressionStatement, ok = program.Statements[1].(*ast.ExpressionStatement)
+		callExpression, ok := expressionStatement.Expression.(*ast.CallExpression)
+		assertNodeType(t, ok, callExpression, "*ast.CallExpression")
+		assertArgumentLength(t, callExpression, 2)
+
+		fieldExpression, ok = callExpression.Function.(*ast.FieldExpression)
+		assertNodeType(t, ok, fieldExpression, "*ast.FieldExpression")
+		assertIdentifierLiteral(t, fieldExpression.Left, "list")
+		assertIdentifierLiteral(t, fieldExpression.Field, "push")
+
+		expressionStatement, ok = program.Statements[2].(*ast.ExpressionStatement)
+		chain, ok := expressionStatement.Expression.(*ast.OptionalChainExpression)
+		assertNodeType(t, ok, chain, "*ast.OptionalChainExpression")
+		fieldExpression, ok = chain.Expression.(*ast.FieldExpression)
+		assertNodeType(t, ok, fieldExpression, "*ast.FieldExpression")
+		assertIdentifierLiteral(t, fieldExpression.Field, "port")
+
+		if !fieldExpression.Optional {
+			t.Errorf("Expected field expression to be optional")
+		}
+
+		parser := NewParser(lexer.NewLexer("config.1"))
+		parser.ParseProgram()
+
+		if len(parser.Errors()) == 0 {
+			t.Errorf("Expected errors for a missing field name")
+		}
+	})
+
+	t.Run("Parse spawn and await expressions", func(t *testing.T) {
+		program := parseInput(t, "spawn worker(1, 2); await spawn fn() { x }")
+
+		assertLength(t, len(program.Statements), 2)
+
+		expressionStatement, ok := program.Statements[0].(*ast.ExpressionStatement)
+		spawnExpression, ok := expressionStatement.Expression.(*ast.SpawnExpression)
+		assertNodeType(t, ok, spawnExpression, "*ast.SpawnExpression")
+		assertTokenLiteral(t, spawnExpression, "spawn")
+
+		callExpression, ok := spawnExpression.Call.(*ast.CallExpression)
+		assertNodeType(t, ok, callExpression, "*ast.CallExpression")
+		assertIdentifierLiteral(t, callExpression.Function, "worker")
+		assertArgumentLength(t, callExpression, 2)
+
+		expressionStatement, ok = program.Statements[1].(*ast.ExpressionStatement)
+		awaitExpression, ok := expressionStatement.Expression.(*ast.AwaitExpression)
+		assertNodeType(t, ok, awaitExpression, "*ast.AwaitExpression")
+		assertTokenLiteral(t, awaitExpression, "await")
+
+		spawnExpression, ok = awaitExpression.Task.(*ast.SpawnExpression)
+		assertNodeType(t, ok, spawnExpression, "*ast.SpawnExpression")
+
+		_, ok = spawnExpression.Call.(*ast.FunctionLiteral)
+		if !ok {
+			t.Errorf("Expected a function literal, got %T", spawnExpression.Call)
+		}
+
+		if program.String() != "(spawn worker(1, 2))(await (spawn fn() x))" {
+			t.Errorf("Wrong string representation, got %q", program.String())
+		}
+	})
+
+	t.Run("Parse generators and for expressions", func(t *testing.T) {
+		program := parseInput(t, "let g = fn(xs) { for (x in xs) { yield x * 2; } }; fn() { x }")
+
+		assertLength(t, len(program.Statements), 2)
+
+		letStatement, ok := program.Statements[0].(*ast.LetStatement)
+		assertNodeType(t, ok, letStatement, "*ast.LetStatement")
+
+		function, ok := letStatement.Value.(*ast.FunctionLiteral)
+		assertNodeType(t, ok, function, "*ast.FunctionLiteral")
+
+		if !function.Generator {
+			t.Errorf("Expected function yielding values to be a generator")
+		}
+
+		expressionStatement, ok := function.Body.Statements[0].(*ast.ExpressionStatement)
+		forExpression, ok := expressionStatement.Expression.(*ast.ForExpression)
+		assertNodeType(t, ok, forExpression, "*ast.ForExpression")
+		assertTokenLiteral(t, forExpression, "for")
+		assertIdentifierLiteral(t, forExpression.Variable, "x")
+		assertIdentifierLiteral(t, forExpression.Iterable, "xs")
+		assertLength(t, len(forExpression.Body.Statements), 1)
+
+		yieldStatement, ok := forExpression.Body.Statements[0].(*ast.YieldStatement)
+		assertNodeType(t, ok, yieldStatement, "*ast.YieldStatement")
+		assertTokenLiteral(t, yieldStatement, "yield")
+
+		expressionStatement, ok = program.Statements[1].(*ast.ExpressionStatement)
+		function, ok = expressionStatement.Expression.(*ast.FunctionLiteral)
+		assertNodeType(t, ok, function, "*ast.FunctionLiteral")
+
+		if function.Generator {
+			t.Errorf("Expected function without yield not to be a generator")
+		}
+
+		if program.String() != "let g = fn<g>(xs) for (x in xs) yield (x * 2);;fn() x" {
+			t.Errorf("Wrong string representation, got %q", program.String())
+		}
+
+		parser := NewParser(lexer.NewLexer("yield 1"))
+		parser.ParseProgram()
+
+		if len(parser.Errors()) == 0 {
+			t.Fatal("Expected errors to be present")
+		}
+
+		if _, ok := parser.Errors()[0].(*YieldOutsideFunctionError); !ok {
+			t.Errorf("Expected YieldOutsideFunctionError, got %T: %s", parser.Errors()[0], parser.Errors()[0])
+		}
+	})
+
+	t.Run("Parse import expressions and export statements", func(t *testing.T) {
+		program := parseInput(t, `export let m = import "text/wrap";`)
+
+		assertLength(t, len(program.Statements), 1)
+
+		exportStatement, ok := program.Statements[0].(*ast.ExportStatement)
+		assertNodeType(t, ok, exportStatement, "*ast.ExportStatement")
+		assertTokenLiteral(t, exportStatement, "export")
+		assertIdentifierLiteral(t, exportStatement.Statement.Name, "m")
+
+		importExpression, ok := exportStatement.Statement.Value.(*ast.ImportExpression)
+		assertNodeType(t, ok, importExpression, "*ast.ImportExpression")
+		assertTokenLiteral(t, importExpression, "import")
+
+		if importExpression.Name != "text/wrap" {
+			t.Errorf("Wrong module name, got %q", importExpression.Name)
+		}
+
+		if program.String() != `export let m = import "text/wrap";` {
+			t.Errorf("Wrong string representation, got %q", program.String())
+		}
+
+		for _, input := range []string{"import m", "export m", "fn() { export let a = 1; }", "if (x) { export let a = 1; }"} {
+			parser := NewParser(lexer.NewLexer(input))
+			parser.ParseProgram()
+
+			if len(parser.Errors()) == 0 {
+				t.Errorf("%s: Expected errors to be present", input)
+			}
+		}
+
+		parser := NewParser(lexer.NewLexer("fn() { export let a = 1; }"))
+		parser.ParseProgram()
+
+		if _, ok := parser.Errors()[0].(*ExportNotAtTopLevelError); !ok {
+			t.Errorf("Expected ExportNotAtTopLevelError, got %T: %s", parser.Errors()[0], parser.Errors()[0])
+		}
+	})
+
+	t.Run("Parse invalid optional chain", func(t *testing.T) {
+		parser := NewParser(lexer.NewLexer("a?.1"))
+		parser.ParseProgram()
+
+		if len(parser.Errors()) == 0 {
+			t.Fatal("Expected errors to be present")
+		}
+
+		if _, ok := parser.Errors()[0].(*OptionalChainError); !ok {
+			t.Errorf("Expected OptionalChainError, got %T: %s", parser.Errors()[0], parser.Errors()[0])
+		}
+	})
+
 	t.Run("Parse array literals", func(t *testing.T) {
 		input := "[1, 2 * 2, 3 + 3]"
 
@@ -538,6 +1003,46 @@ func TestParseProgram(t *testing.T) {
 		assertInfixExpression(t, indexExpressions.Index, 1, "+", 1)
 	})
 
+	t.Run("Parse slice expressions", func(t *testing.T) {
+		tests := []struct {
+			input string
+			start interface{}
+			end   interface{}
+		}{
+			{"myArray[1:2]", 1, 2},
+			{"myArray[1:]", 1, nil},
+			{"myArray[:2]", nil, 2},
+			{"myArray[:]", nil, nil},
+		}
+
+		for _, test := range tests {
+			program := parseInput(t, test.input)
+
+			expressionStatement, ok := program.Statements[0].(*ast.ExpressionStatement)
+			sliceExpression, ok := expressionStatement.Expression.(*ast.SliceExpression)
+			assertNodeType(t, ok, sliceExpression, "*ast.SliceExpression")
+
+			assertIdentifierLiteral(t, sliceExpression.Left, "myArray")
+
+			for _, bound := range []struct {
+				actual   ast.Expression
+				expected interface{}
+			}{
+				{sliceExpression.Start, test.start},
+				{sliceExpression.End, test.end},
+			} {
+				if bound.expected == nil {
+					if bound.actual != nil {
+						t.Errorf("Expected bound to be omitted, got %s", bound.actual)
+					}
+					continue
+				}
+
+				assertLiteralExpression(t, bound.actual, bound.expected)
+			}
+		}
+	})
+
 	t.Run("Parse hash literals with string keys", func(t *testing.T) {
 		input := `{"one": 1, "two": 2, "three": 3}`
 
@@ -668,6 +1173,45 @@ func TestParseProgram(t *testing.T) {
 	})
 }
 
+// collectCalls appends the tail flags of all calls within the function literal
+// expression to calls, in source order.
+func collectCalls(node ast.Node, calls *[]bool) {
+	switch node := node.(type) {
+	case *ast.FunctionLiteral:
+		collectCalls(node.Body, calls)
+	case *ast.BlockStatement:
+		for _, statement := range node.Statements {
+			collectCalls(statement, calls)
+		}
+	case *ast.ExpressionStatement:
+		collectCalls(node.Expression, calls)
+	case *ast.ReturnStatement:
+		collectCalls(node.ReturnValue, calls)
+	case *ast.LetStatement:
+		collectCalls(node.Value, calls)
+	case *ast.InfixExpression:
+		collectCalls(node.Left, calls)
+		collectCalls(node.Right, calls)
+	case *ast.IfExpression:
+		collectCalls(node.Consequence, calls)
+		if node.Alternative != nil {
+			collectCalls(node.Alternative, calls)
+		}
+	case *ast.TryExpression:
+		collectCalls(node.Block, calls)
+		if node.Catch != nil {
+			collectCalls(node.Catch, calls)
+		}
+	case *ast.OptionalChainExpression:
+		collectCalls(node.Expression, calls)
+	case *ast.CallExpression:
+		*calls = append(*calls, node.Tail)
+		for _, argument := range node.Arguments {
+			collectCalls(argument, calls)
+		}
+	}
+}
+
 func assertStatementsPresent(t *testing.T, program *ast.Program) {
 	t.Helper()
 
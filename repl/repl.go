@@ -2,16 +2,11 @@ package repl
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 
-	"github.com/nhoffmann/monkey/object"
-	"github.com/nhoffmann/monkey/vm"
-
-	"github.com/nhoffmann/monkey/compiler"
-
-	"github.com/nhoffmann/monkey/lexer"
-	"github.com/nhoffmann/monkey/parser"
+	"github.com/nhoffmann/monkey"
 )
 
 // PROMPT denotes the REPL is waiting for input
@@ -21,44 +16,39 @@ const PROMPT = ">> "
 func Start(in io.Reader, out io.Writer) {
 	scanner := bufio.NewScanner(in)
 
-	constants := []object.Object{}
-	globals := make([]object.Object, vm.GlobalsSize)
-	symbolTable := compiler.NewSymbolTable()
+	interpreter, err := monkey.NewInterpreter(monkey.WithStdout(out), monkey.WithModulePaths("."))
+	if err != nil {
+		fmt.Fprintf(out, "Starting the interpreter failed: %s\n", err)
+		return
+	}
 
 	for {
-		fmt.Printf(PROMPT)
+		fmt.Fprintf(out, PROMPT)
 		scanned := scanner.Scan()
 		if !scanned {
 			return
 		}
 
-		line := scanner.Text()
-		lexer := lexer.NewLexer(line)
-		parser := parser.NewParser(lexer)
-
-		program := parser.ParseProgram()
-
-		if len(parser.Errors()) != 0 {
-			printParseErrors(out, parser.Errors())
+		err := interpreter.Compile(scanner.Text())
+		if parseError, ok := err.(*monkey.ParseError); ok {
+			printParseErrors(out, parseError.Errors)
 			continue
 		}
-
-		compiler := compiler.NewCompilerWithState(symbolTable, constants)
-		err := compiler.Compile(program)
 		if err != nil {
 			fmt.Fprintf(out, "Compilation failed: %s\n", err)
 			continue
 		}
 
-		machine := vm.NewVmWithGlobalsStore(compiler.Bytecode(), globals)
-		err = machine.Run()
+		result, err := interpreter.Run(context.Background())
 		if err != nil {
 			fmt.Fprintf(out, "Executing bytecode failed: %s\n", err)
+			continue
 		}
 
-		lastPopped := machine.LastPoppedStackElement()
-		io.WriteString(out, lastPopped.Inspect())
-		io.WriteString(out, "\n")
+		if result != nil {
+			io.WriteString(out, result.Inspect())
+			io.WriteString(out, "\n")
+		}
 	}
 }
 
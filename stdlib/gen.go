@@ -0,0 +1,31 @@
+//go:build ignore
+// +build ignore
+
+// gen compiles the prelude to prelude.mkc, run it with go generate.
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+
+	"github.com/nhoffmann/monkey/compiler"
+	"github.com/nhoffmann/monkey/object"
+	"github.com/nhoffmann/monkey/stdlib"
+)
+
+func main() {
+	bytecode, symbolTable, err := stdlib.CompilePrelude(object.NewRegistry())
+	if err != nil {
+		log.Fatalf("compiling the prelude failed: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := compiler.Encode(&buf, bytecode, symbolTable); err != nil {
+		log.Fatalf("encoding the prelude failed: %s", err)
+	}
+
+	if err := ioutil.WriteFile("prelude.mkc", buf.Bytes(), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
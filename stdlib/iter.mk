@@ -0,0 +1,23 @@
+export let enumerate = fn(iterable) {
+  let i = 0;
+  for (x in iterable) {
+    yield [i, x];
+    let i = i + 1
+  }
+};
+
+export let take = fn(iterable, n) {
+  if (n < 1) { return null };
+  let i = 0;
+  for (x in iterable) {
+    yield x;
+    let i = i + 1;
+    if (i == n) { return null }
+  }
+};
+
+export let collect = fn(iterable) {
+  let result = [];
+  for (x in iterable) { let result = push(result, x) };
+  result
+};
@@ -0,0 +1,70 @@
+export let sum = fn(xs) {
+  let total = 0;
+  for (x in xs) { let total = total + x };
+  total
+};
+
+export let product = fn(xs) {
+  let total = 1;
+  for (x in xs) { let total = total * x };
+  total
+};
+
+export let min = fn(xs) {
+  let result = first(xs);
+  for (x in rest(xs) ?? []) {
+    if (x < result) { let result = x }
+  };
+  result
+};
+
+export let max = fn(xs) {
+  let result = first(xs);
+  for (x in rest(xs) ?? []) {
+    if (x > result) { let result = x }
+  };
+  result
+};
+
+export let range = fn(start, end) {
+  let result = [];
+  let loop = fn(i, result) {
+    if (i < end) { loop(i + 1, push(result, i)) } else { result }
+  };
+  loop(start, result)
+};
+
+export let reverse = fn(xs) {
+  let result = [];
+  let i = len(xs);
+  for (x in xs) {
+    let i = i - 1;
+    let result = push(result, xs[i])
+  };
+  result
+};
+
+export let flatten = fn(xs) {
+  let result = [];
+  for (x in xs) {
+    for (y in x) { let result = push(result, y) }
+  };
+  result
+};
+
+export let zip = fn(xs, ys) {
+  let result = [];
+  let i = 0;
+  for (x in xs) {
+    if (i < len(ys)) { let result = push(result, [x, ys[i]]) };
+    let i = i + 1
+  };
+  result
+};
+
+export let find = fn(xs, f) {
+  for (x in xs) {
+    if (f(x)) { return x }
+  };
+  null
+};
@@ -0,0 +1,16 @@
+let list = import "list";
+let iter = import "iter";
+
+let sum = list.sum;
+let product = list.product;
+let min = list.min;
+let max = list.max;
+let range = list.range;
+let reverse = list.reverse;
+let flatten = list.flatten;
+let zip = list.zip;
+let find = list.find;
+
+let enumerate = iter.enumerate;
+let take = iter.take;
+let collect = iter.collect;
@@ -0,0 +1,57 @@
+// Package stdlib holds the standard library of Monkey: modules written in
+// Monkey which are embedded into the binary, so programs can import them
+// without any module paths, and the prelude, which interpreters run before
+// any other program.
+package stdlib
+
+import (
+	"bytes"
+	"embed"
+
+	"github.com/nhoffmann/monkey/ast"
+	"github.com/nhoffmann/monkey/compiler"
+	"github.com/nhoffmann/monkey/object"
+)
+
+//go:generate go run gen.go
+
+// Modules holds the modules of the standard library, the module "list" is
+// the file list.mk.
+//
+//go:embed *.mk
+var Modules embed.FS
+
+// precompiled is the prelude compiled by go generate.
+//
+//go:embed prelude.mkc
+var precompiled []byte
+
+// ParsePrelude parses the prelude, for the evaluator.
+func ParsePrelude() (*ast.Program, error) {
+	return object.NewModuleLoaderFS(Modules).Load("prelude")
+}
+
+// CompilePrelude compiles the prelude for a program using the builtins of
+// builtins. It returns the bytecode together with the global symbol table
+// holding the globals the prelude defines.
+func CompilePrelude(builtins *object.Registry) (*compiler.Bytecode, *compiler.SymbolTable, error) {
+	program, err := ParsePrelude()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	symbolTable := compiler.NewSymbolTableWithBuiltins(builtins)
+	c := compiler.NewCompilerWithState(symbolTable, []object.Object{})
+	c.SetModuleLoader(object.NewModuleLoaderFS(Modules))
+	if err := c.Compile(program); err != nil {
+		return nil, nil, err
+	}
+
+	return c.Bytecode(), symbolTable, nil
+}
+
+// Prelude is like CompilePrelude, but decodes the prelude precompiled when
+// the package was generated instead of compiling it.
+func Prelude(builtins *object.Registry) (*compiler.Bytecode, *compiler.SymbolTable, error) {
+	return compiler.Decode(bytes.NewReader(precompiled), builtins)
+}
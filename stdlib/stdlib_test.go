@@ -0,0 +1,92 @@
+package stdlib_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/nhoffmann/monkey"
+	"github.com/nhoffmann/monkey/compiler"
+	"github.com/nhoffmann/monkey/object"
+	"github.com/nhoffmann/monkey/stdlib"
+)
+
+func TestPrecompiledPrelude(t *testing.T) {
+	bytecode, symbolTable, err := stdlib.CompilePrelude(object.NewRegistry())
+	if err != nil {
+		t.Fatalf("compiling the prelude failed: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := compiler.Encode(&buf, bytecode, symbolTable); err != nil {
+		t.Fatalf("encoding the prelude failed: %s", err)
+	}
+
+	precompiled, err := ioutil.ReadFile("prelude.mkc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), precompiled) {
+		t.Fatalf("prelude.mkc is out of date, run go generate ./stdlib")
+	}
+
+	if _, _, err := stdlib.Prelude(object.NewRegistry()); err != nil {
+		t.Fatalf("decoding the prelude failed: %s", err)
+	}
+}
+
+func TestStdlib(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`sum([1, 2, 3])`, "6"},
+		{`sum([])`, "0"},
+		{`product([2, 3, 4])`, "24"},
+		{`product([])`, "1"},
+		{`min([3, 1, 2])`, "1"},
+		{`max([3, 1, 2])`, "3"},
+		{`max([-1, -3])`, "-1"},
+		{`min([])`, "null"},
+		{`range(2, 5)`, "[2, 3, 4]"},
+		{`range(3, 3)`, "[]"},
+		{`len(range(0, 10000))`, "10000"},
+		{`reverse([1, 2, 3])`, "[3, 2, 1]"},
+		{`flatten([[1, 2], [], [3]])`, "[1, 2, 3]"},
+		{`zip([1, 2, 3], ["a", "b"])`, `[[1, a], [2, b]]`},
+		{`find([1, 2, 3, 4], fn(x) { x > 2 })`, "3"},
+		{`find([1, 2], fn(x) { x > 2 })`, "null"},
+		{`collect(enumerate(["a", "b"]))`, `[[0, a], [1, b]]`},
+		{`collect(take(range(0, 10), 3))`, "[0, 1, 2]"},
+		{`collect(take([1, 2], 0))`, "[]"},
+		{`let ones = fn() { for (x in range(0, 3)) { yield 1 }; throw error("too far") }; collect(take(ones(), 3))`, "[1, 1, 1]"},
+		{`collect("abc")`, "[a, b, c]"},
+		{`let list = import "list"; list.sum([4, 5])`, "9"},
+		{`let iter = import "iter"; iter == import "iter"`, "true"},
+		{`let sum = fn(xs) { "mine" }; sum([1])`, "mine"},
+	}
+
+	for _, engine := range []monkey.Engine{monkey.VM, monkey.Evaluator} {
+		t.Run(engine.String(), func(t *testing.T) {
+			for _, test := range tests {
+				interpreter, err := monkey.NewInterpreter(monkey.WithEngine(engine))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := interpreter.Compile(test.input); err != nil {
+					t.Fatalf("%s: compile error %s", test.input, err)
+				}
+
+				result, err := interpreter.Run(context.Background())
+				if err != nil {
+					t.Errorf("%s: unexpected error %s", test.input, err)
+					continue
+				}
+				if result.Inspect() != test.expected {
+					t.Errorf("%s: wrong result. Want %s, got %s", test.input, test.expected, result.Inspect())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1 @@
+let = 1;
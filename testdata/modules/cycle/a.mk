@@ -0,0 +1 @@
+let b = import "cycle/b";
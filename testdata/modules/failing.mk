@@ -0,0 +1 @@
+throw error("module failed");
@@ -0,0 +1,3 @@
+let math = import "math";
+
+export let circumference = fn(r) { 2 * math.pi * r };
@@ -0,0 +1,3 @@
+puts("loading greeting");
+
+export let greet = fn(name) { "Hello, " + name + "!" };
@@ -0,0 +1,4 @@
+let square = fn(x) { x * x };
+
+export let pi = 3;
+export let area = fn(r) { pi * square(r) };
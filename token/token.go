@@ -30,7 +30,12 @@ const (
 	EQ     = "=="
 	NOT_EQ = "!="
 
+	NULLISH        = "??"
+	OPTIONAL_CHAIN = "?."
+	QUESTION       = "?"
+
 	// Delimiters
+	DOT       = "."
 	COMMA     = ","
 	SEMICOLON = ";"
 	COLON     = ":"
@@ -50,18 +55,42 @@ const (
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	NULL     = "NULL"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
+	THROW    = "THROW"
+	SPAWN    = "SPAWN"
+	AWAIT    = "AWAIT"
+	YIELD    = "YIELD"
+	IMPORT   = "IMPORT"
+	EXPORT   = "EXPORT"
+	FOR      = "FOR"
+	IN       = "IN"
 
 	//
 )
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
+	"fn":      FUNCTION,
+	"let":     LET,
+	"true":    TRUE,
+	"false":   FALSE,
+	"if":      IF,
+	"else":    ELSE,
+	"return":  RETURN,
+	"null":    NULL,
+	"try":     TRY,
+	"catch":   CATCH,
+	"finally": FINALLY,
+	"throw":   THROW,
+	"spawn":   SPAWN,
+	"await":   AWAIT,
+	"yield":   YIELD,
+	"import":  IMPORT,
+	"export":  EXPORT,
+	"for":     FOR,
+	"in":      IN,
 }
 
 // LookupIdent tests whether a given ident is a language keyword
@@ -0,0 +1,26 @@
+package vm
+
+import (
+	"github.com/nhoffmann/monkey/code"
+	"github.com/nhoffmann/monkey/object"
+)
+
+type Frame struct {
+	closure     *object.Closure
+	ip          int
+	basePointer int
+	// generator is set if the frame runs the body of a generator
+	generator *Generator
+}
+
+func NewFrame(closure *object.Closure, basePointer int) *Frame {
+	return &Frame{
+		closure:     closure,
+		ip:          -1,
+		basePointer: basePointer,
+	}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.closure.Fn.Instructions
+}
@@ -0,0 +1,147 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/nhoffmann/monkey/object"
+)
+
+// Generator is returned by calls of generator functions. While it is
+// suspended its frame is saved in the generator, resuming it restores the
+// frame on the stack of the resuming VM and runs it until the next yield.
+// Like in the evaluator, a generator belongs to the run which created it and
+// is exhausted once the run ended. A generator must not be resumed by several
+// tasks at once.
+type Generator struct {
+	closure *object.Closure
+	// scheduler is the scheduler of the run which created the generator
+	scheduler *object.Scheduler
+	ip        int
+	// stack holds the locals of the frame followed by the values it had on
+	// the stack when it yielded
+	stack   []object.Object
+	running bool
+	done    bool
+}
+
+func (g *Generator) Type() object.ObjectType { return object.GENERATOR }
+func (g *Generator) Inspect() string         { return fmt.Sprintf("Generator[%p]", g) }
+
+// finish marks the generator as exhausted once its body returns. It does
+// nothing for a nil generator, so it can be called for any frame.
+func (g *Generator) finish() {
+	if g == nil {
+		return
+	}
+
+	g.done = true
+	g.stack = nil
+}
+
+// pushGenerator replaces the closure and its numArgs arguments on the stack
+// with a generator running the closure. The body only starts once the
+// generator is resumed.
+func (vm *VM) pushGenerator(closure *object.Closure, numArgs int) error {
+	stack := make([]object.Object, closure.Fn.NumLocals)
+	copy(stack, vm.stack[vm.stackPointer-numArgs:vm.stackPointer])
+	vm.stackPointer = vm.stackPointer - numArgs - 1
+
+	return vm.push(&Generator{closure: closure, scheduler: vm.scheduler, ip: -1, stack: stack})
+}
+
+// resume runs g until it yields the next value. ok is false once the body of
+// g returned. Errors raised by the body are returned and exhaust g.
+func (vm *VM) resume(g *Generator) (value object.Object, ok bool, err error) {
+	if g.scheduler != vm.scheduler {
+		g.finish()
+	}
+	if g.done {
+		return nil, false, nil
+	}
+	if g.running {
+		return nil, false, object.NewError(object.ValueError, "generator already running")
+	}
+
+	baseFrame := vm.framesIndex
+	basePointer := vm.stackPointer
+
+	err = vm.push(g.closure)
+	if err != nil {
+		return nil, false, err
+	}
+
+	frame := &Frame{closure: g.closure, ip: g.ip, basePointer: vm.stackPointer, generator: g}
+	err = vm.pushFrame(frame)
+	if err != nil {
+		vm.stackPointer = basePointer
+		return nil, false, err
+	}
+
+	vm.growStack(vm.stackPointer + len(g.stack))
+	copy(vm.stack[vm.stackPointer:], g.stack)
+	vm.stackPointer += len(g.stack)
+
+	g.running = true
+	err = vm.run(baseFrame)
+	g.running = false
+
+	if err != nil {
+		vm.framesIndex = baseFrame
+		vm.stackPointer = basePointer
+		g.finish()
+
+		return nil, false, err
+	}
+
+	value = vm.pop()
+	if g.done {
+		return nil, false, nil
+	}
+
+	return value, true, nil
+}
+
+// yield suspends the generator running in the current frame, saving its
+// frame, and passes value to the loop resuming it.
+func (vm *VM) yield(value object.Object) error {
+	g := vm.currentFrame().generator
+	if g == nil {
+		return object.NewError(object.RuntimeError, "yield outside of a generator")
+	}
+
+	frame := vm.popFrame()
+	g.ip = frame.ip
+	g.stack = append(g.stack[:0], vm.stack[frame.basePointer:vm.stackPointer]...)
+	vm.stackPointer = frame.basePointer - 1
+
+	return vm.push(value)
+}
+
+// iterate pushes an iterator over iterable for a for loop. Generators are
+// their own iterators.
+func (vm *VM) iterate(iterable object.Object) error {
+	if g, ok := iterable.(*Generator); ok {
+		return vm.push(g)
+	}
+
+	iterator, ok := object.Iterate(iterable)
+	if !ok {
+		return object.NotIterableError(iterable)
+	}
+
+	return vm.push(iterator)
+}
+
+// next returns the next element of an iterator pushed by iterate, ok is false
+// once it is exhausted.
+func (vm *VM) next(iterator object.Object) (element object.Object, ok bool, err error) {
+	switch iterator := iterator.(type) {
+	case *Generator:
+		return vm.resume(iterator)
+	case object.Iterator:
+		element, ok := iterator.Next()
+		return element, ok, nil
+	}
+
+	return nil, false, object.NotIterableError(iterator)
+}
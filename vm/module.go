@@ -0,0 +1,109 @@
+package vm
+
+import (
+	"sync"
+
+	"github.com/nhoffmann/monkey/object"
+)
+
+// moduleCache holds the modules imported during a run, it is shared by the VMs
+// of the tasks of the run, so a module is run once even if tasks import it.
+type moduleCache struct {
+	mu      sync.Mutex
+	modules map[int]loadedModule
+}
+
+// loadedModule is a module together with the globals of the VM which loaded
+// it, the functions of the module refer to the globals defined by its body.
+type loadedModule struct {
+	module  *object.Module
+	globals []object.Object
+}
+
+func (c *moduleCache) get(slot int) (loadedModule, bool) {
+	if c == nil {
+		return loadedModule{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	loaded, ok := c.modules[slot]
+	return loaded, ok
+}
+
+func (c *moduleCache) set(slot int, loaded loadedModule) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.modules == nil {
+		c.modules = map[int]loadedModule{}
+	}
+	c.modules[slot] = loaded
+}
+
+// importModule pushes the module object of module. The first import runs the
+// body of the module, which defines its globals, and stores the module object
+// in its global slot, so later imports and runs sharing the globals reuse it.
+// Modules imported by other tasks of the run are not run again, the globals
+// their body defined are copied instead.
+func (vm *VM) importModule(module *object.CompiledModule) error {
+	if loaded := vm.globals[module.Slot]; loaded != nil {
+		return vm.push(loaded)
+	}
+
+	if loaded, ok := vm.modules.get(module.Slot); ok {
+		for i, value := range loaded.globals {
+			if i < len(vm.globals) && vm.globals[i] == nil {
+				vm.globals[i] = value
+			}
+		}
+		return vm.push(loaded.module)
+	}
+
+	baseFrame := vm.framesIndex
+	basePointer := vm.stackPointer
+
+	closure := &object.Closure{Fn: module.Body}
+
+	err := vm.push(closure)
+	if err == nil {
+		err = vm.callClosure(closure, 0)
+	}
+	if err == nil {
+		err = vm.run(baseFrame)
+	}
+
+	if err != nil {
+		vm.framesIndex = baseFrame
+		vm.stackPointer = basePointer
+		return err
+	}
+	vm.pop()
+
+	exports := make(map[string]object.Object, len(module.Exports))
+	for _, export := range module.Exports {
+		value := vm.globals[export.Global]
+		if value == nil {
+			value = Null
+		}
+		exports[export.Name] = value
+	}
+
+	loaded := &object.Module{Name: module.Name, Exports: exports}
+	vm.globals[module.Slot] = loaded
+
+	numGlobals := vm.numGlobals
+	if numGlobals > len(vm.globals) {
+		numGlobals = len(vm.globals)
+	}
+	globals := make([]object.Object, numGlobals)
+	copy(globals, vm.globals)
+	vm.modules.set(module.Slot, loadedModule{module: loaded, globals: globals})
+
+	return vm.push(loaded)
+}
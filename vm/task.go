@@ -0,0 +1,70 @@
+package vm
+
+import "github.com/nhoffmann/monkey/object"
+
+// spawn pops a function and its numArgs arguments and pushes a task calling
+// the function in a new VM. The VM of the task shares the constants, settings
+// and budget of the run and starts with a copy of the globals, so globals set
+// after the spawn are not visible to the task. Modules are shared by the tasks
+// of the run.
+func (vm *VM) spawn(numArgs int) error {
+	fn := vm.stack[vm.stackPointer-1-numArgs]
+	args := make([]object.Object, numArgs)
+	copy(args, vm.stack[vm.stackPointer-numArgs:vm.stackPointer])
+	vm.stackPointer = vm.stackPointer - numArgs - 1
+
+	switch fn.(type) {
+	case *object.Closure, *object.Builtin:
+	default:
+		return object.NewError(object.TypeError, "cannot spawn %s", fn.Type())
+	}
+
+	task := vm.newTaskVm()
+	return vm.push(vm.scheduler.Spawn(vm.budget, func(budget *object.Budget) (object.Object, error) {
+		task.budget = budget
+		return task.call(fn, args)
+	}))
+}
+
+func (vm *VM) newTaskVm() *VM {
+	numGlobals := vm.numGlobals
+	if numGlobals > len(vm.globals) {
+		numGlobals = len(vm.globals)
+	}
+	globals := make([]object.Object, numGlobals)
+	copy(globals, vm.globals)
+
+	mainClosure := &object.Closure{Fn: &object.CompiledFunction{}}
+
+	return &VM{
+		constants:     vm.constants,
+		globals:       globals,
+		numGlobals:    numGlobals,
+		frames:        []*Frame{NewFrame(mainClosure, 0)},
+		framesIndex:   1,
+		maxCallDepth:  vm.maxCallDepth,
+		stepBudget:    vm.stepBudget,
+		memoryLimit:   vm.memoryLimit,
+		stdout:        vm.stdout,
+		builtins:      vm.builtins,
+		schedulerMode: vm.schedulerMode,
+		scheduler:     vm.scheduler,
+		modules:       vm.modules,
+	}
+}
+
+// await waits for task to finish and pushes its result. The error the task
+// failed with is raised.
+func (vm *VM) await(task object.Object) error {
+	t, ok := task.(*object.Task)
+	if !ok {
+		return object.NewError(object.TypeError, "cannot await %s", task.Type())
+	}
+
+	result, err := vm.scheduler.Await(t)
+	if err != nil {
+		return err
+	}
+
+	return vm.push(result)
+}
@@ -1,54 +1,312 @@
 package vm
 
 import (
-	"fmt"
+	"context"
+	"io"
+	"os"
+	"sync"
 
 	"github.com/nhoffmann/monkey/code"
 	"github.com/nhoffmann/monkey/compiler"
 	"github.com/nhoffmann/monkey/object"
 )
 
+// StackSize is the initial size of the value stack, it grows as needed.
 const StackSize = 2048
+
+// GlobalsSize is the number of globals a globals store passed to
+// NewVmWithGlobalsStore should hold to be used for any bytecode.
 const GlobalsSize = 65536
 
-var True = &object.Boolean{Value: true}
-var False = &object.Boolean{Value: false}
-var Null = &object.Null{}
+// stacks pools the value stacks of finished runs, so running shared bytecode
+// in a new VM each time does not allocate a new stack each time.
+var stacks = sync.Pool{
+	New: func() interface{} {
+		stack := make([]object.Object, StackSize)
+		return &stack
+	},
+}
+
+var True = object.True
+var False = object.False
+var Null = object.NullValue
 
+// VM runs bytecode. A VM is not safe for concurrent use, but any number of VMs
+// can run the same bytecode in parallel. Create one VM per goroutine with
+// NewVm, which shares the immutable bytecode and only allocates the globals of
+// the run.
 type VM struct {
-	constants    []object.Object
-	instructions code.Instructions
+	constants []object.Object
+	// stack is taken from the pool while the VM runs and is nil otherwise
 	stack        []object.Object
 	stackPointer int
+	lastPopped   object.Object
 	globals      []object.Object
+	// numGlobals is the number of globals defined by the bytecode, which are
+	// copied for spawned tasks
+	numGlobals int
+
+	// frames grows with the call depth, up to maxCallDepth frames above the
+	// main frame
+	frames       []*Frame
+	framesIndex  int
+	maxCallDepth int
+
+	stepBudget  int
+	memoryLimit int
+	stdout      io.Writer
+	// builtins holds the builtins referred to by OpGetBuiltin and the methods
+	// called by OpCallMethod, the standard ones are used if it is nil
+	builtins *object.Registry
+	// budget limits the current run
+	budget *object.Budget
+
+	schedulerMode object.SchedulerMode
+	// scheduler runs the tasks of the current run
+	scheduler *object.Scheduler
+	// modules holds the modules imported by the tasks of the current run
+	modules *moduleCache
+
+	// applyErr holds an error raised while a builtin called back into a
+	// closure, so it can be returned once the builtin is done
+	applyErr error
 }
 
+// NewVm creates a VM running bytecode with its own globals.
 func NewVm(bytecode *compiler.Bytecode) *VM {
+	return NewVmWithGlobalsStore(bytecode, make([]object.Object, bytecode.NumGlobals))
+}
+
+// NewVmWithGlobalsStore creates a VM keeping its globals in the given store,
+// so they are available to bytecode compiled and run later with the same
+// symbol table. The store is written to by the VM and must not be shared by
+// VMs running at the same time.
+func NewVmWithGlobalsStore(bytecode *compiler.Bytecode, globals []object.Object) *VM {
+	mainFunction := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Handlers:     bytecode.Handlers,
+	}
+	mainClosure := &object.Closure{Fn: mainFunction}
+	mainFrame := NewFrame(mainClosure, 0)
+
 	return &VM{
-		instructions: bytecode.Instructions,
 		constants:    bytecode.Constants,
-		stack:        make([]object.Object, StackSize),
 		stackPointer: 0,
-		globals:      make([]object.Object, GlobalsSize),
+		globals:      globals,
+		numGlobals:   bytecode.NumGlobals,
+		frames:       []*Frame{mainFrame},
+		framesIndex:  1,
+		maxCallDepth: object.DefaultMaxCallDepth,
+		stdout:       os.Stdout,
 	}
 }
 
-func NewVmWithGlobalsStore(bytecode *compiler.Bytecode, globals []object.Object) *VM {
-	vm := NewVm(bytecode)
-	vm.globals = globals
-	return vm
+// SetMaxCallDepth limits the number of nested function calls.
+func (vm *VM) SetMaxCallDepth(depth int) {
+	vm.maxCallDepth = depth
+}
+
+// SetStepBudget limits the number of function calls and backward jumps a run
+// may execute. There is no limit if steps is 0 or less, which is the default.
+func (vm *VM) SetStepBudget(steps int) {
+	vm.stepBudget = steps
+}
+
+// SetMemoryLimit limits the number of bytes a run may allocate for strings,
+// arrays, hashes and big integers. There is no limit if bytes is 0 or less,
+// which is the default.
+func (vm *VM) SetMemoryLimit(bytes int) {
+	vm.memoryLimit = bytes
+}
+
+// SetStdout sets the writer builtins print to, which is os.Stdout by default.
+func (vm *VM) SetStdout(w io.Writer) {
+	vm.stdout = w
+}
+
+// SetBuiltins sets the registry the bytecode was compiled with, see
+// compiler.NewCompilerWithBuiltins.
+func (vm *VM) SetBuiltins(builtins *object.Registry) {
+	vm.builtins = builtins
+}
+
+// SetSchedulerMode sets the mode tasks spawned by a run are executed in,
+// which is object.Parallel by default.
+func (vm *VM) SetSchedulerMode(mode object.SchedulerMode) {
+	vm.schedulerMode = mode
+}
+
+// Run executes the bytecode. Runtime errors are returned as *object.Error, a
+// panic caused by a bug in the VM is recovered and returned as a RuntimeError.
+// The run stops with object.ErrTimeout once ctx is done, with
+// object.ErrBudgetExceeded once the step budget is used up and with
+// object.ErrMemoryLimitExceeded once the memory limit is exceeded.
+func (vm *VM) Run(ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = object.NewError(object.RuntimeError, "internal error: %v", r)
+		}
+	}()
+
+	if vm.acquireStack() {
+		defer vm.releaseStack()
+	}
+
+	vm.budget = object.NewBudget(ctx, vm.stepBudget, vm.memoryLimit)
+	vm.scheduler = object.NewScheduler(vm.schedulerMode)
+	vm.modules = &moduleCache{}
+	defer vm.scheduler.Stop()
+
+	return vm.run(0)
+}
+
+// Call calls fn, a closure or a builtin, with args and returns its result. It
+// is meant for calling functions defined by bytecode that was already run, and
+// stops for the same reasons as Run.
+func (vm *VM) Call(ctx context.Context, fn object.Object, args ...object.Object) (object.Object, error) {
+	vm.budget = object.NewBudget(ctx, vm.stepBudget, vm.memoryLimit)
+	vm.scheduler = object.NewScheduler(vm.schedulerMode)
+	vm.modules = &moduleCache{}
+	defer vm.scheduler.Stop()
+
+	return vm.call(fn, args)
+}
+
+// call calls fn with args on the stack of the VM.
+func (vm *VM) call(fn object.Object, args []object.Object) (result object.Object, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = object.NewError(object.RuntimeError, "internal error: %v", r)
+		}
+	}()
+
+	if vm.acquireStack() {
+		defer vm.releaseStack()
+	}
+
+	result, _ = vm.Apply(fn, args...)
+	if vm.applyErr != nil {
+		err = vm.applyErr
+		vm.applyErr = nil
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// acquireStack takes a stack from the pool unless the VM already has one. It
+// reports whether the stack has to be released once the run is done.
+func (vm *VM) acquireStack() bool {
+	if vm.stack != nil {
+		return false
+	}
+
+	vm.stack = *stacks.Get().(*[]object.Object)
+	return true
 }
 
-func (vm *VM) Run() error {
-	for insPointer := 0; insPointer < len(vm.instructions); insPointer++ {
+// releaseStack clears the stack and puts it back into the pool, keeping the
+// last popped element around. Stacks that grew are left to the garbage
+// collector, so the pool only holds stacks of StackSize.
+func (vm *VM) releaseStack() {
+	if vm.stackPointer < len(vm.stack) {
+		vm.lastPopped = vm.stack[vm.stackPointer]
+	}
+
+	stack := vm.stack
+	vm.stack = nil
+	vm.stackPointer = 0
+
+	if len(stack) == StackSize {
+		for i := range stack {
+			stack[i] = nil
+		}
+		stacks.Put(&stack)
+	}
+}
+
+// run executes instructions until the frame above baseFrame returns or the
+// main frame has no instructions left. Errors are passed to the exception
+// handlers of the frames above baseFrame.
+func (vm *VM) run(baseFrame int) error {
+	for {
+		err := vm.execute(baseFrame)
+		if err == nil {
+			return nil
+		}
+
+		runtimeError, ok := err.(*object.Error)
+		if !ok {
+			return err
+		}
+
+		if runtimeError.Stack == nil {
+			runtimeError = runtimeError.WithStack(vm.stackTrace())
+		}
+
+		if !vm.handleError(runtimeError, baseFrame) {
+			return runtimeError
+		}
+	}
+}
+
+// handleError unwinds the frames above baseFrame until one of them has a
+// handler for the instruction it is executing. It reports false if there is
+// no such handler.
+func (vm *VM) handleError(runtimeError *object.Error, baseFrame int) bool {
+
+	for {
+		frame := vm.currentFrame()
+
+		if handler, ok := frame.closure.Fn.HandlerFor(frame.ip); ok {
+			vm.stackPointer = frame.basePointer + frame.closure.Fn.NumLocals + handler.StackDepth
+			frame.ip = handler.Target - 1
+
+			return vm.push(runtimeError) == nil
+		}
+
+		if vm.framesIndex-1 <= baseFrame {
+			return false
+		}
+
+		vm.popFrame()
+	}
+}
+
+// stackTrace returns the names of the functions of all active frames,
+// innermost first.
+func (vm *VM) stackTrace() []string {
+	stack := []string{}
+
+	for i := vm.framesIndex - 1; i > 0; i-- {
+		name := vm.frames[i].closure.Fn.Name
+		if name == "" {
+			name = "<anonymous>"
+		}
+		stack = append(stack, name)
+	}
+
+	return append(stack, "<main>")
+}
+
+func (vm *VM) execute(baseFrame int) error {
+	var insPointer int
+	var instructions code.Instructions
+	var op code.Opcode
+
+	for vm.framesIndex > baseFrame && vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		vm.currentFrame().ip++
+
 		// fetch
-		op := code.Opcode(vm.instructions[insPointer])
+		insPointer = vm.currentFrame().ip
+		instructions = vm.currentFrame().Instructions()
+		op = code.Opcode(instructions[insPointer])
 
 		// decode
 		switch op {
 		case code.OpConstant:
-			constIndex := code.ReadUint16(vm.instructions[insPointer+1:])
-			insPointer += 2
+			constIndex := code.ReadUint16(instructions[insPointer+1:])
+			vm.currentFrame().ip += 2
 			err := vm.push(vm.constants[constIndex])
 			if err != nil {
 				return err
@@ -86,16 +344,77 @@ func (vm *VM) Run() error {
 				return err
 			}
 		case code.OpJump:
-			position := int(code.ReadUint16(vm.instructions[insPointer+1:]))
-			insPointer = position - 1
+			position := int(code.ReadUint16(instructions[insPointer+1:]))
+			vm.currentFrame().ip = position - 1
+
+			if position <= insPointer {
+				err := vm.budget.Step()
+				if err != nil {
+					return err
+				}
+			}
 		case code.OpJumpNotTruthy:
-			position := int(code.ReadUint16(vm.instructions[insPointer+1:]))
+			position := int(code.ReadUint16(instructions[insPointer+1:]))
 
-			insPointer += 2
+			vm.currentFrame().ip += 2
 
 			condition := vm.pop()
-			if !isTruthy(condition) {
-				insPointer = position - 1
+			if !object.IsTruthy(condition) {
+				vm.currentFrame().ip = position - 1
+			}
+		case code.OpGetBuiltin:
+			builtinIndex := code.ReadUint8(instructions[insPointer+1:])
+			vm.currentFrame().ip++
+
+			err := vm.push(vm.builtin(int(builtinIndex)))
+			if err != nil {
+				return err
+			}
+		case code.OpCall:
+			numArgs := code.ReadUint8(instructions[insPointer+1:])
+			vm.currentFrame().ip++
+
+			err := vm.executeCall(int(numArgs))
+			if err != nil {
+				return err
+			}
+		case code.OpTailCall:
+			numArgs := code.ReadUint8(instructions[insPointer+1:])
+			vm.currentFrame().ip++
+
+			err := vm.executeTailCall(int(numArgs))
+			if err != nil {
+				return err
+			}
+		case code.OpSlice:
+			end := vm.pop()
+			start := vm.pop()
+			left := vm.pop()
+
+			err := vm.executeSliceExpression(left, start, end)
+			if err != nil {
+				return err
+			}
+		case code.OpJumpNull:
+			position := int(code.ReadUint16(instructions[insPointer+1:]))
+			vm.currentFrame().ip += 2
+
+			if vm.peek() == Null {
+				vm.currentFrame().ip = position - 1
+			}
+		case code.OpJumpNotNull:
+			position := int(code.ReadUint16(instructions[insPointer+1:]))
+			vm.currentFrame().ip += 2
+
+			if vm.peek() != Null {
+				vm.currentFrame().ip = position - 1
+			}
+		case code.OpJumpNotError:
+			position := int(code.ReadUint16(instructions[insPointer+1:]))
+			vm.currentFrame().ip += 2
+
+			if vm.peek().Type() != object.ERROR {
+				vm.currentFrame().ip = position - 1
 			}
 		case code.OpNull:
 			err := vm.push(Null)
@@ -103,30 +422,35 @@ func (vm *VM) Run() error {
 				return err
 			}
 		case code.OpSetGlobal:
-			globalIndex := code.ReadUint16(vm.instructions[insPointer+1:])
-			insPointer += 2
+			globalIndex := code.ReadUint16(instructions[insPointer+1:])
+			vm.currentFrame().ip += 2
 			vm.globals[globalIndex] = vm.pop()
 		case code.OpGetGlobal:
-			globalIndex := code.ReadUint16((vm.instructions[insPointer+1:]))
-			insPointer += 2
+			globalIndex := code.ReadUint16(instructions[insPointer+1:])
+			vm.currentFrame().ip += 2
 			err := vm.push(vm.globals[globalIndex])
 			if err != nil {
 				return err
 			}
 		case code.OpArray:
-			numElements := int(code.ReadUint16(vm.instructions[insPointer+1:]))
-			insPointer += 2
+			numElements := int(code.ReadUint16(instructions[insPointer+1:]))
+			vm.currentFrame().ip += 2
 
 			array := vm.buildArray(vm.stackPointer-numElements, vm.stackPointer)
 			vm.stackPointer = vm.stackPointer - numElements
 
-			err := vm.push(array)
+			err := vm.allocate(array)
+			if err != nil {
+				return err
+			}
+
+			err = vm.push(array)
 			if err != nil {
 				return err
 			}
 		case code.OpHash:
-			numElements := int(code.ReadUint16(vm.instructions[insPointer+1:]))
-			insPointer += 2
+			numElements := int(code.ReadUint16(instructions[insPointer+1:]))
+			vm.currentFrame().ip += 2
 
 			hash, err := vm.buildHash(vm.stackPointer-numElements, vm.stackPointer)
 			if err != nil {
@@ -134,10 +458,83 @@ func (vm *VM) Run() error {
 			}
 			vm.stackPointer = vm.stackPointer - numElements
 
+			err = vm.allocate(hash)
+			if err != nil {
+				return err
+			}
+
 			err = vm.push(hash)
 			if err != nil {
 				return err
 			}
+		case code.OpReturnValue:
+			returnValue := vm.pop()
+
+			if vm.framesIndex == 1 {
+				// returning from the main frame ends the program, leaving the
+				// return value as the last popped element
+				vm.currentFrame().ip = len(instructions) - 1
+				continue
+			}
+
+			frame := vm.popFrame()
+			vm.stackPointer = frame.basePointer - 1
+			frame.generator.finish()
+
+			err := vm.push(returnValue)
+			if err != nil {
+				return err
+			}
+		case code.OpReturn:
+			frame := vm.popFrame()
+			vm.stackPointer = frame.basePointer - 1
+			frame.generator.finish()
+
+			err := vm.push(Null)
+			if err != nil {
+				return err
+			}
+		case code.OpSetLocal:
+			localIndex := code.ReadUint8(instructions[insPointer+1:])
+			vm.currentFrame().ip++
+
+			frame := vm.currentFrame()
+			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+		case code.OpGetLocal:
+			localIndex := code.ReadUint8(instructions[insPointer+1:])
+			vm.currentFrame().ip++
+
+			frame := vm.currentFrame()
+			err := vm.push(vm.stack[frame.basePointer+int(localIndex)])
+			if err != nil {
+				return err
+			}
+		case code.OpClosure:
+			constIndex := code.ReadUint16(instructions[insPointer+1:])
+			numFree := code.ReadUint8(instructions[insPointer+3:])
+			vm.currentFrame().ip += 3
+
+			err := vm.pushClosure(int(constIndex), int(numFree))
+			if err != nil {
+				return err
+			}
+		case code.OpGetFree:
+			freeIndex := code.ReadUint8(instructions[insPointer+1:])
+			vm.currentFrame().ip++
+
+			currentClosure := vm.currentFrame().closure
+			err := vm.push(currentClosure.Free[freeIndex])
+			if err != nil {
+				return err
+			}
+		case code.OpCurrentClosure:
+			currentClosure := vm.currentFrame().closure
+			err := vm.push(currentClosure)
+			if err != nil {
+				return err
+			}
+		case code.OpThrow:
+			return object.AsError(vm.pop())
 		case code.OpIndex:
 			index := vm.pop()
 			left := vm.pop()
@@ -146,20 +543,96 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpGetField:
+			nameIndex := code.ReadUint16(instructions[insPointer+1:])
+			vm.currentFrame().ip += 2
+
+			err := vm.executeFieldExpression(vm.constants[nameIndex].(*object.String).Value)
+			if err != nil {
+				return err
+			}
+		case code.OpCallMethod:
+			nameIndex := code.ReadUint16(instructions[insPointer+1:])
+			numArgs := code.ReadUint8(instructions[insPointer+3:])
+			vm.currentFrame().ip += 3
+
+			err := vm.executeMethodCall(vm.constants[nameIndex].(*object.String).Value, int(numArgs))
+			if err != nil {
+				return err
+			}
+		case code.OpSpawn:
+			numArgs := code.ReadUint8(instructions[insPointer+1:])
+			vm.currentFrame().ip++
+
+			err := vm.spawn(int(numArgs))
+			if err != nil {
+				return err
+			}
+		case code.OpAwait:
+			err := vm.await(vm.pop())
+			if err != nil {
+				return err
+			}
+		case code.OpImport:
+			constIndex := code.ReadUint16(instructions[insPointer+1:])
+			vm.currentFrame().ip += 2
+
+			err := vm.importModule(vm.constants[constIndex].(*object.CompiledModule))
+			if err != nil {
+				return err
+			}
+		case code.OpImportError:
+			constIndex := code.ReadUint16(instructions[insPointer+1:])
+			vm.currentFrame().ip += 2
+
+			return object.NewError(object.ImportError, "%s", vm.constants[constIndex].(*object.String).Value)
+		case code.OpYield:
+			err := vm.yield(vm.pop())
+			if err != nil {
+				return err
+			}
+		case code.OpIter:
+			err := vm.iterate(vm.pop())
+			if err != nil {
+				return err
+			}
+		case code.OpIterNext:
+			position := int(code.ReadUint16(instructions[insPointer+1:]))
+			vm.currentFrame().ip += 2
+
+			element, ok, err := vm.next(vm.peek())
+			if err != nil {
+				return err
+			}
+
+			if !ok {
+				vm.pop()
+				vm.currentFrame().ip = position - 1
+				continue
+			}
+
+			err = vm.push(element)
+			if err != nil {
+				return err
+			}
 		}
 	}
 
 	return nil
 }
 
+// LastPoppedStackElement returns the value of the last expression statement
+// executed, which is the result of a run.
 func (vm *VM) LastPoppedStackElement() object.Object {
+	if vm.stack == nil {
+		return vm.lastPopped
+	}
+
 	return vm.stack[vm.stackPointer]
 }
 
 func (vm *VM) push(obj object.Object) error {
-	if vm.stackPointer >= StackSize {
-		return fmt.Errorf("stack overflow")
-	}
+	vm.growStack(vm.stackPointer + 1)
 
 	vm.stack[vm.stackPointer] = obj
 	vm.stackPointer++
@@ -167,6 +640,22 @@ func (vm *VM) push(obj object.Object) error {
 	return nil
 }
 
+// growStack makes sure the stack can hold size values.
+func (vm *VM) growStack(size int) {
+	if size <= len(vm.stack) {
+		return
+	}
+
+	newSize := 2 * len(vm.stack)
+	for newSize < size {
+		newSize *= 2
+	}
+
+	stack := make([]object.Object, newSize)
+	copy(stack, vm.stack)
+	vm.stack = stack
+}
+
 func (vm *VM) pop() object.Object {
 	obj := vm.stack[vm.stackPointer-1]
 	vm.stackPointer--
@@ -174,6 +663,10 @@ func (vm *VM) pop() object.Object {
 	return obj
 }
 
+func (vm *VM) peek() object.Object {
+	return vm.stack[vm.stackPointer-1]
+}
+
 func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
@@ -188,14 +681,14 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 		return vm.executeBinaryStringOperation(op, left, right)
 	}
 
-	return fmt.Errorf("unsupported types for binary operation: %s %s", leftType, rightType)
+	return object.NewError(object.TypeError, "unsupported types for binary operation: %s %s", leftType, rightType)
 }
 
 func (vm *VM) executeComparison(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
 
-	if left.Type() == object.INTEGER || right.Type() == object.INTEGER {
+	if left.Type() == object.INTEGER && right.Type() == object.INTEGER {
 		return vm.executeIntegerComparison(op, left, right)
 	}
 
@@ -205,7 +698,8 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 	case code.OpNotEqual:
 		return vm.push(nativeBoolToBooleanObject(right != left))
 	default:
-		return fmt.Errorf(
+		return object.NewError(
+			object.TypeError,
 			"unknown operator: %d (%s %s)",
 			op,
 			left.Type(),
@@ -215,18 +709,17 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 }
 
 func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object) error {
-	leftValue := left.(*object.Integer).Value
-	rightValue := right.(*object.Integer).Value
+	comparison := object.CompareIntegers(left, right)
 
 	switch op {
 	case code.OpEqual:
-		return vm.push(nativeBoolToBooleanObject(rightValue == leftValue))
+		return vm.push(nativeBoolToBooleanObject(comparison == 0))
 	case code.OpNotEqual:
-		return vm.push(nativeBoolToBooleanObject(rightValue != leftValue))
+		return vm.push(nativeBoolToBooleanObject(comparison != 0))
 	case code.OpGreaterThan:
-		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+		return vm.push(nativeBoolToBooleanObject(comparison > 0))
 	default:
-		return fmt.Errorf("unknown operator: %d", op)
+		return object.NewError(object.TypeError, "unknown operator: %d", op)
 	}
 }
 
@@ -249,33 +742,39 @@ func (vm *VM) executeMinusOperator() error {
 	operand := vm.pop()
 
 	if operand.Type() != object.INTEGER {
-		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
+		return object.NewError(object.TypeError, "unsupported type for negation: %s", operand.Type())
 	}
 
-	value := operand.(*object.Integer).Value
-	return vm.push(&object.Integer{Value: -value})
+	return vm.push(object.NegateInteger(operand))
 }
 
 func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.Object) error {
-	leftValue := left.(*object.Integer).Value
-	rightValue := right.(*object.Integer).Value
-
-	var result int64
+	var operator string
 
 	switch op {
 	case code.OpAdd:
-		result = leftValue + rightValue
+		operator = "+"
 	case code.OpSubtract:
-		result = leftValue - rightValue
+		operator = "-"
 	case code.OpMultiply:
-		result = leftValue * rightValue
+		operator = "*"
 	case code.OpDivide:
-		result = leftValue / rightValue
+		operator = "/"
 	default:
-		return fmt.Errorf("unknonw integer operator: %d", op)
+		return object.NewError(object.TypeError, "unknonw integer operator: %d", op)
 	}
 
-	return vm.push(&object.Integer{Value: result})
+	result := object.IntegerOperation(operator, left, right)
+	if err, ok := result.(*object.Error); ok {
+		return err
+	}
+
+	err := vm.allocate(result)
+	if err != nil {
+		return err
+	}
+
+	return vm.push(result)
 }
 
 func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
@@ -297,9 +796,9 @@ func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
 
 		pair := object.HashPair{Key: key, Value: value}
 
-		hashKey, ok := key.(object.Hashable)
+		hashKey, ok := object.AsHashable(key)
 		if !ok {
-			return nil, fmt.Errorf("unusable as hashkey: %s", key.Type())
+			return nil, object.NewError(object.TypeError, "unusable as hashkey: %s", key.Type())
 		}
 
 		hashedPairs[hashKey.HashKey()] = pair
@@ -312,16 +811,77 @@ func (vm *VM) executeIndexExpression(left, index object.Object) error {
 	switch {
 	case left.Type() == object.ARRAY && index.Type() == object.INTEGER:
 		return vm.executeArrayIndex(left, index)
+	case left.Type() == object.STRING && index.Type() == object.INTEGER:
+		return vm.executeStringIndex(left, index)
 	case left.Type() == object.HASH:
 		return vm.executeHashIndex(left, index)
+	case left.Type() == object.ERROR:
+		if value, ok := left.(*object.Error).Field(index); ok {
+			return vm.push(value)
+		}
+		return vm.push(Null)
 	default:
-		return fmt.Errorf("index operator not supported: %s", left.Type())
+		if host, ok := left.(*object.HostObject); ok {
+			value, ok := host.Field(index)
+			if !ok {
+				return object.MissingFieldError(host, index)
+			}
+			return vm.push(value)
+		}
+		return object.NewError(object.TypeError, "index operator not supported: %s", left.Type())
 	}
 }
 
+// executeFieldExpression replaces the value on top of the stack with its field
+// name, methods are bound to the value.
+func (vm *VM) executeFieldExpression(name string) error {
+	receiver := vm.pop()
+
+	value, method, err := object.ResolveField(receiver, name, vm.method)
+	if err != nil {
+		return err
+	}
+
+	if method {
+		value = object.BindMethod(value.(*object.Builtin), receiver)
+	}
+
+	return vm.push(value)
+}
+
+// executeMethodCall calls the field or method name of the receiver below the
+// arguments. Methods get the receiver as their first argument, it is left in
+// place and the method is inserted below it. A field replaces the receiver.
+func (vm *VM) executeMethodCall(name string, numArgs int) error {
+	receiverIndex := vm.stackPointer - 1 - numArgs
+	receiver := vm.stack[receiverIndex]
+
+	value, method, err := object.ResolveField(receiver, name, vm.method)
+	if err != nil {
+		return err
+	}
+
+	if !method {
+		vm.stack[receiverIndex] = value
+		return vm.executeCall(numArgs)
+	}
+
+	vm.growStack(vm.stackPointer + 1)
+	copy(vm.stack[receiverIndex+1:], vm.stack[receiverIndex:vm.stackPointer])
+	vm.stack[receiverIndex] = value
+	vm.stackPointer++
+
+	return vm.executeCall(numArgs + 1)
+}
+
 func (vm *VM) executeArrayIndex(left, index object.Object) error {
 	arrayObject := left.(*object.Array)
-	i := index.(*object.Integer).Value
+	integer, ok := index.(*object.Integer)
+	if !ok {
+		return vm.push(Null)
+	}
+
+	i := integer.Value
 	max := int64(len(arrayObject.Elements) - 1)
 
 	if i < 0 || i > max {
@@ -331,30 +891,296 @@ func (vm *VM) executeArrayIndex(left, index object.Object) error {
 	return vm.push(arrayObject.Elements[i])
 }
 
+func (vm *VM) executeStringIndex(left, index object.Object) error {
+	integer, ok := index.(*object.Integer)
+	if !ok {
+		return vm.push(Null)
+	}
+
+	character, ok := object.StringIndex(left.(*object.String), integer.Value)
+	if !ok {
+		return vm.push(Null)
+	}
+
+	return vm.push(character)
+}
+
 func (vm *VM) executeHashIndex(left, index object.Object) error {
 	hashObject := left.(*object.Hash)
 
-	key, ok := index.(object.Hashable)
+	key, ok := object.AsHashable(index)
 	if !ok {
-		return fmt.Errorf("unusable as hash key: %s", index.Type())
+		return object.NewError(object.TypeError, "unusable as hash key: %s", index.Type())
 	}
 
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	value, ok := hashObject.Get(key)
 	if !ok {
 		return vm.push(Null)
 	}
 
-	return vm.push(pair.Value)
+	return vm.push(value)
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.framesIndex > vm.maxCallDepth {
+		return object.NewError(object.RecursionError, "maximum recursion depth exceeded")
+	}
+
+	if vm.framesIndex == len(vm.frames) {
+		vm.frames = append(vm.frames, f)
+	} else {
+		vm.frames[vm.framesIndex] = f
+	}
+	vm.framesIndex++
+
+	return nil
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	frame := vm.frames[vm.framesIndex]
+	// release the closure of the returning call
+	vm.frames[vm.framesIndex] = nil
+
+	return frame
+}
+
+func (vm *VM) executeCall(numArgs int) error {
+	err := vm.budget.Step()
+	if err != nil {
+		return err
+	}
+
+	callee := vm.stack[vm.stackPointer-1-numArgs]
+
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		return vm.callBuiltin(callee, numArgs)
+	default:
+		return object.NewError(object.TypeError, "calling non-function: %s", callee.Type())
+	}
+}
+
+// executeTailCall calls a closure by replacing the current frame with its own,
+// so tail recursion runs in constant space. The callee and its arguments are
+// moved down to where the current closure and its arguments are. Calls of
+// other functions are made as usual.
+func (vm *VM) executeTailCall(numArgs int) error {
+	callee := vm.stack[vm.stackPointer-1-numArgs]
+
+	closure, ok := callee.(*object.Closure)
+	if !ok || closure.Fn.Generator || numArgs != closure.Fn.NumParameters {
+		return vm.executeCall(numArgs)
+	}
+
+	err := vm.budget.Step()
+	if err != nil {
+		return err
+	}
+
+	frame := vm.currentFrame()
+	copy(vm.stack[frame.basePointer-1:], vm.stack[vm.stackPointer-1-numArgs:vm.stackPointer])
+
+	vm.frames[vm.framesIndex-1] = NewFrame(closure, frame.basePointer)
+
+	vm.stackPointer = frame.basePointer + closure.Fn.NumLocals
+	vm.growStack(vm.stackPointer)
+
+	return nil
+}
+
+func (vm *VM) callClosure(closure *object.Closure, numArgs int) error {
+	if numArgs != closure.Fn.NumParameters {
+		return object.NewError(object.ArgumentError, "wrong number of arguments: want=%d, got=%d", closure.Fn.NumParameters, numArgs)
+	}
+
+	if closure.Fn.Generator {
+		return vm.pushGenerator(closure, numArgs)
+	}
+
+	frame := NewFrame(closure, vm.stackPointer-numArgs)
+	err := vm.pushFrame(frame)
+	if err != nil {
+		return err
+	}
+
+	vm.stackPointer = frame.basePointer + closure.Fn.NumLocals
+	vm.growStack(vm.stackPointer)
+
+	return nil
+}
+
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
+	args := vm.stack[vm.stackPointer-numArgs : vm.stackPointer]
+
+	result := builtin.Fn(vm, args...)
+	vm.stackPointer = vm.stackPointer - numArgs - 1
+
+	if vm.applyErr != nil {
+		err := vm.applyErr
+		vm.applyErr = nil
+		return err
+	}
+
+	if err, ok := object.BuiltinError(result); ok {
+		return err
+	}
+	if result != nil {
+		return vm.push(result)
+	}
+	return vm.push(Null)
+}
+
+// Apply calls fn from within a builtin function. Closures are executed on the
+// stack of the running VM until they return.
+func (vm *VM) Apply(fn object.Object, args ...object.Object) (object.Object, *object.Error) {
+	if vm.applyErr != nil {
+		return nil, asRuntimeError(vm.applyErr)
+	}
+
+	baseFrame := vm.framesIndex
+	basePointer := vm.stackPointer
+
+	err := vm.push(fn)
+	for _, arg := range args {
+		if err != nil {
+			break
+		}
+		err = vm.push(arg)
+	}
+
+	if err == nil {
+		err = vm.executeCall(len(args))
+	}
+
+	if err == nil {
+		err = vm.run(baseFrame)
+	}
+
+	if err != nil {
+		vm.framesIndex = baseFrame
+		vm.stackPointer = basePointer
+		vm.applyErr = err
+
+		return nil, asRuntimeError(err)
+	}
+
+	return vm.pop(), nil
+}
+
+// Allocate accounts size bytes allocated by a builtin function. Once the
+// memory limit is exceeded, the error is kept to stop the run after the
+// builtin is done.
+func (vm *VM) Allocate(size int) *object.Error {
+	if vm.applyErr != nil {
+		return asRuntimeError(vm.applyErr)
+	}
+
+	err := vm.budget.Allocate(size)
+	if err != nil {
+		vm.applyErr = err
+		return asRuntimeError(err)
+	}
+
+	return nil
+}
+
+func (vm *VM) builtin(index int) *object.Builtin {
+	if vm.builtins != nil {
+		return vm.builtins.Get(index)
+	}
+
+	return object.Builtins[index].Builtin
+}
+
+func (vm *VM) method(objectType object.ObjectType, name string) (*object.Builtin, bool) {
+	if vm.builtins != nil {
+		return vm.builtins.Method(objectType, name)
+	}
+
+	return object.GetMethod(objectType, name)
+}
+
+// Stdout returns the writer builtins print to.
+func (vm *VM) Stdout() io.Writer {
+	return vm.scheduler.Writer(vm.stdout)
+}
+
+func (vm *VM) Scheduler() *object.Scheduler {
+	return vm.scheduler
+}
+
+func (vm *VM) Raise(err error) *object.Error {
+	if vm.applyErr == nil {
+		vm.applyErr = err
+	}
+
+	return asRuntimeError(vm.applyErr)
+}
+
+// allocate accounts obj, which was just created by the VM.
+func (vm *VM) allocate(obj object.Object) error {
+	return vm.budget.Allocate(object.SizeOf(obj))
+}
+
+func asRuntimeError(err error) *object.Error {
+	if runtimeError, ok := err.(*object.Error); ok {
+		return runtimeError
+	}
+
+	return object.NewError(object.RuntimeError, "%s", err)
+}
+
+func (vm *VM) pushClosure(constIndex, numFree int) error {
+	constant := vm.constants[constIndex]
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return object.NewError(object.TypeError, "not a function: %+v", constant)
+	}
+
+	free := make([]object.Object, numFree)
+	for i := 0; i < numFree; i++ {
+		free[i] = vm.stack[vm.stackPointer-numFree+i]
+	}
+	vm.stackPointer = vm.stackPointer - numFree
+
+	closure := &object.Closure{Fn: function, Free: free}
+	return vm.push(closure)
+}
+
+func (vm *VM) executeSliceExpression(left, start, end object.Object) error {
+	result, sliceErr := object.Slice(left, start, end)
+	if sliceErr != nil {
+		return sliceErr
+	}
+
+	err := vm.allocate(result)
+	if err != nil {
+		return err
+	}
+
+	return vm.push(result)
 }
 
 func (vm *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Object) error {
 	if op != code.OpAdd {
-		return fmt.Errorf("unknown string operator: %d", op)
+		return object.NewError(object.TypeError, "unknown string operator: %d", op)
 	}
 
 	leftValue := left.(*object.String).Value
 	rightValue := right.(*object.String).Value
 
+	err := vm.budget.Allocate(object.SizeOf(&object.String{}) + len(leftValue) + len(rightValue))
+	if err != nil {
+		return err
+	}
+
 	return vm.push(&object.String{Value: leftValue + rightValue})
 }
 
@@ -365,15 +1191,3 @@ func nativeBoolToBooleanObject(input bool) object.Object {
 
 	return False
 }
-
-func isTruthy(obj object.Object) bool {
-	switch obj := obj.(type) {
-	case *object.Boolean:
-		return obj.Value
-	case *object.Null:
-		return false
-	default:
-		return true
-	}
-
-}
@@ -1,16 +1,28 @@
 package vm
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/nhoffmann/monkey/compiler"
 
 	"github.com/nhoffmann/monkey/ast"
+	"github.com/nhoffmann/monkey/code"
 	"github.com/nhoffmann/monkey/lexer"
 	"github.com/nhoffmann/monkey/object"
 	"github.com/nhoffmann/monkey/parser"
 )
 
+// bigInteger is the expected decimal representation of an *object.BigInteger.
+type bigInteger string
+
 type vmTestCase struct {
 	input    string
 	expected interface{}
@@ -26,6 +38,7 @@ func TestCases(t *testing.T) {
 			{"1 * 2", 2},
 			{"4 / 2", 2},
 			{"50 / 2 * 2 + 10 - 5", 55},
+			{"0xFF + 0o7 + 0b10 + 1_000", 1264},
 			{"5 * (2 + 10)", 60},
 			{"5 + 5 + 5 + 5 - 10", 10},
 			{"2 * 2 * 2 * 2 * 2", 32},
@@ -136,7 +149,869 @@ func TestCases(t *testing.T) {
 		runVmTests(t, tests)
 	})
 
-	t.Run("", func(t *testing.T) {
+	t.Run("Big integers", func(t *testing.T) {
+		tests := []vmTestCase{
+			{"9223372036854775807 + 1", bigInteger("9223372036854775808")},
+			{"-9223372036854775807 - 2", bigInteger("-9223372036854775809")},
+			{"4294967296 * 4294967296", bigInteger("18446744073709551616")},
+			{"-9223372036854775808", -9223372036854775808},
+			{"-(-9223372036854775807 - 1)", bigInteger("9223372036854775808")},
+			{"(-9223372036854775807 - 1) / -1", bigInteger("9223372036854775808")},
+			{"99999999999999999999", bigInteger("99999999999999999999")},
+			{"99999999999999999999 - 99999999999999999998", 1},
+			{"18446744073709551616 / 4294967296", 4294967296},
+			{"99999999999999999999 > 1", true},
+			{"1 > 99999999999999999999", false},
+			{"-99999999999999999999 < 1", true},
+			{"99999999999999999999 == 99999999999999999999", true},
+			{"99999999999999999999 != 99999999999999999998", true},
+			{"{99999999999999999999: 1}[99999999999999999999]", 1},
+			{"[1, 2][99999999999999999999]", Null},
+			{"[1, 2, 3][-99999999999999999999:99999999999999999999]", []int{1, 2, 3}},
+			{
+				`let fib = fn(n) {
+					let iter = fn(a, b, i) { if (i == 0) { return a; } iter(b, a + b, i - 1) };
+					iter(0, 1, n)
+				};
+				fib(100)`,
+				bigInteger("354224848179261915075"),
+			},
+		}
+
+		runVmTests(t, tests)
+	})
+
+	t.Run("Calling functions", func(t *testing.T) {
+		tests := []vmTestCase{
+			{"let fivePlusTen = fn() { 5 + 10; }; fivePlusTen();", 15},
+			{"let one = fn() { 1; }; let two = fn() { 2; }; one() + two()", 3},
+			{"let early = fn() { return 99; 100; }; early();", 99},
+			{"let noReturn = fn() { }; noReturn();", Null},
+			{"let identity = fn(a) { a; }; identity(4);", 4},
+			{"let sum = fn(a, b) { let c = a + b; c; }; sum(1, 2);", 3},
+			{"let globalSeed = 50; let minusOne = fn() { let num = 1; globalSeed - num; }; minusOne();", 49},
+			{"return 10; 9", 10},
+		}
+
+		runVmTests(t, tests)
+	})
+
+	t.Run("Closures", func(t *testing.T) {
+		tests := []vmTestCase{
+			{
+				`let newAdder = fn(a, b) { fn(c) { a + b + c } };
+				let adder = newAdder(1, 2);
+				adder(8);`,
+				11,
+			},
+			{
+				`let newClosure = fn(a) { fn() { a; }; };
+				let closure = newClosure(99);
+				closure();`,
+				99,
+			},
+			{
+				`let fibonacci = fn(x) {
+					if (x == 0) { return 0; }
+					if (x == 1) { return 1; }
+					fibonacci(x - 1) + fibonacci(x - 2);
+				};
+				fibonacci(15);`,
+				610,
+			},
+			{
+				`let wrapper = fn() {
+					let countDown = fn(x) { if (x == 0) { return 0; } else { countDown(x - 1); } };
+					countDown(1);
+				};
+				wrapper();`,
+				0,
+			},
+		}
+
+		runVmTests(t, tests)
+	})
+
+	t.Run("Hash builtins", func(t *testing.T) {
+		tests := []vmTestCase{
+			{`len({})`, 0},
+			{`len({"a": 1, "b": 2})`, 2},
+			{`keys({"b": 2, "a": 1})`, []string{"a", "b"}},
+			{`keys({10: 1, 2: 2})`, []int{2, 10}},
+			{`values({"b": 2, "a": 1})`, []int{1, 2}},
+			{`map(entries({"b": 2, "a": 1}), fn(e) { e[1] })`, []int{1, 2}},
+			{`has({"a": 1}, "a")`, true},
+			{`has({"a": 1}, "b")`, false},
+			{`has({[1, 2]: 1}, [1, 2])`, true},
+			{`let h = {"a": 1, "b": 2}; delete(h, "a")`, map[object.HashKey]int64{
+				(&object.String{Value: "b"}).HashKey(): 2,
+			}},
+			{`let h = {"a": 1, "b": 2}; delete(h, "a"); len(h)`, 2},
+			{`merge({1: 1, 2: 2}, {2: 3}, {4: 4})`, map[object.HashKey]int64{
+				(&object.Integer{Value: 1}).HashKey(): 1,
+				(&object.Integer{Value: 2}).HashKey(): 3,
+				(&object.Integer{Value: 4}).HashKey(): 4,
+			}},
+			{`let h = {"a": 1}; merge(h, {"b": 2}); len(h)`, 1},
+			{`keys([1])`, &object.Error{Message: "argument 1 to `keys` must be HASH, got ARRAY"}},
+			{`delete({})`, &object.Error{Message: "wrong number of arguments. Got 1, want 2."}},
+			{`merge({}, 1)`, &object.Error{Message: "argument 2 to `merge` must be HASH, got INTEGER"}},
+		}
+
+		runVmTests(t, tests)
+	})
+
+	t.Run("Higher-order builtins", func(t *testing.T) {
+		tests := []vmTestCase{
+			{"map([1, 2, 3], fn(x) { x * 2 })", []int{2, 4, 6}},
+			{"let factor = 3; map([1, 2], fn(x) { return x * factor })", []int{3, 6}},
+			{`map(["a", "b"], upper)`, []string{"A", "B"}},
+			{"map([], fn(x) { x })", []int{}},
+			{"filter([1, 2, 3, 4], fn(x) { x > 2 })", []int{3, 4}},
+			{"reduce([1, 2, 3, 4], 0, fn(acc, x) { acc + x })", 10},
+			{"reduce([], 5, fn(acc, x) { acc + x })", 5},
+			{"sort_by([3, 1, 2], fn(x) { x })", []int{1, 2, 3}},
+			{"sort_by([1, 2, 3], fn(x) { -x })", []int{3, 2, 1}},
+			{`sort_by(["bb", "a", "ccc"], fn(s) { len(s) })`, []string{"a", "bb", "ccc"}},
+			{"any([1, 2, 3], fn(x) { x > 2 })", true},
+			{"any([1, 2, 3], fn(x) { x > 5 })", false},
+			{"all([1, 2, 3], fn(x) { x > 0 })", true},
+			{"all([1, 2, 3], fn(x) { x > 1 })", false},
+			{"map(map([1, 2], fn(x) { x + 1 }), fn(x) { x * 10 })", []int{20, 30}},
+			{"map([[1, 2], [3]], fn(a) { reduce(a, 0, fn(acc, x) { acc + x }) })", []int{3, 3}},
+			{"map(1, fn(x) { x })", &object.Error{Message: "argument 1 to `map` must be ARRAY, got INTEGER"}},
+			{`sort_by([1, 2], fn(x) { if (x == 1) { 1 } else { "b" } })`, &object.Error{Message: "sort keys must be of the same type, got INTEGER and STRING"}},
+		}
+
+		runVmTests(t, tests)
+	})
+
+	t.Run("Builtin functions", func(t *testing.T) {
+		tests := []vmTestCase{
+			{`len("")`, 0},
+			{`len("four")`, 4},
+			{`len([1, 2, 3])`, 3},
+			{`len(1)`, &object.Error{Message: "argument to `len` not supported, got INTEGER."}},
+			{`len("one", "two")`, &object.Error{Message: "wrong number of arguments. Got 2, want 1."}},
+			{`puts("hello", "world!")`, Null},
+			{`first([1, 2, 3])`, 1},
+			{`first([])`, Null},
+			{`last([1, 2, 3])`, 3},
+			{`rest([1, 2, 3])`, []int{2, 3}},
+			{`push([], 1)`, []int{1}},
+		}
+
+		runVmTests(t, tests)
+	})
+
+	t.Run("String builtins", func(t *testing.T) {
+		tests := []vmTestCase{
+			{`"monkey"[0]`, "m"},
+			{`let s = "monkey"; s[len(s) - 1]`, "y"},
+			{`"monkey"[6]`, Null},
+			{`"monkey"[-1]`, Null},
+			{`"héllo"[1]`, "é"},
+			{`"héllo"[4]`, "o"},
+			{`"héllo"[5]`, Null},
+			{`len("héllo")`, 5},
+			{`index_of("héllo", "llo")`, 2},
+			{`split("a,b,c", ",")`, []string{"a", "b", "c"}},
+			{`split("abc", "")`, []string{"a", "b", "c"}},
+			{`join(["a", "b", "c"], "-")`, "a-b-c"},
+			{`join([1, 2], ", ")`, "1, 2"},
+			{`join([], ",")`, ""},
+			{`join(["a"], ",")`, "a"},
+			{"trim(\"  monkey \t\n\")", "monkey"},
+			{`upper("monkey")`, "MONKEY"},
+			{`lower("MoNkEy")`, "monkey"},
+			{`contains("monkey", "key")`, true},
+			{`contains("monkey", "dog")`, false},
+			{`if (contains("monkey", "mon")) { 1 } else { 2 }`, 1},
+			{`replace("banana", "a", "o")`, "bonono"},
+			{`index_of("monkey", "key")`, 3},
+			{`index_of("monkey", "dog")`, -1},
+			{`starts_with("monkey", "mon")`, true},
+			{`starts_with("monkey", "key")`, false},
+			{`ends_with("monkey", "key")`, true},
+			{`ends_with("monkey", "mon")`, false},
+			{`repeat("ab", 3)`, "ababab"},
+			{`repeat("ab", 0)`, ""},
+			{`format("{} + {} = {}", 1, 2, "three")`, "1 + 2 = three"},
+			{`format("no placeholders")`, "no placeholders"},
+			{`upper(1)`, &object.Error{Message: "argument 1 to `upper` must be STRING, got INTEGER"}},
+			{`split("a")`, &object.Error{Message: "wrong number of arguments. Got 1, want 2."}},
+			{`repeat("a", -1)`, &object.Error{Message: "argument to `repeat` must not be negative, got -1"}},
+			{`repeat("ab", 9223372036854775807)`, &object.Error{Message: "result of `repeat` is too large"}},
+			{`repeat("ab", 4000000000)`, &object.Error{Message: "result of `repeat` is too large"}},
+			{`format("{} {}", 1)`, &object.Error{Message: "wrong number of values for `format`. Got 1, want 2."}},
+		}
+
+		runVmTests(t, tests)
+	})
+
+	t.Run("Slice expressions", func(t *testing.T) {
+		tests := []vmTestCase{
+			{"[1, 2, 3, 4][1:3]", []int{2, 3}},
+			{"[1, 2, 3, 4][:2]", []int{1, 2}},
+			{"[1, 2, 3, 4][2:]", []int{3, 4}},
+			{"[1, 2, 3, 4][:]", []int{1, 2, 3, 4}},
+			{"[1, 2, 3, 4][-2:]", []int{3, 4}},
+			{"[1, 2, 3, 4][:-1]", []int{1, 2, 3}},
+			{"[1, 2, 3, 4][-10:10]", []int{1, 2, 3, 4}},
+			{"[1, 2, 3, 4][3:1]", []int{}},
+			{"let a = [1, 2, 3]; let i = 1; a[i:i + 1]", []int{2}},
+			{"[1, 2, 3][null:2]", []int{1, 2}},
+			{`"monkey"[1:4]`, "onk"},
+			{`"monkey"[:-3]`, "mon"},
+			{`"héllo"[1:-1]`, "éll"},
+			{`"日本語"[:2]`, "日本"},
+			{`"monkey"[3:]`, "key"},
+			{`"monkey"[4:2]`, ""},
+			{"let a = null; a?.[1:]", Null},
+		}
+
+		runVmTests(t, tests)
+	})
+
+	t.Run("Null literal and optional chaining", func(t *testing.T) {
+		tests := []vmTestCase{
+			{"null", Null},
+			{"null == null", true},
+			{"null != 1", true},
+			{"1 == null", false},
+			{"null ?? 5", 5},
+			{"3 ?? 5", 3},
+			{"false ?? 5", false},
+			{"null ?? null", Null},
+			{`let config = {"port": 80}; config["host"] ?? 8080`, 8080},
+			{`let config = {"db": {"port": 5432}}; config?.["db"]?.["port"]`, 5432},
+			{`let config = {"db": {"port": 5432}}; config["cache"]?.["port"] ?? 6379`, 6379},
+			{`let config = null; config?.["db"]`, Null},
+			{"let x = null; x?.[1][2]", Null},
+			{"let x = null; x?.[1:][0]", Null},
+			{"let f = null; f?.(1)(2)", Null},
+			{"let x = null; x?.[0]?.[1][2] ?? 3", 3},
+			{"let x = [[1, [2, 3]]]; x?.[0][1][1]", 3},
+			{"let f = fn(x) { fn(y) { x + y } }; f?.(1)(2)", 3},
+		}
+
+		runVmTests(t, tests)
+	})
+
+	t.Run("Field expressions and method calls", func(t *testing.T) {
+		tests := []vmTestCase{
+			{`let config = {"db": {"port": 5432}}; config.db.port`, 5432},
+			{`{"a": 1}.b`, Null},
+			{`let config = null; config?.db?.port`, Null},
+			{`let config = {"db": null}; config.db?.port ?? 6379`, 6379},
+			{`"monkey".upper()`, "MONKEY"},
+			{`" a,b ".trim().split(",")`, []string{"a", "b"}},
+			{`"{} + {}".format(1, 2)`, "1 + 2"},
+			{`[1, 2].push(3).map(fn(x) { x * 2 })`, []int{2, 4, 6}},
+			{`[1, 2, 3].reduce(0, fn(acc, x) { acc + x })`, 6},
+			{`{"a": 1, "b": 2}.keys().len()`, 2},
+			{`{"len": fn() { 42 }}.len()`, 42},
+			{`let upper = "abc".upper; upper()`, "ABC"},
+			{`let s = null; s?.upper()`, Null},
+			{`let x = null; x?.y.z`, Null},
+			{`let x = null; x?.y.z(1).w`, Null},
+			{`let s = null; s?.trim().upper()`, Null},
+			{`let x = {"y": {"z": 1}}; x?.y.z`, 1},
+			{`let x = {"y": null}; x.y?.z.w ?? 2`, 2},
+			{`let x = null; try { (x?.y).z } catch (e) { e.kind }`, "AttributeError"},
+			{`fn(s) { s.upper() }("tail")`, "TAIL"},
+			{`try { throw error("failed") } catch (e) { e.message }`, "failed"},
+			{`try { 1.upper() } catch (e) { e.kind + ": " + e.message }`, "AttributeError: INTEGER has no property or method upper"},
+			{`try { "a".first() } catch (e) { e.message }`, "STRING has no property or method first"},
+			{`"a".upper(1)`, &object.Error{Message: "wrong number of arguments. Got 2, want 1."}},
+		}
+
+		runVmTests(t, tests)
+
+		builtins := object.NewRegistry()
+		builtins.RegisterMethod(object.INTEGER, "times", 1, "Repeats a function.", func(runtime object.Runtime, args ...object.Object) object.Object {
+			for i := int64(0); i < args[0].(*object.Integer).Value; i++ {
+				if _, err := runtime.Apply(args[1], &object.Integer{Value: i}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+		tests = []vmTestCase{
+			{`3.times(fn(i) { i })`, Null},
+			{`try { 3.times(fn(i) { if (i == 2) { throw error("two") } }) } catch (e) { e.message }`, "two"},
+			{`3.times()`, &object.Error{Message: "wrong number of arguments. Got 0, want 1."}},
+			{`"a".upper()`, "A"},
+		}
+
+		for _, test := range tests {
+			compiler := compiler.NewCompilerWithBuiltins(builtins)
+			err := compiler.Compile(parse(test.input))
+			if err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+
+			vm := NewVm(compiler.Bytecode())
+			vm.SetBuiltins(builtins)
+
+			err = vm.Run(context.Background())
+			var runtimeError *object.Error
+			if _, ok := test.expected.(*object.Error); ok && errors.As(err, &runtimeError) {
+				assertExpectedObject(t, runtimeError, test.expected)
+				continue
+			}
+			if err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+
+			assertExpectedObject(t, vm.LastPoppedStackElement(), test.expected)
+		}
+	})
+
+	t.Run("Tasks and channels", func(t *testing.T) {
+		tests := []vmTestCase{
+			{`let t = spawn fn() { 40 + 2 }; await t`, 42},
+			{`let add = fn(a, b) { a + b }; await spawn add(40, 2)`, 42},
+			{`let double = fn(x) { x * 2 }; [1, 2, 3].map(fn(x) { spawn double(x) }).map(fn(t) { await t })`, []int{2, 4, 6}},
+			{`let x = 1; let t = spawn fn() { x + 1 }; await t`, 2},
+			{`let x = 1; let t = spawn fn() { x }(); let x = 2; await t`, 1},
+			{`let x = 1; let get = fn() { x }; let t = spawn fn() { spawn get() }(); let x = 2; await await t`, 1},
+			{`await spawn "abc".upper`, "ABC"},
+			{`let ch = chan(); spawn fn() { send(ch, 1); send(ch, 2); close(ch) }; [recv(ch), recv(ch), recv(ch) ?? 0]`, []int{1, 2, 0}},
+			{`let ch = chan(2); ch.send(1); ch.send(2); ch.close(); [ch.recv(), ch.recv(), ch.recv() ?? 0]`, []int{1, 2, 0}},
+			{`let ch = chan(); let results = chan(); let worker = fn() { let n = recv(ch); send(results, n * n) }; spawn worker(); spawn worker(); send(ch, 3); send(ch, 4); recv(results) + recv(results)`, 25},
+			{`let ch = chan(1); select([[ch, 5]])["index"]`, 0},
+			{`let a = chan(); let b = chan(1); send(b, 2); select([a, b])["value"]`, 2},
+			{`select([chan()], "none")["value"]`, "none"},
+			{`let ch = chan(); spawn fn() { send(ch, 1) }; select([ch])["value"]`, 1},
+			{`let t = spawn fn() { throw error("failed") }; try { await t } catch (e) { e.message }`, "failed"},
+			{`try { recv(chan()) } catch (e) { e.kind + ": " + e.message }`, "DeadlockError: all tasks are waiting"},
+			{`let ch = chan(); let t = spawn fn() { recv(ch) }; try { await t } catch (e) { e.kind }`, "DeadlockError"},
+			{`let ch = chan(); close(ch); try { send(ch, 1) } catch (e) { e.message }`, "send on closed channel"},
+			{`let ch = chan(); close(ch); try { ch.close() } catch (e) { e.message }`, "close of closed channel"},
+			{`try { spawn 1 } catch (e) { e.message }`, "cannot spawn INTEGER"},
+			{`try { await 1 } catch (e) { e.message }`, "cannot await INTEGER"},
+			{`chan(-1)`, &object.Error{Message: "channel capacity must not be negative, got -1"}},
+		}
+
+		runVmTests(t, tests)
+
+		var out bytes.Buffer
+		program := parse(`
+			let ch = chan();
+			let worker = fn(name) { puts(name + " started"); send(ch, name); puts(name + " done") };
+			let a = spawn worker("a");
+			let b = spawn worker("b");
+			puts("main");
+			puts(recv(ch));
+			puts(recv(ch));
+			await a;
+			await b;
+		`)
+
+		c := compiler.NewCompiler()
+		if err := c.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := NewVm(c.Bytecode())
+		vm.SetStdout(&out)
+		vm.SetSchedulerMode(object.Deterministic)
+		if err := vm.Run(context.Background()); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		expected := "main\na started\na done\nb started\na\nb\nb done\n"
+		if out.String() != expected {
+			t.Errorf("wrong output. Want %q, got %q", expected, out.String())
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		for _, input := range []string{
+			"let spin = fn() { spin() }; spawn spin(); recv(chan())",
+			"let spin = fn() { spin() }; let t = spawn spin(); await t",
+		} {
+			c := compiler.NewCompiler()
+			if err := c.Compile(parse(input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+
+			vm := NewVm(c.Bytecode())
+
+			err := vm.Run(ctx)
+			if !errors.Is(err, object.ErrTimeout) {
+				t.Errorf("%s: expected ErrTimeout, got %v", input, err)
+			}
+		}
+	})
+
+	t.Run("Generators and for loops", func(t *testing.T) {
+		tests := []vmTestCase{
+			{`let count = fn() { yield 1; yield 2; yield 3 }; let sum = 0; for (x in count()) { let sum = sum + x }; sum`, 6},
+			{`let collect = fn(it) { let out = []; for (x in it) { let out = out.push(x) }; out }; let g = fn() { let a = 1; yield a; let b = a + 1; yield b; yield a + b }; collect(g())`, []int{1, 2, 3}},
+			{`let collect = fn(it) { let out = []; for (x in it) { let out = out.push(x) }; out }; let evens = fn(xs) { for (x in xs) { if (x / 2 * 2 == x) { yield x } } }; collect(evens([1, 2, 3, 4]))`, []int{2, 4}},
+			{`let collect = fn(it) { let out = []; for (x in it) { let out = out.push(x) }; out }; let count = fn(n) { for (x in [1, 2, 3, 4]) { if (n > x - 1) { yield x } } }; let double = fn(it) { for (x in it) { yield x * 2 } }; collect(double(count(3)))`, []int{2, 4, 6}},
+			{`let collect = fn(it) { let out = []; for (x in it) { let out = out.push(x) }; out }; let g = fn() { yield 1 }; let it = g(); collect(it).len() + collect(it).len()`, 1},
+			{`let g = fn() { yield 1; throw error("unreachable") }; let first = fn(it) { for (x in it) { return x } }; first(g())`, 1},
+			{`let g = fn() { yield 1; throw error("failed") }; try { for (x in g()) { x } } catch (e) { e.message }`, "failed"},
+			{`let g = fn() { try { yield "a"; throw error("b") } catch (e) { yield e.message } }; let s = ""; for (x in g()) { let s = s + x }; s`, "ab"},
+			{`let s = ""; for (c in "abc") { let s = c + s }; s`, "cba"},
+			{`let s = []; for (c in "héllo") { let s = push(s, c) }; join(s, "|")`, "h|é|l|l|o"},
+			{`let s = ""; for (k in {"b": 1, "a": 2}) { let s = s + k }; s`, "ab"},
+			{`let find = fn(xs) { for (x in xs) { if (x > 1) { return x } } }; find([1, 2, 3])`, 2},
+			{`let xs = [1, 2]; for (x in xs) { let xs = xs.push(x) }; xs`, []int{1, 2, 1, 2}},
+			{`(for (x in [1]) { x }) ?? "null"`, "null"},
+			{`try { for (x in 1) { x } } catch (e) { e.kind + ": " + e.message }`, "TypeError: INTEGER is not iterable"},
+		}
+
+		runVmTests(t, tests)
+	})
+
+	t.Run("Modules", func(t *testing.T) {
+		loader := object.NewModuleLoader("../testdata/modules")
+
+		tests := []vmTestCase{
+			{`let math = import "math"; math.area(2)`, 12},
+			{`let math = import "math"; let square = 1; math.area(2) + square`, 13},
+			{`let pi = 100; let circle = import "geometry/circle"; circle.circumference(1) + pi`, 106},
+			{`let circle = import "geometry/circle"; let math = import "math"; math.pi`, 3},
+			{`(import "math") == (import "math")`, true},
+			{`try { (import "math").square } catch (e) { e.kind }`, "AttributeError"},
+			{`try { import "failing" } catch (e) { e.message }`, "module failed"},
+			{`export let x = 1; x`, 1},
+			{`try { import "missing" } catch (e) { e.kind }`, "ImportError"},
+			{`try { import "cycle/a" } catch (e) { e.message }`, "import cycle: cycle/a -> cycle/b -> cycle/a"},
+			{`if (false) { import "missing" } else { 1 }`, 1},
+		}
+
+		for _, test := range tests {
+			c := compiler.NewCompiler()
+			c.SetModuleLoader(loader)
+			if err := c.Compile(parse(test.input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+
+			vm := NewVm(c.Bytecode())
+			if err := vm.Run(context.Background()); err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+
+			assertExpectedObject(t, vm.LastPoppedStackElement(), test.expected)
+		}
+
+		for input, expected := range map[string]string{
+			`import "cycle/a"`: "import cycle: cycle/a -> cycle/b -> cycle/a",
+			`import "missing"`: "module not found: missing",
+			`import "../math"`: `invalid module name "../math"`,
+			`import "broken"`:  `module broken: Expected next token to be "IDENT", but got "="; No prefixParseFunction for given token: "="`,
+		} {
+			c := compiler.NewCompiler()
+			c.SetModuleLoader(loader)
+			if err := c.Compile(parse(input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+
+			err := NewVm(c.Bytecode()).Run(context.Background())
+			if err == nil || err.Error() != expected {
+				t.Errorf("%s: wrong error. Want %q, got %v", input, expected, err)
+			}
+		}
+	})
+
+	t.Run("Calling functions with wrong arguments", func(t *testing.T) {
+		tests := []vmTestCase{
+			{"fn() { 1; }(1);", "wrong number of arguments: want=0, got=1"},
+			{"fn(a) { a; }();", "wrong number of arguments: want=1, got=0"},
+			{"fn(a, b) { a + b; }(1);", "wrong number of arguments: want=2, got=1"},
+			{"map([1], fn(x, y) { x })", "wrong number of arguments: want=2, got=1"},
+			{"map([1], fn(x) { x + true })", "unsupported types for binary operation: INTEGER BOOLEAN"},
+		}
+
+		for _, test := range tests {
+			program := parse(test.input)
+
+			compiler := compiler.NewCompiler()
+			err := compiler.Compile(program)
+			if err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+
+			vm := NewVm(compiler.Bytecode())
+			err = vm.Run(context.Background())
+			if err == nil {
+				t.Fatalf("expected VM error but resulted in none.")
+			}
+
+			if err.Error() != test.expected {
+				t.Errorf("wrong VM error. Want %q, got %q", test.expected, err)
+			}
+		}
+	})
+
+	t.Run("Try and catch", func(t *testing.T) {
+		tests := []vmTestCase{
+			{"try { 1 } catch (e) { 2 }", 1},
+			{`try { throw "boom"; 1 } catch (e) { e["message"] }`, "boom"},
+			{`try { throw "boom" } catch (e) { e["kind"] }`, "Error"},
+			{`try { 1 / 0 } catch (e) { e["kind"] }`, "ZeroDivisionError"},
+			{`try { 1 / 0 } catch (e) { e["unknown"] }`, Null},
+			{"1 + try { throw 1 } catch (e) { 2 }", 3},
+			{"[1, 2, try { [3, 4][5 / 0] } catch (e) { 9 }]", []int{1, 2, 9}},
+			{"try { } catch (e) { 1 }", Null},
+			{"let x = 0; try { 1 } finally { let x = 5; }; x", 5},
+			{"let x = 0; try { try { throw 1 } finally { let x = 1; } } catch (e) { x }", 1},
+			{`try { try { throw "a" } catch (e) { throw e } } catch (e) { e["message"] }`, "a"},
+			{`try { try { throw "a" } catch (e) { throw "b" } finally { 1 } } catch (e) { e["message"] }`, "b"},
+			{"fn() { try { return 1; } finally { return 2; } }()", 2},
+			{"fn() { let x = try { 1 } finally { 2 }; x }()", 1},
+			{
+				`let f = fn() { 1 / 0 };
+				let g = fn() { try { f() } catch (e) { e["message"] } };
+				g()`,
+				"division by zero",
+			},
+			{
+				`let f = fn() { throw "inner" };
+				let g = fn() { let result = f(); result };
+				try { g() } catch (e) { e["stack"] }`,
+				[]string{"f", "g", "<main>"},
+			},
+			{
+				`let f = fn(n) { if (n == 0) { throw "bottom" } 1 + f(n - 1) };
+				try { f(5) } catch (e) { len(e["stack"]) }`,
+				7,
+			},
+			{
+				`let inner = fn(x) { x / 0 };
+				let outer = fn() { map([1], inner) };
+				try { outer() } catch (e) { e["stack"] }`,
+				[]string{"inner", "outer", "<main>"},
+			},
+			{`try { map([1], fn(x) { x / 0 }) } catch (e) { e["kind"] }`, "ZeroDivisionError"},
+			{`try { throw [1, 2] } catch (e) { e["message"] }`, "[1, 2]"},
+			{`try { throw "boom" } catch (e) { e }`, &object.Error{Message: "boom"}},
+			{"let f = fn() { 1 + f() }; try { f() } catch (e) { e[\"kind\"] }", "RecursionError"},
+		}
+
+		runVmTests(t, tests)
+	})
+
+	t.Run("Error values", func(t *testing.T) {
+		tests := []vmTestCase{
+			{`is_error(error("bad"))`, true},
+			{"is_error(1)", false},
+			{`error("bad")["message"]`, "bad"},
+			{`error("bad")["kind"]`, "Error"},
+			{`try { len(1) } catch (e) { "caught" }`, "caught"},
+			{`let r = try { upper(1) } catch (e) { e }; if (is_error(r)) { "failed" } else { r }`, "failed"},
+			{`try { upper(1) } catch (e) { e["kind"] }`, "TypeError"},
+			{"5? + 1", 6},
+			{
+				`let parse = fn(x) { if (x < 0) { return error("negative") } x };
+				let double = fn(x) { parse(x)? * 2 };
+				double(2)`,
+				4,
+			},
+			{
+				`let parse = fn(x) { if (x < 0) { return error("negative") } x };
+				let double = fn(x) { parse(x)? * 2 };
+				double(-1)["message"]`,
+				"negative",
+			},
+			{`let f = fn() { let x = error("a")?; 1 }; is_error(f())`, true},
+			{`fn() { [1, error("a")?, 3] }()["message"]`, "a"},
+			{`fn() { try { error("a")? } finally { return 2 } }()`, 2},
+			{
+				`let check = fn(x) { if (x < 0) { error("negative") } else { x } };
+				len(filter(map([1, -1, 2], check), is_error))`,
+				1,
+			},
+			{`error("stop")?; 1`, &object.Error{Message: "stop"}},
+			{`try { fn() { len(1)?; 1 }() } catch (e) { e["kind"] }`, "TypeError"},
+			{`let f = fn() { upper(1)? }; try { is_error(f()) } catch (e) { "raised" }`, "raised"},
+		}
+
+		runVmTests(t, tests)
+	})
+
+	t.Run("Runtime errors", func(t *testing.T) {
+		tests := []struct {
+			input           string
+			expectedKind    object.ErrorKind
+			expectedMessage string
+		}{
+			{"1 / 0", object.ZeroDivisionError, "division by zero"},
+			{"let x = 0; 10 / x", object.ZeroDivisionError, "division by zero"},
+			{"99999999999999999999 / 0", object.ZeroDivisionError, "division by zero"},
+			{"map([1], fn(x) { x / 0 })", object.ZeroDivisionError, "division by zero"},
+			{"1 + true", object.TypeError, "unsupported types for binary operation: INTEGER BOOLEAN"},
+			{"-true", object.TypeError, "unsupported type for negation: BOOLEAN"},
+			{"1()", object.TypeError, "calling non-function: INTEGER"},
+			{"{}[fn() {}]", object.TypeError, "unusable as hash key: CLOSURE"},
+			{"fn(x) { x }()", object.ArgumentError, "wrong number of arguments: want=1, got=0"},
+			{"let f = fn() { 1 + f() }; f()", object.RecursionError, "maximum recursion depth exceeded"},
+			{`throw "boom"`, object.UserError, "boom"},
+			{`try { throw "boom" } finally { 1 }`, object.UserError, "boom"},
+			{`let f = fn() { try { 1 / 0 } finally { 1 } }; f()`, object.ZeroDivisionError, "division by zero"},
+		}
+
+		for _, test := range tests {
+			program := parse(test.input)
+
+			compiler := compiler.NewCompiler()
+			err := compiler.Compile(program)
+			if err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+
+			vm := NewVm(compiler.Bytecode())
+			err = vm.Run(context.Background())
+
+			var runtimeError *object.Error
+			if !errors.As(err, &runtimeError) {
+				t.Errorf("%s: expected *object.Error, got %T: %v", test.input, err, err)
+				continue
+			}
+
+			if runtimeError.Kind != test.expectedKind {
+				t.Errorf("%s: wrong error kind. Want %s, got %s", test.input, test.expectedKind, runtimeError.Kind)
+			}
+
+			if runtimeError.Message != test.expectedMessage {
+				t.Errorf("%s: wrong error message. Want %q, got %q", test.input, test.expectedMessage, runtimeError.Message)
+			}
+		}
+	})
+
+	t.Run("Call depth", func(t *testing.T) {
+		recursive := "let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } };"
+
+		tests := []struct {
+			input        string
+			maxCallDepth int
+			expected     interface{}
+		}{
+			{recursive + "f(5000)", object.DefaultMaxCallDepth, 5000},
+			{recursive + "f(9)", 10, 9},
+			{
+				recursive + `try { f(10) } catch (e) { [e["kind"], e["message"]] }`,
+				10,
+				[]string{"RecursionError", "maximum recursion depth exceeded"},
+			},
+			{recursive + `try { f(20) } catch (e) { len(e["stack"]) }`, 10, 11},
+			{
+				`let f = fn(n) { if (n == 0) { 0 } else { 1 + first(map([n - 1], f)) } };
+				try { f(20) } catch (e) { e["kind"] }`,
+				10,
+				"RecursionError",
+			},
+		}
+
+		for _, test := range tests {
+			program := parse(test.input)
+
+			compiler := compiler.NewCompiler()
+			err := compiler.Compile(program)
+			if err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+
+			vm := NewVm(compiler.Bytecode())
+			vm.SetMaxCallDepth(test.maxCallDepth)
+
+			err = vm.Run(context.Background())
+			if err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+
+			assertExpectedObject(t, vm.LastPoppedStackElement(), test.expected)
+		}
+	})
+
+	t.Run("Tail calls", func(t *testing.T) {
+		tests := []vmTestCase{
+			{
+				`let loop = fn(n, acc) { if (n == 0) { acc } else { loop(n - 1, acc + 1) } };
+				loop(1000000, 0)`,
+				1000000,
+			},
+			{
+				`let even = fn(n, odd) { if (n == 0) { return true; } odd(n - 1, even) };
+				let odd = fn(n, even) { if (n == 0) { return false; } even(n - 1, odd) };
+				even(100001, odd)`,
+				false,
+			},
+			{
+				`let sum = fn(xs, acc) { if (len(xs) == 0) { acc } else { sum(rest(xs), acc + first(xs)) } };
+				sum([1, 2, 3, 4, 5], 0)`,
+				15,
+			},
+			{"let adder = fn(x) { fn(y) { x + y } }; let apply = fn(f, v) { f(v) }; apply(adder(1), 2)", 3},
+			{"let f = fn(x) { x }; let g = fn() { f(1, 2) }; try { g() } catch (e) { e[\"stack\"] }", []string{"g", "<main>"}},
+			{
+				`let f = fn() { throw "inner" };
+				let g = fn() { f() };
+				try { g() } catch (e) { e["stack"] }`,
+				[]string{"f", "<main>"},
+			},
+		}
+
+		runVmTests(t, tests)
+	})
+
+	t.Run("Budgets", func(t *testing.T) {
+		canceled, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		timeout, cancelTimeout := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancelTimeout()
+
+		tests := []struct {
+			input      string
+			ctx        context.Context
+			stepBudget int
+			expected   error
+		}{
+			{"let add = fn(a, b) { a + b }; add(1, 2)", context.Background(), 1, nil},
+			{"let f = fn() { f() }; f()", context.Background(), 1000, object.ErrBudgetExceeded},
+			{"let f = fn() { 1 + f() }; f()", context.Background(), 1000, object.ErrBudgetExceeded},
+			{"let f = fn() { f() }; try { f() } catch (e) { 1 } finally { 2 }", context.Background(), 1000, object.ErrBudgetExceeded},
+			{"map([1], fn(x) { let f = fn() { f() }; f() })", context.Background(), 1000, object.ErrBudgetExceeded},
+			{"let f = fn() { f() }; f()", timeout, 0, object.ErrTimeout},
+			{"len([])", canceled, 0, object.ErrTimeout},
+		}
+
+		for _, test := range tests {
+			program := parse(test.input)
+
+			compiler := compiler.NewCompiler()
+			err := compiler.Compile(program)
+			if err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+
+			vm := NewVm(compiler.Bytecode())
+			vm.SetStepBudget(test.stepBudget)
+
+			err = vm.Run(test.ctx)
+			if !errors.Is(err, test.expected) {
+				t.Errorf("%s: wrong error. Want %v, got %v", test.input, test.expected, err)
+			}
+		}
+	})
+
+	t.Run("Memory limits", func(t *testing.T) {
+		tests := []struct {
+			input       string
+			memoryLimit int
+			expected    error
+		}{
+			{`let s = "ab"; s + s`, 10000, nil},
+			{`len(repeat("a", 100000))`, 0, nil},
+			{`let grow = fn(s) { grow(s + s) }; grow("a")`, 10000, object.ErrMemoryLimitExceeded},
+			{`let grow = fn(x) { grow(x * x) }; grow(2)`, 10000, object.ErrMemoryLimitExceeded},
+			{`let fill = fn(a, n) { if (n == 0) { a } else { fill(push(a, n), n - 1) } }; fill([], 1000)`, 10000, object.ErrMemoryLimitExceeded},
+			{`let fill = fn(n) { if (n == 0) { 0 } else { let h = {n: [n]}; fill(n - 1) } }; fill(1000)`, 10000, object.ErrMemoryLimitExceeded},
+			{`repeat("ab", 1000000000000)`, 10000, object.ErrMemoryLimitExceeded},
+			{`replace(repeat("a", 100), "a", repeat("b", 1000))`, 10000, object.ErrMemoryLimitExceeded},
+			{`join([1, 2, 3, 4, 5, 6], repeat("-", 2000))`, 10000, object.ErrMemoryLimitExceeded},
+			{`let s = repeat("a", 2000); format("{}{}{}{}{}{}", s, s, s, s, s, s)`, 10000, object.ErrMemoryLimitExceeded},
+			{`let s = repeat("a", 2000); format("{}{}", s, s)`, 10000, nil},
+			{`map([1, 2, 3], fn(x) { repeat("a", 100000) })`, 10000, object.ErrMemoryLimitExceeded},
+			{`try { repeat("a", 100000) } catch (e) { 1 }`, 10000, object.ErrMemoryLimitExceeded},
+		}
+
+		for _, test := range tests {
+			program := parse(test.input)
+
+			compiler := compiler.NewCompiler()
+			err := compiler.Compile(program)
+			if err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+
+			vm := NewVm(compiler.Bytecode())
+			vm.SetMemoryLimit(test.memoryLimit)
+
+			err = vm.Run(context.Background())
+			if !errors.Is(err, test.expected) {
+				t.Errorf("%s: wrong error. Want %v, got %v", test.input, test.expected, err)
+			}
+		}
+	})
+
+	t.Run("Registered builtins", func(t *testing.T) {
+		builtins := object.NewRegistry()
+		builtins.Register("answer", 0, "Returns the answer.", func(runtime object.Runtime, args ...object.Object) object.Object {
+			return &object.Integer{Value: 42}
+		})
+		builtins.Register("twice", 2, "Calls a function twice.", func(runtime object.Runtime, args ...object.Object) object.Object {
+			result, err := runtime.Apply(args[0], args[1])
+			if err != nil {
+				return err
+			}
+			result, err = runtime.Apply(args[0], result)
+			if err != nil {
+				return err
+			}
+			return result
+		})
+
+		tests := []vmTestCase{
+			{"answer()", 42},
+			{"answer() + len([1, 2])", 44},
+			{"twice(fn(x) { x * 3 }, 2)", 18},
+			{"let answer = fn() { 1 }; answer()", 1},
+			{"answer(1)", &object.Error{Message: "wrong number of arguments. Got 1, want 0."}},
+		}
+
+		for _, test := range tests {
+			compiler := compiler.NewCompilerWithBuiltins(builtins)
+			err := compiler.Compile(parse(test.input))
+			if err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+
+			vm := NewVm(compiler.Bytecode())
+			vm.SetBuiltins(builtins)
+
+			err = vm.Run(context.Background())
+			var runtimeError *object.Error
+			if _, ok := test.expected.(*object.Error); ok && errors.As(err, &runtimeError) {
+				assertExpectedObject(t, runtimeError, test.expected)
+				continue
+			}
+			if err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+
+			assertExpectedObject(t, vm.LastPoppedStackElement(), test.expected)
+		}
+
+		err := compiler.NewCompiler().Compile(parse("answer()"))
+		if err == nil {
+			t.Errorf("registered builtins must not be visible to other compilers")
+		}
+	})
+
+	t.Run("Recovering from panics", func(t *testing.T) {
+		bytecode := &compiler.Bytecode{
+			Instructions: code.Make(code.OpPop),
+		}
+
+		vm := NewVm(bytecode)
+		err := vm.Run(context.Background())
+
+		var runtimeError *object.Error
+		if !errors.As(err, &runtimeError) || runtimeError.Kind != object.RuntimeError {
+			t.Fatalf("expected RuntimeError, got %T: %v", err, err)
+		}
+	})
+
+	t.Run("Index expressions", func(t *testing.T) {
 		tests := []vmTestCase{
 			{"[1, 2, 3][1]", 2},
 			{"[1, 2, 3][0 + 2]", 3},
@@ -148,6 +1023,9 @@ func TestCases(t *testing.T) {
 			{"{1: 1, 2: 2}[2]", 2},
 			{"{1: 1}[0]", Null},
 			{"{}[0]", Null},
+			{"{[1, 2]: 1, [2, 1]: 2}[[2, 1]]", 2},
+			{"let x = 1; {[x, [x, true]]: 3}[[1, [1, true]]]", 3},
+			{"{[1, 2]: 1}[[1]]", Null},
 		}
 
 		runVmTests(t, tests)
@@ -167,7 +1045,14 @@ func runVmTests(t *testing.T, tests []vmTestCase) {
 		}
 
 		vm := NewVm(compiler.Bytecode())
-		err = vm.Run()
+		err = vm.Run(context.Background())
+
+		// Errors raised by the program are compared like error values.
+		var runtimeError *object.Error
+		if _, ok := test.expected.(*object.Error); ok && errors.As(err, &runtimeError) {
+			assertExpectedObject(t, runtimeError, test.expected)
+			continue
+		}
 		if err != nil {
 			t.Fatalf("vm error: %s", err)
 		}
@@ -192,8 +1077,28 @@ func assertExpectedObject(t *testing.T, actual object.Object, expected interface
 		assertStringObject(t, actual, expected)
 	case map[object.HashKey]int64:
 		assertHashWithIntegerKeysAndValues(t, actual, expected)
+	case []string:
+		assertStringArray(t, actual, expected)
 	case *object.Null:
 		assertNull(t, actual)
+	case *object.Error:
+		assertErrorObject(t, actual, expected)
+	case bigInteger:
+		assertBigIntegerObject(t, actual, string(expected))
+	}
+}
+
+func assertBigIntegerObject(t *testing.T, actual object.Object, want string) {
+	t.Helper()
+
+	integer, ok := actual.(*object.BigInteger)
+	if !ok {
+		t.Errorf("Object is not a big integer. Got %T: %+v", actual, actual)
+		return
+	}
+
+	if integer.Inspect() != want {
+		t.Errorf("Object has improper value. Expected %s, got %s", want, integer.Inspect())
 	}
 }
 
@@ -233,6 +1138,39 @@ func assertIntegerArray(t *testing.T, actual object.Object, expected []int) {
 	}
 }
 
+func assertStringArray(t *testing.T, actual object.Object, expected []string) {
+	t.Helper()
+
+	array, ok := actual.(*object.Array)
+	if !ok {
+		t.Errorf("Object is not an array. Got %T: %+v", actual, actual)
+		return
+	}
+
+	if len(array.Elements) != len(expected) {
+		t.Errorf("Wrong number of elements. Got %d, want %d", len(array.Elements), len(expected))
+		return
+	}
+
+	for i, expectedElement := range expected {
+		assertStringObject(t, array.Elements[i], expectedElement)
+	}
+}
+
+func assertErrorObject(t *testing.T, actual object.Object, expected *object.Error) {
+	t.Helper()
+
+	errorObject, ok := actual.(*object.Error)
+	if !ok {
+		t.Errorf("Object is not an error. Got %T: %+v", actual, actual)
+		return
+	}
+
+	if errorObject.Message != expected.Message {
+		t.Errorf("Wrong error message. Expected %q, got %q", expected.Message, errorObject.Message)
+	}
+}
+
 func assertBooleanObject(t *testing.T, evaluated object.Object, want bool) {
 	t.Helper()
 
@@ -290,3 +1228,92 @@ func assertHashWithIntegerKeysAndValues(t *testing.T, actual object.Object, expe
 		assertIntegerObject(t, pair.Value, expectedValue)
 	}
 }
+
+func TestParallelRuns(t *testing.T) {
+	shared := object.NewError(object.UserError, "shared")
+
+	builtins := object.NewRegistry()
+	builtins.Register("shared_error", 0, "", func(runtime object.Runtime, args ...object.Object) object.Object {
+		return shared
+	})
+
+	symbolTable := compiler.NewSymbolTableWithBuiltins(builtins)
+	input := symbolTable.Define("input")
+
+	c := compiler.NewCompilerWithState(symbolTable, []object.Object{})
+	err := c.Compile(parse(`
+		let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+		let depth = fn(n) { if (n == 0) { 0 } else { 1 + depth(n - 1) } };
+		let words = ["a", "b"].map(fn(w) { w.upper() + input.name });
+		let failed = try { throw shared_error() } catch (e) { "{}{}".format(e.message, e.stack.len()) };
+		[fib(input.n), depth(input.depth), words.join(","), 9223372036854775807 + input.n, failed]
+	`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	bytecode := c.Bytecode()
+
+	constants := make([]string, len(bytecode.Constants))
+	for i, constant := range bytecode.Constants {
+		constants[i] = constant.Inspect()
+	}
+
+	const runs = 64
+
+	results := make([]string, runs)
+	errs := make([]error, runs)
+
+	var wg sync.WaitGroup
+	for i := 0; i < runs; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// deep recursion grows the stack beyond StackSize in some runs
+			depth := i
+			if i%4 == 0 {
+				depth = 1000
+			}
+
+			globals := make([]object.Object, bytecode.NumGlobals)
+			globals[input.Index], _ = object.FromGo(map[string]interface{}{"n": i % 15, "depth": depth, "name": fmt.Sprint(i)})
+
+			vm := NewVmWithGlobalsStore(bytecode, globals)
+			vm.SetBuiltins(builtins)
+
+			errs[i] = vm.Run(context.Background())
+			if errs[i] == nil {
+				results[i] = vm.LastPoppedStackElement().Inspect()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	fib := []int{0, 1, 1, 2, 3, 5, 8, 13, 21, 34, 55, 89, 144, 233, 377}
+	for i := 0; i < runs; i++ {
+		if errs[i] != nil {
+			t.Fatalf("run %d: vm error: %s", i, errs[i])
+		}
+
+		depth := i
+		if i%4 == 0 {
+			depth = 1000
+		}
+
+		expected := fmt.Sprintf("[%d, %d, A%d,B%d, %s, shared1]", fib[i%15], depth, i, i, new(big.Int).Add(big.NewInt(math.MaxInt64), big.NewInt(int64(i%15))))
+		if results[i] != expected {
+			t.Errorf("run %d: want %s, got %s", i, expected, results[i])
+		}
+	}
+
+	for i, constant := range bytecode.Constants {
+		if constant.Inspect() != constants[i] {
+			t.Errorf("constant %d was modified: %s became %s", i, constants[i], constant.Inspect())
+		}
+	}
+
+	if shared.Stack != nil {
+		t.Errorf("raising an error must not modify it, got stack %v", shared.Stack)
+	}
+}